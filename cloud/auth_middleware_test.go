@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"alfred-cloud/security"
+	"alfred-cloud/wb"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gorilla/mux"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+)
+
+func newAuthTestServer(t *testing.T) (*httptest.Server, *security.TokenVerifier, *redis.Client) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+
+	verifier, err := security.NewTokenVerifier("test-secret")
+	require.NoError(t, err)
+
+	r := mux.NewRouter()
+	r.Use(newAPIAuth(verifier).Middleware)
+	r.HandleFunc("/healthz", healthHandler).Methods("GET")
+	registerWhiteboardRoutes(r, wb.NewBus(client))
+
+	server := httptest.NewServer(r)
+	t.Cleanup(server.Close)
+	return server, verifier, client
+}
+
+func signTestToken(t *testing.T, verifier *security.TokenVerifier, claims security.AuthClaims) string {
+	t.Helper()
+	if claims.ExpiresAt == 0 {
+		claims.ExpiresAt = time.Now().Add(time.Hour).Unix()
+	}
+	token, err := verifier.Sign(claims)
+	require.NoError(t, err)
+	return token
+}
+
+func doAuthRequest(t *testing.T, method, url, token string, body any) *http.Response {
+	t.Helper()
+	var payload []byte
+	if body != nil {
+		var err error
+		payload, err = json.Marshal(body)
+		require.NoError(t, err)
+	}
+	req, err := http.NewRequest(method, url, bytes.NewReader(payload))
+	require.NoError(t, err)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = resp.Body.Close() })
+	return resp
+}
+
+func TestAuthMiddlewareRejectsMissingAndInvalidTokens(t *testing.T) {
+	server, _, _ := newAuthTestServer(t)
+
+	resp := doAuthRequest(t, http.MethodGet, server.URL+"/healthz", "", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode, "health check stays public")
+
+	resp = doAuthRequest(t, http.MethodGet, server.URL+"/wb/stream", "", nil)
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	other, err := security.NewTokenVerifier("other-secret")
+	require.NoError(t, err)
+	forged := signTestToken(t, other, security.AuthClaims{Subject: "user-1"})
+	resp = doAuthRequest(t, http.MethodGet, server.URL+"/wb/stream", forged, nil)
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
+func TestAuthMiddlewareRejectsMismatchedUserID(t *testing.T) {
+	server, verifier, _ := newAuthTestServer(t)
+	token := signTestToken(t, verifier, security.AuthClaims{Subject: "user-1"})
+
+	resp := doAuthRequest(t, http.MethodGet, server.URL+"/wb/stream?user_id=user-2", token, nil)
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	resp = doAuthRequest(t, http.MethodGet, server.URL+"/wb/ws?user_id=user-2&access_token="+token, "", nil)
+	require.Equal(t, http.StatusForbidden, resp.StatusCode, "query token is accepted and still checked")
+}
+
+func TestAuthMiddlewareAdminRoutesRequireRole(t *testing.T) {
+	server, verifier, client := newAuthTestServer(t)
+	body := map[string]any{
+		"user_id":   "user-2",
+		"thread_id": "thread-1",
+		"values":    map[string]any{"type": "talker.user_message", "content": "hi"},
+	}
+
+	userToken := signTestToken(t, verifier, security.AuthClaims{Subject: "user-1"})
+	resp := doAuthRequest(t, http.MethodPost, server.URL+"/admin/wb/append", userToken, body)
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	adminToken := signTestToken(t, verifier, security.AuthClaims{Subject: "ops", Role: security.RoleAdmin})
+	resp = doAuthRequest(t, http.MethodPost, server.URL+"/admin/wb/append", adminToken, body)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var out appendResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&out))
+	require.Equal(t, "user-2", out.UserID, "admins may append on behalf of any user")
+
+	n, err := client.XLen(resp.Request.Context(), wb.StreamKey("user-2")).Result()
+	require.NoError(t, err)
+	require.EqualValues(t, 1, n)
+}
+
+func TestUserActionRouteIsScopedToTokenUser(t *testing.T) {
+	server, verifier, client := newAuthTestServer(t)
+	userToken := signTestToken(t, verifier, security.AuthClaims{Subject: "user-1"})
+
+	action := map[string]any{
+		"user_id":   "user-2",
+		"thread_id": "tap-1",
+		"values":    map[string]any{"type": "manager.user_action", "action_id": "1-0", "choice": "send"},
+	}
+	resp := doAuthRequest(t, http.MethodPost, server.URL+"/wb/actions", userToken, action)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var out appendResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&out))
+	require.Equal(t, "user-1", out.UserID, "the token decides whose stream the action lands on")
+
+	other := map[string]any{
+		"thread_id": "tap-2",
+		"values":    map[string]any{"type": "talker.user_message", "content": "hi"},
+	}
+	resp = doAuthRequest(t, http.MethodPost, server.URL+"/wb/actions", userToken, other)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp = doAuthRequest(t, http.MethodPost, server.URL+"/wb/actions", "", action)
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	n, err := client.XLen(resp.Request.Context(), wb.StreamKey("user-1")).Result()
+	require.NoError(t, err)
+	require.EqualValues(t, 1, n)
+	n, err = client.XLen(resp.Request.Context(), wb.StreamKey("user-2")).Result()
+	require.NoError(t, err)
+	require.Zero(t, n)
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"alfred-cloud/subagents/calendar_planner"
+	cal "google.golang.org/api/calendar/v3"
+)
+
+// applyProposalOperations applies ops in order as one logical transaction.
+// Each patch or delete first records the event's current state; when a step
+// fails, the steps already applied are compensated in reverse order and the
+// remaining ones are skipped. Every op's Status reports what happened to it.
+func applyProposalOperations(ctx context.Context, updater calendarUpdater, ops []calendar_planner.ProposalOperation) error {
+	for i := range ops {
+		op := &ops[i]
+		if err := applyOperation(ctx, updater, op); err != nil {
+			op.Status = calendar_planner.OperationFailed
+			op.Error = err.Error()
+			for j := i + 1; j < len(ops); j++ {
+				ops[j].Status = calendar_planner.OperationSkipped
+			}
+			// Finish compensating even if the caller has gone away.
+			rollbackOperations(context.WithoutCancel(ctx), updater, ops[:i])
+			return fmt.Errorf("%s %s: %w", op.Action, operationTarget(op), err)
+		}
+		op.Status = calendar_planner.OperationApplied
+	}
+	return nil
+}
+
+func applyOperation(ctx context.Context, updater calendarUpdater, op *calendar_planner.ProposalOperation) error {
+	switch op.Action {
+	case calendar_planner.OperationPatch:
+		before, err := updater.GetEvent(ctx, op.CalendarID, op.EventID)
+		if err != nil {
+			return fmt.Errorf("load event: %w", err)
+		}
+		op.Before = before
+		updated, err := updater.UpdateEvent(ctx, op.CalendarID, op.EventID, buildCalendarEventPayload(*op.Event))
+		if err != nil {
+			return err
+		}
+		op.AppliedEtag, op.AppliedSequence = updated.Etag, updated.Sequence
+		return nil
+	case calendar_planner.OperationCreate:
+		created, err := updater.InsertEvent(ctx, op.CalendarID, buildCalendarEventPayload(*op.Event))
+		if err != nil {
+			return err
+		}
+		op.EventID = created.Id
+		op.AppliedEtag, op.AppliedSequence = created.Etag, created.Sequence
+		return nil
+	case calendar_planner.OperationDelete:
+		before, err := updater.GetEvent(ctx, op.CalendarID, op.EventID)
+		if err != nil {
+			return fmt.Errorf("load event: %w", err)
+		}
+		op.Before = before
+		return updater.DeleteEvent(ctx, op.CalendarID, op.EventID)
+	}
+	return fmt.Errorf("unsupported action %q", op.Action)
+}
+
+// errEventChanged means an event was edited after a proposal changed it, so
+// restoring the snapshot would overwrite someone's newer edit.
+var errEventChanged = errors.New("event was changed after the proposal was applied")
+
+// verifyOperationsUnchanged checks that every applied op's event is still in
+// the state the op left it in: same etag (or sequence when Google returned no
+// etag), and deleted events still cancelled.
+func verifyOperationsUnchanged(ctx context.Context, updater calendarUpdater, ops []calendar_planner.ProposalOperation) error {
+	for i := range ops {
+		op := &ops[i]
+		if op.Status != calendar_planner.OperationApplied {
+			continue
+		}
+		current, err := updater.GetEvent(ctx, op.CalendarID, op.EventID)
+		if err != nil {
+			return fmt.Errorf("load %s: %w", operationTarget(op), err)
+		}
+		changed := current.Etag != op.AppliedEtag
+		switch {
+		case op.Action == calendar_planner.OperationDelete:
+			changed = current.Status != "cancelled"
+		case op.AppliedEtag == "":
+			changed = current.Sequence != op.AppliedSequence
+		}
+		if changed {
+			return fmt.Errorf("%w: %s", errEventChanged, operationTarget(op))
+		}
+	}
+	return nil
+}
+
+// rollbackOperations reverts applied ops, newest first. It reports whether
+// every op was reverted.
+func rollbackOperations(ctx context.Context, updater calendarUpdater, ops []calendar_planner.ProposalOperation) bool {
+	ok := true
+	for i := len(ops) - 1; i >= 0; i-- {
+		op := &ops[i]
+		if err := revertOperation(ctx, updater, op); err != nil {
+			log.Printf("calendar apply: failed to revert %s %s: %v", op.Action, operationTarget(op), err)
+			op.Status = calendar_planner.OperationRollbackFailed
+			op.Error = err.Error()
+			ok = false
+			continue
+		}
+		op.Status = calendar_planner.OperationRolledBack
+	}
+	return ok
+}
+
+func revertOperation(ctx context.Context, updater calendarUpdater, op *calendar_planner.ProposalOperation) error {
+	switch op.Action {
+	case calendar_planner.OperationPatch:
+		if op.Before == nil {
+			return errors.New("pre-change state missing")
+		}
+		_, err := updater.UpdateEvent(ctx, op.CalendarID, op.EventID, restoreEventPayload(op.Before))
+		return err
+	case calendar_planner.OperationCreate:
+		return updater.DeleteEvent(ctx, op.CalendarID, op.EventID)
+	case calendar_planner.OperationDelete:
+		if op.Before == nil {
+			return errors.New("pre-change state missing")
+		}
+		// Google keeps deleted events as cancelled, so patching the status back
+		// restores the event under its original ID.
+		payload := restoreEventPayload(op.Before)
+		payload.Status = "confirmed"
+		_, err := updater.UpdateEvent(ctx, op.CalendarID, op.EventID, payload)
+		return err
+	}
+	return fmt.Errorf("unsupported action %q", op.Action)
+}
+
+// restoreEventPayload is a patch that puts back the fields a plan can change.
+// Empty text fields are sent explicitly so values the plan added are cleared.
+func restoreEventPayload(before *cal.Event) *cal.Event {
+	return &cal.Event{
+		Summary:         before.Summary,
+		Description:     before.Description,
+		Location:        before.Location,
+		Start:           before.Start,
+		End:             before.End,
+		ForceSendFields: []string{"Summary", "Description", "Location"},
+	}
+}
+
+func operationTarget(op *calendar_planner.ProposalOperation) string {
+	if op.EventID == "" {
+		return "new event on " + op.CalendarID
+	}
+	return fmt.Sprintf("event %s on %s", op.EventID, op.CalendarID)
+}
@@ -4,11 +4,12 @@ import (
 	"context"
 	"fmt"
 	"log"
-	"strings"
+	"sync"
 	"time"
 
 	"alfred-cloud/security"
 	"alfred-cloud/streams"
+	"alfred-cloud/subagents/calendar_planner"
 	"alfred-cloud/subagents/productivity"
 	"github.com/redis/go-redis/v9"
 	calendar "google.golang.org/api/calendar/v3"
@@ -19,10 +20,12 @@ type CalendarPullSync struct {
 	streamsHelper *streams.StreamsHelper
 	heuristics    *productivity.HeuristicService
 	redisClient   *redis.Client
+	mu            sync.Mutex
 	configuredIDs []string
+	managed       bool // configuredIDs is kept in sync by SetUsers; empty means no users
 	interval      time.Duration
 	lookback      time.Duration
-	calendarID    string
+	calendars     *calendar_planner.CalendarDirectory
 	enabled       bool
 }
 
@@ -35,7 +38,7 @@ func NewCalendarPullSync(redisClient *redis.Client, tokenStore *security.TokenSt
 		configuredIDs: userIDs,
 		interval:      interval,
 		lookback:      lookback,
-		calendarID:    "primary",
+		calendars:     calendar_planner.NewCalendarDirectory(redisClient),
 		enabled:       enabled,
 	}
 }
@@ -66,9 +69,20 @@ func (p *CalendarPullSync) Start(ctx context.Context) {
 	}()
 }
 
+// SetUsers replaces the synced users at runtime. Once called, an empty list
+// means no users rather than discovery from the token index.
+func (p *CalendarPullSync) SetUsers(ctx context.Context, userIDs []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.configuredIDs = append([]string(nil), userIDs...)
+	p.managed = true
+}
+
 func (p *CalendarPullSync) runOnce(ctx context.Context) {
-	userIDs := p.configuredIDs
-	if len(userIDs) == 0 {
+	p.mu.Lock()
+	userIDs, managed := append([]string(nil), p.configuredIDs...), p.managed
+	p.mu.Unlock()
+	if len(userIDs) == 0 && !managed {
 		userIDs = p.discoverUsers(ctx)
 	}
 	if len(userIDs) == 0 {
@@ -81,29 +95,13 @@ func (p *CalendarPullSync) runOnce(ctx context.Context) {
 }
 
 func (p *CalendarPullSync) discoverUsers(ctx context.Context) []string {
-	if p.redisClient == nil {
+	if p.tokenStore == nil {
 		return nil
 	}
-	iter := p.redisClient.Scan(ctx, 0, "oauth_token:*:calendar", 100).Iterator()
-	seen := make(map[string]struct{})
-	for iter.Next(ctx) {
-		key := iter.Val()
-		parts := strings.Split(key, ":")
-		if len(parts) < 3 {
-			continue
-		}
-		userID := parts[1]
-		if userID == "" {
-			continue
-		}
-		seen[userID] = struct{}{}
-	}
-	if err := iter.Err(); err != nil {
-		log.Printf("Pull sync: discover users scan error: %v", err)
-	}
-	users := make([]string, 0, len(seen))
-	for u := range seen {
-		users = append(users, u)
+	users, err := p.tokenStore.ListServiceUsers(ctx, security.ServiceCalendar)
+	if err != nil {
+		log.Printf("Pull sync: discover users error: %v", err)
+		return nil
 	}
 	return users
 }
@@ -116,9 +114,20 @@ func (p *CalendarPullSync) syncUser(ctx context.Context, userID string) {
 		return
 	}
 
-	events, err := p.fetchRecentEvents(ctx, calendarService, p.calendarID, time.Now().Add(-p.lookback))
+	calendarIDs, err := p.calendars.WatchedCalendars(ctx, userID)
+	if err != nil {
+		log.Printf("Pull sync: watched calendars err user=%s: %v", userID, err)
+		return
+	}
+	for _, calendarID := range calendarIDs {
+		p.syncCalendar(ctx, calendarService, userID, calendarID)
+	}
+}
+
+func (p *CalendarPullSync) syncCalendar(ctx context.Context, calendarService *calendar.Service, userID, calendarID string) {
+	events, err := p.fetchRecentEvents(ctx, calendarService, calendarID, time.Now().Add(-p.lookback))
 	if err != nil {
-		log.Printf("Pull sync: fetch recent err user=%s: %v", userID, err)
+		log.Printf("Pull sync: fetch recent err user=%s calendar=%s: %v", userID, calendarID, err)
 		return
 	}
 	if len(events) == 0 {
@@ -132,7 +141,7 @@ func (p *CalendarPullSync) syncUser(ctx context.Context, userID string) {
 		changeData["resource_uri"] = changeData["resource_uri"]
 		changeData["notified_at"] = time.Now().UTC().Format(time.RFC3339Nano)
 		changeData["user_id"] = userID
-		changeData["calendar_id"] = p.calendarID
+		changeData["calendar_id"] = calendarID
 
 		if _, err := p.streamsHelper.AppendToStream(ctx, inputKey, changeData); err != nil {
 			log.Printf("Pull sync: enqueue err user=%s: %v", userID, err)
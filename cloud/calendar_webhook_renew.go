@@ -17,6 +17,7 @@ import (
 type WebhookRenewer struct {
 	redisClient *redis.Client
 	tokenStore  *security.TokenStore
+	calendars   *calendar_planner.CalendarDirectory
 	interval    time.Duration
 	threshold   time.Duration
 	enabled     bool
@@ -26,6 +27,7 @@ func NewWebhookRenewer(redisClient *redis.Client, tokenStore *security.TokenStor
 	return &WebhookRenewer{
 		redisClient: redisClient,
 		tokenStore:  tokenStore,
+		calendars:   calendar_planner.NewCalendarDirectory(redisClient),
 		interval:    interval,
 		threshold:   threshold,
 		enabled:     enabled,
@@ -92,16 +94,28 @@ func (r *WebhookRenewer) scanAndRenew(ctx context.Context) error {
 			continue
 		}
 
-		log.Printf("Renewal: renewing calendar webhook user=%s calendar=%s channel=%s resource=%s expiring=%s", userID, calendarID, channelID, resourceID, expTime.Format(time.RFC3339))
-
 		googleClient := security.NewGoogleServiceClient(r.tokenStore)
 		calendarService, err := googleClient.GetCalendarService(ctx, userID)
 		if err != nil {
 			log.Printf("Renewal: failed to get calendar service for user %s: %v", userID, err)
 			continue
 		}
-
 		registrar := calendar_planner.NewWebhookRegistrar(r.redisClient, calendarService)
+
+		// Let channels for calendars the user stopped watching lapse instead of renewing them
+		if watched, err := r.calendars.IsWatched(ctx, userID, calendarID); err == nil && !watched {
+			log.Printf("Renewal: dropping webhook for unwatched calendar user=%s calendar=%s channel=%s", userID, calendarID, channelID)
+			if err := registrar.UnregisterWebhook(ctx, channelID, resourceID); err != nil {
+				log.Printf("Renewal: failed to stop channel %s: %v", channelID, err)
+			}
+			if err := r.redisClient.Del(ctx, key).Err(); err != nil {
+				log.Printf("Renewal: failed to delete old webhook key %s: %v", key, err)
+			}
+			continue
+		}
+
+		log.Printf("Renewal: renewing calendar webhook user=%s calendar=%s channel=%s resource=%s expiring=%s", userID, calendarID, channelID, resourceID, expTime.Format(time.RFC3339))
+
 		channel, err := registrar.RegisterWebhook(ctx, userID, calendarID, webhookURL)
 		if err != nil {
 			log.Printf("Renewal: register failed for user %s: %v", userID, err)
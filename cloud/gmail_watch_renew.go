@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"alfred-cloud/subagents/email_triage"
+)
+
+// gmailWatcher registers Gmail push watches (implemented by email_triage.EmailPoller).
+type gmailWatcher interface {
+	EnsureWatch(ctx context.Context, userID, topic string, threshold time.Duration) (*email_triage.GmailWatch, bool, error)
+}
+
+// GmailWatchRenewer keeps a Gmail push watch alive for every email user. Gmail
+// watches lapse after seven days, so they are renewed once within threshold of expiry.
+type GmailWatchRenewer struct {
+	watcher   gmailWatcher
+	topic     string
+	interval  time.Duration
+	threshold time.Duration
+	enabled   bool
+
+	mu    sync.Mutex
+	users []string
+	kick  chan struct{}
+}
+
+func NewGmailWatchRenewer(watcher gmailWatcher, topic string, interval, threshold time.Duration, enabled bool) *GmailWatchRenewer {
+	return &GmailWatchRenewer{
+		watcher:   watcher,
+		topic:     topic,
+		interval:  interval,
+		threshold: threshold,
+		enabled:   enabled,
+		kick:      make(chan struct{}, 1),
+	}
+}
+
+// SetUsers replaces the watched users and triggers a scan so new users get a watch right away.
+func (r *GmailWatchRenewer) SetUsers(ctx context.Context, userIDs []string) {
+	r.mu.Lock()
+	r.users = append([]string(nil), userIDs...)
+	r.mu.Unlock()
+	select {
+	case r.kick <- struct{}{}:
+	default:
+	}
+}
+
+func (r *GmailWatchRenewer) Start(ctx context.Context) {
+	if !r.enabled {
+		log.Println("Gmail watch renewal disabled")
+		return
+	}
+	if r.watcher == nil || r.topic == "" {
+		log.Println("Gmail watch renewal disabled: missing poller or topic")
+		return
+	}
+	if r.interval <= 0 {
+		r.interval = time.Hour
+	}
+	if r.threshold <= 0 {
+		r.threshold = 24 * time.Hour
+	}
+	go r.loop(ctx)
+}
+
+func (r *GmailWatchRenewer) loop(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		r.scanAndRenew(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-r.kick:
+		}
+	}
+}
+
+func (r *GmailWatchRenewer) scanAndRenew(ctx context.Context) {
+	r.mu.Lock()
+	users := append([]string(nil), r.users...)
+	r.mu.Unlock()
+
+	for _, userID := range users {
+		watch, renewed, err := r.watcher.EnsureWatch(ctx, userID, r.topic, r.threshold)
+		if err != nil {
+			log.Printf("Gmail watch: renew failed for user %s: %v", userID, err)
+			continue
+		}
+		if renewed {
+			log.Printf("Gmail watch: registered user=%s address=%s history=%d expiring=%s", userID, watch.EmailAddress, watch.HistoryID, watch.Expiration.Format(time.RFC3339))
+		}
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"syscall"
 	"time"
 
+	"alfred-cloud/registry"
 	"alfred-cloud/security"
 	"alfred-cloud/streams"
 	"alfred-cloud/subagents/calendar_planner"
@@ -71,12 +72,44 @@ func main() {
 	// Initialize OAuth (separate stores per service)
 	gmailTokenStore := security.NewTokenStore(redisClient)
 	calendarTokenStore := security.NewTokenStore(redisClient)
+	tokenCipher, err := security.RequiredTokenCipherFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to load OAuth token keys: %v", err)
+	}
+	if tokenCipher != nil {
+		gmailTokenStore.UseCipher(tokenCipher)
+		calendarTokenStore.UseCipher(tokenCipher)
+		// Migrate plaintext records and rotate old-key records in the background
+		go func() {
+			stats, err := gmailTokenStore.ReencryptTokens(ctx)
+			if err != nil {
+				log.Printf("OAuth token re-encryption failed: %v", err)
+				return
+			}
+			log.Printf("OAuth tokens on key %s: scanned=%d migrated=%d rotated=%d failed=%d",
+				tokenCipher.ActiveKeyID(), stats.Scanned, stats.Migrated, stats.Rotated, stats.Failed)
+		}()
+	} else {
+		log.Println("Warning: OAUTH_TOKEN_ENCRYPTION_DISABLED=true, OAuth tokens are stored unencrypted")
+	}
+	// Backfill the connected-user index for tokens stored before it existed
+	if indexed, err := gmailTokenStore.RebuildIndex(ctx); err != nil {
+		log.Printf("OAuth token index rebuild failed: %v", err)
+	} else {
+		log.Printf("OAuth token index covers %d tokens", indexed)
+	}
 	googleAuthHandler := initGoogleAuthForMain(gmailTokenStore, calendarTokenStore)
 
 	// Initialize streams helper
 	streamsHelper := streams.NewStreamsHelper(redisClient)
 	wbBus := wb.NewBus(redisClient)
 
+	// Retention for the whiteboard and agent input streams, archiving trimmed whiteboard entries
+	streamTrimmer := streams.NewTrimmer(redisClient, streams.TrimmerOptionsFromEnv())
+	if strings.ToLower(strings.TrimSpace(os.Getenv("STREAM_TRIM_ENABLED"))) != "false" {
+		go streamTrimmer.Run(ctx)
+	}
+
 	// Initialize productivity heuristic store (used by calendar events for expected apps)
 	prodHeuristicStore := productivity.NewHeuristicStore(redisClient)
 	prodHeuristicService, err := productivity.NewHeuristicService(prodHeuristicStore, nil)
@@ -94,98 +127,116 @@ func main() {
 	renewer := NewWebhookRenewer(redisClient, calendarTokenStore, renewInterval, renewThreshold, renewEnabled)
 	renewer.Start(ctx)
 
+	// User registry: per-user feature flags drive which workers run for whom.
+	// Users are registered by the OAuth callback; the legacy *_USERS lists only seed it.
+	userRegistry := registry.New(redisClient)
+	googleAuthHandler.UseUserRegistry(userRegistry)
+	backfillUserRegistry(ctx, userRegistry, gmailTokenStore)
+	seedUserRegistry(ctx, userRegistry, "EMAIL_POLLER_USERS", registry.FeatureEmail)
+	seedUserRegistry(ctx, userRegistry, "EMAIL_TRIAGE_USERS", registry.FeatureEmail)
+	seedUserRegistry(ctx, userRegistry, "PRODUCTIVITY_USERS", registry.FeatureProductivity)
+	seedUserRegistry(ctx, userRegistry, "CALENDAR_SHADOW_USERS", registry.FeatureCalendar)
+	seedUserRegistry(ctx, userRegistry, "CALENDAR_PULL_SYNC_USERS", registry.FeatureCalendar)
+
 	// Calendar pull sync fallback
 	pullEnabled := true
 	pullInterval := 5 * time.Minute
 	pullLookback := parseDurationOrDefault(os.Getenv("CALENDAR_PULL_SYNC_LOOKBACK"), 48*time.Hour)
-	pullUsers := parseUserList("CALENDAR_PULL_SYNC_USERS", "test-user")
-	pullSync := NewCalendarPullSync(redisClient, calendarTokenStore, streamsHelper, prodHeuristicService, pullUsers, pullInterval, pullLookback, pullEnabled)
+	pullSync := NewCalendarPullSync(redisClient, calendarTokenStore, streamsHelper, prodHeuristicService, nil, pullInterval, pullLookback, pullEnabled)
+	watchUsers(ctx, userRegistry, registry.FeatureCalendar, pullSync)
 	pullSync.Start(ctx)
 
 	// Initialize Email Poller
 	var emailPoller *email_triage.EmailPoller
+	var emailPushHandler *EmailPushHandler
 	isEmailEnabled := globalGmailClient != nil
 	if isEmailEnabled {
-		userIDs := parseUserList("EMAIL_POLLER_USERS", "test-user")
-		if len(userIDs) > 0 {
-			emailPoller = email_triage.NewEmailPoller(globalGmailClient, redisClient, userIDs)
-			go func() {
-				if err := emailPoller.Start(ctx); err != nil {
-					log.Printf("Failed to start email poller: %v", err)
-				}
-			}()
-		} else {
-			log.Println("Email poller disabled: EMAIL_POLLER_USERS empty")
-		}
+		emailPoller = email_triage.NewEmailPoller(globalGmailClient, redisClient, nil)
+		watchUsers(ctx, userRegistry, registry.FeatureEmail, emailPoller)
+		emailPushHandler = initGmailPush(ctx, emailPoller, userRegistry)
+		go func() {
+			if err := emailPoller.Start(ctx); err != nil {
+				log.Printf("Failed to start email poller: %v", err)
+			}
+		}()
 	}
 
 	// Initialize Email Triage Consumer
 	var emailConsumer *email_triage.EmailConsumer
-	emailTriageUsers := parseUserList("EMAIL_TRIAGE_USERS", getEnv("EMAIL_POLLER_USERS", "test-user"))
-	if len(emailTriageUsers) > 0 {
-		classifier, err := email_triage.NewEmailClassifier()
-		if err != nil {
-			log.Printf("Email triage consumer disabled: %v", err)
-		} else {
-			emailConsumer = email_triage.NewEmailConsumer(redisClient, classifier, emailTriageUsers)
-			go func() {
-				if err := emailConsumer.Start(ctx); err != nil {
-					log.Printf("Failed to start email consumer: %v", err)
-				}
-			}()
-		}
+	classifier, err := email_triage.NewEmailClassifier()
+	if err != nil {
+		log.Printf("Email triage consumer disabled: %v", err)
 	} else {
-		log.Println("Email triage consumer disabled: EMAIL_TRIAGE_USERS empty")
-	}
-
-	// Initialize Productivity Subagent (Consumer)
-	var productivityConsumer *productivity.ProductivityConsumer
-	prodUsers := parseUserList("PRODUCTIVITY_USERS", "test-user")
-	if len(prodUsers) > 0 {
-		prodClassifier, err := productivity.NewClassifier(prodHeuristicService)
-		if err != nil {
-			log.Fatalf("Failed to init productivity classifier: %v", err)
-		}
-
-		productivityConsumer = productivity.NewProductivityConsumer(redisClient, prodClassifier, prodHeuristicService, prodUsers)
+		emailConsumer = email_triage.NewEmailConsumer(redisClient, classifier, nil)
+		watchUsers(ctx, userRegistry, registry.FeatureEmail, emailConsumer)
 		go func() {
-			if err := productivityConsumer.Start(ctx); err != nil {
-				log.Printf("Failed to start productivity consumer: %v", err)
+			if err := emailConsumer.Start(ctx); err != nil {
+				log.Printf("Failed to start email consumer: %v", err)
 			}
 		}()
-	} else {
-		log.Println("Productivity consumer disabled: PRODUCTIVITY_USERS empty")
 	}
 
+	// Initialize Productivity Subagent (Consumer)
+	prodClassifier, err := productivity.NewClassifier(prodHeuristicService, productivity.WithStateStore(productivity.NewRedisClassifierStore(redisClient)))
+	if err != nil {
+		log.Fatalf("Failed to init productivity classifier: %v", err)
+	}
+	productivityConsumer := productivity.NewProductivityConsumer(redisClient, prodClassifier, prodHeuristicService, nil)
+	watchUsers(ctx, userRegistry, registry.FeatureProductivity, productivityConsumer)
+	go func() {
+		if err := productivityConsumer.Start(ctx); err != nil {
+			log.Printf("Failed to start productivity consumer: %v", err)
+		}
+	}()
+
 	// Initialize shadow calendar service (planner subagent)
-	var shadowCalendarService *calendar_planner.ShadowCalendarService
-	shadowUsers := parseUserList("CALENDAR_SHADOW_USERS", "test-user")
-	if len(shadowUsers) > 0 {
-		plannerScript := strings.TrimSpace(os.Getenv("PLANNER_SCRIPT"))
-		if plannerScript == "" {
-			exePath, _ := os.Executable()
-			exeDir := filepath.Dir(exePath)
-			plannerScript = findFileUpwards(exeDir, "python_helper/planner_tool.py")
+	// Without a planner, conflicts are still proposed, with the scheduler's slots
+	// or no plan, and /planner/health reports the error.
+	plannerRunner, err := ensureCalendarManagerService()
+	if err != nil {
+		log.Printf("Calendar planner disabled: %v", err)
+	} else {
+		log.Printf("Calendar planner model: %s", calendarManagerModel)
+	}
+	scheduling := calendar_planner.SchedulingConstraints{
+		MinGap: parseDurationOrDefault(os.Getenv("CALENDAR_SCHEDULER_MIN_GAP"), 0),
+	}
+	for env, field := range map[string]*time.Duration{
+		"CALENDAR_WORKDAY_START": &scheduling.WorkdayStart,
+		"CALENDAR_WORKDAY_END":   &scheduling.WorkdayEnd,
+	} {
+		if raw := strings.TrimSpace(os.Getenv(env)); raw != "" {
+			clock, err := calendar_planner.ParseClock(raw)
+			if err != nil {
+				log.Fatalf("Invalid %s: %v", env, err)
+			}
+			*field = clock
 		}
-		log.Printf("Planner script path: %s", plannerScript)
-		plannerRunner := calendar_planner.NewCalendarManagerService(plannerScript)
-		shadowService, err := calendar_planner.NewShadowCalendarService(redisClient, plannerRunner, calendar_planner.ShadowCalendarOptions{
-			UserIDs: shadowUsers,
-		})
+	}
+	if tz := strings.TrimSpace(os.Getenv("CALENDAR_TIMEZONE")); tz != "" {
+		loc, err := time.LoadLocation(tz)
 		if err != nil {
-			log.Fatalf("Failed to initialize shadow calendar service: %v", err)
+			log.Fatalf("Invalid CALENDAR_TIMEZONE: %v", err)
 		}
-		if err := shadowService.Start(ctx); err != nil {
-			log.Fatalf("Failed to start shadow calendar service: %v", err)
-		}
-		shadowCalendarService = shadowService
-		defer shadowCalendarService.Stop()
-	} else {
-		log.Println("Shadow calendar service disabled: CALENDAR_SHADOW_USERS empty")
+		scheduling.Location = loc
 	}
+	shadowCalendarService, err := calendar_planner.NewShadowCalendarService(redisClient, plannerRunner, calendar_planner.ShadowCalendarOptions{Bus: wbBus, Scheduling: scheduling, Retry: streams.RetryPolicyFromEnv()})
+	if err != nil {
+		log.Fatalf("Failed to initialize shadow calendar service: %v", err)
+	}
+	watchUsers(ctx, userRegistry, registry.FeatureCalendar, shadowCalendarService)
+	if err := shadowCalendarService.Start(ctx); err != nil {
+		log.Fatalf("Failed to start shadow calendar service: %v", err)
+	}
+	defer shadowCalendarService.Stop()
 
 	r := mux.NewRouter()
 
+	// Bearer auth on everything except health checks and Google callbacks
+	if auth := initAPIAuth(); auth != nil {
+		r.Use(auth.Middleware)
+	}
+
 	// Health check endpoint
 	r.HandleFunc("/healthz", healthHandler).Methods("GET")
 	r.HandleFunc("/", rootHandler).Methods("GET")
@@ -198,14 +249,28 @@ func main() {
 	calendarWebhookHandler.RegisterRoutes(r)
 	registerProdDebugRoutes(r, prodHeuristicService)
 	registerProdHeuristicRoutes(r, streamsHelper)
+	registerProdControlRoutes(r, prodClassifier)
 
 	// Calendar manager tool endpoints
 	registerCalendarManagerRoutes(r)
 	registerShadowCalendarRoutes(r, shadowCalendarService)
-	registerProposalConfirmRoutes(r, shadowCalendarService, globalCalendarClient)
+	registerProposalConfirmRoutes(r, shadowCalendarService, globalCalendarClient, parseDurationOrDefault(os.Getenv("CALENDAR_PROPOSAL_UNDO_WINDOW"), defaultProposalUndoWindow))
+	registerProposalRoutes(r, shadowCalendarService)
+	registerCalendarListRoutes(r, calendar_planner.NewCalendarDirectory(redisClient), calendarTokenStore)
 	registerEmailTriageRoutes(r)
 	registerManagerRoutes(r)
 	registerWhiteboardRoutes(r, wbBus)
+	registerWhiteboardArchiveRoutes(r, streamTrimmer.Archive())
+	registerUserRegistryRoutes(r, userRegistry)
+	registerDeadLetterRoutes(r, streamsHelper)
+	consumerSources := []consumerMetricsSource{productivityConsumer, shadowCalendarService}
+	if emailConsumer != nil {
+		consumerSources = append(consumerSources, emailConsumer)
+	}
+	registerStreamConsumerRoutes(r, consumerSources...)
+	if emailPushHandler != nil {
+		emailPushHandler.RegisterRoutes(r)
+	}
 
 	// Test endpoint to easily get auth URL
 	r.HandleFunc("/test/gmail-auth-url", getGmailAuthURL).Methods("GET")
@@ -246,9 +311,7 @@ func main() {
 	}
 
 	// Stop productivity consumer
-	if productivityConsumer != nil {
-		productivityConsumer.Stop()
-	}
+	productivityConsumer.Stop()
 
 	// Shutdown server
 	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -301,7 +364,11 @@ var (
 )
 
 func getGmailAuthURL(w http.ResponseWriter, r *http.Request) {
-	userID := r.URL.Query().Get("user_id")
+	userID, err := requestUserID(r, r.URL.Query().Get("user_id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
 	if userID == "" {
 		userID = "test-user"
 	}
@@ -424,9 +491,55 @@ func initGoogleAuthForMain(gmailStore, calendarStore *security.TokenStore) *Goog
 	return NewGoogleAuthHandler(gmailClient, calendarClient)
 }
 
+// initGmailPush enables Gmail push notifications when EMAIL_INGEST_MODE=push.
+// Polling keeps running at EMAIL_PUSH_FALLBACK_INTERVAL to catch missed pushes.
+func initGmailPush(ctx context.Context, poller *email_triage.EmailPoller, userRegistry *registry.Registry) *EmailPushHandler {
+	if !strings.EqualFold(strings.TrimSpace(os.Getenv("EMAIL_INGEST_MODE")), "push") {
+		return nil
+	}
+	topic := strings.TrimSpace(os.Getenv("GMAIL_PUSH_TOPIC"))
+	token := strings.TrimSpace(os.Getenv("EMAIL_PUSH_TOKEN"))
+	if topic == "" || token == "" {
+		log.Println("Gmail push disabled: GMAIL_PUSH_TOPIC and EMAIL_PUSH_TOKEN are required, falling back to polling")
+		return nil
+	}
+	if poller.SyncMode() != email_triage.SyncModeHistory {
+		log.Println("Gmail push disabled: requires EMAIL_SYNC_MODE=history, falling back to polling")
+		return nil
+	}
+
+	poller.SetPollInterval(parseDurationOrDefault(os.Getenv("EMAIL_PUSH_FALLBACK_INTERVAL"), 10*time.Minute))
+	renewInterval := parseDurationOrDefault(os.Getenv("GMAIL_WATCH_RENEW_INTERVAL"), time.Hour)
+	renewThreshold := parseDurationOrDefault(os.Getenv("GMAIL_WATCH_RENEW_THRESHOLD"), 24*time.Hour)
+	renewer := NewGmailWatchRenewer(poller, topic, renewInterval, renewThreshold, true)
+	watchUsers(ctx, userRegistry, registry.FeatureEmail, renewer)
+	renewer.Start(ctx)
+
+	log.Printf("Gmail push enabled on topic %s", topic)
+	return NewEmailPushHandler(poller, token)
+}
+
+// initAPIAuth builds the bearer-token middleware from AUTH_JWT_SECRET.
+// AUTH_DISABLED=true skips auth entirely for local development.
+func initAPIAuth() *apiAuth {
+	if strings.EqualFold(strings.TrimSpace(os.Getenv("AUTH_DISABLED")), "true") {
+		log.Println("Warning: AUTH_DISABLED=true, API requests are not authenticated")
+		return nil
+	}
+	verifier, err := security.NewTokenVerifier(os.Getenv("AUTH_JWT_SECRET"))
+	if err != nil {
+		log.Fatal("AUTH_JWT_SECRET environment variable is required (set AUTH_DISABLED=true for local development)")
+	}
+	return newAPIAuth(verifier)
+}
+
 // Test endpoint to generate Calendar OAuth URL
 func getCalendarAuthURL(w http.ResponseWriter, r *http.Request) {
-	userID := r.URL.Query().Get("user_id")
+	userID, err := requestUserID(r, r.URL.Query().Get("user_id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
 	if userID == "" {
 		userID = "test-user"
 	}
@@ -2,6 +2,7 @@ package manager
 
 import (
 	"context"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -19,7 +20,10 @@ type Checkpoint struct {
 // CheckpointStore persists checkpoints.
 type CheckpointStore interface {
 	Get(userID, threadID string) Checkpoint
+	// Save merges cp into whatever is stored, so a replica saving a checkpoint it read
+	// earlier cannot undo progress another replica made in the meantime.
 	Save(userID, threadID string, cp Checkpoint)
+	Threads(userID string) []string
 }
 
 type checkpointKey struct{}
@@ -49,14 +53,26 @@ func (s *InMemoryCheckpointStore) Get(userID, threadID string) Checkpoint {
 	return Checkpoint{}
 }
 
-// Save upserts the checkpoint for a user/thread.
+// Save merges cp into the stored checkpoint for a user/thread, see mergeCheckpoint.
 func (s *InMemoryCheckpointStore) Save(userID, threadID string, cp Checkpoint) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	if _, ok := s.store[userID]; !ok {
 		s.store[userID] = make(map[string]Checkpoint)
 	}
-	s.store[userID][threadID] = cp
+	s.store[userID][threadID] = mergeCheckpoint(s.store[userID][threadID], cp)
+}
+
+// Threads lists the thread ids that have a checkpoint for the user.
+func (s *InMemoryCheckpointStore) Threads(userID string) []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	threads := make([]string, 0, len(s.store[userID]))
+	for threadID := range s.store[userID] {
+		threads = append(threads, threadID)
+	}
+	sort.Strings(threads)
+	return threads
 }
 
 // WithCheckpoint attaches a checkpoint to the context for idempotency guards.
@@ -89,6 +105,20 @@ func shouldSkipID(id, lastProcessed string) bool {
 	return false
 }
 
+// lowestStreamID returns the smallest non-empty stream id, or "" when none are set.
+func lowestStreamID(ids []string) string {
+	lowest := ""
+	for _, id := range ids {
+		if strings.TrimSpace(id) == "" {
+			continue
+		}
+		if lowest == "" || shouldSkipID(id, lowest) {
+			lowest = id
+		}
+	}
+	return lowest
+}
+
 func splitStreamID(id string) (int64, int64) {
 	parts := strings.Split(id, "-")
 	if len(parts) != 2 {
@@ -113,6 +143,38 @@ func dedupeStrings(in []string) []string {
 	return out
 }
 
+// applyRunResult advances a checkpoint to wbID (never backwards) and merges what the graph run produced.
+func applyRunResult(cp Checkpoint, wbID string, res RunResult) Checkpoint {
+	if !shouldSkipID(wbID, cp.LastWBID) {
+		cp.LastWBID = wbID
+	}
+	if res.PromptID != "" {
+		cp.PendingPromptID = res.PromptID
+	}
+	if res.LastPlanID != "" {
+		cp.LastPlanID = res.LastPlanID
+	}
+	if res.LastPlanVersion != "" {
+		cp.LastPlanVersion = res.LastPlanVersion
+	}
+	if len(res.SideEffects) > 0 {
+		cp.SideEffects = dedupeStrings(append(cp.SideEffects, res.SideEffects...))
+	}
+	return cp
+}
+
+// mergeCheckpoint combines a checkpoint being saved with the one already stored, which
+// another replica may have advanced since cp was read. The cursor never moves backwards,
+// the newer entry's plan and prompt state win, and side effects accumulate.
+func mergeCheckpoint(stored, cp Checkpoint) Checkpoint {
+	merged := cp
+	if shouldSkipID(cp.LastWBID, stored.LastWBID) && cp.LastWBID != stored.LastWBID {
+		merged = stored
+	}
+	merged.SideEffects = dedupeStrings(append(append([]string(nil), stored.SideEffects...), cp.SideEffects...))
+	return merged
+}
+
 // sideEffectRecorded reports whether the idempotency key already exists in the checkpoint log.
 func sideEffectRecorded(cp Checkpoint, key string) bool {
 	for _, existing := range cp.SideEffects {
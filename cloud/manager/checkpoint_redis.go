@@ -2,12 +2,18 @@ package manager
 
 import (
 	"context"
+	"errors"
+	"log"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/redis/go-redis/v9"
 )
 
+// maxCheckpointSaveAttempts bounds the WATCH retries of one Save.
+const maxCheckpointSaveAttempts = 10
+
 // RedisCheckpointStore persists checkpoints to Redis for replay safety.
 type RedisCheckpointStore struct {
 	client *redis.Client
@@ -32,17 +38,27 @@ func (s *RedisCheckpointStore) sideEffectsKey(userID, threadID string) string {
 	return strings.Join([]string{s.prefix, "side_effects", strings.TrimSpace(userID), strings.TrimSpace(threadID)}, ":")
 }
 
+func (s *RedisCheckpointStore) threadsKey(userID string) string {
+	return strings.Join([]string{s.prefix, "threads", strings.TrimSpace(userID)}, ":")
+}
+
 // Get returns the checkpoint for a user/thread, falling back to zero value if not found.
 func (s *RedisCheckpointStore) Get(userID, threadID string) Checkpoint {
 	if s == nil || s.client == nil {
 		return Checkpoint{}
 	}
 	ctx := context.Background()
-	hash := s.hashKey(userID, threadID)
+	cp, err := s.load(ctx, s.client, userID, threadID)
+	if err != nil {
+		return Checkpoint{}
+	}
+	return cp
+}
 
-	fields, err := s.client.HGetAll(ctx, hash).Result()
+func (s *RedisCheckpointStore) load(ctx context.Context, client redis.Cmdable, userID, threadID string) (Checkpoint, error) {
+	fields, err := client.HGetAll(ctx, s.hashKey(userID, threadID)).Result()
 	if err != nil || len(fields) == 0 {
-		return Checkpoint{}
+		return Checkpoint{}, err
 	}
 
 	cp := Checkpoint{
@@ -53,37 +69,87 @@ func (s *RedisCheckpointStore) Get(userID, threadID string) Checkpoint {
 	}
 
 	// Side effects stored in a set to dedupe.
-	if members, err := s.client.SMembers(ctx, s.sideEffectsKey(userID, threadID)).Result(); err == nil {
+	if members, err := client.SMembers(ctx, s.sideEffectsKey(userID, threadID)).Result(); err == nil {
 		cp.SideEffects = dedupeStrings(members)
 	}
-	return cp
+	return cp, nil
 }
 
-// Save upserts the checkpoint for a user/thread.
+// Save merges cp into the stored checkpoint for a user/thread. The hash is watched
+// so replicas saving the same thread at once retry instead of overwriting each other.
 func (s *RedisCheckpointStore) Save(userID, threadID string, cp Checkpoint) {
 	if s == nil || s.client == nil {
 		return
 	}
 	ctx := context.Background()
 	hash := s.hashKey(userID, threadID)
+	sideEffects := s.sideEffectsKey(userID, threadID)
 
-	payload := map[string]string{
-		"last_wb_id":        cp.LastWBID,
-		"last_plan_id":      cp.LastPlanID,
-		"last_plan_version": cp.LastPlanVersion,
-		"pending_prompt_id": cp.PendingPromptID,
+	for attempt := 0; attempt < maxCheckpointSaveAttempts; attempt++ {
+		err := s.client.Watch(ctx, func(tx *redis.Tx) error {
+			stored, err := s.load(ctx, tx, userID, threadID)
+			if err != nil {
+				return err
+			}
+			merged := mergeCheckpoint(stored, cp)
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.HSet(ctx, hash, map[string]string{
+					"last_wb_id":        merged.LastWBID,
+					"last_plan_id":      merged.LastPlanID,
+					"last_plan_version": merged.LastPlanVersion,
+					"pending_prompt_id": merged.PendingPromptID,
+				})
+				pipe.SAdd(ctx, s.threadsKey(userID), strings.TrimSpace(threadID))
+				if len(cp.SideEffects) > 0 {
+					members := make([]any, 0, len(cp.SideEffects))
+					for _, key := range cp.SideEffects {
+						members = append(members, key)
+					}
+					pipe.SAdd(ctx, sideEffects, members...)
+				}
+				if s.ttl > 0 {
+					pipe.Expire(ctx, hash, s.ttl)
+					pipe.Expire(ctx, sideEffects, s.ttl)
+				}
+				return nil
+			})
+			return err
+		}, hash)
+		if errors.Is(err, redis.TxFailedErr) {
+			continue
+		}
+		if err != nil {
+			log.Printf("manager: save checkpoint %s/%s: %v", userID, threadID, err)
+		}
+		return
 	}
-	_ = s.client.HSet(ctx, hash, payload).Err()
-	if s.ttl > 0 {
-		_ = s.client.Expire(ctx, hash, s.ttl).Err()
-		_ = s.client.Expire(ctx, s.sideEffectsKey(userID, threadID), s.ttl).Err()
+	log.Printf("manager: save checkpoint %s/%s: too many concurrent updates", userID, threadID)
+}
+
+// Threads lists the thread ids that have a live checkpoint for the user.
+// Threads whose checkpoint hash expired are pruned from the index.
+func (s *RedisCheckpointStore) Threads(userID string) []string {
+	if s == nil || s.client == nil {
+		return nil
+	}
+	ctx := context.Background()
+	members, err := s.client.SMembers(ctx, s.threadsKey(userID)).Result()
+	if err != nil {
+		return nil
 	}
+	sort.Strings(members)
 
-	if len(cp.SideEffects) > 0 {
-		members := make([]any, 0, len(cp.SideEffects))
-		for _, key := range cp.SideEffects {
-			members = append(members, key)
+	threads := make([]string, 0, len(members))
+	for _, threadID := range members {
+		exists, err := s.client.Exists(ctx, s.hashKey(userID, threadID)).Result()
+		if err != nil {
+			continue
+		}
+		if exists == 0 {
+			_ = s.client.SRem(ctx, s.threadsKey(userID), threadID).Err()
+			continue
 		}
-		_ = s.client.SAdd(ctx, s.sideEffectsKey(userID, threadID), members...).Err()
+		threads = append(threads, threadID)
 	}
+	return threads
 }
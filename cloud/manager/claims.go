@@ -0,0 +1,62 @@
+package manager
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ClaimStore reserves side effects across replicas. A checkpoint is per thread and
+// only saved after a run, so two runs acting on the same thing must claim it first.
+type ClaimStore interface {
+	// Claim stores value under key unless the key is held, in which case it reports
+	// false along with the value already stored.
+	Claim(ctx context.Context, key, value string, ttl time.Duration) (bool, string, error)
+	// Complete replaces the value of a held key and extends it to ttl.
+	Complete(ctx context.Context, key, value string, ttl time.Duration) error
+	// Release drops a claim whose side effect failed so it can be retried.
+	Release(ctx context.Context, key string) error
+}
+
+// RedisClaimStore keeps claims as plain Redis keys set with SETNX.
+type RedisClaimStore struct {
+	client *redis.Client
+}
+
+// NewRedisClaimStore creates a Redis-backed claim store.
+func NewRedisClaimStore(client *redis.Client) *RedisClaimStore {
+	return &RedisClaimStore{client: client}
+}
+
+// Claim implements ClaimStore.
+func (s *RedisClaimStore) Claim(ctx context.Context, key, value string, ttl time.Duration) (bool, string, error) {
+	ok, err := s.client.SetNX(ctx, key, value, ttl).Result()
+	if err != nil || ok {
+		return ok, "", err
+	}
+	held, err := s.client.Get(ctx, key).Result()
+	if errors.Is(err, redis.Nil) {
+		// Expired between the two calls; try once more.
+		ok, err = s.client.SetNX(ctx, key, value, ttl).Result()
+		return ok, "", err
+	}
+	return false, held, err
+}
+
+// Complete implements ClaimStore.
+func (s *RedisClaimStore) Complete(ctx context.Context, key, value string, ttl time.Duration) error {
+	return s.client.Set(ctx, key, value, ttl).Err()
+}
+
+// Release implements ClaimStore.
+func (s *RedisClaimStore) Release(ctx context.Context, key string) error {
+	return s.client.Del(ctx, key).Err()
+}
+
+// claimKey scopes a claim to a user, e.g. manager:email_sent:{user}:{message_id}.
+func claimKey(kind, userID, id string) string {
+	return strings.Join([]string{"manager", kind, strings.TrimSpace(userID), id}, ":")
+}
@@ -1,8 +1,11 @@
 package manager
 
 import (
+	"fmt"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 )
 
 const (
@@ -11,30 +14,78 @@ const (
 	defaultManagerPlannerURL      = "http://localhost:8080/planner/run"
 	defaultManagerProdControlURL  = "http://localhost:8080/prod/control/recompute"
 	defaultManagerWhiteboardStart = "" // empty -> tail only new entries
+	defaultManagerClaimIdle       = time.Minute
+	defaultManagerMaxAttempts     = 5
+	defaultManagerOAuthRedirect   = "http://localhost:8080/auth/google/callback"
 )
 
 // RuntimeConfig holds the minimal settings needed to bootstrap the Manager runtime.
 type RuntimeConfig struct {
+	// Users are watched from startup. With UseRegistry they only seed the user
+	// registry, and users with the manager feature are added or removed at runtime.
 	Users          []string
+	UseRegistry    bool
 	RedisURL       string
 	PlannerURL     string
 	ProdControlURL string
 	ListenAddr     string
 	StartAfterID   string
+
+	// ConsumerGroup switches the whiteboard tail from XREAD to XREADGROUP so several
+	// manager replicas can share the load. Empty keeps the single-process tail mode.
+	ConsumerGroup string
+	ConsumerName  string
+	// ClaimIdle is how long an entry may sit unacknowledged before another consumer
+	// reclaims it with XAUTOCLAIM. Zero disables reclaiming.
+	ClaimIdle time.Duration
+	// MaxAttempts is how many deliveries a failing entry gets in group mode before it
+	// is moved to the user's dead-letter stream, user:{id}:dlq:manager.
+	MaxAttempts int64
+
+	// GmailClientSecret enables sending approved email replies; empty leaves email actions unconfigured.
+	GmailClientSecret string
+	OAuthRedirectURL  string
+
+	// ServiceToken authenticates calls to the cloud API when it enforces bearer auth.
+	ServiceToken string
 }
 
 // RuntimeConfigFromEnv builds a RuntimeConfig using environment variables with safe defaults.
 func RuntimeConfigFromEnv() RuntimeConfig {
+	useRegistry := !strings.EqualFold(pickEnv("MANAGER_USER_REGISTRY", "true"), "false")
+	fallbackUsers := ""
+	if !useRegistry {
+		fallbackUsers = "test-user"
+	}
 	return RuntimeConfig{
-		Users:          parseUserList(os.Getenv("MANAGER_USERS"), "test-user"),
+		Users:          parseUserList(os.Getenv("MANAGER_USERS"), fallbackUsers),
+		UseRegistry:    useRegistry,
 		RedisURL:       pickEnv("REDIS_URL", defaultManagerRedisURL),
 		PlannerURL:     pickEnv("MANAGER_PLANNER_URL", defaultManagerPlannerURL),
 		ProdControlURL: pickEnv("MANAGER_PROD_CONTROL_URL", defaultManagerProdControlURL),
 		ListenAddr:     pickEnv("MANAGER_LISTEN_ADDR", defaultManagerListenAddr),
 		StartAfterID:   pickEnv("MANAGER_WB_AFTER", defaultManagerWhiteboardStart),
+		ConsumerGroup:  pickEnv("MANAGER_WB_GROUP", ""),
+		ConsumerName:   pickEnv("MANAGER_WB_CONSUMER", defaultConsumerName()),
+		ClaimIdle:      pickDuration("MANAGER_WB_CLAIM_IDLE", defaultManagerClaimIdle),
+		MaxAttempts:    pickInt("MANAGER_WB_MAX_ATTEMPTS", defaultManagerMaxAttempts),
+
+		GmailClientSecret: pickEnv("GMAIL_CLIENT_SECRET", ""),
+		OAuthRedirectURL:  pickEnv("OAUTH_REDIRECT_URL", defaultManagerOAuthRedirect),
+
+		ServiceToken: pickEnv("MANAGER_SERVICE_TOKEN", ""),
 	}
 }
 
+// defaultConsumerName identifies this process within the manager consumer group.
+func defaultConsumerName() string {
+	host, err := os.Hostname()
+	if err != nil || strings.TrimSpace(host) == "" {
+		host = "manager"
+	}
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}
+
 func parseUserList(raw string, fallback string) []string {
 	if strings.TrimSpace(raw) == "" {
 		raw = fallback
@@ -62,3 +113,21 @@ func pickEnv(key, fallback string) string {
 	}
 	return fallback
 }
+
+func pickDuration(key string, fallback time.Duration) time.Duration {
+	if raw := strings.TrimSpace(os.Getenv(key)); raw != "" {
+		if d, err := time.ParseDuration(raw); err == nil && d >= 0 {
+			return d
+		}
+	}
+	return fallback
+}
+
+func pickInt(key string, fallback int64) int64 {
+	if raw := strings.TrimSpace(os.Getenv(key)); raw != "" {
+		if n, err := strconv.ParseInt(raw, 10, 64); err == nil && n > 0 {
+			return n
+		}
+	}
+	return fallback
+}
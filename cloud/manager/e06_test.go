@@ -40,7 +40,7 @@ func TestE06Requirements(t *testing.T) {
 	}
 
 	// Run the event through the graph
-	err = graph.Run(context.Background(), evt)
+	_, err = graph.Run(context.Background(), evt)
 	require.NoError(t, err)
 
 	// Verify exactly one prompt append occurred
@@ -137,7 +137,7 @@ func TestE06NodePaths(t *testing.T) {
 		t.Run(tc.name, func(t *testing.T) {
 			// Capture log output to verify routing
 			originalAppend := bus.appends
-			err := graph.Run(context.Background(), tc.evt)
+			_, err := graph.Run(context.Background(), tc.evt)
 			require.NoError(t, err)
 
 			// For prod events that should generate prompts, verify exactly one
@@ -175,7 +175,7 @@ func TestE06Idempotency(t *testing.T) {
 
 	// Run the same event multiple times
 	for i := 0; i < 3; i++ {
-		err := graph.Run(context.Background(), evt)
+		_, err := graph.Run(context.Background(), evt)
 		require.NoError(t, err)
 	}
 
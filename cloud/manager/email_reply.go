@@ -0,0 +1,144 @@
+package manager
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"mime"
+	"strings"
+
+	"alfred-cloud/security"
+	"google.golang.org/api/gmail/v1"
+)
+
+// EmailReply is a reply the user approved from an email.reply_needed prompt.
+type EmailReply struct {
+	MessageID string
+	Body      string
+}
+
+// EmailReplier delivers approved replies on the user's behalf.
+type EmailReplier interface {
+	SendReply(ctx context.Context, userID string, reply EmailReply) (string, error)
+	CreateDraft(ctx context.Context, userID string, reply EmailReply) (string, error)
+}
+
+// GmailReplier answers Gmail messages in-thread using the user's stored OAuth token.
+type GmailReplier struct {
+	service func(ctx context.Context, userID string) (*gmail.Service, error)
+}
+
+// NewGmailReplier builds a replier backed by the Google service client.
+func NewGmailReplier(client *security.GoogleServiceClient) *GmailReplier {
+	if client == nil {
+		return nil
+	}
+	return &GmailReplier{service: client.GetGmailService}
+}
+
+// SendReply sends the reply in the original message's thread and returns the sent message id.
+func (r *GmailReplier) SendReply(ctx context.Context, userID string, reply EmailReply) (string, error) {
+	svc, msg, err := r.prepare(ctx, userID, reply)
+	if err != nil {
+		return "", err
+	}
+	sent, err := svc.Users.Messages.Send("me", msg).Context(ctx).Do()
+	if err != nil {
+		return "", fmt.Errorf("gmail send failed: %w", err)
+	}
+	return sent.Id, nil
+}
+
+// CreateDraft stores the reply as a Gmail draft in the original thread and returns the draft id.
+func (r *GmailReplier) CreateDraft(ctx context.Context, userID string, reply EmailReply) (string, error) {
+	svc, msg, err := r.prepare(ctx, userID, reply)
+	if err != nil {
+		return "", err
+	}
+	draft, err := svc.Users.Drafts.Create("me", &gmail.Draft{Message: msg}).Context(ctx).Do()
+	if err != nil {
+		return "", fmt.Errorf("gmail draft failed: %w", err)
+	}
+	return draft.Id, nil
+}
+
+func (r *GmailReplier) prepare(ctx context.Context, userID string, reply EmailReply) (*gmail.Service, *gmail.Message, error) {
+	if r == nil || r.service == nil {
+		return nil, nil, errors.New("gmail replier not configured")
+	}
+	if strings.TrimSpace(reply.MessageID) == "" {
+		return nil, nil, errors.New("message id is required")
+	}
+	svc, err := r.service(ctx, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	orig, err := svc.Users.Messages.Get("me", reply.MessageID).
+		Format("metadata").
+		MetadataHeaders("Subject", "From", "Reply-To", "Message-ID", "References").
+		Context(ctx).
+		Do()
+	if err != nil {
+		return nil, nil, fmt.Errorf("gmail fetch original %s failed: %w", reply.MessageID, err)
+	}
+
+	raw := buildReplyMIME(headerMap(orig), reply.Body)
+	return svc, &gmail.Message{
+		Raw:      base64.URLEncoding.EncodeToString([]byte(raw)),
+		ThreadId: orig.ThreadId,
+	}, nil
+}
+
+func headerMap(msg *gmail.Message) map[string]string {
+	out := make(map[string]string)
+	if msg == nil || msg.Payload == nil {
+		return out
+	}
+	for _, h := range msg.Payload.Headers {
+		out[strings.ToLower(h.Name)] = h.Value
+	}
+	return out
+}
+
+// buildReplyMIME renders an RFC 2822 reply that Gmail threads under the original message.
+func buildReplyMIME(orig map[string]string, body string) string {
+	to := orig["reply-to"]
+	if strings.TrimSpace(to) == "" {
+		to = orig["from"]
+	}
+	subject := strings.TrimSpace(orig["subject"])
+	if !strings.HasPrefix(strings.ToLower(subject), "re:") {
+		subject = strings.TrimSpace("Re: " + subject)
+	}
+	messageID := orig["message-id"]
+	references := strings.TrimSpace(orig["references"] + " " + messageID)
+
+	var b strings.Builder
+	writeHeader(&b, "To", to)
+	writeHeader(&b, "Subject", mime.QEncoding.Encode("utf-8", sanitizeHeader(subject)))
+	writeHeader(&b, "In-Reply-To", messageID)
+	writeHeader(&b, "References", references)
+	writeHeader(&b, "MIME-Version", "1.0")
+	writeHeader(&b, "Content-Type", `text/plain; charset="UTF-8"`)
+	b.WriteString("\r\n")
+	b.WriteString(body)
+	return b.String()
+}
+
+func writeHeader(b *strings.Builder, name, value string) {
+	value = sanitizeHeader(value)
+	if value == "" {
+		return
+	}
+	b.WriteString(name)
+	b.WriteString(": ")
+	b.WriteString(value)
+	b.WriteString("\r\n")
+}
+
+// sanitizeHeader strips line breaks so user-controlled values cannot inject headers.
+func sanitizeHeader(value string) string {
+	return strings.TrimSpace(strings.NewReplacer("\r", " ", "\n", " ").Replace(value))
+}
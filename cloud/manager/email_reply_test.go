@@ -0,0 +1,256 @@
+package manager
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"alfred-cloud/wb"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/gmail/v1"
+	"google.golang.org/api/option"
+)
+
+type fakeReplier struct {
+	sent    []EmailReply
+	drafts  []EmailReply
+	sendErr error
+}
+
+func (f *fakeReplier) SendReply(ctx context.Context, userID string, reply EmailReply) (string, error) {
+	if f.sendErr != nil {
+		return "", f.sendErr
+	}
+	f.sent = append(f.sent, reply)
+	return "sent-1", nil
+}
+
+func (f *fakeReplier) CreateDraft(ctx context.Context, userID string, reply EmailReply) (string, error) {
+	f.drafts = append(f.drafts, reply)
+	return "draft-1", nil
+}
+
+func TestEmailReplyNeededEmitsPromptWithDraft(t *testing.T) {
+	bus := &stubBus{}
+	graph, err := NewManagerGraph(GraphConfig{PlannerURL: "http://example.com/planner/run", Bus: bus})
+	require.NoError(t, err)
+
+	_, err = graph.Run(context.Background(), NormalizedEvent{
+		WBID:     "10-0",
+		UserID:   "user-1",
+		ThreadID: "gmail-thread-1",
+		Event: Event{
+			Source: "email",
+			Kind:   "reply_needed",
+			Payload: map[string]any{
+				"message_id": "msg-1",
+				"sender":     "Dana <dana@example.com>",
+				"summary":    "asks to move the review",
+				"draft":      "Thursday works for me.",
+			},
+		},
+	})
+	require.NoError(t, err)
+
+	require.Len(t, bus.appends, 1)
+	call := bus.appends[0]
+	require.Equal(t, "manager.prompt", call.values["type"])
+	require.Equal(t, "email", call.values["source"])
+	require.Equal(t, "msg-1", call.values["message_id"])
+	require.Equal(t, "Thursday works for me.", call.values["draft"])
+	require.Equal(t, "send,edit,dismiss", call.values["choices"])
+	require.Contains(t, call.values["content"], "Dana")
+}
+
+func TestEmailUserActionSendsReplyOnce(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer client.Close()
+	ctx := context.Background()
+
+	bus := wb.NewBus(client)
+	replier := &fakeReplier{}
+	graph, err := NewManagerGraph(GraphConfig{PlannerURL: "http://example.com/planner/run", Bus: bus, Email: replier})
+	require.NoError(t, err)
+
+	promptRes, err := graph.Run(ctx, NormalizedEvent{
+		WBID:     "10-0",
+		UserID:   "user-1",
+		ThreadID: "gmail-thread-1",
+		Event: Event{
+			Source: "email",
+			Kind:   "reply_needed",
+			Payload: map[string]any{
+				"message_id": "msg-1",
+				"sender":     "dana@example.com",
+				"summary":    "asks to move the review",
+				"draft":      "Thursday works for me.",
+			},
+		},
+	})
+	require.NoError(t, err)
+	require.NotEmpty(t, promptRes.PromptID)
+
+	// The action arrives through the whiteboard, so metadata is a JSON string.
+	actionEntry := wb.Event{
+		ID:       "20-0",
+		UserID:   "user-1",
+		ThreadID: "gmail-thread-1",
+		Values: map[string]any{
+			"type":      "manager.user_action",
+			"action_id": promptRes.PromptID,
+			"choice":    "send",
+			"metadata":  `{"draft":"Thursday at 3 works for me."}`,
+		},
+	}
+	action, err := NormalizeWhiteboardEvent(actionEntry)
+	require.NoError(t, err)
+
+	res, err := graph.Run(ctx, action)
+	require.NoError(t, err)
+	require.Equal(t, []string{"email_send:msg-1"}, res.SideEffects)
+	require.Len(t, replier.sent, 1)
+	require.Equal(t, EmailReply{MessageID: "msg-1", Body: "Thursday at 3 works for me."}, replier.sent[0])
+
+	// A replayed send, or a later edit, must not touch Gmail again.
+	cp := applyRunResult(Checkpoint{}, action.WBID, res)
+	_, err = graph.Run(WithCheckpoint(ctx, cp), action)
+	require.NoError(t, err)
+
+	action.Event.Payload["choice"] = "edit"
+	_, err = graph.Run(WithCheckpoint(ctx, cp), action)
+	require.NoError(t, err)
+
+	require.Len(t, replier.sent, 1)
+	require.Empty(t, replier.drafts)
+}
+
+func TestEmailUserActionSendIsClaimedAcrossThreads(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer client.Close()
+	ctx := context.Background()
+
+	replier := &fakeReplier{sendErr: errors.New("gmail unavailable")}
+	graph, err := NewManagerGraph(GraphConfig{
+		PlannerURL: "http://example.com/planner/run",
+		Bus:        &stubBus{},
+		Email:      replier,
+		Claims:     NewRedisClaimStore(client),
+	})
+	require.NoError(t, err)
+
+	// Each tap posts on a fresh thread, so no checkpoint is shared between them.
+	action := func(wbID, threadID string) NormalizedEvent {
+		return NormalizedEvent{
+			WBID:     wbID,
+			UserID:   "user-1",
+			ThreadID: threadID,
+			Event: Event{
+				Source: "manager",
+				Kind:   "user_action",
+				Payload: map[string]any{
+					"action_id": "prompt-1",
+					"choice":    "send",
+					"metadata":  map[string]any{"message_id": "msg-1", "draft": "Thursday works."},
+				},
+			},
+		}
+	}
+
+	_, err = graph.Run(ctx, action("30-0", "tap-1"))
+	require.Error(t, err)
+	require.False(t, mr.Exists("manager:email_sent:user-1:msg-1"), "a failed send must release its claim")
+
+	replier.sendErr = nil
+	res, err := graph.Run(ctx, action("31-0", "tap-2"))
+	require.NoError(t, err)
+	require.Equal(t, []string{"email_send:msg-1"}, res.SideEffects)
+
+	res, err = graph.Run(ctx, action("32-0", "tap-3"))
+	require.NoError(t, err)
+	require.Equal(t, []string{"email_send:msg-1"}, res.SideEffects)
+	require.Len(t, replier.sent, 1)
+}
+
+func TestEmailUserActionEditCreatesDraftAndDismissIsRecorded(t *testing.T) {
+	replier := &fakeReplier{}
+	graph, err := NewManagerGraph(GraphConfig{PlannerURL: "http://example.com/planner/run", Bus: &stubBus{}, Email: replier})
+	require.NoError(t, err)
+
+	action := func(choice string) NormalizedEvent {
+		return NormalizedEvent{
+			WBID:     "30-0",
+			UserID:   "user-1",
+			ThreadID: "gmail-thread-1",
+			Event: Event{
+				Source: "manager",
+				Kind:   "user_action",
+				Payload: map[string]any{
+					"action_id": "prompt-x",
+					"choice":    choice,
+					"metadata":  map[string]any{"message_id": "msg-2", "draft": "Let me check."},
+				},
+			},
+		}
+	}
+
+	res, err := graph.Run(context.Background(), action("edit"))
+	require.NoError(t, err)
+	require.Equal(t, []string{"email_draft:msg-2"}, res.SideEffects)
+	require.Len(t, replier.drafts, 1)
+
+	res, err = graph.Run(context.Background(), action("dismiss"))
+	require.NoError(t, err)
+	require.Equal(t, []string{"email_dismiss:msg-2"}, res.SideEffects)
+	require.Empty(t, replier.sent)
+}
+
+func TestGmailReplierSendsInThread(t *testing.T) {
+	var sent gmail.Message
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/messages/msg-1"):
+			_ = json.NewEncoder(w).Encode(gmail.Message{
+				Id:       "msg-1",
+				ThreadId: "thread-9",
+				Payload: &gmail.MessagePart{Headers: []*gmail.MessagePartHeader{
+					{Name: "From", Value: "Dana <dana@example.com>"},
+					{Name: "Subject", Value: "Review\r\nBcc: attacker@example.com"},
+					{Name: "Message-ID", Value: "<abc@mail.example.com>"},
+				}},
+			})
+		case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/messages/send"):
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&sent))
+			_ = json.NewEncoder(w).Encode(gmail.Message{Id: "sent-1"})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	replier := &GmailReplier{service: func(ctx context.Context, userID string) (*gmail.Service, error) {
+		return gmail.NewService(ctx, option.WithEndpoint(srv.URL+"/"), option.WithHTTPClient(srv.Client()))
+	}}
+
+	id, err := replier.SendReply(context.Background(), "user-1", EmailReply{MessageID: "msg-1", Body: "Thursday works."})
+	require.NoError(t, err)
+	require.Equal(t, "sent-1", id)
+	require.Equal(t, "thread-9", sent.ThreadId)
+
+	raw, err := base64.URLEncoding.DecodeString(sent.Raw)
+	require.NoError(t, err)
+	mimeText := string(raw)
+	require.Contains(t, mimeText, "To: Dana <dana@example.com>\r\n")
+	require.Contains(t, mimeText, "In-Reply-To: <abc@mail.example.com>\r\n")
+	require.Contains(t, mimeText, "Subject: Re: Review")
+	require.NotContains(t, mimeText, "\r\nBcc:")
+	require.True(t, strings.HasSuffix(mimeText, "\r\n\r\nThursday works."))
+}
@@ -1,27 +1,61 @@
 package manager
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
 	"log"
+	"net/http"
 	"strings"
+	"time"
+
+	"alfred-cloud/wb"
 )
 
+const defaultGraphHTTPTimeout = 2 * time.Minute
+
 type whiteboardAppender interface {
 	AppendWithThread(ctx context.Context, userID, threadID string, values map[string]any) (string, error)
 }
 
+// whiteboardReader looks up earlier entries, e.g. the prompt a user action answers.
+type whiteboardReader interface {
+	Get(ctx context.Context, userID, id string) (*wb.Event, error)
+}
+
 // GraphConfig configures the Manager LangGraph runtime.
 type GraphConfig struct {
 	PlannerURL     string
 	ProdControlURL string
 	Bus            whiteboardAppender
+	HTTPClient     *http.Client
+	// ServiceToken is sent as a bearer token on planner and prod control calls.
+	ServiceToken string
+	// Reader resolves user actions to their prompts; defaults to Bus when it can read.
+	Reader whiteboardReader
+	// Email sends or drafts approved replies; email actions fail while it is nil.
+	Email EmailReplier
+	// Claims reserves side effects across threads and replicas; without it only
+	// the thread checkpoint dedupes them.
+	Claims ClaimStore
+}
+
+// RunResult reports what a graph run produced so the runtime can checkpoint it.
+type RunResult struct {
+	PromptID        string
+	LastPlanID      string
+	LastPlanVersion string
+	SideEffects     []string
 }
 
 // ManagerGraph is the LangGraph runtime placeholder; nodes are added in later tasks.
 type ManagerGraph struct {
 	config GraphConfig
 	bus    whiteboardAppender
+	reader whiteboardReader
+	http   *http.Client
 }
 
 // NewManagerGraph constructs a ManagerGraph with the provided configuration.
@@ -32,26 +66,37 @@ func NewManagerGraph(cfg GraphConfig) (*ManagerGraph, error) {
 	if cfg.Bus == nil {
 		return nil, fmt.Errorf("whiteboard bus is required")
 	}
+	httpClient := cfg.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: defaultGraphHTTPTimeout}
+	}
+	reader := cfg.Reader
+	if reader == nil {
+		reader, _ = cfg.Bus.(whiteboardReader)
+	}
 	return &ManagerGraph{
 		config: cfg,
 		bus:    cfg.Bus,
+		reader: reader,
+		http:   httpClient,
 	}, nil
 }
 
 // Run feeds a normalized whiteboard event through the LangGraph.
-func (g *ManagerGraph) Run(ctx context.Context, evt NormalizedEvent) error {
+// A checkpoint attached via WithCheckpoint suppresses side effects already recorded.
+func (g *ManagerGraph) Run(ctx context.Context, evt NormalizedEvent) (RunResult, error) {
 	if g == nil || g.bus == nil {
-		return fmt.Errorf("manager graph not initialized")
+		return RunResult{}, fmt.Errorf("manager graph not initialized")
 	}
 	return g.ingestWB(ctx, evt)
 }
 
-func (g *ManagerGraph) ingestWB(ctx context.Context, evt NormalizedEvent) error {
+func (g *ManagerGraph) ingestWB(ctx context.Context, evt NormalizedEvent) (RunResult, error) {
 	log.Printf("manager graph node=ingest_wb wb=%s user=%s thread=%s type=%s.%s", evt.WBID, evt.UserID, evt.ThreadID, evt.Event.Source, evt.Event.Kind)
 	return g.router(ctx, evt)
 }
 
-func (g *ManagerGraph) router(ctx context.Context, evt NormalizedEvent) error {
+func (g *ManagerGraph) router(ctx context.Context, evt NormalizedEvent) (RunResult, error) {
 	switch strings.ToLower(strings.TrimSpace(evt.Event.Source)) {
 	case "calendar":
 		log.Printf("manager graph node=router next=calendar_branch wb=%s", evt.WBID)
@@ -69,51 +114,215 @@ func (g *ManagerGraph) router(ctx context.Context, evt NormalizedEvent) error {
 		}
 	}
 	log.Printf("manager graph node=router drop wb=%s type=%s.%s", evt.WBID, evt.Event.Source, evt.Event.Kind)
-	return nil
+	return RunResult{}, nil
 }
 
-func (g *ManagerGraph) calendarBranch(ctx context.Context, evt NormalizedEvent) error {
+func (g *ManagerGraph) calendarBranch(ctx context.Context, evt NormalizedEvent) (RunResult, error) {
 	log.Printf("manager graph node=calendar_branch wb=%s kind=%s", evt.WBID, evt.Event.Kind)
-	return nil
+	switch strings.ToLower(strings.TrimSpace(evt.Event.Kind)) {
+	case "plan.proposed":
+		deltaID := strings.TrimSpace(stringFromPayload(evt.Event.Payload, "delta_id"))
+		res, err := g.emitPrompt(ctx, evt, g.maybePromptUser(evt), map[string]any{
+			"delta_id": deltaID,
+			"choices":  "accept,reject",
+		})
+		res.LastPlanID = deltaID
+		return res, err
+	case "plan.new_version":
+		return g.applyNewPlanVersion(ctx, evt)
+	}
+	log.Printf("manager graph node=calendar_branch wb=%s decision=drop kind=%s", evt.WBID, evt.Event.Kind)
+	return RunResult{}, nil
 }
 
-func (g *ManagerGraph) prodBranch(ctx context.Context, evt NormalizedEvent) error {
+// applyNewPlanVersion runs planner_call -> prod_recalc_signal -> emit_plan_outcome. Each step is
+// keyed in the checkpoint so a retried run only repeats the steps that did not complete.
+func (g *ManagerGraph) applyNewPlanVersion(ctx context.Context, evt NormalizedEvent) (RunResult, error) {
+	res := RunResult{
+		LastPlanID:      strings.TrimSpace(stringFromPayload(evt.Event.Payload, "plan_id")),
+		LastPlanVersion: strings.TrimSpace(stringFromPayload(evt.Event.Payload, "version")),
+	}
+
+	planner, err := g.plannerCall(ctx, evt)
+	res.SideEffects = append(res.SideEffects, planner.SideEffects...)
+	if err != nil {
+		return res, err
+	}
+
+	prod, err := g.prodRecalcSignal(ctx, evt)
+	res.SideEffects = append(res.SideEffects, prod.SideEffects...)
+	if err != nil {
+		return res, err
+	}
+
+	outcome, err := g.emitPlanOutcome(ctx, evt, planner, prod)
+	res.SideEffects = append(res.SideEffects, outcome.SideEffects...)
+	return res, err
+}
+
+func (g *ManagerGraph) prodBranch(ctx context.Context, evt NormalizedEvent) (RunResult, error) {
 	log.Printf("manager graph node=prod_branch wb=%s kind=%s", evt.WBID, evt.Event.Kind)
 	prompt := g.maybePromptUser(evt)
 	if prompt == "" {
 		log.Printf("manager graph node=prod_branch wb=%s decision=no_prompt", evt.WBID)
-		return nil
+		return RunResult{}, nil
 	}
-	return g.emitPrompt(ctx, evt, prompt)
-}
-
-func (g *ManagerGraph) emailBranch(ctx context.Context, evt NormalizedEvent) error {
-	log.Printf("manager graph node=email_branch wb=%s kind=%s", evt.WBID, evt.Event.Kind)
-	return nil
+	return g.emitPrompt(ctx, evt, prompt, nil)
 }
 
-func (g *ManagerGraph) userActionBranch(ctx context.Context, evt NormalizedEvent) error {
-	log.Printf("manager graph node=user_action_branch wb=%s kind=%s", evt.WBID, evt.Event.Kind)
-	return nil
+// stepOutcome is the result of one outbound call made by the calendar branch.
+type stepOutcome struct {
+	Status      string
+	Response    map[string]any
+	SideEffects []string
 }
 
-func (g *ManagerGraph) plannerCall(ctx context.Context, evt NormalizedEvent) error {
+func (g *ManagerGraph) plannerCall(ctx context.Context, evt NormalizedEvent) (stepOutcome, error) {
 	log.Printf("manager graph node=planner_call wb=%s", evt.WBID)
-	return nil
+	key := sideEffectKey("planner_call", evt)
+	if g.sideEffectDone(ctx, key) {
+		log.Printf("manager graph node=planner_call wb=%s skip=already_called", evt.WBID)
+		return stepOutcome{Status: "replayed"}, nil
+	}
+
+	planID := stringFromPayload(evt.Event.Payload, "plan_id")
+	version := stringFromPayload(evt.Event.Payload, "version")
+	timeBlock := strings.TrimSpace(stringFromPayload(evt.Event.Payload, "time_block"))
+	if timeBlock == "" {
+		timeBlock = fmt.Sprintf("Replan for plan %s version %s", planID, version)
+	}
+
+	body := map[string]any{
+		"user_id":    evt.UserID,
+		"thread_id":  evt.ThreadID,
+		"plan_id":    planID,
+		"version":    version,
+		"time_block": timeBlock,
+	}
+	if planDate := strings.TrimSpace(stringFromPayload(evt.Event.Payload, "plan_date")); planDate != "" {
+		body["plan_date"] = planDate
+	}
+
+	resp, err := g.postJSON(ctx, g.config.PlannerURL, body)
+	if err != nil {
+		return stepOutcome{}, fmt.Errorf("planner_call failed for wb=%s: %w", evt.WBID, err)
+	}
+	return stepOutcome{Status: "ok", Response: resp, SideEffects: []string{key}}, nil
 }
 
-func (g *ManagerGraph) prodRecalcSignal(ctx context.Context, evt NormalizedEvent) error {
+func (g *ManagerGraph) prodRecalcSignal(ctx context.Context, evt NormalizedEvent) (stepOutcome, error) {
 	log.Printf("manager graph node=prod_recalc_signal wb=%s", evt.WBID)
-	return nil
+	if strings.TrimSpace(g.config.ProdControlURL) == "" {
+		return stepOutcome{Status: "skipped"}, nil
+	}
+	key := sideEffectKey("prod_recalc_signal", evt)
+	if g.sideEffectDone(ctx, key) {
+		log.Printf("manager graph node=prod_recalc_signal wb=%s skip=already_signaled", evt.WBID)
+		return stepOutcome{Status: "replayed"}, nil
+	}
+
+	resp, err := g.postJSON(ctx, g.config.ProdControlURL, map[string]any{
+		"user_id":   evt.UserID,
+		"thread_id": evt.ThreadID,
+		"plan_id":   stringFromPayload(evt.Event.Payload, "plan_id"),
+		"version":   stringFromPayload(evt.Event.Payload, "version"),
+	})
+	if err != nil {
+		return stepOutcome{}, fmt.Errorf("prod_recalc_signal failed for wb=%s: %w", evt.WBID, err)
+	}
+	return stepOutcome{Status: "ok", Response: resp, SideEffects: []string{key}}, nil
+}
+
+func (g *ManagerGraph) emitPlanOutcome(ctx context.Context, evt NormalizedEvent, planner, prod stepOutcome) (RunResult, error) {
+	key := sideEffectKey("emit_plan_outcome", evt)
+	if g.sideEffectDone(ctx, key) {
+		log.Printf("manager graph node=emit_plan_outcome wb=%s skip=already_emitted", evt.WBID)
+		return RunResult{}, nil
+	}
+
+	values := map[string]any{
+		"type":           "manager.plan_outcome",
+		"plan_id":        stringFromPayload(evt.Event.Payload, "plan_id"),
+		"version":        stringFromPayload(evt.Event.Payload, "version"),
+		"planner_status": planner.Status,
+		"prod_status":    prod.Status,
+		"wb_parent_id":   evt.WBID,
+	}
+	if blocks, ok := planner.Response["blocks"].([]any); ok {
+		values["planner_blocks"] = len(blocks)
+	}
+	if notes, ok := planner.Response["notes"].([]any); ok && len(notes) > 0 {
+		if raw, err := json.Marshal(notes); err == nil {
+			values["planner_notes"] = string(raw)
+		}
+	}
+
+	id, err := g.bus.AppendWithThread(ctx, evt.UserID, evt.ThreadID, values)
+	if err != nil {
+		return RunResult{}, fmt.Errorf("emit_plan_outcome failed for wb=%s: %w", evt.WBID, err)
+	}
+	log.Printf("manager graph node=emit_plan_outcome wb=%s outcome_id=%s planner=%s prod=%s", evt.WBID, id, planner.Status, prod.Status)
+	return RunResult{SideEffects: []string{key}}, nil
+}
+
+// postJSON POSTs body to url and decodes a JSON object response (empty bodies are allowed).
+func (g *ManagerGraph) postJSON(ctx context.Context, url string, body map[string]any) (map[string]any, error) {
+	if strings.TrimSpace(url) == "" {
+		return nil, fmt.Errorf("endpoint not configured")
+	}
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token := strings.TrimSpace(g.config.ServiceToken); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := g.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("status %d: %s", resp.StatusCode, strings.TrimSpace(string(raw)))
+	}
+
+	out := map[string]any{}
+	if len(bytes.TrimSpace(raw)) > 0 {
+		if err := json.Unmarshal(raw, &out); err != nil {
+			return nil, fmt.Errorf("decode response: %w", err)
+		}
+	}
+	return out, nil
 }
 
 func (g *ManagerGraph) maybePromptUser(evt NormalizedEvent) string {
 	source := strings.ToLower(strings.TrimSpace(evt.Event.Source))
+	kind := strings.ToLower(strings.TrimSpace(evt.Event.Kind))
+	if source == "calendar" && kind == "plan.proposed" {
+		summary := strings.TrimSpace(stringFromPayload(evt.Event.Payload, "summary"))
+		if summary == "" {
+			summary = "A calendar change is proposed"
+		}
+		if impact := strings.TrimSpace(stringFromPayload(evt.Event.Payload, "impact")); impact != "" {
+			return fmt.Sprintf("%s (%s). Should I apply it?", summary, impact)
+		}
+		return fmt.Sprintf("%s. Should I apply it?", summary)
+	}
 	if source != "prod" {
 		return ""
 	}
 
-	kind := strings.ToLower(strings.TrimSpace(evt.Event.Kind))
 	activity := strings.TrimSpace(stringFromPayload(evt.Event.Payload, "activity_label"))
 	if activity == "" {
 		activity = "this block"
@@ -126,12 +335,52 @@ func (g *ManagerGraph) maybePromptUser(evt NormalizedEvent) string {
 		return fmt.Sprintf("You seem behind on %s. Want to adjust?", activity)
 	case "nudge":
 		return fmt.Sprintf("Time to get back to %s?", activity)
+	case "allowlist":
+		observed := strings.TrimSpace(stringFromPayload(evt.Event.Payload, "observed"))
+		if observed == "" {
+			return ""
+		}
+		return fmt.Sprintf("Should I count %s as part of %s from now on?", observed, activity)
 	default:
 		return ""
 	}
 }
 
-func (g *ManagerGraph) emitPrompt(ctx context.Context, evt NormalizedEvent, prompt string) error {
+const (
+	// promptClaimPendingTTL bounds how long an append in flight blocks a retry of the
+	// same entry; it stays under the default claim idle so a crashed run is retried.
+	promptClaimPendingTTL = 30 * time.Second
+	// promptClaimTTL keeps the emitted prompt id for retries that never saw the checkpoint.
+	promptClaimTTL     = 24 * time.Hour
+	promptClaimPending = "pending"
+)
+
+// emitPrompt appends a manager.prompt for evt; extra carries branch-specific fields the client
+// needs to answer (for example the calendar delta_id).
+func (g *ManagerGraph) emitPrompt(ctx context.Context, evt NormalizedEvent, prompt string, extra map[string]any) (RunResult, error) {
+	key := sideEffectKey("emit_prompt", evt)
+	if g.sideEffectDone(ctx, key) {
+		log.Printf("manager graph node=emit_prompt wb=%s skip=already_emitted key=%s", evt.WBID, key)
+		return RunResult{}, nil
+	}
+
+	// The checkpoint is only saved after the run, so claim the prompt before appending
+	// it; a retry after a crash or failed save then finds the prompt id instead.
+	claim := claimKey("prompt", evt.UserID, evt.WBID)
+	if g.config.Claims != nil {
+		ok, held, err := g.config.Claims.Claim(ctx, claim, promptClaimPending, promptClaimPendingTTL)
+		if err != nil {
+			return RunResult{}, fmt.Errorf("emit_prompt claim failed for wb=%s: %w", evt.WBID, err)
+		}
+		if !ok {
+			if held == promptClaimPending {
+				return RunResult{}, fmt.Errorf("emit_prompt for wb=%s is already in flight", evt.WBID)
+			}
+			log.Printf("manager graph node=emit_prompt wb=%s skip=already_emitted prompt_id=%s", evt.WBID, held)
+			return RunResult{PromptID: held, SideEffects: []string{key}}, nil
+		}
+	}
+
 	values := map[string]any{
 		"type":         "manager.prompt",
 		"source":       evt.Event.Source,
@@ -140,14 +389,41 @@ func (g *ManagerGraph) emitPrompt(ctx context.Context, evt NormalizedEvent, prom
 		"prompt":       prompt,
 		"wb_parent_id": evt.WBID,
 	}
+	for k, v := range extra {
+		if _, reserved := values[k]; !reserved {
+			values[k] = v
+		}
+	}
 
 	id, err := g.bus.AppendWithThread(ctx, evt.UserID, evt.ThreadID, values)
 	if err != nil {
-		return fmt.Errorf("emit_prompt failed for wb=%s: %w", evt.WBID, err)
+		if g.config.Claims != nil {
+			if relErr := g.config.Claims.Release(context.WithoutCancel(ctx), claim); relErr != nil {
+				log.Printf("manager graph node=emit_prompt wb=%s release claim failed: %v", evt.WBID, relErr)
+			}
+		}
+		return RunResult{}, fmt.Errorf("emit_prompt failed for wb=%s: %w", evt.WBID, err)
+	}
+	if g.config.Claims != nil {
+		if err := g.config.Claims.Complete(ctx, claim, id, promptClaimTTL); err != nil {
+			// The prompt is out; the checkpoint still records it once this run is saved.
+			log.Printf("manager graph node=emit_prompt wb=%s complete claim failed: %v", evt.WBID, err)
+		}
 	}
 
 	log.Printf("manager graph node=emit_prompt wb=%s prompt_id=%s user=%s thread=%s", evt.WBID, id, evt.UserID, evt.ThreadID)
-	return nil
+	return RunResult{PromptID: id, SideEffects: []string{key}}, nil
+}
+
+// sideEffectKey is the idempotency key recorded when node acts on a wb entry.
+func sideEffectKey(node string, evt NormalizedEvent) string {
+	return node + ":" + evt.WBID
+}
+
+// sideEffectDone reports whether the checkpoint attached to ctx already holds key.
+func (g *ManagerGraph) sideEffectDone(ctx context.Context, key string) bool {
+	cp, ok := checkpointFromContext(ctx)
+	return ok && sideEffectRecorded(cp, key)
 }
 
 func stringFromPayload(payload map[string]any, key string) string {
@@ -0,0 +1,171 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// emailSentClaimTTL is how long a sent reply blocks another send for the same message.
+const emailSentClaimTTL = 30 * 24 * time.Hour
+
+func (g *ManagerGraph) emailBranch(ctx context.Context, evt NormalizedEvent) (RunResult, error) {
+	log.Printf("manager graph node=email_branch wb=%s kind=%s", evt.WBID, evt.Event.Kind)
+	if strings.ToLower(strings.TrimSpace(evt.Event.Kind)) != "reply_needed" {
+		log.Printf("manager graph node=email_branch wb=%s decision=drop kind=%s", evt.WBID, evt.Event.Kind)
+		return RunResult{}, nil
+	}
+
+	sender := strings.TrimSpace(stringFromPayload(evt.Event.Payload, "sender"))
+	if sender == "" {
+		sender = "someone"
+	}
+	summary := strings.TrimSpace(stringFromPayload(evt.Event.Payload, "summary"))
+	prompt := fmt.Sprintf("%s is waiting on a reply. Send the drafted response?", sender)
+	if summary != "" {
+		prompt = fmt.Sprintf("%s is waiting on a reply: %s. Send the drafted response?", sender, summary)
+	}
+
+	return g.emitPrompt(ctx, evt, prompt, map[string]any{
+		"message_id": stringFromPayload(evt.Event.Payload, "message_id"),
+		"sender":     stringFromPayload(evt.Event.Payload, "sender"),
+		"summary":    summary,
+		"draft":      stringFromPayload(evt.Event.Payload, "draft"),
+		"choices":    "send,edit,dismiss",
+	})
+}
+
+func (g *ManagerGraph) userActionBranch(ctx context.Context, evt NormalizedEvent) (RunResult, error) {
+	log.Printf("manager graph node=user_action_branch wb=%s kind=%s", evt.WBID, evt.Event.Kind)
+
+	actionID := strings.TrimSpace(stringFromPayload(evt.Event.Payload, "action_id"))
+	choice := strings.ToLower(strings.TrimSpace(stringFromPayload(evt.Event.Payload, "choice")))
+	meta, _ := evt.Event.Payload["metadata"].(map[string]any)
+
+	prompt, err := g.lookupPrompt(ctx, evt.UserID, actionID)
+	if err != nil {
+		return RunResult{}, fmt.Errorf("user_action lookup failed for wb=%s action=%s: %w", evt.WBID, actionID, err)
+	}
+
+	source := ""
+	if prompt != nil {
+		source = strings.ToLower(stringFromPayload(prompt, "source"))
+	}
+	if source == "" && stringFromPayload(meta, "message_id") != "" {
+		source = "email"
+	}
+
+	switch source {
+	case "email":
+		return g.emailAction(ctx, evt, prompt, meta, choice)
+	}
+	log.Printf("manager graph node=user_action_branch wb=%s decision=drop action=%s source=%q", evt.WBID, actionID, source)
+	return RunResult{}, nil
+}
+
+// lookupPrompt returns the values of the manager.prompt a user action answers, or nil.
+func (g *ManagerGraph) lookupPrompt(ctx context.Context, userID, actionID string) (map[string]any, error) {
+	if g.reader == nil || actionID == "" {
+		return nil, nil
+	}
+	entry, err := g.reader.Get(ctx, userID, actionID)
+	if err != nil || entry == nil {
+		return nil, err
+	}
+	if strings.ToLower(stringVal(entry.Values["type"])) != "manager.prompt" {
+		return nil, nil
+	}
+	return entry.Values, nil
+}
+
+// emailAction applies send/edit/dismiss to the reply offered by an email prompt. Once a reply
+// has been sent for a message every later action on it is ignored.
+func (g *ManagerGraph) emailAction(ctx context.Context, evt NormalizedEvent, prompt, meta map[string]any, choice string) (RunResult, error) {
+	messageID := firstNonEmpty(stringFromPayload(meta, "message_id"), stringFromPayload(prompt, "message_id"))
+	if messageID == "" {
+		log.Printf("manager graph node=email_action wb=%s decision=drop reason=no_message_id", evt.WBID)
+		return RunResult{}, nil
+	}
+	draft := firstNonEmpty(stringFromPayload(meta, "draft"), stringFromPayload(prompt, "draft"))
+
+	sendKey := "email_send:" + messageID
+	if g.sideEffectDone(ctx, sendKey) {
+		log.Printf("manager graph node=email_action wb=%s message=%s skip=already_sent", evt.WBID, messageID)
+		return RunResult{}, nil
+	}
+
+	var key string
+	switch choice {
+	case "send":
+		key = sendKey
+	case "edit":
+		key = "email_draft:" + messageID
+	case "dismiss":
+		key = "email_dismiss:" + messageID
+	default:
+		log.Printf("manager graph node=email_action wb=%s decision=drop choice=%q", evt.WBID, choice)
+		return RunResult{}, nil
+	}
+	if g.sideEffectDone(ctx, key) {
+		log.Printf("manager graph node=email_action wb=%s message=%s skip=already_done key=%s", evt.WBID, messageID, key)
+		return RunResult{}, nil
+	}
+	if choice == "dismiss" {
+		log.Printf("manager graph node=email_action wb=%s message=%s decision=dismissed", evt.WBID, messageID)
+		return RunResult{SideEffects: []string{key}}, nil
+	}
+
+	if g.config.Email == nil {
+		return RunResult{}, fmt.Errorf("email_action %s for message %s: email replier not configured", choice, messageID)
+	}
+	if strings.TrimSpace(draft) == "" {
+		log.Printf("manager graph node=email_action wb=%s message=%s decision=drop reason=empty_draft", evt.WBID, messageID)
+		return RunResult{}, nil
+	}
+
+	reply := EmailReply{MessageID: messageID, Body: draft}
+	var (
+		id  string
+		err error
+	)
+	if choice == "send" {
+		// Actions arrive on their own threads and replicas run concurrently, so the
+		// checkpoint alone cannot stop a second send; the per-user claim does.
+		claim := claimKey("email_sent", evt.UserID, messageID)
+		if g.config.Claims != nil {
+			ok, _, err := g.config.Claims.Claim(ctx, claim, evt.WBID, emailSentClaimTTL)
+			if err != nil {
+				return RunResult{}, fmt.Errorf("email_action send for message %s: claim failed: %w", messageID, err)
+			}
+			if !ok {
+				log.Printf("manager graph node=email_action wb=%s message=%s skip=already_sent", evt.WBID, messageID)
+				return RunResult{SideEffects: []string{key}}, nil
+			}
+		}
+		id, err = g.config.Email.SendReply(ctx, evt.UserID, reply)
+		if err != nil && g.config.Claims != nil {
+			if relErr := g.config.Claims.Release(context.WithoutCancel(ctx), claim); relErr != nil {
+				log.Printf("manager graph node=email_action wb=%s message=%s release claim failed: %v", evt.WBID, messageID, relErr)
+			}
+		}
+	} else {
+		id, err = g.config.Email.CreateDraft(ctx, evt.UserID, reply)
+	}
+	if err != nil {
+		return RunResult{}, fmt.Errorf("email_action %s failed for message %s: %w", choice, messageID, err)
+	}
+
+	log.Printf("manager graph node=email_action wb=%s message=%s choice=%s gmail_id=%s", evt.WBID, messageID, choice, id)
+	return RunResult{SideEffects: []string{key}}, nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if trimmed := strings.TrimSpace(v); trimmed != "" {
+			return trimmed
+		}
+	}
+	return ""
+}
@@ -2,8 +2,14 @@ package manager
 
 import (
 	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 
+	"alfred-cloud/wb"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
 	"github.com/stretchr/testify/require"
 )
 
@@ -49,7 +55,7 @@ func TestProdOverrunEmitsSinglePrompt(t *testing.T) {
 		},
 	}
 
-	err = graph.Run(context.Background(), evt)
+	_, err = graph.Run(context.Background(), evt)
 	require.NoError(t, err)
 
 	require.Len(t, bus.appends, 1, "expected exactly one prompt append")
@@ -63,3 +69,212 @@ func TestProdOverrunEmitsSinglePrompt(t *testing.T) {
 	require.NotEmpty(t, call.values["content"])
 	require.Contains(t, call.values["content"], "coding")
 }
+
+func TestEmitPromptIsClaimedBeforeAppend(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer client.Close()
+	ctx := context.Background()
+
+	bus := wb.NewBus(client)
+	graph, err := NewManagerGraph(GraphConfig{
+		PlannerURL: "http://example.com/planner/run",
+		Bus:        &flakyAppender{bus: bus, failures: 1},
+		Claims:     NewRedisClaimStore(client),
+	})
+	require.NoError(t, err)
+
+	evt := func(wbID string) NormalizedEvent {
+		return NormalizedEvent{
+			WBID:     wbID,
+			UserID:   "user-1",
+			ThreadID: "thread-1",
+			Event: Event{
+				Source:  "prod",
+				Kind:    "overrun",
+				Payload: map[string]any{"activity_label": "coding"},
+			},
+		}
+	}
+
+	_, err = graph.Run(ctx, evt("1-0"))
+	require.Error(t, err)
+	require.False(t, mr.Exists("manager:prompt:user-1:1-0"), "a failed append must release its claim")
+
+	first, err := graph.Run(ctx, evt("1-0"))
+	require.NoError(t, err)
+	require.NotEmpty(t, first.PromptID)
+
+	// The checkpoint from the first run was never saved; the claim still stops a second prompt.
+	again, err := graph.Run(ctx, evt("1-0"))
+	require.NoError(t, err)
+	require.Equal(t, first.PromptID, again.PromptID)
+	require.Equal(t, []string{"emit_prompt:1-0"}, again.SideEffects)
+	n, err := client.XLen(ctx, wb.StreamKey("user-1")).Result()
+	require.NoError(t, err)
+	require.EqualValues(t, 1, n)
+
+	// Another run still appending holds the claim, so this one retries later.
+	require.NoError(t, mr.Set("manager:prompt:user-1:2-0", promptClaimPending))
+	_, err = graph.Run(ctx, evt("2-0"))
+	require.Error(t, err)
+}
+
+func TestCalendarProposedEmitsPromptWithDelta(t *testing.T) {
+	bus := &stubBus{}
+	graph, err := NewManagerGraph(GraphConfig{
+		PlannerURL: "http://example.com/planner/run",
+		Bus:        bus,
+	})
+	require.NoError(t, err)
+
+	res, err := graph.Run(context.Background(), NormalizedEvent{
+		WBID:     "5-0",
+		UserID:   "user-1",
+		ThreadID: "thread-1",
+		Event: Event{
+			Source: "calendar",
+			Kind:   "plan.proposed",
+			Payload: map[string]any{
+				"delta_id": "delta-7",
+				"summary":  "Move standup to 10:30",
+				"impact":   "overlaps with interview",
+			},
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, "delta-7", res.LastPlanID)
+	require.Equal(t, "wb-append-id", res.PromptID)
+
+	require.Len(t, bus.appends, 1)
+	call := bus.appends[0]
+	require.Equal(t, "manager.prompt", call.values["type"])
+	require.Equal(t, "delta-7", call.values["delta_id"])
+	require.Equal(t, "5-0", call.values["wb_parent_id"])
+	require.Contains(t, call.values["content"], "Move standup")
+}
+
+func TestCalendarNewVersionCallsPlannerAndProd(t *testing.T) {
+	var plannerBody, prodBody map[string]any
+	var plannerAuth string
+	plannerSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		plannerAuth = r.Header.Get("Authorization")
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&plannerBody))
+		_, _ = w.Write([]byte(`{"ok":true,"notes":["moved lunch"],"blocks":[{},{}]}`))
+	}))
+	defer plannerSrv.Close()
+	prodSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&prodBody))
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer prodSrv.Close()
+
+	bus := &stubBus{}
+	graph, err := NewManagerGraph(GraphConfig{
+		PlannerURL:     plannerSrv.URL,
+		ProdControlURL: prodSrv.URL,
+		Bus:            bus,
+		ServiceToken:   "svc-token",
+	})
+	require.NoError(t, err)
+
+	res, err := graph.Run(context.Background(), NormalizedEvent{
+		WBID:     "6-0",
+		UserID:   "user-1",
+		ThreadID: "thread-1",
+		Event: Event{
+			Source:  "calendar",
+			Kind:    "plan.new_version",
+			Payload: map[string]any{"plan_id": "plan-9", "version": "4"},
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, "plan-9", res.LastPlanID)
+	require.Equal(t, "4", res.LastPlanVersion)
+	require.ElementsMatch(t, []string{"planner_call:6-0", "prod_recalc_signal:6-0", "emit_plan_outcome:6-0"}, res.SideEffects)
+
+	require.Equal(t, "plan-9", plannerBody["plan_id"])
+	require.Equal(t, "Bearer svc-token", plannerAuth)
+	require.NotEmpty(t, plannerBody["time_block"])
+	require.Equal(t, "4", prodBody["version"])
+
+	require.Len(t, bus.appends, 1)
+	call := bus.appends[0]
+	require.Equal(t, "manager.plan_outcome", call.values["type"])
+	require.Equal(t, "6-0", call.values["wb_parent_id"])
+	require.Equal(t, "ok", call.values["planner_status"])
+	require.Equal(t, "ok", call.values["prod_status"])
+	require.Equal(t, 2, call.values["planner_blocks"])
+}
+
+func TestCalendarNewVersionRetrySkipsCompletedPlannerCall(t *testing.T) {
+	plannerCalls := 0
+	plannerSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		plannerCalls++
+	}))
+	defer plannerSrv.Close()
+	prodFail := true
+	prodSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if prodFail {
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+		}
+	}))
+	defer prodSrv.Close()
+
+	bus := &stubBus{}
+	graph, err := NewManagerGraph(GraphConfig{
+		PlannerURL:     plannerSrv.URL,
+		ProdControlURL: prodSrv.URL,
+		Bus:            bus,
+	})
+	require.NoError(t, err)
+
+	evt := NormalizedEvent{
+		WBID:     "7-0",
+		UserID:   "user-1",
+		ThreadID: "thread-1",
+		Event: Event{
+			Source:  "calendar",
+			Kind:    "plan.new_version",
+			Payload: map[string]any{"plan_id": "plan-9", "version": "5"},
+		},
+	}
+
+	res, err := graph.Run(context.Background(), evt)
+	require.Error(t, err)
+	require.Equal(t, []string{"planner_call:7-0"}, res.SideEffects)
+	require.Empty(t, bus.appends)
+
+	prodFail = false
+	cp := Checkpoint{SideEffects: res.SideEffects}
+	res, err = graph.Run(WithCheckpoint(context.Background(), cp), evt)
+	require.NoError(t, err)
+	require.Equal(t, 1, plannerCalls, "planner must not be called again on retry")
+	require.Len(t, bus.appends, 1)
+	require.Equal(t, "replayed", bus.appends[0].values["planner_status"])
+}
+
+func TestProdAllowlistAsksToKeepObservedApp(t *testing.T) {
+	bus := &stubBus{}
+	graph, err := NewManagerGraph(GraphConfig{PlannerURL: "http://example.com/planner/run", Bus: bus})
+	require.NoError(t, err)
+
+	_, err = graph.Run(context.Background(), NormalizedEvent{
+		WBID:     "8-0",
+		UserID:   "user-1",
+		ThreadID: "system",
+		Event: Event{
+			Source: "prod",
+			Kind:   "allowlist",
+			Payload: map[string]any{
+				"block_id":       "evt-1",
+				"activity_label": "coding",
+				"observed":       "com.spotify.client",
+			},
+		},
+	})
+	require.NoError(t, err)
+	require.Len(t, bus.appends, 1)
+	require.Equal(t, "allowlist", bus.appends[0].values["kind"])
+	require.Contains(t, bus.appends[0].values["content"], "com.spotify.client")
+}
@@ -1,6 +1,7 @@
 package manager
 
 import (
+	"encoding/json"
 	"fmt"
 	"log"
 	"strings"
@@ -16,94 +17,38 @@ type NormalizedEvent struct {
 	Event    Event
 }
 
-// NormalizeWhiteboardEvent maps a raw whiteboard event into a Manager event shape.
+// NormalizeWhiteboardEvent maps a raw whiteboard event into a Manager event shape. The event
+// must validate against its registered wb schema; every declared field that is present is
+// copied into the payload.
 func NormalizeWhiteboardEvent(evt wb.Event) (NormalizedEvent, error) {
-	eventType := detectEventType(evt.Values)
+	eventType := wb.TypeOf(evt.Values)
 	if eventType == "" {
 		return NormalizedEvent{}, fmt.Errorf("whiteboard event %s missing type/kind", evt.ID)
 	}
+	schema, ok := wb.LookupSchema(eventType)
+	if !ok || !schema.Managed {
+		return NormalizedEvent{}, fmt.Errorf("unsupported whiteboard event type %s", eventType)
+	}
+	if err := schema.Validate(evt.Values, false); err != nil {
+		return NormalizedEvent{}, fmt.Errorf("whiteboard event %s: %w", evt.ID, err)
+	}
 
 	threadID := pickThreadID(evt)
 	payload := make(map[string]any)
-	switch eventType {
-	case "calendar.plan.proposed":
-		deltaID, err := requiredString(evt.Values, "delta_id")
-		if err != nil {
-			return NormalizedEvent{}, err
-		}
-		summary, err := requiredString(evt.Values, "summary")
-		if err != nil {
-			return NormalizedEvent{}, err
-		}
-		impact, err := requiredString(evt.Values, "impact")
-		if err != nil {
-			return NormalizedEvent{}, err
-		}
-		payload["delta_id"] = deltaID
-		payload["summary"] = summary
-		payload["impact"] = impact
-	case "calendar.plan.new_version":
-		planID, err := requiredString(evt.Values, "plan_id")
-		if err != nil {
-			return NormalizedEvent{}, err
-		}
-		version, err := requiredString(evt.Values, "version")
-		if err != nil {
-			return NormalizedEvent{}, err
-		}
-		payload["plan_id"] = planID
-		payload["version"] = version
-	case "prod.underrun", "prod.overrun", "prod.nudge":
-		blockID, err := requiredString(evt.Values, "block_id")
-		if err != nil {
-			return NormalizedEvent{}, err
-		}
-		activity, err := requiredString(evt.Values, "activity_label")
-		if err != nil {
-			return NormalizedEvent{}, err
-		}
-		payload["block_id"] = blockID
-		payload["activity_label"] = activity
-	case "email.reply_needed":
-		messageID, err := requiredString(evt.Values, "message_id")
-		if err != nil {
-			return NormalizedEvent{}, err
-		}
-		sender, err := requiredString(evt.Values, "sender")
-		if err != nil {
-			return NormalizedEvent{}, err
-		}
-		summary, err := requiredString(evt.Values, "summary")
-		if err != nil {
-			return NormalizedEvent{}, err
-		}
-		draft, err := requiredString(evt.Values, "draft")
-		if err != nil {
-			return NormalizedEvent{}, err
-		}
-		payload["message_id"] = messageID
-		payload["sender"] = sender
-		payload["summary"] = summary
-		payload["draft"] = draft
-	case "manager.user_action":
-		actionID, err := requiredString(evt.Values, "action_id")
-		if err != nil {
-			return NormalizedEvent{}, err
-		}
-		choice, err := requiredString(evt.Values, "choice")
-		if err != nil {
-			return NormalizedEvent{}, err
-		}
-		payload["action_id"] = actionID
-		payload["choice"] = choice
-		if threadID != "" {
-			payload["thread_id"] = threadID
+	for _, field := range schema.Fields {
+		raw := evt.Values[field.Name]
+		if field.Name == "thread_id" {
+			raw = threadID
+		}
+		if field.Kind == wb.FieldObject {
+			if meta := metadataVal(raw); len(meta) > 0 {
+				payload[field.Name] = meta
+			}
+			continue
 		}
-		if meta, ok := evt.Values["metadata"].(map[string]any); ok && len(meta) > 0 {
-			payload["metadata"] = meta
+		if v := stringVal(raw); v != "" {
+			payload[field.Name] = v
 		}
-	default:
-		return NormalizedEvent{}, fmt.Errorf("unsupported whiteboard event type %s", eventType)
 	}
 
 	userID := pickUserID(evt)
@@ -124,31 +69,6 @@ func NormalizeWhiteboardEvent(evt wb.Event) (NormalizedEvent, error) {
 	return normalized, nil
 }
 
-func detectEventType(values map[string]any) string {
-	if len(values) == 0 {
-		return ""
-	}
-
-	for _, key := range []string{"type", "kind", "event_type"} {
-		if raw, ok := values[key]; ok {
-			if s := stringVal(raw); s != "" {
-				return strings.ToLower(s)
-			}
-		}
-	}
-	return ""
-}
-
-func requiredString(values map[string]any, key string) (string, error) {
-	if len(values) == 0 {
-		return "", fmt.Errorf("%s is required", key)
-	}
-	if s := stringVal(values[key]); s != "" {
-		return s, nil
-	}
-	return "", fmt.Errorf("%s is required", key)
-}
-
 func pickUserID(evt wb.Event) string {
 	if trimmed := strings.TrimSpace(evt.UserID); trimmed != "" {
 		return trimmed
@@ -172,6 +92,27 @@ func splitEventType(eventType string) (string, string) {
 	return eventType, ""
 }
 
+// metadataVal accepts metadata as a map (in-process callers) or a JSON object string
+// (entries read back from Redis, which stores flat string fields).
+func metadataVal(v any) map[string]any {
+	switch val := v.(type) {
+	case map[string]any:
+		return val
+	case string, []byte:
+		raw := stringVal(val)
+		if raw == "" {
+			return nil
+		}
+		var meta map[string]any
+		if err := json.Unmarshal([]byte(raw), &meta); err != nil {
+			return nil
+		}
+		return meta
+	default:
+		return nil
+	}
+}
+
 func stringVal(v any) string {
 	switch val := v.(type) {
 	case string:
@@ -61,6 +61,31 @@ func TestNormalizeWhiteboardEventMappings(t *testing.T) {
 				"version": "4",
 			},
 		},
+		{
+			name: "calendar proposal rejected",
+			input: wb.Event{
+				ID:       "2-1",
+				UserID:   "user-b",
+				ThreadID: "evt-a|evt-b",
+				Values: map[string]any{
+					"type":            "calendar.proposal.rejected",
+					"proposal_id":     "proposal-1",
+					"status":          "rejected",
+					"previous_status": "pending",
+					"reason":          "rejected by user",
+				},
+			},
+			wantSource: "calendar",
+			wantKind:   "proposal.rejected",
+			wantThread: "evt-a|evt-b",
+			wantUser:   "user-b",
+			wantPayload: map[string]any{
+				"proposal_id":     "proposal-1",
+				"status":          "rejected",
+				"previous_status": "pending",
+				"reason":          "rejected by user",
+			},
+		},
 		{
 			name: "prod underrun",
 			input: wb.Event{
@@ -149,6 +174,31 @@ func TestNormalizeWhiteboardEventMappings(t *testing.T) {
 				"draft":      "Yes, 3pm works.",
 			},
 		},
+		{
+			name: "prod allowlist with observation",
+			input: wb.Event{
+				ID:       "6-1",
+				UserID:   "user-p",
+				ThreadID: "system",
+				Values: map[string]any{
+					"type":           "prod.allowlist",
+					"block_id":       "evt-1",
+					"activity_label": "Coding",
+					"observed":       "com.spotify.client",
+					"expected_apps":  `["com.microsoft.VSCode"]`,
+				},
+			},
+			wantSource: "prod",
+			wantKind:   "allowlist",
+			wantThread: "system",
+			wantUser:   "user-p",
+			wantPayload: map[string]any{
+				"block_id":       "evt-1",
+				"activity_label": "Coding",
+				"observed":       "com.spotify.client",
+				"expected_apps":  `["com.microsoft.VSCode"]`,
+			},
+		},
 		{
 			name: "manager user action with kind fallback",
 			input: wb.Event{
@@ -203,3 +253,24 @@ func TestNormalizeWhiteboardEventRejectsUnknown(t *testing.T) {
 	})
 	require.Error(t, err)
 }
+
+func TestNormalizeWhiteboardEventValidatesSchema(t *testing.T) {
+	_, err := NormalizeWhiteboardEvent(wb.Event{
+		ID:     "8-0",
+		UserID: "user-x",
+		Values: map[string]any{
+			"type":           "prod.nudge",
+			"block_id":       "block-1",
+			"activity_label": "coding",
+			"decided_at":     "yesterday",
+		},
+	})
+	require.ErrorIs(t, err, wb.ErrInvalidEvent)
+
+	_, err = NormalizeWhiteboardEvent(wb.Event{
+		ID:     "8-1",
+		UserID: "user-x",
+		Values: map[string]any{"type": "manager.prompt", "content": "hi"},
+	})
+	require.ErrorContains(t, err, "unsupported whiteboard event type manager.prompt")
+}
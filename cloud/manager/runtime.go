@@ -4,16 +4,24 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 
+	"alfred-cloud/registry"
+	"alfred-cloud/security"
+	"alfred-cloud/streams"
 	"alfred-cloud/wb"
 	"github.com/redis/go-redis/v9"
 )
 
+// DeadLetterAgent names the manager's dead-letter streams, user:{id}:dlq:manager.
+const DeadLetterAgent = "manager"
+
 // Runtime is the long-lived Manager worker that tails the whiteboard and feeds LangGraph.
 type Runtime struct {
 	cfg   RuntimeConfig
@@ -21,6 +29,13 @@ type Runtime struct {
 	bus   *wb.Bus
 	graph *ManagerGraph
 
+	checkpoints CheckpointStore
+	// users, when set, supplies the watched users at runtime instead of cfg.Users alone.
+	users *registry.Registry
+
+	workersMu sync.Mutex
+	workers   map[string]context.CancelFunc // user -> whiteboard consumer
+
 	mu      sync.RWMutex
 	lastIDs map[string]map[string]string // user -> thread -> wb id
 	lastErr error
@@ -37,21 +52,43 @@ func NewRuntimeFromEnv(ctx context.Context) (*Runtime, error) {
 	}
 
 	bus := wb.NewBus(client)
-	graph, err := NewManagerGraph(GraphConfig{
+	graphCfg := GraphConfig{
 		PlannerURL:     cfg.PlannerURL,
 		ProdControlURL: cfg.ProdControlURL,
 		Bus:            bus,
-	})
+		ServiceToken:   cfg.ServiceToken,
+		Claims:         NewRedisClaimStore(client),
+	}
+	if cfg.GmailClientSecret != "" {
+		tokenStore := security.NewTokenStore(client)
+		tokenCipher, err := security.RequiredTokenCipherFromEnv()
+		if err != nil {
+			return nil, fmt.Errorf("load OAuth token keys: %w", err)
+		}
+		tokenStore.UseCipher(tokenCipher)
+		gmailClient := security.NewGoogleServiceClient(tokenStore)
+		gmailClient.InitializeGmailOnly(cfg.GmailClientSecret, cfg.OAuthRedirectURL)
+		graphCfg.Email = NewGmailReplier(gmailClient)
+	} else {
+		log.Printf("manager: GMAIL_CLIENT_SECRET not set; email reply actions disabled")
+	}
+	graph, err := NewManagerGraph(graphCfg)
 	if err != nil {
 		return nil, err
 	}
+	var users *registry.Registry
+	if cfg.UseRegistry {
+		users = registry.New(client)
+	}
 
 	return &Runtime{
-		cfg:     cfg,
-		redis:   client,
-		bus:     bus,
-		graph:   graph,
-		lastIDs: map[string]map[string]string{
+		cfg:         cfg,
+		redis:       client,
+		bus:         bus,
+		graph:       graph,
+		checkpoints: NewRedisCheckpointStore(client),
+		users:       users,
+		lastIDs:     map[string]map[string]string{
 			// init lazily per user
 		},
 		started: time.Now().UTC(),
@@ -68,16 +105,72 @@ func (rt *Runtime) Run(ctx context.Context) error {
 	log.Printf("manager: LangGraph runtime ready (planner=%s prod_control=%s)", rt.cfg.PlannerURL, rt.cfg.ProdControlURL)
 	log.Printf("manager: connected to Redis at %s", rt.cfg.RedisURL)
 
+	if rt.users == nil {
+		rt.SetUsers(ctx, rt.cfg.Users)
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	// MANAGER_USERS seeds the registry; users onboarded later arrive via Watch.
 	for _, userID := range rt.cfg.Users {
-		user := strings.TrimSpace(userID)
-		if user == "" {
+		if err := rt.users.Register(ctx, userID, registry.FeatureManager); err != nil {
+			log.Printf("manager: register %s: %v", userID, err)
+		}
+	}
+	rt.users.Watch(ctx, registry.FeatureManager, func(users []string) {
+		rt.SetUsers(ctx, users)
+	})
+	return ctx.Err()
+}
+
+// SetUsers reconciles the per-user whiteboard consumers with users: new users start
+// consuming from their resume point, removed users are stopped. Consumers run until
+// ctx is canceled or the user is removed.
+func (rt *Runtime) SetUsers(ctx context.Context, users []string) {
+	next := make([]string, 0, len(users))
+	for _, userID := range users {
+		if user := strings.TrimSpace(userID); user != "" {
+			next = append(next, user)
+		}
+	}
+
+	rt.workersMu.Lock()
+	defer rt.workersMu.Unlock()
+	if rt.workers == nil {
+		rt.workers = make(map[string]context.CancelFunc)
+	}
+	current := make([]string, 0, len(rt.workers))
+	for userID := range rt.workers {
+		current = append(current, userID)
+	}
+
+	added, removed := registry.Diff(current, next)
+	for _, userID := range removed {
+		rt.workers[userID]()
+		delete(rt.workers, userID)
+		log.Printf("manager: stopped watching wb stream %s", wb.StreamKey(userID))
+	}
+	for _, userID := range added {
+		userCtx, cancel := context.WithCancel(ctx)
+		rt.workers[userID] = cancel
+		if rt.groupMode() {
+			go rt.consumeUserGroup(userCtx, userID)
 			continue
 		}
-		go rt.consumeUser(ctx, user)
+		go rt.consumeUser(userCtx, userID)
 	}
+}
 
-	<-ctx.Done()
-	return ctx.Err()
+// Users returns the users with a running whiteboard consumer, sorted.
+func (rt *Runtime) Users() []string {
+	rt.workersMu.Lock()
+	defer rt.workersMu.Unlock()
+	users := make([]string, 0, len(rt.workers))
+	for userID := range rt.workers {
+		users = append(users, userID)
+	}
+	sort.Strings(users)
+	return users
 }
 
 // Handler returns a minimal HTTP handler exposing /healthz.
@@ -99,14 +192,11 @@ func (rt *Runtime) ListenAddr() string {
 }
 
 func (rt *Runtime) consumeUser(ctx context.Context, userID string) {
-	startID := rt.cfg.StartAfterID
-	if startID == "" {
-		startID = "$"
-	}
+	startID := rt.resumeID(userID)
 
 	log.Printf("manager: watching wb stream %s from %q", wb.StreamKey(userID), startID)
 
-	lastID := rt.cfg.StartAfterID
+	lastID := startID
 	for {
 		select {
 		case <-ctx.Done():
@@ -132,24 +222,164 @@ func (rt *Runtime) consumeUser(ctx context.Context, userID string) {
 
 		lastID = nextID
 		for _, evt := range events {
-			normalized, normErr := NormalizeWhiteboardEvent(evt)
-			if normErr != nil {
-				log.Printf("manager: skip wb=%s for user=%s: %v", evt.ID, userID, normErr)
-				continue
+			_ = rt.handleEvent(ctx, userID, evt)
+		}
+	}
+}
+
+// consumeUserGroup reads the user's whiteboard through the shared consumer group. Entries are
+// acknowledged only once the graph run succeeds; anything left pending longer than ClaimIdle
+// (a failed run, or a replica that crashed mid-graph) is reclaimed by whichever consumer sees it.
+func (rt *Runtime) consumeUserGroup(ctx context.Context, userID string) {
+	group := rt.cfg.ConsumerGroup
+	consumer := rt.cfg.ConsumerName
+	startID := rt.resumeID(userID)
+
+	for {
+		err := rt.bus.EnsureGroup(ctx, userID, group, startID)
+		if err == nil {
+			break
+		}
+		rt.recordError(err)
+		log.Printf("manager: create group %s on %s failed: %v", group, wb.StreamKey(userID), err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Second):
+		}
+	}
+
+	log.Printf("manager: consuming wb stream %s as %s/%s (claim_idle=%s)", wb.StreamKey(userID), group, consumer, rt.cfg.ClaimIdle)
+
+	var lastClaim time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+
+		if rt.cfg.ClaimIdle > 0 && time.Since(lastClaim) >= rt.cfg.ClaimIdle {
+			lastClaim = time.Now()
+			claimed, err := rt.bus.ClaimStale(ctx, userID, group, consumer, rt.cfg.ClaimIdle)
+			if err != nil && !errors.Is(err, context.Canceled) {
+				rt.recordError(err)
+				log.Printf("manager: claim error for %s: %v", userID, err)
+			}
+			if len(claimed) > 0 {
+				log.Printf("manager: reclaimed %d stale wb entries for %s", len(claimed), userID)
+				rt.processGroupBatch(ctx, userID, claimed)
 			}
+		}
 
-			if normalized.ThreadID == "" {
-				log.Printf("manager: drop wb=%s for user=%s because thread_id missing (E-03 requirement)", evt.ID, userID)
-				continue
+		events, err := rt.bus.ReadGroup(ctx, userID, group, consumer)
+		if err != nil {
+			if errors.Is(err, context.Canceled) {
+				return
 			}
+			rt.recordError(err)
+			log.Printf("manager: group read error for %s: %v", userID, err)
+			time.Sleep(350 * time.Millisecond)
+			continue
+		}
 
-			rt.recordSeen(userID, normalized.ThreadID, evt.ID)
-			if err := rt.graph.Run(ctx, normalized); err != nil {
-				rt.recordError(err)
-				log.Printf("manager: graph run failed for wb=%s user=%s: %v", evt.ID, userID, err)
+		rt.recordError(nil)
+		rt.processGroupBatch(ctx, userID, events)
+	}
+}
+
+// processGroupBatch handles entries read or claimed by this consumer. A failing entry
+// stays pending for ClaimStale to retry until it has been delivered MaxAttempts times,
+// then it is moved to the user's dead-letter stream.
+func (rt *Runtime) processGroupBatch(ctx context.Context, userID string, events []wb.Event) {
+	delivery := streams.NewDelivery(rt.redis, rt.cfg.ConsumerGroup, rt.cfg.ConsumerName, DeadLetterAgent, streams.RetryPolicy{MaxAttempts: rt.cfg.MaxAttempts})
+	stream := wb.StreamKey(userID)
+	for _, evt := range events {
+		msg := redis.XMessage{ID: evt.ID, Values: evt.Values}
+		err := delivery.HandlePending(ctx, stream, msg, func(ctx context.Context, _ redis.XMessage) error {
+			return rt.handleEvent(ctx, userID, evt)
+		})
+		if err != nil {
+			rt.recordError(err)
+			log.Printf("manager: wb=%s user=%s: %v", evt.ID, userID, err)
+		}
+	}
+}
+
+func (rt *Runtime) groupMode() bool {
+	return strings.TrimSpace(rt.cfg.ConsumerGroup) != ""
+}
+
+// resumeID picks the stream cursor for a user. The lowest checkpointed LastWBID wins so
+// that no thread misses entries written while the manager was down; threads that are
+// further ahead skip the replayed entries via their own checkpoint.
+func (rt *Runtime) resumeID(userID string) string {
+	if rt.checkpoints != nil {
+		var ids []string
+		for _, threadID := range rt.checkpoints.Threads(userID) {
+			cp := rt.checkpoints.Get(userID, threadID)
+			if cp.LastWBID == "" {
+				continue
 			}
+			ids = append(ids, cp.LastWBID)
+			rt.recordSeen(userID, threadID, cp.LastWBID)
 		}
+		if lowest := lowestStreamID(ids); lowest != "" {
+			log.Printf("manager: resuming %s from checkpoint wb=%s (%d threads)", userID, lowest, len(ids))
+			return lowest
+		}
+	}
+	if rt.cfg.StartAfterID != "" {
+		return rt.cfg.StartAfterID
 	}
+	return "$"
+}
+
+// handleEvent runs a single whiteboard entry through the graph and checkpoints the outcome.
+// It returns an error only when the entry should be retried; malformed or already
+// processed entries return nil so group consumers acknowledge them.
+func (rt *Runtime) handleEvent(ctx context.Context, userID string, evt wb.Event) error {
+	normalized, normErr := NormalizeWhiteboardEvent(evt)
+	if normErr != nil {
+		log.Printf("manager: skip wb=%s for user=%s: %v", evt.ID, userID, normErr)
+		return nil
+	}
+
+	if normalized.ThreadID == "" {
+		log.Printf("manager: drop wb=%s for user=%s because thread_id missing (E-03 requirement)", evt.ID, userID)
+		return nil
+	}
+
+	var cp Checkpoint
+	if rt.checkpoints != nil {
+		cp = rt.checkpoints.Get(userID, normalized.ThreadID)
+	}
+	// The cursor check only applies to XREAD replays; a consumer group never redelivers an
+	// acknowledged entry, and reclaimed entries may legitimately be older than the checkpoint.
+	// Saving one cannot move the cursor back: the store merges, see mergeCheckpoint.
+	if !rt.groupMode() && shouldSkipID(evt.ID, cp.LastWBID) {
+		log.Printf("manager: skip wb=%s for user=%s thread=%s (checkpoint at %s)", evt.ID, userID, normalized.ThreadID, cp.LastWBID)
+		return nil
+	}
+
+	rt.recordSeen(userID, normalized.ThreadID, evt.ID)
+	res, err := rt.graph.Run(WithCheckpoint(ctx, cp), normalized)
+	if err != nil {
+		rt.recordError(err)
+		log.Printf("manager: graph run failed for wb=%s user=%s: %v", evt.ID, userID, err)
+		// Keep the cursor where it was so the entry is retried on resume, but remember
+		// any side effects that already happened so the retry does not repeat them.
+		if rt.checkpoints != nil && len(res.SideEffects) > 0 {
+			cp.SideEffects = dedupeStrings(append(cp.SideEffects, res.SideEffects...))
+			rt.checkpoints.Save(userID, normalized.ThreadID, cp)
+		}
+		return err
+	}
+
+	if rt.checkpoints != nil {
+		rt.checkpoints.Save(userID, normalized.ThreadID, applyRunResult(cp, evt.ID, res))
+	}
+	return nil
 }
 
 func (rt *Runtime) healthz(w http.ResponseWriter, r *http.Request) {
@@ -160,13 +390,16 @@ func (rt *Runtime) healthz(w http.ResponseWriter, r *http.Request) {
 }
 
 func (rt *Runtime) health() map[string]any {
+	users := rt.Users()
+
 	rt.mu.RLock()
 	defer rt.mu.RUnlock()
 
 	payload := map[string]any{
 		"ok":              rt.lastErr == nil,
 		"service":         "manager-runtime",
-		"users":           append([]string(nil), rt.cfg.Users...),
+		"users":           users,
+		"user_registry":   rt.users != nil,
 		"planner_url":     rt.cfg.PlannerURL,
 		"prod_control":    rt.cfg.ProdControlURL,
 		"redis":           rt.cfg.RedisURL,
@@ -174,6 +407,8 @@ func (rt *Runtime) health() map[string]any {
 		"started_at":      rt.started.Format(time.RFC3339Nano),
 		"checked_at":      time.Now().UTC().Format(time.RFC3339Nano),
 		"start_after_id":  rt.cfg.StartAfterID,
+		"consumer_group":  rt.cfg.ConsumerGroup,
+		"consumer_name":   rt.cfg.ConsumerName,
 		"langgraph_ready": rt.graph != nil,
 	}
 
@@ -0,0 +1,323 @@
+package manager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"alfred-cloud/registry"
+	"alfred-cloud/streams"
+	"alfred-cloud/wb"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestRuntime(t *testing.T) (*Runtime, *redis.Client) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+
+	bus := wb.NewBus(client)
+	graph, err := NewManagerGraph(GraphConfig{
+		PlannerURL:     "http://example.com/planner/run",
+		ProdControlURL: "http://example.com/prod/recompute",
+		Bus:            bus,
+	})
+	require.NoError(t, err)
+
+	return &Runtime{
+		redis:       client,
+		bus:         bus,
+		graph:       graph,
+		checkpoints: NewRedisCheckpointStore(client),
+		lastIDs:     map[string]map[string]string{},
+	}, client
+}
+
+func countPrompts(t *testing.T, client *redis.Client, userID string) int {
+	t.Helper()
+	msgs, err := client.XRange(context.Background(), wb.StreamKey(userID), "-", "+").Result()
+	require.NoError(t, err)
+	count := 0
+	for _, msg := range msgs {
+		if msg.Values["type"] == "manager.prompt" {
+			count++
+		}
+	}
+	return count
+}
+
+func TestRuntimeResumeIDUsesLowestCheckpoint(t *testing.T) {
+	rt, _ := newTestRuntime(t)
+	rt.cfg.StartAfterID = "0"
+
+	require.Equal(t, "0", rt.resumeID("user-1"), "no checkpoints falls back to configured start")
+
+	rt.checkpoints.Save("user-1", "thread-a", Checkpoint{LastWBID: "1700000000005-0"})
+	rt.checkpoints.Save("user-1", "thread-b", Checkpoint{LastWBID: "1700000000002-3"})
+	rt.checkpoints.Save("user-1", "thread-c", Checkpoint{PendingPromptID: "p-1"})
+
+	require.Equal(t, "1700000000002-3", rt.resumeID("user-1"))
+	require.Equal(t, "1700000000005-0", rt.lastIDs["user-1"]["thread-a"])
+}
+
+func TestRuntimeHandleEventCheckpointsAndSkipsReplay(t *testing.T) {
+	rt, client := newTestRuntime(t)
+	ctx := context.Background()
+
+	id, err := rt.bus.AppendWithThread(ctx, "user-1", "thread-1", map[string]any{
+		"type":           "prod.overrun",
+		"block_id":       "block-1",
+		"activity_label": "coding",
+	})
+	require.NoError(t, err)
+
+	evt := wb.Event{
+		ID:       id,
+		UserID:   "user-1",
+		ThreadID: "thread-1",
+		Values: map[string]any{
+			"type":           "prod.overrun",
+			"block_id":       "block-1",
+			"activity_label": "coding",
+		},
+	}
+
+	rt.handleEvent(ctx, "user-1", evt)
+	require.Equal(t, 1, countPrompts(t, client, "user-1"))
+
+	cp := rt.checkpoints.Get("user-1", "thread-1")
+	require.Equal(t, id, cp.LastWBID)
+	require.NotEmpty(t, cp.PendingPromptID)
+	require.Contains(t, cp.SideEffects, "emit_prompt:"+id)
+
+	// Replay after restart: the entry is at or below the checkpoint and is skipped.
+	rt.handleEvent(ctx, "user-1", evt)
+	require.Equal(t, 1, countPrompts(t, client, "user-1"))
+}
+
+func TestRuntimeHandleEventDoesNotRepeatRecordedPrompt(t *testing.T) {
+	rt, client := newTestRuntime(t)
+	ctx := context.Background()
+
+	// Simulate a crash after the prompt was emitted but before the cursor advanced.
+	rt.checkpoints.Save("user-1", "thread-1", Checkpoint{
+		LastWBID:    "1-0",
+		SideEffects: []string{"emit_prompt:2-0"},
+	})
+
+	rt.handleEvent(ctx, "user-1", wb.Event{
+		ID:       "2-0",
+		UserID:   "user-1",
+		ThreadID: "thread-1",
+		Values: map[string]any{
+			"type":           "prod.nudge",
+			"block_id":       "block-1",
+			"activity_label": "writing",
+		},
+	})
+
+	require.Equal(t, 0, countPrompts(t, client, "user-1"))
+	require.Equal(t, "2-0", rt.checkpoints.Get("user-1", "thread-1").LastWBID)
+}
+
+func TestRedisCheckpointStoreThreadsIndex(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer client.Close()
+
+	store := NewRedisCheckpointStore(client)
+	store.Save("user-1", "thread-b", Checkpoint{LastWBID: "2-0"})
+	store.Save("user-1", "thread-a", Checkpoint{LastWBID: "1-0"})
+	store.Save("user-2", "thread-c", Checkpoint{LastWBID: "3-0"})
+
+	require.Equal(t, []string{"thread-a", "thread-b"}, store.Threads("user-1"))
+
+	mr.Del(store.hashKey("user-1", "thread-a"))
+	require.Equal(t, []string{"thread-b"}, store.Threads("user-1"))
+}
+
+func TestRedisCheckpointStoreNeverMovesCursorBack(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer client.Close()
+
+	store := NewRedisCheckpointStore(client)
+	store.Save("user-1", "thread-1", Checkpoint{LastWBID: "5-0", PendingPromptID: "6-0", SideEffects: []string{"emit_prompt:5-0"}})
+
+	// A replica finishing a reclaimed older entry saves what it read before 5-0 was handled.
+	store.Save("user-1", "thread-1", applyRunResult(Checkpoint{LastWBID: "2-0"}, "3-0", RunResult{SideEffects: []string{"emit_prompt:3-0"}}))
+
+	cp := store.Get("user-1", "thread-1")
+	require.Equal(t, "5-0", cp.LastWBID)
+	require.Equal(t, "6-0", cp.PendingPromptID)
+	require.ElementsMatch(t, []string{"emit_prompt:5-0", "emit_prompt:3-0"}, cp.SideEffects)
+}
+
+type flakyAppender struct {
+	bus      *wb.Bus
+	failures int
+}
+
+func (f *flakyAppender) AppendWithThread(ctx context.Context, userID, threadID string, values map[string]any) (string, error) {
+	if f.failures > 0 {
+		f.failures--
+		return "", errors.New("redis unavailable")
+	}
+	return f.bus.AppendWithThread(ctx, userID, threadID, values)
+}
+
+func appendProdEvent(t *testing.T, bus *wb.Bus, userID, threadID, label string) string {
+	t.Helper()
+	id, err := bus.AppendWithThread(context.Background(), userID, threadID, map[string]any{
+		"type":           "prod.overrun",
+		"block_id":       "block-" + label,
+		"activity_label": label,
+	})
+	require.NoError(t, err)
+	return id
+}
+
+func TestRuntimeGroupModeSplitsEventsAcrossReplicas(t *testing.T) {
+	rtA, client := newTestRuntime(t)
+	rtA.cfg = RuntimeConfig{ConsumerGroup: "manager", ConsumerName: "replica-a", StartAfterID: "0", ClaimIdle: time.Minute}
+
+	rtB := &Runtime{
+		cfg:         RuntimeConfig{ConsumerGroup: "manager", ConsumerName: "replica-b", StartAfterID: "0", ClaimIdle: time.Minute},
+		redis:       client,
+		bus:         rtA.bus,
+		graph:       rtA.graph,
+		checkpoints: rtA.checkpoints,
+		lastIDs:     map[string]map[string]string{},
+	}
+
+	for i, label := range []string{"coding", "email", "design", "review"} {
+		appendProdEvent(t, rtA.bus, "user-1", fmt.Sprintf("thread-%d", i), label)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go rtA.consumeUserGroup(ctx, "user-1")
+	go rtB.consumeUserGroup(ctx, "user-1")
+
+	require.Eventually(t, func() bool {
+		return countPrompts(t, client, "user-1") == 4
+	}, 3*time.Second, 20*time.Millisecond)
+
+	// Give any duplicate delivery a chance to show up before asserting.
+	time.Sleep(100 * time.Millisecond)
+	require.Equal(t, 4, countPrompts(t, client, "user-1"))
+
+	pending, err := client.XPending(context.Background(), wb.StreamKey("user-1"), "manager").Result()
+	require.NoError(t, err)
+	require.Zero(t, pending.Count)
+}
+
+func TestRuntimeGroupModeReclaimsFailedEntries(t *testing.T) {
+	rt, client := newTestRuntime(t)
+	ctx := context.Background()
+
+	appender := &flakyAppender{bus: rt.bus, failures: 1}
+	graph, err := NewManagerGraph(GraphConfig{PlannerURL: "http://example.com/planner/run", Bus: appender})
+	require.NoError(t, err)
+	rt.graph = graph
+	rt.cfg = RuntimeConfig{ConsumerGroup: "manager", ConsumerName: "replica-a", ClaimIdle: 10 * time.Millisecond}
+
+	require.NoError(t, rt.bus.EnsureGroup(ctx, "user-1", "manager", "0"))
+	id := appendProdEvent(t, rt.bus, "user-1", "thread-1", "coding")
+
+	events, err := rt.bus.ReadGroup(ctx, "user-1", "manager", "replica-a")
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+
+	rt.processGroupBatch(ctx, "user-1", events)
+	pending, err := client.XPending(ctx, wb.StreamKey("user-1"), "manager").Result()
+	require.NoError(t, err)
+	require.EqualValues(t, 1, pending.Count, "failed graph run must not be acknowledged")
+	require.Equal(t, 0, countPrompts(t, client, "user-1"))
+
+	time.Sleep(20 * time.Millisecond)
+	claimed, err := rt.bus.ClaimStale(ctx, "user-1", "manager", "replica-b", rt.cfg.ClaimIdle)
+	require.NoError(t, err)
+	require.Len(t, claimed, 1)
+	require.Equal(t, id, claimed[0].ID)
+
+	rt.processGroupBatch(ctx, "user-1", claimed)
+	pending, err = client.XPending(ctx, wb.StreamKey("user-1"), "manager").Result()
+	require.NoError(t, err)
+	require.Zero(t, pending.Count)
+	require.Equal(t, 1, countPrompts(t, client, "user-1"))
+}
+
+func TestRuntimeGroupModeDeadLettersAfterMaxAttempts(t *testing.T) {
+	rt, client := newTestRuntime(t)
+	ctx := context.Background()
+
+	graph, err := NewManagerGraph(GraphConfig{PlannerURL: "http://example.com/planner/run", Bus: &flakyAppender{bus: rt.bus, failures: 100}})
+	require.NoError(t, err)
+	rt.graph = graph
+	rt.cfg = RuntimeConfig{ConsumerGroup: "manager", ConsumerName: "replica-a", ClaimIdle: 10 * time.Millisecond, MaxAttempts: 2}
+
+	require.NoError(t, rt.bus.EnsureGroup(ctx, "user-1", "manager", "0"))
+	id := appendProdEvent(t, rt.bus, "user-1", "thread-1", "coding")
+
+	events, err := rt.bus.ReadGroup(ctx, "user-1", "manager", "replica-a")
+	require.NoError(t, err)
+	rt.processGroupBatch(ctx, "user-1", events)
+	pending, err := client.XPending(ctx, wb.StreamKey("user-1"), "manager").Result()
+	require.NoError(t, err)
+	require.EqualValues(t, 1, pending.Count, "the first failure is retried")
+
+	time.Sleep(20 * time.Millisecond)
+	claimed, err := rt.bus.ClaimStale(ctx, "user-1", "manager", "replica-b", rt.cfg.ClaimIdle)
+	require.NoError(t, err)
+	require.Len(t, claimed, 1)
+	rt.processGroupBatch(ctx, "user-1", claimed)
+
+	pending, err = client.XPending(ctx, wb.StreamKey("user-1"), "manager").Result()
+	require.NoError(t, err)
+	require.Zero(t, pending.Count, "the last attempt moves the entry out of the pending list")
+	letters, err := streams.NewStreamsHelper(client).DeadLetters(ctx, "user-1", DeadLetterAgent, 10)
+	require.NoError(t, err)
+	require.Len(t, letters, 1)
+	require.Equal(t, id, letters[0].SourceID)
+	require.EqualValues(t, 2, letters[0].Attempts)
+}
+
+func TestRuntimeFollowsUserRegistry(t *testing.T) {
+	rt, client := newTestRuntime(t)
+	rt.cfg = RuntimeConfig{Users: []string{"user-1"}, StartAfterID: "0"}
+	rt.users = registry.New(client)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = rt.Run(ctx) }()
+
+	require.Eventually(t, func() bool {
+		return equalStrings(rt.Users(), []string{"user-1"})
+	}, 2*time.Second, 10*time.Millisecond, "MANAGER_USERS seeds the registry")
+
+	// A user onboarded at runtime is picked up without a restart.
+	require.NoError(t, rt.users.Register(ctx, "user-2", registry.FeatureManager))
+	require.Eventually(t, func() bool {
+		return equalStrings(rt.Users(), []string{"user-1", "user-2"})
+	}, 2*time.Second, 10*time.Millisecond)
+
+	appendProdEvent(t, rt.bus, "user-2", "thread-1", "coding")
+	require.Eventually(t, func() bool {
+		return countPrompts(t, client, "user-2") == 1
+	}, 3*time.Second, 20*time.Millisecond)
+
+	require.NoError(t, rt.users.SetFeature(ctx, "user-1", registry.FeatureManager, false))
+	require.Eventually(t, func() bool {
+		return equalStrings(rt.Users(), []string{"user-2"})
+	}, 2*time.Second, 10*time.Millisecond)
+}
+
+func equalStrings(a, b []string) bool {
+	return fmt.Sprint(a) == fmt.Sprint(b)
+}
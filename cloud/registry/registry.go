@@ -0,0 +1,287 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Feature names a per-user worker that can be switched on or off.
+type Feature string
+
+const (
+	FeatureEmail        Feature = "email"
+	FeatureCalendar     Feature = "calendar"
+	FeatureProductivity Feature = "productivity"
+	FeatureManager      Feature = "manager"
+)
+
+// AllFeatures lists every known feature in a stable order.
+var AllFeatures = []Feature{FeatureEmail, FeatureCalendar, FeatureProductivity, FeatureManager}
+
+const (
+	usersKey          = "users:registry"
+	featureUsersFmt   = "users:feature:%s"
+	userFeaturesFmt   = "user:%s:features"
+	changesChannel    = "users:registry:changes"
+	defaultResyncTick = time.Minute
+)
+
+// Registry is the Redis-backed list of users and the features enabled for each.
+//
+// Layout:
+//   - users:registry          set of every registered user
+//   - user:{id}:features      hash feature -> "1"/"0" (explicit flags survive re-registration)
+//   - users:feature:{feature} set of users with the feature enabled
+//
+// Every change is announced on users:registry:changes so workers can reconcile
+// without polling.
+type Registry struct {
+	client *redis.Client
+}
+
+// New creates a registry on the given redis client.
+func New(client *redis.Client) *Registry {
+	return &Registry{client: client}
+}
+
+// ParseFeature validates a feature name.
+func ParseFeature(raw string) (Feature, error) {
+	f := Feature(strings.ToLower(strings.TrimSpace(raw)))
+	for _, known := range AllFeatures {
+		if f == known {
+			return f, nil
+		}
+	}
+	return "", fmt.Errorf("unknown feature %q", raw)
+}
+
+// Register adds a user and enables the given features unless the user has
+// already set an explicit flag for them.
+func (r *Registry) Register(ctx context.Context, userID string, features ...Feature) error {
+	userID = strings.TrimSpace(userID)
+	if userID == "" {
+		return errors.New("user id is required")
+	}
+	if err := r.client.SAdd(ctx, usersKey, userID).Err(); err != nil {
+		return fmt.Errorf("register user %s: %w", userID, err)
+	}
+	for _, feature := range features {
+		if _, err := r.client.HSetNX(ctx, userFeaturesKey(userID), string(feature), "1").Result(); err != nil {
+			return fmt.Errorf("register %s for %s: %w", feature, userID, err)
+		}
+	}
+	if err := r.syncFeatureSets(ctx, userID); err != nil {
+		return err
+	}
+	r.announce(ctx, userID)
+	return nil
+}
+
+// SetFeature explicitly enables or disables a feature for a registered user.
+func (r *Registry) SetFeature(ctx context.Context, userID string, feature Feature, enabled bool) error {
+	userID = strings.TrimSpace(userID)
+	if userID == "" {
+		return errors.New("user id is required")
+	}
+	flag := "0"
+	if enabled {
+		flag = "1"
+	}
+	_, err := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.SAdd(ctx, usersKey, userID)
+		pipe.HSet(ctx, userFeaturesKey(userID), string(feature), flag)
+		if enabled {
+			pipe.SAdd(ctx, featureUsersKey(feature), userID)
+		} else {
+			pipe.SRem(ctx, featureUsersKey(feature), userID)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("set %s=%t for %s: %w", feature, enabled, userID, err)
+	}
+	r.announce(ctx, userID)
+	return nil
+}
+
+// Remove drops a user and all of their feature flags.
+func (r *Registry) Remove(ctx context.Context, userID string) error {
+	userID = strings.TrimSpace(userID)
+	_, err := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.SRem(ctx, usersKey, userID)
+		pipe.Del(ctx, userFeaturesKey(userID))
+		for _, feature := range AllFeatures {
+			pipe.SRem(ctx, featureUsersKey(feature), userID)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("remove user %s: %w", userID, err)
+	}
+	r.announce(ctx, userID)
+	return nil
+}
+
+// Users returns the sorted users with the feature enabled.
+func (r *Registry) Users(ctx context.Context, feature Feature) ([]string, error) {
+	users, err := r.client.SMembers(ctx, featureUsersKey(feature)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("list %s users: %w", feature, err)
+	}
+	sort.Strings(users)
+	return users, nil
+}
+
+// AllUsers returns every registered user, sorted.
+func (r *Registry) AllUsers(ctx context.Context) ([]string, error) {
+	users, err := r.client.SMembers(ctx, usersKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("list users: %w", err)
+	}
+	sort.Strings(users)
+	return users, nil
+}
+
+// Features returns the explicit feature flags for a user. Unknown users get an empty map.
+func (r *Registry) Features(ctx context.Context, userID string) (map[Feature]bool, error) {
+	raw, err := r.client.HGetAll(ctx, userFeaturesKey(userID)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("load features for %s: %w", userID, err)
+	}
+	out := make(map[Feature]bool, len(raw))
+	for name, flag := range raw {
+		out[Feature(name)] = flag == "1"
+	}
+	return out, nil
+}
+
+// Watch calls fn with the users that have the feature enabled: once immediately,
+// then whenever the set changes. Change notifications arrive over pub/sub; a
+// periodic resync covers messages missed while disconnected. Watch blocks until
+// ctx is canceled.
+func (r *Registry) Watch(ctx context.Context, feature Feature, fn func(users []string)) {
+	r.watch(ctx, feature, defaultResyncTick, fn)
+}
+
+func (r *Registry) watch(ctx context.Context, feature Feature, resync time.Duration, fn func(users []string)) {
+	sub := r.client.Subscribe(ctx, changesChannel)
+	defer sub.Close()
+	// Wait for the subscription so changes made right after the initial read are not lost.
+	if _, err := sub.Receive(ctx); err != nil && ctx.Err() == nil {
+		log.Printf("registry: subscribe to %s failed, relying on resync: %v", changesChannel, err)
+	}
+	changes := sub.Channel()
+
+	ticker := time.NewTicker(resync)
+	defer ticker.Stop()
+
+	var last []string
+	first := true
+	reload := func() {
+		users, err := r.Users(ctx, feature)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("registry: reload %s users failed: %v", feature, err)
+			}
+			return
+		}
+		if !first && equalUsers(last, users) {
+			return
+		}
+		first = false
+		last = users
+		fn(append([]string(nil), users...))
+	}
+
+	reload()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case _, ok := <-changes:
+			if !ok {
+				return
+			}
+			reload()
+		case <-ticker.C:
+			reload()
+		}
+	}
+}
+
+// syncFeatureSets mirrors a user's flag hash into the per-feature sets.
+func (r *Registry) syncFeatureSets(ctx context.Context, userID string) error {
+	flags, err := r.Features(ctx, userID)
+	if err != nil {
+		return err
+	}
+	_, err = r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for feature, enabled := range flags {
+			if enabled {
+				pipe.SAdd(ctx, featureUsersKey(feature), userID)
+			} else {
+				pipe.SRem(ctx, featureUsersKey(feature), userID)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("sync feature sets for %s: %w", userID, err)
+	}
+	return nil
+}
+
+func (r *Registry) announce(ctx context.Context, userID string) {
+	if err := r.client.Publish(ctx, changesChannel, userID).Err(); err != nil {
+		log.Printf("registry: announce change for %s failed: %v", userID, err)
+	}
+}
+
+func userFeaturesKey(userID string) string {
+	return fmt.Sprintf(userFeaturesFmt, userID)
+}
+
+func featureUsersKey(feature Feature) string {
+	return fmt.Sprintf(featureUsersFmt, feature)
+}
+
+// Diff reports which users appear only in next (added) and only in current (removed).
+func Diff(current, next []string) (added, removed []string) {
+	have := make(map[string]bool, len(current))
+	for _, id := range current {
+		have[id] = true
+	}
+	want := make(map[string]bool, len(next))
+	for _, id := range next {
+		want[id] = true
+		if !have[id] {
+			added = append(added, id)
+		}
+	}
+	for _, id := range current {
+		if !want[id] {
+			removed = append(removed, id)
+		}
+	}
+	return added, removed
+}
+
+func equalUsers(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,99 @@
+package registry
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestRegistry(t *testing.T) *Registry {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	return New(client)
+}
+
+func TestRegistry_RegisterAndFeatureFlags(t *testing.T) {
+	ctx := context.Background()
+	reg := newTestRegistry(t)
+
+	require.NoError(t, reg.Register(ctx, "user-2", FeatureEmail, FeatureManager))
+	require.NoError(t, reg.Register(ctx, "user-1", FeatureCalendar, FeatureManager))
+
+	users, err := reg.Users(ctx, FeatureManager)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"user-1", "user-2"}, users)
+
+	require.NoError(t, reg.SetFeature(ctx, "user-2", FeatureEmail, false))
+	users, err = reg.Users(ctx, FeatureEmail)
+	require.NoError(t, err)
+	assert.Empty(t, users)
+
+	// Re-registering after another OAuth grant keeps the explicit opt-out.
+	require.NoError(t, reg.Register(ctx, "user-2", FeatureEmail, FeatureManager))
+	flags, err := reg.Features(ctx, "user-2")
+	require.NoError(t, err)
+	assert.Equal(t, map[Feature]bool{FeatureEmail: false, FeatureManager: true}, flags)
+
+	require.NoError(t, reg.Remove(ctx, "user-1"))
+	users, err = reg.AllUsers(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"user-2"}, users)
+	users, err = reg.Users(ctx, FeatureCalendar)
+	require.NoError(t, err)
+	assert.Empty(t, users)
+
+	assert.Error(t, reg.Register(ctx, " ", FeatureEmail))
+	_, err = ParseFeature("sms")
+	assert.Error(t, err)
+}
+
+func TestRegistry_WatchReportsChanges(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reg := newTestRegistry(t)
+	require.NoError(t, reg.Register(ctx, "user-1", FeatureEmail))
+
+	updates := make(chan []string, 8)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		reg.watch(ctx, FeatureEmail, time.Hour, func(users []string) { updates <- users })
+	}()
+
+	next := func() []string {
+		t.Helper()
+		select {
+		case users := <-updates:
+			return users
+		case <-time.After(2 * time.Second):
+			t.Fatal("timed out waiting for registry update")
+			return nil
+		}
+	}
+
+	assert.Equal(t, []string{"user-1"}, next())
+
+	require.NoError(t, reg.Register(ctx, "user-2", FeatureEmail))
+	assert.Equal(t, []string{"user-1", "user-2"}, next())
+
+	// Changes to other features do not wake email watchers.
+	require.NoError(t, reg.Register(ctx, "user-3", FeatureCalendar))
+	require.NoError(t, reg.SetFeature(ctx, "user-1", FeatureEmail, false))
+	assert.Equal(t, []string{"user-2"}, next())
+
+	cancel()
+	<-done
+}
+
+func TestDiff(t *testing.T) {
+	added, removed := Diff([]string{"a", "b"}, []string{"b", "c"})
+	assert.Equal(t, []string{"c"}, added)
+	assert.Equal(t, []string{"a"}, removed)
+}
@@ -19,22 +19,36 @@ import (
 type proposalService interface {
 	GetProposal(ctx context.Context, userID, proposalID string) (*calendar_planner.ShadowProposal, error)
 	SaveProposal(ctx context.Context, proposal *calendar_planner.ShadowProposal) error
+	TransitionProposal(ctx context.Context, userID, proposalID, status, reason string) (*calendar_planner.ShadowProposal, error)
+	ClaimProposal(ctx context.Context, userID, proposalID string) (func(), error)
 }
 
+// calendarUpdater is the slice of the Google Calendar events API used to apply
+// proposals. UpdateEvent patches; InsertEvent and DeleteEvent create and remove.
 type calendarUpdater interface {
+	GetEvent(ctx context.Context, calendarID, eventID string) (*cal.Event, error)
 	UpdateEvent(ctx context.Context, calendarID, eventID string, event *cal.Event) (*cal.Event, error)
+	InsertEvent(ctx context.Context, calendarID string, event *cal.Event) (*cal.Event, error)
+	DeleteEvent(ctx context.Context, calendarID, eventID string) error
 }
 
 type calendarUpdaterFactory func(ctx context.Context, userID string) (calendarUpdater, error)
 
+// defaultProposalUndoWindow is how long after confirming a proposal it can still be undone.
+const defaultProposalUndoWindow = 15 * time.Minute
+
 type proposalConfirmHandler struct {
 	proposals  proposalService
 	newUpdater calendarUpdaterFactory
+	undoWindow time.Duration
 }
 
 type confirmProposalRequest struct {
 	UserID     string `json:"user_id"`
 	ProposalID string `json:"proposal_id"`
+	// ApplyTo and PlanIndex pick the single event to move, and CalendarID the
+	// calendar it is on, for plans whose events carry no action/target; other
+	// plans are applied whole on each event's own calendar.
 	CalendarID string `json:"calendar_id,omitempty"`
 	ApplyTo    string `json:"apply_to,omitempty"`   // primary|conflicting
 	PlanIndex  int    `json:"plan_index,omitempty"` // which plan.Events entry to apply
@@ -48,17 +62,34 @@ type confirmProposalResponse struct {
 	Status       string                               `json:"status"`
 	UpdatedAt    string                               `json:"updated_at"`
 	AppliedEvent calendar_planner.GoogleCalendarEvent `json:"applied_event"`
+	Operations   []calendar_planner.ProposalOperation `json:"operations"`
+	Error        string                               `json:"error,omitempty"`
+}
+
+type undoProposalRequest struct {
+	UserID     string `json:"user_id"`
+	ProposalID string `json:"proposal_id"`
 }
 
-func registerProposalConfirmRoutes(router *mux.Router, service *calendar_planner.ShadowCalendarService, calendarClient *security.GoogleServiceClient) {
+type undoProposalResponse struct {
+	OK         bool                                 `json:"ok"`
+	ProposalID string                               `json:"proposal_id"`
+	Status     string                               `json:"status"`
+	Operations []calendar_planner.ProposalOperation `json:"operations"`
+	Error      string                               `json:"error,omitempty"`
+}
+
+func registerProposalConfirmRoutes(router *mux.Router, service *calendar_planner.ShadowCalendarService, calendarClient *security.GoogleServiceClient, undoWindow time.Duration) {
 	if router == nil || service == nil || calendarClient == nil {
 		return
 	}
 	handler := &proposalConfirmHandler{
 		proposals:  service,
 		newUpdater: makeCalendarUpdaterFactory(calendarClient),
+		undoWindow: undoWindow,
 	}
 	router.HandleFunc("/calendar/proposals/confirm", handler.handleConfirm).Methods("POST")
+	router.HandleFunc("/calendar/proposals/undo", handler.handleUndo).Methods("POST")
 }
 
 func makeCalendarUpdaterFactory(client *security.GoogleServiceClient) calendarUpdaterFactory {
@@ -78,10 +109,22 @@ type googleCalendarUpdater struct {
 	events *cal.EventsService
 }
 
+func (g *googleCalendarUpdater) GetEvent(ctx context.Context, calendarID, eventID string) (*cal.Event, error) {
+	return g.events.Get(calendarID, eventID).Context(ctx).Do()
+}
+
 func (g *googleCalendarUpdater) UpdateEvent(ctx context.Context, calendarID, eventID string, event *cal.Event) (*cal.Event, error) {
 	return g.events.Patch(calendarID, eventID, event).Context(ctx).Do()
 }
 
+func (g *googleCalendarUpdater) InsertEvent(ctx context.Context, calendarID string, event *cal.Event) (*cal.Event, error) {
+	return g.events.Insert(calendarID, event).Context(ctx).Do()
+}
+
+func (g *googleCalendarUpdater) DeleteEvent(ctx context.Context, calendarID, eventID string) error {
+	return g.events.Delete(calendarID, eventID).Context(ctx).Do()
+}
+
 func (h *proposalConfirmHandler) handleConfirm(w http.ResponseWriter, r *http.Request) {
 	var req confirmProposalRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -89,7 +132,12 @@ func (h *proposalConfirmHandler) handleConfirm(w http.ResponseWriter, r *http.Re
 		return
 	}
 
-	req.UserID = strings.TrimSpace(req.UserID)
+	userID, err := requestUserID(r, req.UserID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	req.UserID = userID
 	req.ProposalID = strings.TrimSpace(req.ProposalID)
 	req.CalendarID = strings.TrimSpace(req.CalendarID)
 	target := strings.ToLower(strings.TrimSpace(req.ApplyTo))
@@ -99,10 +147,6 @@ func (h *proposalConfirmHandler) handleConfirm(w http.ResponseWriter, r *http.Re
 		return
 	}
 
-	if req.CalendarID == "" {
-		req.CalendarID = "primary"
-	}
-
 	if target == "" {
 		target = "primary"
 	}
@@ -116,6 +160,13 @@ func (h *proposalConfirmHandler) handleConfirm(w http.ResponseWriter, r *http.Re
 		return
 	}
 
+	// Claimed before the status is read, so a second confirm sees it accepted.
+	release, ok := h.claim(w, r, req.UserID, req.ProposalID)
+	if !ok {
+		return
+	}
+	defer release()
+
 	proposal, err := h.proposals.GetProposal(r.Context(), req.UserID, req.ProposalID)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("failed to load proposal: %v", err), http.StatusInternalServerError)
@@ -125,69 +176,180 @@ func (h *proposalConfirmHandler) handleConfirm(w http.ResponseWriter, r *http.Re
 		http.Error(w, "proposal not found", http.StatusNotFound)
 		return
 	}
-	if proposal.Plan == nil || len(proposal.Plan.Events) == 0 {
-		http.Error(w, "proposal missing plan events", http.StatusBadRequest)
+	if proposal.Status != calendar_planner.ProposalPending {
+		http.Error(w, fmt.Sprintf("proposal is %s", proposal.Status), http.StatusConflict)
 		return
 	}
-	if req.PlanIndex >= len(proposal.Plan.Events) {
-		http.Error(w, "plan_index out of range", http.StatusBadRequest)
+	ops, err := proposal.PlannedOperations(target, req.PlanIndex, req.CalendarID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	selected := proposal.Plan.Events[req.PlanIndex]
-	if strings.TrimSpace(selected.Start.DateTime) == "" || strings.TrimSpace(selected.End.DateTime) == "" {
-		http.Error(w, "proposal event missing start or end time", http.StatusBadRequest)
+	updater, err := h.newUpdater(r.Context(), req.UserID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("calendar not ready: %v", err), http.StatusFailedDependency)
 		return
 	}
 
-	var targetEventID string
-	switch target {
-	case "conflicting":
-		if proposal.ConflictingEvent != nil {
-			targetEventID = proposal.ConflictingEvent.EventID
-		}
-	default:
-		if proposal.PrimaryEvent != nil {
-			targetEventID = proposal.PrimaryEvent.EventID
-		}
+	resp := confirmProposalResponse{ProposalID: proposal.ID, Operations: ops}
+	if ops[0].Event != nil {
+		resp.AppliedEvent = *ops[0].Event
 	}
-	if strings.TrimSpace(targetEventID) == "" {
-		http.Error(w, "target event missing", http.StatusBadRequest)
+	if err := applyProposalOperations(r.Context(), updater, ops); err != nil {
+		// Everything applied so far was compensated; the proposal stays pending.
+		resp.Status = proposal.Status
+		resp.Error = fmt.Sprintf("failed to update calendar: %v", err)
+		resp.EventID, resp.CalendarID = ops[0].EventID, ops[0].CalendarID
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadGateway)
+		_ = json.NewEncoder(w).Encode(resp)
 		return
 	}
 
-	updater, err := h.newUpdater(r.Context(), req.UserID)
+	// The calendar already changed, so failing to record it is only logged.
+	proposal.Operations = ops
+	if err := h.proposals.SaveProposal(r.Context(), proposal); err != nil {
+		log.Printf("calendar confirm: failed to record operations for proposal %s: %v", proposal.ID, err)
+	}
+	if accepted, err := h.proposals.TransitionProposal(r.Context(), req.UserID, proposal.ID, calendar_planner.ProposalAccepted, "confirmed by user"); err != nil {
+		log.Printf("calendar confirm: failed to accept proposal %s: %v", proposal.ID, err)
+		proposal.Status = calendar_planner.ProposalAccepted
+		proposal.UpdatedAt = time.Now().UTC()
+	} else {
+		proposal = accepted
+	}
+
+	resp.OK = true
+	resp.EventID, resp.CalendarID = ops[0].EventID, ops[0].CalendarID
+	resp.Status = proposal.Status
+	resp.UpdatedAt = proposal.UpdatedAt.Format(time.RFC3339Nano)
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// handleUndo reverts an accepted proposal's calendar changes from the recorded
+// snapshots and reopens it. It refuses once the undo window has passed or when
+// any event was edited after the proposal changed it.
+func (h *proposalConfirmHandler) handleUndo(w http.ResponseWriter, r *http.Request) {
+	var req undoProposalRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	userID, err := requestUserID(r, req.UserID)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("calendar not ready: %v", err), http.StatusFailedDependency)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	req.ProposalID = strings.TrimSpace(req.ProposalID)
+	if userID == "" || req.ProposalID == "" {
+		http.Error(w, "user_id and proposal_id are required", http.StatusBadRequest)
 		return
 	}
 
-	payload := buildCalendarEventPayload(selected)
-	if _, err := updater.UpdateEvent(r.Context(), req.CalendarID, targetEventID, payload); err != nil {
-		http.Error(w, fmt.Sprintf("failed to update calendar: %v", err), http.StatusBadGateway)
+	release, ok := h.claim(w, r, userID, req.ProposalID)
+	if !ok {
 		return
 	}
+	defer release()
 
-	proposal.Status = "applied"
-	proposal.UpdatedAt = time.Now().UTC()
-	if err := h.proposals.SaveProposal(r.Context(), proposal); err != nil {
-		log.Printf("calendar confirm: failed to persist proposal %s: %v", proposal.ID, err)
+	proposal, err := h.proposals.GetProposal(r.Context(), userID, req.ProposalID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to load proposal: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if proposal == nil {
+		http.Error(w, "proposal not found", http.StatusNotFound)
+		return
+	}
+	if proposal.Status != calendar_planner.ProposalAccepted {
+		http.Error(w, fmt.Sprintf("proposal is %s", proposal.Status), http.StatusConflict)
+		return
+	}
+	if len(proposal.Operations) == 0 {
+		http.Error(w, "proposal has no recorded calendar changes to undo", http.StatusConflict)
+		return
+	}
+	window := h.undoWindow
+	if window <= 0 {
+		window = defaultProposalUndoWindow
+	}
+	if time.Since(acceptedAt(proposal)) > window {
+		http.Error(w, "undo window has passed", http.StatusConflict)
+		return
+	}
+
+	updater, err := h.newUpdater(r.Context(), userID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("calendar not ready: %v", err), http.StatusFailedDependency)
+		return
+	}
+	ops := proposal.Operations
+	if err := verifyOperationsUnchanged(r.Context(), updater, ops); err != nil {
+		status := http.StatusBadGateway
+		if errors.Is(err, errEventChanged) {
+			status = http.StatusConflict
+		}
+		http.Error(w, err.Error(), status)
+		return
 	}
 
-	resp := confirmProposalResponse{
-		OK:           true,
-		ProposalID:   proposal.ID,
-		EventID:      targetEventID,
-		CalendarID:   req.CalendarID,
-		Status:       proposal.Status,
-		UpdatedAt:    proposal.UpdatedAt.Format(time.RFC3339Nano),
-		AppliedEvent: selected,
+	resp := undoProposalResponse{ProposalID: proposal.ID, Operations: ops}
+	if !rollbackOperations(context.WithoutCancel(r.Context()), updater, ops) {
+		// Some changes are back and some are not; record which, keep it accepted.
+		if err := h.proposals.SaveProposal(r.Context(), proposal); err != nil {
+			log.Printf("calendar undo: failed to record operations for proposal %s: %v", proposal.ID, err)
+		}
+		resp.Status = proposal.Status
+		resp.Error = "failed to restore every event"
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadGateway)
+		_ = json.NewEncoder(w).Encode(resp)
+		return
 	}
 
+	if err := h.proposals.SaveProposal(r.Context(), proposal); err != nil {
+		log.Printf("calendar undo: failed to record operations for proposal %s: %v", proposal.ID, err)
+	}
+	reopened, err := h.proposals.TransitionProposal(r.Context(), userID, proposal.ID, calendar_planner.ProposalPending, "undone by user")
+	if err != nil {
+		log.Printf("calendar undo: failed to reopen proposal %s: %v", proposal.ID, err)
+		resp.Status = proposal.Status
+	} else {
+		resp.Status = reopened.Status
+	}
+	resp.OK = true
 	w.Header().Set("Content-Type", "application/json")
 	_ = json.NewEncoder(w).Encode(resp)
 }
 
+// claim reserves the proposal while the calendar is changed on its behalf,
+// writing the error response when it cannot.
+func (h *proposalConfirmHandler) claim(w http.ResponseWriter, r *http.Request, userID, proposalID string) (func(), bool) {
+	release, err := h.proposals.ClaimProposal(r.Context(), userID, proposalID)
+	if errors.Is(err, calendar_planner.ErrProposalBusy) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return nil, false
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to claim proposal: %v", err), http.StatusInternalServerError)
+		return nil, false
+	}
+	return release, true
+}
+
+// acceptedAt is when the proposal was last accepted.
+func acceptedAt(proposal *calendar_planner.ShadowProposal) time.Time {
+	for i := len(proposal.History) - 1; i >= 0; i-- {
+		if proposal.History[i].To == calendar_planner.ProposalAccepted {
+			return proposal.History[i].At
+		}
+	}
+	return proposal.UpdatedAt
+}
+
 func buildCalendarEventPayload(evt calendar_planner.GoogleCalendarEvent) *cal.Event {
 	startTZ := strings.TrimSpace(evt.Start.TimeZone)
 	endTZ := strings.TrimSpace(evt.End.TimeZone)
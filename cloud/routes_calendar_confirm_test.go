@@ -4,18 +4,23 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 	"time"
 
 	"alfred-cloud/subagents/calendar_planner"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
 	"github.com/stretchr/testify/require"
 	cal "google.golang.org/api/calendar/v3"
 )
 
 type stubProposalService struct {
 	proposals map[string]*calendar_planner.ShadowProposal
+	claimed   bool
 }
 
 func (s *stubProposalService) GetProposal(ctx context.Context, userID, proposalID string) (*calendar_planner.ShadowProposal, error) {
@@ -27,20 +32,64 @@ func (s *stubProposalService) GetProposal(ctx context.Context, userID, proposalI
 }
 
 func (s *stubProposalService) SaveProposal(ctx context.Context, proposal *calendar_planner.ShadowProposal) error {
-	if s.proposals == nil {
-		s.proposals = make(map[string]*calendar_planner.ShadowProposal)
-	}
 	copy := *proposal
 	s.proposals[proposal.ID] = &copy
 	return nil
 }
 
+func (s *stubProposalService) TransitionProposal(ctx context.Context, userID, proposalID, status, reason string) (*calendar_planner.ShadowProposal, error) {
+	proposal, ok := s.proposals[proposalID]
+	if !ok || proposal.UserID != userID {
+		return nil, calendar_planner.ErrProposalNotFound
+	}
+	if err := proposal.Transition(status, reason, time.Now().UTC()); err != nil {
+		return nil, err
+	}
+	copy := *proposal
+	return &copy, nil
+}
+
+func (s *stubProposalService) ClaimProposal(ctx context.Context, userID, proposalID string) (func(), error) {
+	if s.claimed {
+		return nil, calendar_planner.ErrProposalBusy
+	}
+	s.claimed = true
+	return func() { s.claimed = false }, nil
+}
+
+// stubCalendarUpdater is an in-memory calendar. failOn makes the named call
+// ("get", "update", "insert" or "delete") fail, optionally only for one event
+// as "update:<event id>".
 type stubCalendarUpdater struct {
 	calls      int
 	calendarID string
 	eventID    string
 	lastEvent  cal.Event
 	err        error
+
+	events  map[string]*cal.Event
+	failOn  map[string]error
+	log     []string
+	created int
+	version int
+}
+
+func (s *stubCalendarUpdater) fail(call, eventID string) error {
+	if err := s.failOn[call+":"+eventID]; err != nil {
+		return err
+	}
+	return s.failOn[call]
+}
+
+func (s *stubCalendarUpdater) GetEvent(ctx context.Context, calendarID, eventID string) (*cal.Event, error) {
+	if err := s.fail("get", eventID); err != nil {
+		return nil, err
+	}
+	if evt, ok := s.events[eventID]; ok {
+		copy := *evt
+		return &copy, nil
+	}
+	return &cal.Event{Id: eventID, Status: "confirmed"}, nil
 }
 
 func (s *stubCalendarUpdater) UpdateEvent(ctx context.Context, calendarID, eventID string, event *cal.Event) (*cal.Event, error) {
@@ -53,9 +102,55 @@ func (s *stubCalendarUpdater) UpdateEvent(ctx context.Context, calendarID, event
 	if s.err != nil {
 		return nil, s.err
 	}
+	if err := s.fail("update", eventID); err != nil {
+		return nil, err
+	}
+	s.log = append(s.log, "update "+eventID)
+	if evt, ok := s.events[eventID]; ok {
+		evt.Summary, evt.Start, evt.End = event.Summary, event.Start, event.End
+		if event.Status != "" {
+			evt.Status = event.Status
+		}
+		s.touch(evt)
+		copy := *evt
+		return &copy, nil
+	}
 	return event, nil
 }
 
+// touch gives an event a new etag, as Google does on every change.
+func (s *stubCalendarUpdater) touch(evt *cal.Event) {
+	s.version++
+	evt.Etag = fmt.Sprintf(`"%d"`, s.version)
+}
+
+func (s *stubCalendarUpdater) InsertEvent(ctx context.Context, calendarID string, event *cal.Event) (*cal.Event, error) {
+	if err := s.fail("insert", ""); err != nil {
+		return nil, err
+	}
+	s.created++
+	created := *event
+	created.Id = fmt.Sprintf("created-%d", s.created)
+	s.touch(&created)
+	if s.events != nil {
+		s.events[created.Id] = &created
+	}
+	s.log = append(s.log, "insert "+created.Id)
+	return &created, nil
+}
+
+func (s *stubCalendarUpdater) DeleteEvent(ctx context.Context, calendarID, eventID string) error {
+	if err := s.fail("delete", eventID); err != nil {
+		return err
+	}
+	s.log = append(s.log, "delete "+eventID)
+	if evt, ok := s.events[eventID]; ok {
+		evt.Status = "cancelled"
+		s.touch(evt)
+	}
+	return nil
+}
+
 func TestConfirmProposalUpdatesCalendar(t *testing.T) {
 	proposal := &calendar_planner.ShadowProposal{
 		ID:     "proposal-1",
@@ -112,7 +207,7 @@ func TestConfirmProposalUpdatesCalendar(t *testing.T) {
 	require.Equal(t, "proposal-1", resp.ProposalID)
 	require.Equal(t, "event-123", resp.EventID)
 	require.Equal(t, "primary", updater.calendarID)
-	require.Equal(t, "applied", resp.Status)
+	require.Equal(t, calendar_planner.ProposalAccepted, resp.Status)
 
 	require.Equal(t, 1, updater.calls)
 	require.Equal(t, "2025-01-01T10:00:00Z", updater.lastEvent.Start.DateTime)
@@ -120,5 +215,269 @@ func TestConfirmProposalUpdatesCalendar(t *testing.T) {
 
 	saved, err := proposalStore.GetProposal(context.Background(), "user-1", "proposal-1")
 	require.NoError(t, err)
-	require.Equal(t, "applied", saved.Status)
+	require.Equal(t, calendar_planner.ProposalAccepted, saved.Status)
+
+	// An accepted proposal cannot be confirmed a second time.
+	req = httptest.NewRequest(http.MethodPost, "/calendar/proposals/confirm", bytes.NewReader(body))
+	w = httptest.NewRecorder()
+	handler.handleConfirm(w, req)
+	require.Equal(t, http.StatusConflict, w.Code)
+	require.Equal(t, 1, updater.calls)
+}
+
+func TestConfirmProposalAppliesAllOperationsOrRollsBack(t *testing.T) {
+	at := func(hour int) calendar_planner.GoogleCalendarTime {
+		return calendar_planner.GoogleCalendarTime{DateTime: time.Date(2025, 1, 1, hour, 0, 0, 0, time.UTC).Format(time.RFC3339), TimeZone: "UTC"}
+	}
+	newProposal := func() *calendar_planner.ShadowProposal {
+		return &calendar_planner.ShadowProposal{
+			ID:               "proposal-1",
+			UserID:           "user-1",
+			PrimaryEvent:     &calendar_planner.ShadowEventSummary{EventID: "standup", CalendarID: "primary"},
+			ConflictingEvent: &calendar_planner.ShadowEventSummary{EventID: "review", CalendarID: "team@example.com"},
+			Status:           calendar_planner.ProposalPending,
+			Plan: &calendar_planner.CalendarPlan{Events: []calendar_planner.GoogleCalendarEvent{
+				{Action: "patch", Target: "primary", Summary: "Standup", Start: at(9), End: at(10)},
+				{Action: "patch", Target: "conflicting", Summary: "Review", Start: at(11), End: at(12)},
+				{Action: "create", Summary: "Focus", Start: at(13), End: at(14)},
+			}},
+		}
+	}
+	newCalendar := func() *stubCalendarUpdater {
+		return &stubCalendarUpdater{events: map[string]*cal.Event{
+			"standup": {Id: "standup", Summary: "Standup", Status: "confirmed", Start: &cal.EventDateTime{DateTime: at(10).DateTime}, End: &cal.EventDateTime{DateTime: at(11).DateTime}},
+			"review":  {Id: "review", Summary: "Review", Status: "confirmed", Start: &cal.EventDateTime{DateTime: at(10).DateTime}, End: &cal.EventDateTime{DateTime: at(11).DateTime}},
+		}}
+	}
+	confirm := func(store *stubProposalService, calendar *stubCalendarUpdater) (int, confirmProposalResponse) {
+		handler := &proposalConfirmHandler{
+			proposals: store,
+			newUpdater: func(ctx context.Context, userID string) (calendarUpdater, error) {
+				return calendar, nil
+			},
+		}
+		// Clients send the calendar_id of the event shown; whole plans ignore it.
+		body, err := json.Marshal(confirmProposalRequest{UserID: "user-1", ProposalID: "proposal-1", CalendarID: "primary"})
+		require.NoError(t, err)
+		w := httptest.NewRecorder()
+		handler.handleConfirm(w, httptest.NewRequest(http.MethodPost, "/calendar/proposals/confirm", bytes.NewReader(body)))
+		var resp confirmProposalResponse
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+		return w.Code, resp
+	}
+
+	// Every operation succeeds: both events move and a new block is created.
+	store := &stubProposalService{proposals: map[string]*calendar_planner.ShadowProposal{"proposal-1": newProposal()}}
+	calendar := newCalendar()
+	code, resp := confirm(store, calendar)
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, []string{"update standup", "update review", "insert created-1"}, calendar.log)
+	require.Len(t, resp.Operations, 3)
+	for _, op := range resp.Operations {
+		require.Equal(t, calendar_planner.OperationApplied, op.Status)
+	}
+	require.Equal(t, []string{"primary", "team@example.com", "primary"},
+		[]string{resp.Operations[0].CalendarID, resp.Operations[1].CalendarID, resp.Operations[2].CalendarID}, "each operation stays on its event's calendar")
+	require.Equal(t, "created-1", resp.Operations[2].EventID)
+	saved := store.proposals["proposal-1"]
+	require.Equal(t, calendar_planner.ProposalAccepted, saved.Status)
+	require.Len(t, saved.Operations, 3)
+	require.Equal(t, at(10).DateTime, saved.Operations[0].Before.Start.DateTime, "pre-change state is recorded")
+
+	// The create fails: both patches are reverted newest first and the proposal stays pending.
+	store = &stubProposalService{proposals: map[string]*calendar_planner.ShadowProposal{"proposal-1": newProposal()}}
+	calendar = newCalendar()
+	calendar.failOn = map[string]error{"insert": errors.New("quota exceeded")}
+	code, resp = confirm(store, calendar)
+	require.Equal(t, http.StatusBadGateway, code)
+	require.False(t, resp.OK)
+	require.Contains(t, resp.Error, "quota exceeded")
+	require.Equal(t, []string{"update standup", "update review", "update review", "update standup"}, calendar.log)
+	require.Equal(t, []string{calendar_planner.OperationRolledBack, calendar_planner.OperationRolledBack, calendar_planner.OperationFailed},
+		[]string{resp.Operations[0].Status, resp.Operations[1].Status, resp.Operations[2].Status})
+	require.Equal(t, at(10).DateTime, calendar.events["standup"].Start.DateTime)
+	require.Equal(t, at(10).DateTime, calendar.events["review"].Start.DateTime)
+	require.Equal(t, calendar_planner.ProposalPending, store.proposals["proposal-1"].Status)
+
+	// A delete is compensated by restoring the cancelled event; later steps are skipped.
+	proposal := newProposal()
+	proposal.Plan.Events = []calendar_planner.GoogleCalendarEvent{
+		{Action: "delete", Target: "conflicting"},
+		{Action: "patch", Target: "primary", Summary: "Standup", Start: at(9), End: at(10)},
+		{Action: "create", Summary: "Focus", Start: at(13), End: at(14)},
+	}
+	store = &stubProposalService{proposals: map[string]*calendar_planner.ShadowProposal{"proposal-1": proposal}}
+	calendar = newCalendar()
+	calendar.failOn = map[string]error{"update:standup": errors.New("forbidden")}
+	code, resp = confirm(store, calendar)
+	require.Equal(t, http.StatusBadGateway, code)
+	require.Equal(t, []string{"delete review", "update review"}, calendar.log)
+	require.Equal(t, "confirmed", calendar.events["review"].Status)
+	require.Equal(t, calendar_planner.OperationSkipped, resp.Operations[2].Status)
+}
+
+// gatedCalendarUpdater holds every update until proceed is closed.
+type gatedCalendarUpdater struct {
+	*stubCalendarUpdater
+	entered chan struct{}
+	proceed chan struct{}
+}
+
+func (g *gatedCalendarUpdater) UpdateEvent(ctx context.Context, calendarID, eventID string, event *cal.Event) (*cal.Event, error) {
+	g.entered <- struct{}{}
+	<-g.proceed
+	return g.stubCalendarUpdater.UpdateEvent(ctx, calendarID, eventID, event)
+}
+
+func TestConcurrentConfirmsApplyOnce(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	service, err := calendar_planner.NewShadowCalendarService(client, stubPlannerRunner{}, calendar_planner.ShadowCalendarOptions{})
+	require.NoError(t, err)
+	start := time.Now().UTC().Add(24 * time.Hour).Truncate(time.Hour)
+	require.NoError(t, service.SaveProposal(context.Background(), &calendar_planner.ShadowProposal{
+		ID:               "proposal-1",
+		UserID:           "user-1",
+		PrimaryEvent:     &calendar_planner.ShadowEventSummary{EventID: "standup", CalendarID: "primary"},
+		ConflictingEvent: &calendar_planner.ShadowEventSummary{EventID: "review", CalendarID: "primary"},
+		Status:           calendar_planner.ProposalPending,
+		ExpiresAt:        start.Add(time.Hour),
+		Plan: &calendar_planner.CalendarPlan{Events: []calendar_planner.GoogleCalendarEvent{{
+			Action: "create", Summary: "Focus",
+			Start: calendar_planner.GoogleCalendarTime{DateTime: start.Add(2 * time.Hour).Format(time.RFC3339)},
+			End:   calendar_planner.GoogleCalendarTime{DateTime: start.Add(3 * time.Hour).Format(time.RFC3339)},
+		}, {
+			Action: "patch", Target: "primary", Summary: "Standup",
+			Start: calendar_planner.GoogleCalendarTime{DateTime: start.Add(4 * time.Hour).Format(time.RFC3339)},
+			End:   calendar_planner.GoogleCalendarTime{DateTime: start.Add(5 * time.Hour).Format(time.RFC3339)},
+		}}},
+	}))
+
+	calendar := &gatedCalendarUpdater{
+		stubCalendarUpdater: &stubCalendarUpdater{events: map[string]*cal.Event{}},
+		entered:             make(chan struct{}, 2),
+		proceed:             make(chan struct{}),
+	}
+	handler := &proposalConfirmHandler{
+		proposals: service,
+		newUpdater: func(ctx context.Context, userID string) (calendarUpdater, error) {
+			return calendar, nil
+		},
+	}
+	confirm := func() <-chan *httptest.ResponseRecorder {
+		done := make(chan *httptest.ResponseRecorder, 1)
+		go func() {
+			body, _ := json.Marshal(confirmProposalRequest{UserID: "user-1", ProposalID: "proposal-1"})
+			w := httptest.NewRecorder()
+			handler.handleConfirm(w, httptest.NewRequest(http.MethodPost, "/calendar/proposals/confirm", bytes.NewReader(body)))
+			done <- w
+		}()
+		return done
+	}
+	wait := func(done <-chan *httptest.ResponseRecorder) *httptest.ResponseRecorder {
+		select {
+		case w := <-done:
+			return w
+		case <-time.After(5 * time.Second):
+			t.Fatal("confirm did not return")
+			return nil
+		}
+	}
+
+	// The first confirm has created the focus block and is moving the standup.
+	first := confirm()
+	<-calendar.entered
+	second := wait(confirm())
+	require.Equal(t, http.StatusConflict, second.Code)
+	require.Contains(t, second.Body.String(), calendar_planner.ErrProposalBusy.Error())
+
+	close(calendar.proceed)
+	require.Equal(t, http.StatusOK, wait(first).Code)
+	require.Equal(t, []string{"insert created-1", "update standup"}, calendar.log, "the plan is applied once")
+
+	// The claim is released; later confirms see the proposal accepted.
+	third := wait(confirm())
+	require.Equal(t, http.StatusConflict, third.Code)
+	require.Contains(t, third.Body.String(), "proposal is accepted")
+}
+
+func TestUndoProposalRestoresSnapshots(t *testing.T) {
+	at := func(hour int) *cal.EventDateTime {
+		return &cal.EventDateTime{DateTime: time.Date(2025, 1, 1, hour, 0, 0, 0, time.UTC).Format(time.RFC3339), TimeZone: "UTC"}
+	}
+	planTime := func(hour int) calendar_planner.GoogleCalendarTime {
+		return calendar_planner.GoogleCalendarTime{DateTime: at(hour).DateTime, TimeZone: "UTC"}
+	}
+	setup := func(window time.Duration) (*proposalConfirmHandler, *stubProposalService, *stubCalendarUpdater) {
+		store := &stubProposalService{proposals: map[string]*calendar_planner.ShadowProposal{"proposal-1": {
+			ID:               "proposal-1",
+			UserID:           "user-1",
+			PrimaryEvent:     &calendar_planner.ShadowEventSummary{EventID: "standup"},
+			ConflictingEvent: &calendar_planner.ShadowEventSummary{EventID: "review"},
+			Status:           calendar_planner.ProposalPending,
+			Plan: &calendar_planner.CalendarPlan{Events: []calendar_planner.GoogleCalendarEvent{
+				{Action: "patch", Target: "primary", Summary: "Standup (moved)", Start: planTime(9), End: planTime(10)},
+				{Action: "create", Summary: "Focus", Start: planTime(13), End: planTime(14)},
+			}},
+		}}}
+		calendar := &stubCalendarUpdater{events: map[string]*cal.Event{
+			"standup": {Id: "standup", Summary: "Standup", Status: "confirmed", Etag: `"0"`, Start: at(10), End: at(11)},
+		}}
+		handler := &proposalConfirmHandler{
+			proposals:  store,
+			undoWindow: window,
+			newUpdater: func(ctx context.Context, userID string) (calendarUpdater, error) {
+				return calendar, nil
+			},
+		}
+		return handler, store, calendar
+	}
+	post := func(handle http.HandlerFunc, body any) *httptest.ResponseRecorder {
+		payload, err := json.Marshal(body)
+		require.NoError(t, err)
+		w := httptest.NewRecorder()
+		handle(w, httptest.NewRequest(http.MethodPost, "/calendar/proposals/undo", bytes.NewReader(payload)))
+		return w
+	}
+	confirmReq := confirmProposalRequest{UserID: "user-1", ProposalID: "proposal-1"}
+	undoReq := undoProposalRequest{UserID: "user-1", ProposalID: "proposal-1"}
+
+	handler, store, calendar := setup(time.Minute)
+	require.Equal(t, http.StatusConflict, post(handler.handleUndo, undoReq).Code, "pending proposals have nothing to undo")
+	require.Equal(t, http.StatusOK, post(handler.handleConfirm, confirmReq).Code)
+	require.Equal(t, "Standup (moved)", calendar.events["standup"].Summary)
+
+	w := post(handler.handleUndo, undoReq)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var resp undoProposalResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+	require.True(t, resp.OK)
+	require.Equal(t, calendar_planner.ProposalPending, resp.Status)
+	require.Equal(t, "Standup", calendar.events["standup"].Summary)
+	require.Equal(t, at(10).DateTime, calendar.events["standup"].Start.DateTime)
+	require.Equal(t, "cancelled", calendar.events["created-1"].Status)
+	require.Equal(t, calendar_planner.ProposalPending, store.proposals["proposal-1"].Status)
+
+	// An event edited after the confirm is left alone.
+	handler, store, calendar = setup(time.Minute)
+	require.Equal(t, http.StatusOK, post(handler.handleConfirm, confirmReq).Code)
+	calendar.events["standup"].Summary = "Standup (edited by hand)"
+	calendar.touch(calendar.events["standup"])
+	calendar.log = nil
+	w = post(handler.handleUndo, undoReq)
+	require.Equal(t, http.StatusConflict, w.Code)
+	require.Contains(t, w.Body.String(), "event standup")
+	require.Empty(t, calendar.log)
+	require.Equal(t, calendar_planner.ProposalAccepted, store.proposals["proposal-1"].Status)
+
+	// Past the window the confirm stands.
+	handler, _, calendar = setup(time.Nanosecond)
+	require.Equal(t, http.StatusOK, post(handler.handleConfirm, confirmReq).Code)
+	time.Sleep(time.Millisecond)
+	calendar.log = nil
+	w = post(handler.handleUndo, undoReq)
+	require.Equal(t, http.StatusConflict, w.Code)
+	require.Contains(t, w.Body.String(), "undo window")
+	require.Empty(t, calendar.log)
 }
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"alfred-cloud/security"
+	"alfred-cloud/subagents/calendar_planner"
+	"github.com/gorilla/mux"
+	calendar "google.golang.org/api/calendar/v3"
+)
+
+type calendarServiceFunc func(ctx context.Context, userID string) (*calendar.Service, error)
+
+type calendarListHandler struct {
+	directory       *calendar_planner.CalendarDirectory
+	calendarService calendarServiceFunc
+}
+
+type calendarListResponse struct {
+	UserID    string                          `json:"user_id"`
+	Calendars []calendar_planner.CalendarInfo `json:"calendars"`
+}
+
+// updateCalendarsRequest replaces the watched calendars when Watched is set and
+// flips the informational flag for each calendar listed in Informational.
+type updateCalendarsRequest struct {
+	UserID        string          `json:"user_id"`
+	Watched       []string        `json:"watched,omitempty"`
+	Informational map[string]bool `json:"informational,omitempty"`
+}
+
+func registerCalendarListRoutes(r *mux.Router, directory *calendar_planner.CalendarDirectory, tokenStore *security.TokenStore) {
+	h := &calendarListHandler{
+		directory: directory,
+		calendarService: func(ctx context.Context, userID string) (*calendar.Service, error) {
+			return security.NewGoogleServiceClient(tokenStore).GetCalendarService(ctx, userID)
+		},
+	}
+	r.HandleFunc("/calendar/calendars", h.handleList).Methods("GET")
+	r.HandleFunc("/calendar/calendars", h.handleUpdate).Methods("PUT")
+}
+
+// handleList returns the user's calendars. ?refresh=true re-discovers them from Google.
+func (h *calendarListHandler) handleList(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID, err := requestUserID(r, r.URL.Query().Get("user_id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if userID == "" {
+		http.Error(w, "user_id is required", http.StatusBadRequest)
+		return
+	}
+
+	var calendars []calendar_planner.CalendarInfo
+	if r.URL.Query().Get("refresh") == "true" {
+		service, err := h.calendarService(ctx, userID)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed to get calendar service: %v", err), http.StatusUnauthorized)
+			return
+		}
+		calendars, err = h.directory.Discover(ctx, userID, service)
+		if err != nil {
+			log.Printf("calendar list: discover for %s: %v", userID, err)
+			http.Error(w, "failed to discover calendars", http.StatusBadGateway)
+			return
+		}
+	} else {
+		calendars, err = h.directory.Calendars(ctx, userID)
+		if err != nil {
+			log.Printf("calendar list: load for %s: %v", userID, err)
+			http.Error(w, "failed to load calendars", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	writeRegistryJSON(w, calendarListResponse{UserID: userID, Calendars: calendars})
+}
+
+func (h *calendarListHandler) handleUpdate(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	ctx := r.Context()
+
+	var req updateCalendarsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	userID, err := requestUserID(r, req.UserID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if userID == "" {
+		http.Error(w, "user_id is required", http.StatusBadRequest)
+		return
+	}
+
+	if req.Watched != nil {
+		if _, err := h.directory.SetWatched(ctx, userID, req.Watched); err != nil {
+			log.Printf("calendar list: set watched for %s: %v", userID, err)
+			http.Error(w, "failed to update watched calendars", http.StatusInternalServerError)
+			return
+		}
+	}
+	for calendarID, informational := range req.Informational {
+		if strings.TrimSpace(calendarID) == "" {
+			http.Error(w, "informational calendar ids must not be empty", http.StatusBadRequest)
+			return
+		}
+		if err := h.directory.SetInformational(ctx, userID, calendarID, informational); err != nil {
+			log.Printf("calendar list: set informational for %s: %v", userID, err)
+			http.Error(w, "failed to update informational calendars", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	calendars, err := h.directory.Calendars(ctx, userID)
+	if err != nil {
+		http.Error(w, "failed to load calendars", http.StatusInternalServerError)
+		return
+	}
+	writeRegistryJSON(w, calendarListResponse{UserID: userID, Calendars: calendars})
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"alfred-cloud/security"
+	"alfred-cloud/subagents/calendar_planner"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gorilla/mux"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+	calendar "google.golang.org/api/calendar/v3"
+	"google.golang.org/api/option"
+)
+
+func TestCalendarListRoutesDiscoverAndConfigure(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+
+	google := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/calendar/v3/users/me/calendarList", r.URL.Path)
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(calendar.CalendarList{Items: []*calendar.CalendarListEntry{
+			{Id: "user@example.com", Summary: "user@example.com", Primary: true, AccessRole: "owner"},
+			{Id: "en.usa#holiday@group.v.calendar.google.com", Summary: "Holidays", AccessRole: "reader"},
+			{Id: "partner@example.com", Summary: "Partner", SummaryOverride: "Sam", AccessRole: "reader"},
+		}})
+	}))
+	t.Cleanup(google.Close)
+	service, err := calendar.NewService(context.Background(), option.WithEndpoint(google.URL+"/calendar/v3/"), option.WithHTTPClient(google.Client()))
+	require.NoError(t, err)
+
+	directory := calendar_planner.NewCalendarDirectory(client)
+	h := &calendarListHandler{
+		directory: directory,
+		calendarService: func(ctx context.Context, userID string) (*calendar.Service, error) {
+			return service, nil
+		},
+	}
+	verifier, err := security.NewTokenVerifier("test-secret")
+	require.NoError(t, err)
+	r := mux.NewRouter()
+	r.Use(newAPIAuth(verifier).Middleware)
+	r.HandleFunc("/calendar/calendars", h.handleList).Methods("GET")
+	r.HandleFunc("/calendar/calendars", h.handleUpdate).Methods("PUT")
+	server := httptest.NewServer(r)
+	t.Cleanup(server.Close)
+	token := signTestToken(t, verifier, security.AuthClaims{Subject: "user-1"})
+
+	resp := doAuthRequest(t, http.MethodGet, server.URL+"/calendar/calendars?refresh=true", token, nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var list calendarListResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&list))
+	require.Len(t, list.Calendars, 3)
+	require.Equal(t, "primary", list.Calendars[0].ID, "the user's own calendar uses the primary alias")
+	require.True(t, list.Calendars[0].Watched, "primary is watched by default")
+	require.Equal(t, "Sam", list.Calendars[2].Summary)
+	require.False(t, list.Calendars[2].Watched)
+
+	// The user's address maps back to the primary alias so it gets one sync token.
+	resp = doAuthRequest(t, http.MethodPut, server.URL+"/calendar/calendars", token, updateCalendarsRequest{
+		Watched:       []string{"user@example.com", "partner@example.com"},
+		Informational: map[string]bool{"partner@example.com": true},
+	})
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	watched, err := directory.WatchedCalendars(context.Background(), "user-1")
+	require.NoError(t, err)
+	require.Equal(t, []string{"partner@example.com", "primary"}, watched)
+	informational, err := directory.InformationalCalendars(context.Background(), "user-1")
+	require.NoError(t, err)
+	require.Equal(t, map[string]bool{"partner@example.com": true}, informational)
+
+	resp = doAuthRequest(t, http.MethodGet, server.URL+"/calendar/calendars?user_id=user-2", token, nil)
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+}
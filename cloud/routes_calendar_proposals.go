@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"alfred-cloud/subagents/calendar_planner"
+	"github.com/gorilla/mux"
+)
+
+type proposalLifecycle interface {
+	ListProposals(ctx context.Context, userID, status string) ([]*calendar_planner.ShadowProposal, error)
+	GetProposal(ctx context.Context, userID, proposalID string) (*calendar_planner.ShadowProposal, error)
+	TransitionProposal(ctx context.Context, userID, proposalID, status, reason string) (*calendar_planner.ShadowProposal, error)
+}
+
+type proposalHandler struct {
+	proposals proposalLifecycle
+}
+
+type proposalListResponse struct {
+	UserID    string                             `json:"user_id"`
+	Proposals []*calendar_planner.ShadowProposal `json:"proposals"`
+}
+
+type rejectProposalRequest struct {
+	UserID string `json:"user_id"`
+	Reason string `json:"reason,omitempty"`
+}
+
+func registerProposalRoutes(router *mux.Router, service *calendar_planner.ShadowCalendarService) {
+	if router == nil || service == nil {
+		return
+	}
+	handler := &proposalHandler{proposals: service}
+	router.HandleFunc("/calendar/proposals", handler.handleList).Methods("GET")
+	router.HandleFunc("/calendar/proposals/{proposalID}", handler.handleGet).Methods("GET")
+	router.HandleFunc("/calendar/proposals/{proposalID}/reject", handler.handleReject).Methods("POST")
+}
+
+// handleList returns the user's proposals, optionally filtered with ?status=.
+func (h *proposalHandler) handleList(w http.ResponseWriter, r *http.Request) {
+	userID, err := requestUserID(r, r.URL.Query().Get("user_id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if userID == "" {
+		http.Error(w, "user_id is required", http.StatusBadRequest)
+		return
+	}
+	status := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("status")))
+	if status != "" && !calendar_planner.IsProposalStatus(status) {
+		http.Error(w, "unknown status", http.StatusBadRequest)
+		return
+	}
+
+	proposals, err := h.proposals.ListProposals(r.Context(), userID, status)
+	if err != nil {
+		log.Printf("calendar proposals: list for %s: %v", userID, err)
+		http.Error(w, "failed to load proposals", http.StatusInternalServerError)
+		return
+	}
+	writeRegistryJSON(w, proposalListResponse{UserID: userID, Proposals: proposals})
+}
+
+func (h *proposalHandler) handleGet(w http.ResponseWriter, r *http.Request) {
+	userID, err := requestUserID(r, r.URL.Query().Get("user_id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if userID == "" {
+		http.Error(w, "user_id is required", http.StatusBadRequest)
+		return
+	}
+
+	proposal, err := h.proposals.GetProposal(r.Context(), userID, mux.Vars(r)["proposalID"])
+	if err != nil {
+		log.Printf("calendar proposals: get for %s: %v", userID, err)
+		http.Error(w, "failed to load proposal", http.StatusInternalServerError)
+		return
+	}
+	if proposal == nil {
+		http.Error(w, "proposal not found", http.StatusNotFound)
+		return
+	}
+	writeRegistryJSON(w, proposal)
+}
+
+// handleReject declines a pending proposal. The conflict it resolved is not
+// proposed again unless one of the events moves.
+func (h *proposalHandler) handleReject(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	var req rejectProposalRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	userID, err := requestUserID(r, req.UserID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if userID == "" {
+		http.Error(w, "user_id is required", http.StatusBadRequest)
+		return
+	}
+	reason := strings.TrimSpace(req.Reason)
+	if reason == "" {
+		reason = "rejected by user"
+	}
+
+	proposal, err := h.proposals.TransitionProposal(r.Context(), userID, mux.Vars(r)["proposalID"], calendar_planner.ProposalRejected, reason)
+	switch {
+	case errors.Is(err, calendar_planner.ErrProposalNotFound):
+		http.Error(w, "proposal not found", http.StatusNotFound)
+		return
+	case errors.Is(err, calendar_planner.ErrInvalidTransition):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		log.Printf("calendar proposals: reject for %s: %v", userID, err)
+		http.Error(w, "failed to reject proposal", http.StatusInternalServerError)
+		return
+	}
+	writeRegistryJSON(w, proposal)
+}
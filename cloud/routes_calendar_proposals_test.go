@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"alfred-cloud/security"
+	"alfred-cloud/subagents/calendar_planner"
+	"alfred-cloud/wb"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gorilla/mux"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+)
+
+type stubPlannerRunner struct{}
+
+func (stubPlannerRunner) GenerateCalendarPlan(ctx context.Context, planDate, timeBlock, activityType string) (*calendar_planner.CalendarPlan, error) {
+	return &calendar_planner.CalendarPlan{}, nil
+}
+
+func TestProposalRoutesListInspectAndReject(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	service, err := calendar_planner.NewShadowCalendarService(client, stubPlannerRunner{}, calendar_planner.ShadowCalendarOptions{})
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	start := time.Now().UTC().Add(24 * time.Hour)
+	for _, id := range []string{"proposal-1", "proposal-2"} {
+		require.NoError(t, service.SaveProposal(ctx, &calendar_planner.ShadowProposal{
+			ID:               id,
+			UserID:           "user-1",
+			ConflictKey:      "evt-a|" + id,
+			PrimaryEvent:     &calendar_planner.ShadowEventSummary{EventID: "evt-a"},
+			ConflictingEvent: &calendar_planner.ShadowEventSummary{EventID: id},
+			Status:           calendar_planner.ProposalPending,
+			ExpiresAt:        start,
+			CreatedAt:        time.Now().UTC(),
+		}))
+	}
+
+	verifier, err := security.NewTokenVerifier("test-secret")
+	require.NoError(t, err)
+	r := mux.NewRouter()
+	r.Use(newAPIAuth(verifier).Middleware)
+	registerProposalRoutes(r, service)
+	server := httptest.NewServer(r)
+	t.Cleanup(server.Close)
+	token := signTestToken(t, verifier, security.AuthClaims{Subject: "user-1"})
+
+	resp := doAuthRequest(t, http.MethodPost, server.URL+"/calendar/proposals/proposal-1/reject", token, rejectProposalRequest{Reason: "keep both"})
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var rejected calendar_planner.ShadowProposal
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&rejected))
+	require.Equal(t, calendar_planner.ProposalRejected, rejected.Status)
+	require.Equal(t, "keep both", rejected.StatusReason)
+
+	resp = doAuthRequest(t, http.MethodPost, server.URL+"/calendar/proposals/proposal-1/reject", token, rejectProposalRequest{})
+	require.Equal(t, http.StatusConflict, resp.StatusCode)
+	resp = doAuthRequest(t, http.MethodPost, server.URL+"/calendar/proposals/missing/reject", token, rejectProposalRequest{})
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp = doAuthRequest(t, http.MethodGet, server.URL+"/calendar/proposals?status=pending", token, nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var list proposalListResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&list))
+	require.Len(t, list.Proposals, 1)
+	require.Equal(t, "proposal-2", list.Proposals[0].ID)
+
+	resp = doAuthRequest(t, http.MethodGet, server.URL+"/calendar/proposals?status=bogus", token, nil)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp = doAuthRequest(t, http.MethodGet, server.URL+"/calendar/proposals/proposal-1", token, nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var inspected calendar_planner.ShadowProposal
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&inspected))
+	require.Equal(t, calendar_planner.ProposalRejected, inspected.Status)
+	require.Len(t, inspected.History, 1)
+
+	// Other users cannot read someone else's proposals.
+	other := signTestToken(t, verifier, security.AuthClaims{Subject: "user-2"})
+	resp = doAuthRequest(t, http.MethodGet, server.URL+"/calendar/proposals?user_id=user-1", other, nil)
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	entries, err := client.XRange(ctx, wb.StreamKey("user-1"), "-", "+").Result()
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, "calendar.proposal.rejected", entries[0].Values["type"])
+}
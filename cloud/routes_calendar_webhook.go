@@ -26,7 +26,7 @@ const calendarSyncLookback = 30 * 24 * time.Hour
 type CalendarWebhookHandler struct {
 	redisClient      *redis.Client
 	tokenStore       *security.TokenStore
-	webhookRegistrar *calendar_planner.WebhookRegistrar
+	calendars        *calendar_planner.CalendarDirectory
 	streamsHelper    *streams.StreamsHelper
 	heuristicService *productivity.HeuristicService
 }
@@ -36,6 +36,7 @@ func NewCalendarWebhookHandler(redisClient *redis.Client, tokenStore *security.T
 	return &CalendarWebhookHandler{
 		redisClient:      redisClient,
 		tokenStore:       tokenStore,
+		calendars:        calendar_planner.NewCalendarDirectory(redisClient),
 		streamsHelper:    streamsHelper,
 		heuristicService: heuristicService,
 	}
@@ -57,13 +58,18 @@ type WebhookRegistrationRequest struct {
 	WebhookURL string `json:"webhook_url,omitempty"`
 }
 
-// WebhookRegistrationResponse represents the response from webhook registration
+// WebhookRegistrationResponse represents the response from webhook registration.
+// The top-level fields describe the first channel; Channels lists one channel
+// per registered calendar.
 type WebhookRegistrationResponse struct {
-	ChannelID  string    `json:"channel_id"`
-	ResourceID string    `json:"resource_id"`
-	Expiration time.Time `json:"expiration"`
-	WebhookURL string    `json:"webhook_url"`
-	Status     string    `json:"status"`
+	ChannelID       string                        `json:"channel_id"`
+	ResourceID      string                        `json:"resource_id"`
+	CalendarID      string                        `json:"calendar_id"`
+	Expiration      time.Time                     `json:"expiration"`
+	WebhookURL      string                        `json:"webhook_url"`
+	Status          string                        `json:"status"`
+	Channels        []WebhookRegistrationResponse `json:"channels,omitempty"`
+	FailedCalendars []string                      `json:"failed_calendars,omitempty"`
 }
 
 // WebhookNotification represents a Google Calendar push notification
@@ -84,15 +90,27 @@ func (h *CalendarWebhookHandler) handleRegisterWebhook(w http.ResponseWriter, r
 		return
 	}
 
+	userID, err := requestUserID(r, req.UserID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	req.UserID = userID
+
 	// Validate required fields
 	if req.UserID == "" {
 		http.Error(w, "user_id is required", http.StatusBadRequest)
 		return
 	}
 
-	// Default to primary calendar if not specified
-	if req.CalendarID == "" {
-		req.CalendarID = "primary"
+	// Without an explicit calendar, register a channel for every watched calendar
+	calendarIDs := []string{strings.TrimSpace(req.CalendarID)}
+	if calendarIDs[0] == "" {
+		calendarIDs, err = h.calendars.WatchedCalendars(ctx, req.UserID)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to load watched calendars: %v", err), http.StatusInternalServerError)
+			return
+		}
 	}
 
 	// Get Google Calendar service
@@ -114,28 +132,53 @@ func (h *CalendarWebhookHandler) handleRegisterWebhook(w http.ResponseWriter, r
 		webhookURL = fmt.Sprintf("%s://%s/calendar/webhook/notification", scheme, r.Host)
 	}
 
-	// Initialize webhook registrar if not already done
-	if h.webhookRegistrar == nil {
-		h.webhookRegistrar = calendar_planner.NewWebhookRegistrar(h.redisClient, calendarService)
+	registrar := calendar_planner.NewWebhookRegistrar(h.redisClient, calendarService)
+	var (
+		channels []WebhookRegistrationResponse
+		failed   []string
+		lastErr  error
+	)
+	for _, calendarID := range calendarIDs {
+		channel, err := h.registerCalendarWebhook(ctx, registrar, calendarService, req.UserID, calendarID, webhookURL)
+		if err != nil {
+			log.Printf("Warning: Failed to register webhook for user %s calendar %s: %v", req.UserID, calendarID, err)
+			failed = append(failed, calendarID)
+			lastErr = err
+			continue
+		}
+		channels = append(channels, *channel)
 	}
+	if len(channels) == 0 {
+		http.Error(w, fmt.Sprintf("Failed to register webhook: %v", lastErr), http.StatusInternalServerError)
+		return
+	}
+
+	response := channels[0]
+	response.Channels = channels
+	response.FailedCalendars = failed
 
-	// Register webhook
-	channel, err := h.webhookRegistrar.RegisterWebhook(ctx, req.UserID, req.CalendarID, webhookURL)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// registerCalendarWebhook opens a push channel for one calendar, records it and
+// primes the calendar's sync token.
+func (h *CalendarWebhookHandler) registerCalendarWebhook(ctx context.Context, registrar *calendar_planner.WebhookRegistrar, calendarService *calendar.Service, userID, calendarID, webhookURL string) (*WebhookRegistrationResponse, error) {
+	channel, err := registrar.RegisterWebhook(ctx, userID, calendarID, webhookURL)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to register webhook: %v", err), http.StatusInternalServerError)
-		return
+		return nil, err
 	}
 
 	// Store webhook registration in Redis
-	registrationKey := fmt.Sprintf("calendar_webhook:%s:%s", req.UserID, channel.Id)
+	registrationKey := fmt.Sprintf("calendar_webhook:%s:%s", userID, channel.Id)
 	registrationData := map[string]interface{}{
 		"channel_id":  channel.Id,
 		"resource_id": channel.ResourceId,
-		"calendar_id": req.CalendarID,
+		"calendar_id": calendarID,
 		"webhook_url": webhookURL,
 		"expiration":  channel.Expiration,
 		"created_at":  time.Now(),
-		"user_id":     req.UserID,
+		"user_id":     userID,
 	}
 
 	if err := h.redisClient.HMSet(ctx, registrationKey, registrationData).Err(); err != nil {
@@ -149,20 +192,18 @@ func (h *CalendarWebhookHandler) handleRegisterWebhook(w http.ResponseWriter, r
 		log.Printf("Warning: Failed to set expiration on webhook registration: %v", err)
 	}
 
-	if _, _, err := h.getOrCreateSyncToken(ctx, calendarService, req.UserID, req.CalendarID); err != nil {
+	if _, _, err := h.getOrCreateSyncToken(ctx, calendarService, userID, calendarID); err != nil {
 		log.Printf("Warning: Failed to prime calendar sync token: %v", err)
 	}
 
-	response := WebhookRegistrationResponse{
+	return &WebhookRegistrationResponse{
 		ChannelID:  channel.Id,
 		ResourceID: channel.ResourceId,
+		CalendarID: calendarID,
 		Expiration: expirationTime,
 		WebhookURL: webhookURL,
 		Status:     "registered",
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	}, nil
 }
 
 // handleWebhookNotification handles incoming webhook notifications from Google Calendar
@@ -219,6 +260,11 @@ func (h *CalendarWebhookHandler) handleWebhookNotification(w http.ResponseWriter
 		w.WriteHeader(http.StatusOK)
 		return
 	}
+	if watched, err := h.calendars.IsWatched(ctx, userID, calendarID); err == nil && !watched {
+		log.Printf("Calendar webhook: ignoring notification for unwatched calendar user=%s calendar=%s", userID, calendarID)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
 
 	changes, err := h.collectCalendarChanges(ctx, userID, calendarID)
 	if err != nil {
@@ -310,12 +356,19 @@ func (h *CalendarWebhookHandler) handleUnregisterWebhook(w http.ResponseWriter,
 		return
 	}
 
+	userID, err := requestUserID(r, req.UserID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	req.UserID = userID
+
 	if req.UserID == "" {
 		http.Error(w, "user_id is required", http.StatusBadRequest)
 		return
 	}
 
-	// Find webhook registration for this user
+	// Find webhook registrations for this user, optionally limited to one calendar
 	pattern := fmt.Sprintf("calendar_webhook:%s:*", req.UserID)
 	keys, err := h.redisClient.Keys(ctx, pattern).Result()
 	if err != nil {
@@ -323,50 +376,64 @@ func (h *CalendarWebhookHandler) handleUnregisterWebhook(w http.ResponseWriter,
 		return
 	}
 
-	if len(keys) == 0 {
-		http.Error(w, "No webhooks found for user", http.StatusNotFound)
-		return
+	type registration struct {
+		key        string
+		channelID  string
+		resourceID string
+	}
+	calendarFilter := strings.TrimSpace(req.CalendarID)
+	var registrations []registration
+	for _, key := range keys {
+		registrationData, err := h.redisClient.HMGet(ctx, key, "channel_id", "resource_id", "calendar_id").Result()
+		if err != nil {
+			http.Error(w, "Failed to get webhook details", http.StatusInternalServerError)
+			return
+		}
+		channelID, ok1 := registrationData[0].(string)
+		resourceID, ok2 := registrationData[1].(string)
+		if !ok1 || !ok2 {
+			http.Error(w, "Invalid webhook registration data", http.StatusInternalServerError)
+			return
+		}
+		if calendarFilter != "" {
+			if calendarID, _ := registrationData[2].(string); calendarID != calendarFilter {
+				continue
+			}
+		}
+		registrations = append(registrations, registration{key: key, channelID: channelID, resourceID: resourceID})
 	}
 
-	// Get registration details
-	registrationData, err := h.redisClient.HMGet(ctx, keys[0], "channel_id", "resource_id").Result()
-	if err != nil {
-		http.Error(w, "Failed to get webhook details", http.StatusInternalServerError)
+	if len(registrations) == 0 {
+		http.Error(w, "No webhooks found for user", http.StatusNotFound)
 		return
 	}
 
-	channelID, ok1 := registrationData[0].(string)
-	resourceID, ok2 := registrationData[1].(string)
-	if !ok1 || !ok2 {
-		http.Error(w, "Invalid webhook registration data", http.StatusInternalServerError)
+	googleClient := security.NewGoogleServiceClient(h.tokenStore)
+	calendarService, err := googleClient.GetCalendarService(ctx, req.UserID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get Calendar service: %v", err), http.StatusUnauthorized)
 		return
 	}
+	registrar := calendar_planner.NewWebhookRegistrar(h.redisClient, calendarService)
 
-	// Initialize webhook registrar if not already done
-	if h.webhookRegistrar == nil {
-		googleClient := security.NewGoogleServiceClient(h.tokenStore)
-		calendarService, err := googleClient.GetCalendarService(ctx, req.UserID)
-		if err != nil {
-			http.Error(w, fmt.Sprintf("Failed to get Calendar service: %v", err), http.StatusUnauthorized)
+	channelIDs := make([]string, 0, len(registrations))
+	for _, reg := range registrations {
+		if err := registrar.UnregisterWebhook(ctx, reg.channelID, reg.resourceID); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to unregister webhook: %v", err), http.StatusInternalServerError)
 			return
 		}
-		h.webhookRegistrar = calendar_planner.NewWebhookRegistrar(h.redisClient, calendarService)
-	}
-
-	// Unregister webhook
-	if err := h.webhookRegistrar.UnregisterWebhook(ctx, channelID, resourceID); err != nil {
-		http.Error(w, fmt.Sprintf("Failed to unregister webhook: %v", err), http.StatusInternalServerError)
-		return
-	}
 
-	// Remove from Redis
-	if err := h.redisClient.Del(ctx, keys[0]).Err(); err != nil {
-		log.Printf("Warning: Failed to remove webhook registration from Redis: %v", err)
+		// Remove from Redis
+		if err := h.redisClient.Del(ctx, reg.key).Err(); err != nil {
+			log.Printf("Warning: Failed to remove webhook registration from Redis: %v", err)
+		}
+		channelIDs = append(channelIDs, reg.channelID)
 	}
 
-	response := map[string]string{
-		"status":     "unregistered",
-		"channel_id": channelID,
+	response := map[string]interface{}{
+		"status":      "unregistered",
+		"channel_id":  channelIDs[0],
+		"channel_ids": channelIDs,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -376,7 +443,11 @@ func (h *CalendarWebhookHandler) handleUnregisterWebhook(w http.ResponseWriter,
 // handleWebhookStatus returns the status of registered webhooks
 func (h *CalendarWebhookHandler) handleWebhookStatus(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	userID := r.URL.Query().Get("user_id")
+	userID, err := requestUserID(r, r.URL.Query().Get("user_id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
 	if userID == "" {
 		http.Error(w, "user_id parameter is required", http.StatusBadRequest)
 		return
@@ -543,10 +614,13 @@ func (h *CalendarWebhookHandler) refreshSyncToken(ctx context.Context, calendarS
 	return token, nil
 }
 
+// fetchInitialSyncToken starts an incremental sync. Incremental syncs list
+// recurring series as masters plus their exceptions (SingleEvents false); the
+// shadow calendar expands the occurrences itself.
 func (h *CalendarWebhookHandler) fetchInitialSyncToken(ctx context.Context, calendarService *calendar.Service, calendarID string) (string, error) {
 	call := calendarService.Events.List(calendarID).
 		ShowDeleted(true).
-		SingleEvents(true).
+		SingleEvents(false).
 		TimeMin(time.Now().Add(-calendarSyncLookback).Format(time.RFC3339))
 
 	resp, err := call.Context(ctx).Do()
@@ -571,7 +645,7 @@ func (h *CalendarWebhookHandler) fetchChangedEvents(ctx context.Context, calenda
 	for {
 		call := calendarService.Events.List(calendarID).
 			ShowDeleted(true).
-			SingleEvents(true).
+			SingleEvents(false).
 			SyncToken(syncToken)
 
 		if pageToken != "" {
@@ -628,12 +702,23 @@ func (h *CalendarWebhookHandler) handleManualSync(w http.ResponseWriter, r *http
 		http.Error(w, "invalid json", http.StatusBadRequest)
 		return
 	}
+	userID, err := requestUserID(r, req.UserID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	req.UserID = userID
 	if req.UserID == "" {
 		http.Error(w, "user_id required", http.StatusBadRequest)
 		return
 	}
-	if req.CalendarID == "" {
-		req.CalendarID = "primary"
+	calendarIDs := []string{strings.TrimSpace(req.CalendarID)}
+	if calendarIDs[0] == "" {
+		calendarIDs, err = h.calendars.WatchedCalendars(ctx, req.UserID)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed to load watched calendars: %v", err), http.StatusInternalServerError)
+			return
+		}
 	}
 	lookback := time.Duration(req.LookbackH) * time.Hour
 	if lookback <= 0 {
@@ -647,10 +732,14 @@ func (h *CalendarWebhookHandler) handleManualSync(w http.ResponseWriter, r *http
 		return
 	}
 
-	events, err := h.fetchRecentEvents(ctx, calendarService, req.CalendarID, time.Now().Add(-lookback))
-	if err != nil {
-		http.Error(w, fmt.Sprintf("failed to fetch events: %v", err), http.StatusInternalServerError)
-		return
+	var events []map[string]interface{}
+	for _, calendarID := range calendarIDs {
+		recent, err := h.fetchRecentEvents(ctx, calendarService, calendarID, time.Now().Add(-lookback))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed to fetch events for calendar %s: %v", calendarID, err), http.StatusInternalServerError)
+			return
+		}
+		events = append(events, recent...)
 	}
 
 	if h.streamsHelper != nil {
@@ -661,7 +750,6 @@ func (h *CalendarWebhookHandler) handleManualSync(w http.ResponseWriter, r *http
 			changeData["resource_uri"] = fmt.Sprint(changeData["resource_uri"])
 			changeData["notified_at"] = time.Now().UTC().Format(time.RFC3339Nano)
 			changeData["user_id"] = req.UserID
-			changeData["calendar_id"] = req.CalendarID
 
 			if _, err := h.streamsHelper.AppendToStream(ctx, inputKey, changeData); err != nil {
 				log.Printf("Manual sync: failed to store calendar change: %v", err)
@@ -690,10 +778,11 @@ func (h *CalendarWebhookHandler) handleManualSync(w http.ResponseWriter, r *http
 
 	w.Header().Set("Content-Type", "application/json")
 	_ = json.NewEncoder(w).Encode(map[string]any{
-		"user_id":  req.UserID,
-		"calendar": req.CalendarID,
-		"count":    len(events),
-		"status":   "synced",
+		"user_id":   req.UserID,
+		"calendar":  calendarIDs[0],
+		"calendars": calendarIDs,
+		"count":     len(events),
+		"status":    "synced",
 	})
 }
 
@@ -712,6 +801,8 @@ func normalizeEventChange(event *calendar.Event, userID, calendarID string) map[
 	attendeesJSON, _ := json.Marshal(event.Attendees)
 	rawJSON, _ := json.Marshal(event)
 
+	originalStart, _, _ := formatEventDateTime(event.OriginalStartTime)
+
 	creatorEmail := ""
 	if event.Creator != nil {
 		creatorEmail = event.Creator.Email
@@ -725,31 +816,34 @@ func normalizeEventChange(event *calendar.Event, userID, calendarID string) map[
 	changeType := determineChangeType(event)
 
 	return map[string]interface{}{
-		"type":               "calendar_delta",
-		"user_id":            userID,
-		"calendar_id":        calendarID,
-		"event_id":           event.Id,
-		"event_summary":      event.Summary,
-		"event_description":  event.Description,
-		"event_location":     event.Location,
-		"start_time":         startTime,
-		"end_time":           endTime,
-		"start_timezone":     startTZ,
-		"end_timezone":       endTZ,
-		"all_day":            strconv.FormatBool(startAllDay),
-		"status":             event.Status,
-		"change_type":        changeType,
-		"html_link":          event.HtmlLink,
-		"creator_email":      creatorEmail,
-		"organizer_email":    organizerEmail,
-		"attendees_json":     string(attendeesJSON),
-		"attendees_count":    strconv.Itoa(len(event.Attendees)),
-		"sequence":           strconv.Itoa(int(event.Sequence)),
-		"created":            event.Created,
-		"updated":            event.Updated,
-		"hangout_link":       event.HangoutLink,
-		"recurring_event_id": event.RecurringEventId,
-		"raw_event":          string(rawJSON),
+		"type":                "calendar_delta",
+		"user_id":             userID,
+		"calendar_id":         calendarID,
+		"event_id":            event.Id,
+		"event_summary":       event.Summary,
+		"event_description":   event.Description,
+		"event_location":      event.Location,
+		"start_time":          startTime,
+		"end_time":            endTime,
+		"start_timezone":      startTZ,
+		"end_timezone":        endTZ,
+		"all_day":             strconv.FormatBool(startAllDay),
+		"status":              event.Status,
+		"transparency":        event.Transparency,
+		"change_type":         changeType,
+		"html_link":           event.HtmlLink,
+		"creator_email":       creatorEmail,
+		"organizer_email":     organizerEmail,
+		"attendees_json":      string(attendeesJSON),
+		"attendees_count":     strconv.Itoa(len(event.Attendees)),
+		"sequence":            strconv.Itoa(int(event.Sequence)),
+		"created":             event.Created,
+		"updated":             event.Updated,
+		"hangout_link":        event.HangoutLink,
+		"recurring_event_id":  event.RecurringEventId,
+		"original_start_time": originalStart,
+		"recurrence":          strings.Join(event.Recurrence, "\n"),
+		"raw_event":           string(rawJSON),
 	}
 }
 
@@ -782,6 +876,19 @@ func (h *CalendarWebhookHandler) buildHeuristicPayload(change map[string]interfa
 		payload.EndTime = payload.StartTime.Add(time.Hour)
 	}
 
+	// Series masters carry their first occurrence; predict for the next one instead.
+	if recurrence, _ := change["recurrence"].(string); strings.TrimSpace(recurrence) != "" {
+		loc := payload.StartTime.Location()
+		if tz, _ := change["start_timezone"].(string); tz != "" {
+			if tzLoc, err := time.LoadLocation(tz); err == nil {
+				loc = tzLoc
+			}
+		}
+		if start, end, ok := calendar_planner.NextOccurrence(strings.Split(recurrence, "\n"), payload.StartTime, payload.EndTime, loc, time.Now()); ok {
+			payload.StartTime, payload.EndTime = start, end
+		}
+	}
+
 	return payload, nil
 }
 
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+
+	"alfred-cloud/streams"
+)
+
+const (
+	defaultDeadLetterCount = 50
+	maxDeadLetterCount     = 500
+)
+
+type deadLetterHandler struct {
+	streams *streams.StreamsHelper
+}
+
+type deadLettersResponse struct {
+	UserID      string               `json:"user_id"`
+	Agent       string               `json:"agent"`
+	Stream      string               `json:"stream"`
+	DeadLetters []streams.DeadLetter `json:"dead_letters"`
+}
+
+type replayResponse struct {
+	ID       string `json:"id"`
+	Stream   string `json:"stream"`
+	Replayed string `json:"replayed_id"`
+}
+
+// registerDeadLetterRoutes exposes the user:{id}:dlq:{agent} streams to admins.
+func registerDeadLetterRoutes(r *mux.Router, helper *streams.StreamsHelper) {
+	h := &deadLetterHandler{streams: helper}
+	r.HandleFunc("/admin/dlq/{userID}/{agent}", h.handleList).Methods("GET")
+	r.HandleFunc("/admin/dlq/{userID}/{agent}/{entryID}/replay", h.handleReplay).Methods("POST")
+	r.HandleFunc("/admin/dlq/{userID}/{agent}/{entryID}", h.handleDiscard).Methods("DELETE")
+}
+
+func (h *deadLetterHandler) handleList(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	count := int64(defaultDeadLetterCount)
+	if raw := r.URL.Query().Get("count"); raw != "" {
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || n <= 0 {
+			http.Error(w, "count must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		count = min(n, maxDeadLetterCount)
+	}
+
+	letters, err := h.streams.DeadLetters(r.Context(), vars["userID"], vars["agent"], count)
+	if err != nil {
+		log.Printf("dead letters: list %s/%s: %v", vars["userID"], vars["agent"], err)
+		http.Error(w, "failed to read dead letters", http.StatusInternalServerError)
+		return
+	}
+	writeRegistryJSON(w, deadLettersResponse{
+		UserID:      vars["userID"],
+		Agent:       vars["agent"],
+		Stream:      streams.DeadLetterStream(vars["userID"], vars["agent"]),
+		DeadLetters: letters,
+	})
+}
+
+func (h *deadLetterHandler) handleReplay(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	letter, replayedID, err := h.streams.ReplayDeadLetter(r.Context(), vars["userID"], vars["agent"], vars["entryID"])
+	if errors.Is(err, streams.ErrDeadLetterNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("dead letters: replay %s from %s/%s: %v", vars["entryID"], vars["userID"], vars["agent"], err)
+		http.Error(w, "failed to replay dead letter", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("dead letters: replayed %s to %s as %s", letter.ID, letter.SourceStream, replayedID)
+	writeRegistryJSON(w, replayResponse{ID: letter.ID, Stream: letter.SourceStream, Replayed: replayedID})
+}
+
+func (h *deadLetterHandler) handleDiscard(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	err := h.streams.DiscardDeadLetter(r.Context(), vars["userID"], vars["agent"], vars["entryID"])
+	if errors.Is(err, streams.ErrDeadLetterNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("dead letters: discard %s from %s/%s: %v", vars["entryID"], vars["userID"], vars["agent"], err)
+		http.Error(w, "failed to discard dead letter", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
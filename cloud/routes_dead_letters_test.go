@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"alfred-cloud/security"
+	"alfred-cloud/streams"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gorilla/mux"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeadLetterRoutes(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	ctx := context.Background()
+
+	const stream = "user:user-1:in:email"
+	require.NoError(t, client.XGroupCreateMkStream(ctx, stream, "email-triage", "0").Err())
+	require.NoError(t, client.XAdd(ctx, &redis.XAddArgs{Stream: stream, Values: map[string]any{"raw_json": "{"}}).Err())
+	res, err := client.XReadGroup(ctx, &redis.XReadGroupArgs{Group: "email-triage", Consumer: "c1", Streams: []string{stream, ">"}, Block: -1}).Result()
+	require.NoError(t, err)
+	delivery := streams.NewDelivery(client, "email-triage", "c1", "email", streams.DefaultRetryPolicy())
+	require.Error(t, delivery.Handle(ctx, stream, res[0].Messages[0], func(ctx context.Context, msg redis.XMessage) error {
+		return streams.Permanent(errors.New("unexpected end of JSON input"))
+	}))
+
+	verifier, err := security.NewTokenVerifier("test-secret")
+	require.NoError(t, err)
+	r := mux.NewRouter()
+	r.Use(newAPIAuth(verifier).Middleware)
+	registerDeadLetterRoutes(r, streams.NewStreamsHelper(client))
+	server := httptest.NewServer(r)
+	t.Cleanup(server.Close)
+
+	userToken := signTestToken(t, verifier, security.AuthClaims{Subject: "user-1"})
+	resp := doAuthRequest(t, http.MethodGet, server.URL+"/admin/dlq/user-1/email", userToken, nil)
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	adminToken := signTestToken(t, verifier, security.AuthClaims{Subject: "ops", Role: security.RoleAdmin})
+	resp = doAuthRequest(t, http.MethodGet, server.URL+"/admin/dlq/user-1/email", adminToken, nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var list deadLettersResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&list))
+	require.Equal(t, "user:user-1:dlq:email", list.Stream)
+	require.Len(t, list.DeadLetters, 1)
+	letter := list.DeadLetters[0]
+	require.Equal(t, "unexpected end of JSON input", letter.Error)
+	require.Equal(t, map[string]any{"raw_json": "{"}, letter.Values)
+
+	resp = doAuthRequest(t, http.MethodPost, server.URL+"/admin/dlq/user-1/email/"+letter.ID+"/replay", adminToken, nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var replay replayResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&replay))
+	require.Equal(t, stream, replay.Stream)
+	entries, err := client.XRange(ctx, stream, replay.Replayed, replay.Replayed).Result()
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, map[string]any{"raw_json": "{"}, entries[0].Values)
+
+	resp = doAuthRequest(t, http.MethodPost, server.URL+"/admin/dlq/user-1/email/"+letter.ID+"/replay", adminToken, nil)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	resp = doAuthRequest(t, http.MethodDelete, server.URL+"/admin/dlq/user-1/email/"+letter.ID, adminToken, nil)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	resp = doAuthRequest(t, http.MethodGet, server.URL+"/admin/dlq/user-1/email?count=0", adminToken, nil)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
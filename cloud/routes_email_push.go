@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const emailPushSyncTimeout = 2 * time.Minute
+
+// emailPushSyncer resolves a watched mailbox to its user and syncs their new mail.
+type emailPushSyncer interface {
+	UserForAddress(ctx context.Context, emailAddress string) (string, error)
+	SyncUser(ctx context.Context, userID string) error
+}
+
+// EmailPushHandler receives Gmail watch notifications from a Pub/Sub push subscription.
+type EmailPushHandler struct {
+	syncer emailPushSyncer
+	token  string
+}
+
+// NewEmailPushHandler creates the handler. token must match the ?token= query
+// parameter configured on the Pub/Sub push endpoint URL.
+func NewEmailPushHandler(syncer emailPushSyncer, token string) *EmailPushHandler {
+	return &EmailPushHandler{syncer: syncer, token: strings.TrimSpace(token)}
+}
+
+// RegisterRoutes registers the Pub/Sub push endpoint
+func (h *EmailPushHandler) RegisterRoutes(r *mux.Router) {
+	r.HandleFunc("/email/push", h.handlePush).Methods("POST")
+}
+
+// pubSubPushEnvelope is the JSON body Pub/Sub posts to push endpoints.
+type pubSubPushEnvelope struct {
+	Message struct {
+		Data        string `json:"data"`
+		MessageID   string `json:"messageId"`
+		PublishTime string `json:"publishTime"`
+	} `json:"message"`
+	Subscription string `json:"subscription"`
+}
+
+// gmailPushNotification is the decoded message data of a Gmail watch notification.
+type gmailPushNotification struct {
+	EmailAddress string          `json:"emailAddress"`
+	HistoryID    json.RawMessage `json:"historyId"`
+}
+
+// handlePush acknowledges the notification immediately and syncs the user's
+// mailbox in the background. Notifications for unknown mailboxes are
+// acknowledged too, so Pub/Sub does not redeliver them.
+func (h *EmailPushHandler) handlePush(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	if h.token == "" {
+		http.Error(w, "email push not configured", http.StatusServiceUnavailable)
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(r.URL.Query().Get("token")), []byte(h.token)) != 1 {
+		http.Error(w, "invalid push token", http.StatusForbidden)
+		return
+	}
+
+	var envelope pubSubPushEnvelope
+	if err := json.NewDecoder(r.Body).Decode(&envelope); err != nil {
+		http.Error(w, "invalid push envelope", http.StatusBadRequest)
+		return
+	}
+	data, err := base64.StdEncoding.DecodeString(envelope.Message.Data)
+	if err != nil {
+		http.Error(w, "invalid message data", http.StatusBadRequest)
+		return
+	}
+	var notification gmailPushNotification
+	if err := json.Unmarshal(data, &notification); err != nil || strings.TrimSpace(notification.EmailAddress) == "" {
+		http.Error(w, "invalid Gmail notification", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := h.syncer.UserForAddress(r.Context(), notification.EmailAddress)
+	if err != nil {
+		log.Printf("Email push: resolve %s failed: %v", notification.EmailAddress, err)
+		http.Error(w, "failed to resolve mailbox", http.StatusInternalServerError)
+		return
+	}
+	if userID == "" {
+		log.Printf("Email push: no user watches %s (message %s)", notification.EmailAddress, envelope.Message.MessageID)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	log.Printf("Email push: notification for user %s history=%s (message %s)", userID, string(notification.HistoryID), envelope.Message.MessageID)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), emailPushSyncTimeout)
+		defer cancel()
+		if err := h.syncer.SyncUser(ctx, userID); err != nil {
+			log.Printf("Email push: sync for user %s failed: %v", userID, err)
+		}
+	}()
+
+	w.WriteHeader(http.StatusNoContent)
+}
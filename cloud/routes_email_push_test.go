@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"alfred-cloud/security"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+)
+
+type stubPushSyncer struct {
+	addresses map[string]string
+	synced    chan string
+}
+
+func (s *stubPushSyncer) UserForAddress(ctx context.Context, emailAddress string) (string, error) {
+	return s.addresses[emailAddress], nil
+}
+
+func (s *stubPushSyncer) SyncUser(ctx context.Context, userID string) error {
+	s.synced <- userID
+	return nil
+}
+
+func pushEnvelope(data string) map[string]any {
+	return map[string]any{
+		"message": map[string]any{
+			"data":        base64.StdEncoding.EncodeToString([]byte(data)),
+			"messageId":   "pubsub-1",
+			"publishTime": "2025-01-01T00:00:00Z",
+		},
+		"subscription": "projects/p/subscriptions/gmail-push",
+	}
+}
+
+func TestEmailPushTriggersSyncForWatchedMailbox(t *testing.T) {
+	syncer := &stubPushSyncer{addresses: map[string]string{"user@example.com": "user-1"}, synced: make(chan string, 4)}
+	verifier, err := security.NewTokenVerifier("test-secret")
+	require.NoError(t, err)
+
+	r := mux.NewRouter()
+	r.Use(newAPIAuth(verifier).Middleware)
+	NewEmailPushHandler(syncer, "push-secret").RegisterRoutes(r)
+	server := httptest.NewServer(r)
+	t.Cleanup(server.Close)
+
+	// Pub/Sub sends no bearer token; the push token in the URL authenticates it.
+	resp := doAuthRequest(t, http.MethodPost, server.URL+"/email/push?token=push-secret", "", pushEnvelope(`{"emailAddress":"user@example.com","historyId":9876}`))
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+	select {
+	case userID := <-syncer.synced:
+		require.Equal(t, "user-1", userID)
+	case <-time.After(2 * time.Second):
+		t.Fatal("push did not trigger a sync")
+	}
+
+	resp = doAuthRequest(t, http.MethodPost, server.URL+"/email/push?token=wrong", "", pushEnvelope(`{"emailAddress":"user@example.com"}`))
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	resp = doAuthRequest(t, http.MethodPost, server.URL+"/email/push?token=push-secret", "", pushEnvelope(`not json`))
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	// Unknown mailboxes are acknowledged so Pub/Sub stops redelivering them.
+	resp = doAuthRequest(t, http.MethodPost, server.URL+"/email/push?token=push-secret", "", pushEnvelope(`{"emailAddress":"other@example.com","historyId":"1"}`))
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+	select {
+	case userID := <-syncer.synced:
+		t.Fatalf("unexpected sync for %s", userID)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"log"
 	"net/http"
 
+	"alfred-cloud/registry"
 	"alfred-cloud/security"
 
 	"github.com/gorilla/mux"
@@ -16,6 +17,7 @@ import (
 type GoogleAuthHandler struct {
 	gmailClient    *security.GoogleServiceClient
 	calendarClient *security.GoogleServiceClient
+	userRegistry   *registry.Registry
 }
 
 type serviceClient struct {
@@ -106,6 +108,11 @@ func NewGoogleAuthHandler(gmailClient, calendarClient *security.GoogleServiceCli
 	}
 }
 
+// UseUserRegistry registers users in reg once their OAuth callback succeeds.
+func (h *GoogleAuthHandler) UseUserRegistry(reg *registry.Registry) {
+	h.userRegistry = reg
+}
+
 // AuthRequest represents an authentication request
 type AuthRequest struct {
 	UserID  string                `json:"user_id"`
@@ -147,6 +154,45 @@ func (h *GoogleAuthHandler) RegisterRoutes(router *mux.Router) {
 
 	// Revoke access
 	router.HandleFunc("/auth/revoke/{service}", h.RevokeAccess).Methods("DELETE")
+
+	// Connected users per service (admin)
+	router.HandleFunc("/admin/oauth/users", h.ListConnectedUsers).Methods("GET")
+}
+
+// ConnectedUsersResponse lists connected users keyed by service
+type ConnectedUsersResponse struct {
+	Services map[security.ServiceScope][]string `json:"services"`
+}
+
+// ListConnectedUsers lists every user holding a token, optionally filtered by ?service=
+func (h *GoogleAuthHandler) ListConnectedUsers(w http.ResponseWriter, r *http.Request) {
+	clients := h.availableClients()
+	if filter := security.ServiceScope(r.URL.Query().Get("service")); filter != "" {
+		client, err := h.clientForService(filter)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		clients = []serviceClient{{service: filter, client: client}}
+	}
+	if len(clients) == 0 {
+		http.Error(w, "OAuth services not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	response := ConnectedUsersResponse{Services: make(map[security.ServiceScope][]string)}
+	for _, entry := range clients {
+		users, err := entry.client.ListConnectedUsers(r.Context(), entry.service)
+		if err != nil {
+			log.Printf("Failed to list %s users: %v", entry.service, err)
+			http.Error(w, "Failed to list connected users", http.StatusInternalServerError)
+			return
+		}
+		response.Services[entry.service] = users
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
 }
 
 // StartAuth initiates OAuth authentication for a service
@@ -157,6 +203,13 @@ func (h *GoogleAuthHandler) StartAuth(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	userID, err := requestUserID(r, req.UserID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	req.UserID = userID
+
 	// Validate request
 	if req.UserID == "" {
 		http.Error(w, "user_id is required", http.StatusBadRequest)
@@ -240,6 +293,13 @@ func (h *GoogleAuthHandler) HandleCallback(w http.ResponseWriter, r *http.Reques
 
 	log.Printf("Successfully authenticated user %s for service %s", userID, service)
 
+	// Start the user's workers without a redeploy
+	if h.userRegistry != nil {
+		if err := h.userRegistry.Register(ctx, userID, oauthFeatures(service)...); err != nil {
+			log.Printf("Failed to register user %s after %s auth: %v", userID, service, err)
+		}
+	}
+
 	// Validate the service access immediately
 	switch service {
 	case security.ServiceGmail:
@@ -265,7 +325,11 @@ func (h *GoogleAuthHandler) HandleCallback(w http.ResponseWriter, r *http.Reques
 
 // GetStatus returns authentication status for all services
 func (h *GoogleAuthHandler) GetStatus(w http.ResponseWriter, r *http.Request) {
-	userID := r.URL.Query().Get("user_id")
+	userID, err := requestUserID(r, r.URL.Query().Get("user_id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
 	if userID == "" {
 		http.Error(w, "user_id parameter is required", http.StatusBadRequest)
 		return
@@ -297,7 +361,11 @@ func (h *GoogleAuthHandler) GetStatus(w http.ResponseWriter, r *http.Request) {
 func (h *GoogleAuthHandler) ValidateService(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	serviceStr := vars["service"]
-	userID := r.URL.Query().Get("user_id")
+	userID, err := requestUserID(r, r.URL.Query().Get("user_id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
 
 	if userID == "" {
 		http.Error(w, "user_id parameter is required", http.StatusBadRequest)
@@ -339,7 +407,11 @@ func (h *GoogleAuthHandler) ValidateService(w http.ResponseWriter, r *http.Reque
 func (h *GoogleAuthHandler) RevokeAccess(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	serviceStr := vars["service"]
-	userID := r.URL.Query().Get("user_id")
+	userID, err := requestUserID(r, r.URL.Query().Get("user_id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
 
 	if userID == "" {
 		http.Error(w, "user_id parameter is required", http.StatusBadRequest)
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"alfred-cloud/security"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gorilla/mux"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+func TestListConnectedUsersByService(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer client.Close()
+	ctx := context.Background()
+
+	gmailStore := security.NewTokenStore(client)
+	calendarStore := security.NewTokenStore(client)
+	require.NoError(t, gmailStore.StoreToken(ctx, security.ServiceGmail, "user-1", &oauth2.Token{AccessToken: "a"}))
+	require.NoError(t, gmailStore.StoreToken(ctx, security.ServiceGmail, "user-2", &oauth2.Token{AccessToken: "b"}))
+	require.NoError(t, calendarStore.StoreToken(ctx, security.ServiceCalendar, "user-2", &oauth2.Token{AccessToken: "c"}))
+
+	r := mux.NewRouter()
+	NewGoogleAuthHandler(security.NewGoogleServiceClient(gmailStore), security.NewGoogleServiceClient(calendarStore)).RegisterRoutes(r)
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/oauth/users", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var resp ConnectedUsersResponse
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+	require.Equal(t, []string{"user-1", "user-2"}, resp.Services[security.ServiceGmail])
+	require.Equal(t, []string{"user-2"}, resp.Services[security.ServiceCalendar])
+
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/oauth/users?service=calendar", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	resp = ConnectedUsersResponse{}
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+	require.Len(t, resp.Services, 1)
+
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/oauth/users?service=drive", nil))
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+}
@@ -47,6 +47,13 @@ func managerHandler(w http.ResponseWriter, r *http.Request) {
 	req.Kind = strings.TrimSpace(req.Kind)
 	req.ThreadID = strings.TrimSpace(req.ThreadID)
 
+	userID, err := requestUserID(r, req.UserID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	req.UserID = userID
+
 	if req.Kind == "" && req.Source == "" {
 		http.Error(w, "kind is required", http.StatusBadRequest)
 		return
@@ -38,7 +38,8 @@ type calendarManagerRunner func(context.Context, calendarManagerRequest) (*calen
 
 var (
 	calendarManagerInitOnce sync.Once
-	calendarManagerSvc      *calendar_planner.CalendarManagerService
+	calendarManagerSvc      calendar_planner.PlannerRunner
+	calendarManagerModel    string
 	calendarManagerInitErr  error
 
 	runCalendarManager calendarManagerRunner = invokeCalendarManager
@@ -64,7 +65,13 @@ func calendarManagerHandler(w http.ResponseWriter, r *http.Request) {
 
 	req.TimeBlock = strings.TrimSpace(req.TimeBlock)
 	req.ActivityType = strings.TrimSpace(req.ActivityType)
-	req.UserID = strings.TrimSpace(req.UserID)
+	userID, err := requestUserID(r, req.UserID)
+	if err != nil {
+		log.Printf("[calendar-manager:%s] Authorization error: %v", correlationID, err)
+		writeCalendarManagerError(w, http.StatusForbidden, correlationID, err.Error())
+		return
+	}
+	req.UserID = userID
 
 	if req.TimeBlock == "" {
 		log.Printf("[calendar-manager:%s] Validation error: missing time_block", correlationID)
@@ -123,9 +130,12 @@ func calendarManagerHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// registerCalendarManagerRoutes registers the planner routes even when the
+// planner failed to initialize: /planner/run then fails and /planner/health
+// reports the error.
 func registerCalendarManagerRoutes(router *mux.Router) {
 	if _, err := ensureCalendarManagerService(); err != nil {
-		log.Fatalf("Failed to initialize calendar manager tool: %v", err)
+		log.Printf("Calendar manager tool unavailable: %v", err)
 	}
 
 	router.HandleFunc("/planner/run", calendarManagerHandler).Methods("POST")
@@ -140,7 +150,7 @@ func calendarManagerHealthHandler(w http.ResponseWriter, r *http.Request) {
 	status := map[string]interface{}{
 		"ok":         calendarManagerSvc != nil && calendarManagerInitErr == nil,
 		"service":    "calendar-manager-tool",
-		"model":      "gpt-4o-2024-08-06 (python_helper)",
+		"model":      calendarManagerModel,
 		"checked_at": time.Now().UTC().Format(time.RFC3339Nano),
 	}
 
@@ -152,21 +162,39 @@ func calendarManagerHealthHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(status)
 }
 
-func ensureCalendarManagerService() (*calendar_planner.CalendarManagerService, error) {
+// ensureCalendarManagerService builds the planner selected by PLANNER_BACKEND:
+// "go" (the default) calls the model directly, "python" runs the legacy
+// python_helper/planner_tool.py script.
+func ensureCalendarManagerService() (calendar_planner.PlannerRunner, error) {
 	calendarManagerInitOnce.Do(func() {
-		scriptPath := strings.TrimSpace(os.Getenv("PLANNER_SCRIPT"))
-		if scriptPath == "" {
-			exePath, _ := os.Executable()
-			exeDir := filepath.Dir(exePath)
-			scriptPath = findFileUpwards(exeDir, "python_helper/planner_tool.py")
+		backend := strings.ToLower(strings.TrimSpace(os.Getenv("PLANNER_BACKEND")))
+		switch backend {
+		case "", "go":
+			planner, err := calendar_planner.NewLLMPlannerFromEnv()
+			if err != nil {
+				calendarManagerInitErr = err
+				return
+			}
+			calendarManagerSvc = planner
+			calendarManagerModel = planner.Model()
+		case "python":
+			scriptPath := strings.TrimSpace(os.Getenv("PLANNER_SCRIPT"))
+			if scriptPath == "" {
+				exePath, _ := os.Executable()
+				exeDir := filepath.Dir(exePath)
+				scriptPath = findFileUpwards(exeDir, "python_helper/planner_tool.py")
+			}
+
+			if _, err := os.Stat(scriptPath); err != nil {
+				calendarManagerInitErr = fmt.Errorf("planner script not found: %w", err)
+				return
+			}
+
+			calendarManagerSvc = calendar_planner.NewCalendarManagerService(scriptPath)
+			calendarManagerModel = "python_helper"
+		default:
+			calendarManagerInitErr = fmt.Errorf("unknown PLANNER_BACKEND %q (want go or python)", backend)
 		}
-
-		if _, err := os.Stat(scriptPath); err != nil {
-			calendarManagerInitErr = fmt.Errorf("planner script not found: %w", err)
-			return
-		}
-
-		calendarManagerSvc = calendar_planner.NewCalendarManagerService(scriptPath)
 	})
 
 	return calendarManagerSvc, calendarManagerInitErr
@@ -7,10 +7,19 @@ import (
 	"errors"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strings"
 	"sync"
 	"testing"
 
+	"alfred-cloud/manager"
+	"alfred-cloud/security"
 	"alfred-cloud/subagents/calendar_planner"
+	"alfred-cloud/subagents/productivity"
+	"alfred-cloud/wb"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gorilla/mux"
+	"github.com/redis/go-redis/v9"
 )
 
 func TestCalendarManagerHandlerSuccess(t *testing.T) {
@@ -171,6 +180,7 @@ func TestCalendarManagerHealthHandler(t *testing.T) {
 
 func TestEnsureCalendarManagerServiceMissingScript(t *testing.T) {
 	resetCalendarManagerTestState()
+	t.Setenv("PLANNER_BACKEND", "python")
 	t.Setenv("PLANNER_SCRIPT", "/tmp/fake-planner-script.py")
 
 	if _, err := ensureCalendarManagerService(); err == nil {
@@ -178,8 +188,163 @@ func TestEnsureCalendarManagerServiceMissingScript(t *testing.T) {
 	}
 }
 
+func TestEnsureCalendarManagerServiceSelectsBackend(t *testing.T) {
+	resetCalendarManagerTestState()
+	t.Setenv("PLANNER_BACKEND", "go")
+	t.Setenv("PLANNER_API_KEY", "test-key")
+	t.Setenv("PLANNER_MODEL_NAME", "test-model")
+
+	runner, err := ensureCalendarManagerService()
+	if err != nil {
+		t.Fatalf("expected native planner, got %v", err)
+	}
+	if _, ok := runner.(*calendar_planner.LLMPlanner); !ok {
+		t.Fatalf("expected *LLMPlanner got %T", runner)
+	}
+	if calendarManagerModel != "test-model" {
+		t.Fatalf("expected model test-model got %q", calendarManagerModel)
+	}
+
+	resetCalendarManagerTestState()
+	t.Setenv("PLANNER_BACKEND", "ruby")
+	if _, err := ensureCalendarManagerService(); err == nil {
+		t.Fatalf("expected unknown backend error")
+	}
+}
+
+func TestCalendarManagerRoutesWithoutPlanner(t *testing.T) {
+	resetCalendarManagerTestState()
+	t.Setenv("PLANNER_BACKEND", "")
+	t.Setenv("PLANNER_API_KEY", "")
+	t.Setenv("OPENAI_API_KEY", "")
+
+	r := mux.NewRouter()
+	registerCalendarManagerRoutes(r)
+
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, httptest.NewRequest("GET", "/planner/health", nil))
+	var payload map[string]any
+	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+		t.Fatalf("failed to decode health response: %v", err)
+	}
+	if payload["ok"].(bool) {
+		t.Fatalf("expected health ok false without a planner")
+	}
+	if msg, _ := payload["error"].(string); !strings.Contains(msg, "PLANNER_API_KEY") {
+		t.Fatalf("expected the init error in health, got %q", msg)
+	}
+
+	resp = httptest.NewRecorder()
+	r.ServeHTTP(resp, httptest.NewRequest("POST", "/planner/run", bytes.NewBufferString(`{"time_block":"coding 10-12"}`)))
+	if resp.Code != http.StatusInternalServerError {
+		t.Fatalf("expected 500 got %d", resp.Code)
+	}
+}
+
+// TestManagerPlanNewVersionAgainstCloudRouter runs calendar.plan.new_version through the
+// manager graph with the default runtime config, calling this server's own routes.
+func TestManagerPlanNewVersionAgainstCloudRouter(t *testing.T) {
+	resetCalendarManagerTestState()
+	t.Cleanup(resetCalendarManagerTestState)
+	t.Setenv("PLANNER_BACKEND", "go")
+	t.Setenv("PLANNER_API_KEY", "test-key")
+	t.Setenv("MANAGER_PROD_CONTROL_URL", "")
+	t.Setenv("PRODUCTIVITY_MODEL_API_KEY", "test-key")
+	runCalendarManager = func(ctx context.Context, req calendarManagerRequest) (*calendar_planner.CalendarPlan, error) {
+		return &calendar_planner.CalendarPlan{Blocks: []calendar_planner.PlanBlock{{Title: "Focus"}}}, nil
+	}
+
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	bus := wb.NewBus(client)
+
+	heuristics, err := productivity.NewHeuristicService(productivity.NewHeuristicStore(client), nil)
+	if err != nil {
+		t.Fatalf("heuristics: %v", err)
+	}
+	classifierState := productivity.NewMemoryClassifierStore()
+	classifier, err := productivity.NewClassifier(heuristics, productivity.WithStateStore(classifierState))
+	if err != nil {
+		t.Fatalf("classifier: %v", err)
+	}
+	ctx := context.Background()
+	if err := classifierState.UpdateState(ctx, "user-1", func(st *productivity.ClassifierState) error {
+		st.EventID, st.DecisionRecorded = "old-block", true
+		return nil
+	}); err != nil {
+		t.Fatalf("seed classifier state: %v", err)
+	}
+
+	verifier, err := security.NewTokenVerifier("test-secret")
+	if err != nil {
+		t.Fatalf("verifier: %v", err)
+	}
+	r := mux.NewRouter()
+	r.Use(newAPIAuth(verifier).Middleware)
+	registerCalendarManagerRoutes(r)
+	registerProdControlRoutes(r, classifier)
+	server := httptest.NewServer(r)
+	t.Cleanup(server.Close)
+
+	// The default URL must name a route this server serves; only the host differs here.
+	cfg := manager.RuntimeConfigFromEnv()
+	prodURL, err := url.Parse(cfg.ProdControlURL)
+	if err != nil || prodURL.Path != "/prod/control/recompute" {
+		t.Fatalf("unexpected default prod control URL %q", cfg.ProdControlURL)
+	}
+	graph, err := manager.NewManagerGraph(manager.GraphConfig{
+		PlannerURL:     server.URL + "/planner/run",
+		ProdControlURL: server.URL + prodURL.Path,
+		Bus:            bus,
+		ServiceToken:   signTestToken(t, verifier, security.AuthClaims{Subject: "manager", Role: security.RoleAdmin}),
+	})
+	if err != nil {
+		t.Fatalf("graph: %v", err)
+	}
+
+	id, err := bus.AppendWithThread(ctx, "user-1", "plan-thread", map[string]any{
+		"type": "calendar.plan.new_version", "plan_id": "plan-1", "version": "2",
+	})
+	if err != nil {
+		t.Fatalf("append: %v", err)
+	}
+	evt, err := bus.Get(ctx, "user-1", id)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	normalized, err := manager.NormalizeWhiteboardEvent(*evt)
+	if err != nil {
+		t.Fatalf("normalize: %v", err)
+	}
+	res, err := graph.Run(ctx, normalized)
+	if err != nil {
+		t.Fatalf("plan.new_version run failed: %v", err)
+	}
+	if len(res.SideEffects) != 3 {
+		t.Fatalf("expected planner_call, prod_recalc_signal and emit_plan_outcome side effects, got %v", res.SideEffects)
+	}
+
+	entries, err := client.XRange(ctx, wb.StreamKey("user-1"), "-", "+").Result()
+	if err != nil {
+		t.Fatalf("xrange: %v", err)
+	}
+	outcome := entries[len(entries)-1].Values
+	if outcome["type"] != "manager.plan_outcome" || outcome["planner_status"] != "ok" || outcome["prod_status"] != "ok" {
+		t.Fatalf("unexpected plan outcome %v", outcome)
+	}
+	state, err := classifierState.LoadState(ctx, "user-1")
+	if err != nil {
+		t.Fatalf("load classifier state: %v", err)
+	}
+	if state.EventID != "" || state.DecisionRecorded {
+		t.Fatalf("expected recompute to clear classifier state, got %+v", state)
+	}
+}
+
 func resetCalendarManagerTestState() {
 	calendarManagerSvc = nil
+	calendarManagerModel = ""
 	calendarManagerInitErr = nil
 	calendarManagerInitOnce = sync.Once{}
 	runCalendarManager = invokeCalendarManager
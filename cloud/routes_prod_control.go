@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"alfred-cloud/subagents/productivity"
+	"github.com/gorilla/mux"
+)
+
+type prodRecomputeRequest struct {
+	UserID   string `json:"user_id"`
+	ThreadID string `json:"thread_id"`
+	PlanID   string `json:"plan_id"`
+	Version  string `json:"version"`
+}
+
+// registerProdControlRoutes serves the recompute signal the manager sends after a
+// new plan version, so productivity stops judging heartbeats against the old plan.
+func registerProdControlRoutes(r *mux.Router, classifier *productivity.Classifier) {
+	r.HandleFunc("/prod/control/recompute", func(w http.ResponseWriter, req *http.Request) {
+		var payload prodRecomputeRequest
+		if err := json.NewDecoder(req.Body).Decode(&payload); err != nil {
+			http.Error(w, "invalid JSON body", http.StatusBadRequest)
+			return
+		}
+
+		userID, err := requestUserID(req, payload.UserID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		if userID == "" {
+			http.Error(w, "user_id is required", http.StatusBadRequest)
+			return
+		}
+
+		if err := classifier.Recompute(req.Context(), userID); err != nil {
+			http.Error(w, fmt.Sprintf("recompute failed: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"ok":      true,
+			"user_id": userID,
+			"plan_id": strings.TrimSpace(payload.PlanID),
+			"version": strings.TrimSpace(payload.Version),
+		})
+	}).Methods("POST")
+}
@@ -27,8 +27,15 @@ func registerProdHeuristicRoutes(r *mux.Router, streams *streams.StreamsHelper)
 			return
 		}
 
-		// Default to test-user since we don't have auth yet
-		userID := "test-user"
+		userID, err := requestUserID(req, "")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		if userID == "" {
+			// Auth disabled: fall back to the single dev user
+			userID = "test-user"
+		}
 
 		// Construct stream payload
 		values := map[string]interface{}{
@@ -46,7 +53,7 @@ func registerProdHeuristicRoutes(r *mux.Router, streams *streams.StreamsHelper)
 		}
 
 		streamKey := "user:" + userID + ":in:prod"
-		_, err := streams.AppendToStream(req.Context(), streamKey, values)
+		_, err = streams.AppendToStream(req.Context(), streamKey, values)
 		if err != nil {
 			http.Error(w, "failed to enqueue heartbeat", http.StatusInternalServerError)
 			return
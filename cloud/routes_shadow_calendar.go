@@ -3,7 +3,6 @@ package main
 import (
 	"encoding/json"
 	"net/http"
-	"strings"
 
 	"alfred-cloud/subagents/calendar_planner"
 	"github.com/gorilla/mux"
@@ -23,7 +22,11 @@ func registerShadowCalendarRoutes(router *mux.Router, service *calendar_planner.
 
 func (h *shadowCalendarHandler) handleGetSnapshot(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
-	userID := strings.TrimSpace(vars["userID"])
+	userID, err := requestUserID(r, vars["userID"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
 	if userID == "" {
 		http.Error(w, "userID is required", http.StatusBadRequest)
 		return
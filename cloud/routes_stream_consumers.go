@@ -0,0 +1,30 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"alfred-cloud/streams"
+)
+
+// consumerMetricsSource is a subagent running a streams.Consumer.
+type consumerMetricsSource interface {
+	Metrics() streams.ConsumerMetrics
+}
+
+type streamConsumersResponse struct {
+	Consumers []streams.ConsumerMetrics `json:"consumers"`
+}
+
+// registerStreamConsumerRoutes reports read, ack, retry and dead-letter
+// counters for each stream consumer in this process.
+func registerStreamConsumerRoutes(r *mux.Router, sources ...consumerMetricsSource) {
+	r.HandleFunc("/admin/streams/consumers", func(w http.ResponseWriter, req *http.Request) {
+		resp := streamConsumersResponse{Consumers: make([]streams.ConsumerMetrics, 0, len(sources))}
+		for _, source := range sources {
+			resp.Consumers = append(resp.Consumers, source.Metrics())
+		}
+		writeRegistryJSON(w, resp)
+	}).Methods("GET")
+}
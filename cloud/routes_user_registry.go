@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"alfred-cloud/registry"
+)
+
+type userRegistryHandler struct {
+	registry *registry.Registry
+}
+
+type userFeaturesResponse struct {
+	UserID   string                    `json:"user_id"`
+	Features map[registry.Feature]bool `json:"features"`
+}
+
+type setFeatureRequest struct {
+	UserID  string `json:"user_id"`
+	Feature string `json:"feature"`
+	Enabled bool   `json:"enabled"`
+}
+
+type registryUsersResponse struct {
+	Feature string   `json:"feature,omitempty"`
+	Users   []string `json:"users"`
+}
+
+func registerUserRegistryRoutes(r *mux.Router, reg *registry.Registry) {
+	h := &userRegistryHandler{registry: reg}
+	r.HandleFunc("/users/features", h.handleGetFeatures).Methods("GET")
+	r.HandleFunc("/users/features", h.handleSetFeature).Methods("PUT")
+	r.HandleFunc("/admin/users", h.handleListUsers).Methods("GET")
+}
+
+func (h *userRegistryHandler) handleGetFeatures(w http.ResponseWriter, r *http.Request) {
+	userID, err := requestUserID(r, r.URL.Query().Get("user_id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if userID == "" {
+		http.Error(w, "user_id is required", http.StatusBadRequest)
+		return
+	}
+
+	features, err := h.registry.Features(r.Context(), userID)
+	if err != nil {
+		log.Printf("user registry: load features for %s: %v", userID, err)
+		http.Error(w, "failed to load features", http.StatusInternalServerError)
+		return
+	}
+
+	writeRegistryJSON(w, userFeaturesResponse{UserID: userID, Features: features})
+}
+
+func (h *userRegistryHandler) handleSetFeature(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	var req setFeatureRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	userID, err := requestUserID(r, req.UserID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if userID == "" {
+		http.Error(w, "user_id is required", http.StatusBadRequest)
+		return
+	}
+	feature, err := registry.ParseFeature(req.Feature)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.registry.SetFeature(r.Context(), userID, feature, req.Enabled); err != nil {
+		log.Printf("user registry: set %s for %s: %v", feature, userID, err)
+		http.Error(w, "failed to update feature", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("user registry: %s set %s=%t", userID, feature, req.Enabled)
+
+	features, err := h.registry.Features(r.Context(), userID)
+	if err != nil {
+		http.Error(w, "failed to load features", http.StatusInternalServerError)
+		return
+	}
+	writeRegistryJSON(w, userFeaturesResponse{UserID: userID, Features: features})
+}
+
+func (h *userRegistryHandler) handleListUsers(w http.ResponseWriter, r *http.Request) {
+	raw := r.URL.Query().Get("feature")
+
+	var (
+		users []string
+		err   error
+	)
+	if raw == "" {
+		users, err = h.registry.AllUsers(r.Context())
+	} else {
+		feature, parseErr := registry.ParseFeature(raw)
+		if parseErr != nil {
+			http.Error(w, parseErr.Error(), http.StatusBadRequest)
+			return
+		}
+		users, err = h.registry.Users(r.Context(), feature)
+	}
+	if err != nil {
+		log.Printf("user registry: list users: %v", err)
+		http.Error(w, "failed to list users", http.StatusInternalServerError)
+		return
+	}
+
+	writeRegistryJSON(w, registryUsersResponse{Feature: raw, Users: users})
+}
+
+func writeRegistryJSON(w http.ResponseWriter, payload any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(payload)
+}
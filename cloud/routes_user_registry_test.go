@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"alfred-cloud/registry"
+	"alfred-cloud/security"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gorilla/mux"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+func TestUserRegistryRoutes(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	reg := registry.New(client)
+	require.NoError(t, reg.Register(context.Background(), "user-1", oauthFeatures(security.ServiceGmail)...))
+
+	verifier, err := security.NewTokenVerifier("test-secret")
+	require.NoError(t, err)
+	r := mux.NewRouter()
+	r.Use(newAPIAuth(verifier).Middleware)
+	registerUserRegistryRoutes(r, reg)
+	server := httptest.NewServer(r)
+	t.Cleanup(server.Close)
+
+	userToken := signTestToken(t, verifier, security.AuthClaims{Subject: "user-1"})
+	resp := doAuthRequest(t, http.MethodGet, server.URL+"/users/features", userToken, nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var features userFeaturesResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&features))
+	require.Equal(t, map[registry.Feature]bool{registry.FeatureEmail: true, registry.FeatureManager: true}, features.Features)
+
+	resp = doAuthRequest(t, http.MethodPut, server.URL+"/users/features", userToken, setFeatureRequest{Feature: "email", Enabled: false})
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	users, err := reg.Users(context.Background(), registry.FeatureEmail)
+	require.NoError(t, err)
+	require.Empty(t, users)
+
+	resp = doAuthRequest(t, http.MethodPut, server.URL+"/users/features", userToken, setFeatureRequest{UserID: "user-2", Feature: "email", Enabled: true})
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+	resp = doAuthRequest(t, http.MethodPut, server.URL+"/users/features", userToken, setFeatureRequest{Feature: "sms", Enabled: true})
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp = doAuthRequest(t, http.MethodGet, server.URL+"/admin/users?feature=manager", userToken, nil)
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	adminToken := signTestToken(t, verifier, security.AuthClaims{Subject: "ops", Role: security.RoleAdmin})
+	resp = doAuthRequest(t, http.MethodGet, server.URL+"/admin/users?feature=manager", adminToken, nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var list registryUsersResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&list))
+	require.Equal(t, []string{"user-1"}, list.Users)
+}
+
+func TestBackfillUserRegistryFromTokenIndex(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	ctx := context.Background()
+
+	store := security.NewTokenStore(client)
+	require.NoError(t, store.StoreToken(ctx, security.ServiceGmail, "user-1", &oauth2.Token{AccessToken: "a"}))
+	require.NoError(t, store.StoreToken(ctx, security.ServiceCalendar, "user-2", &oauth2.Token{AccessToken: "b"}))
+
+	reg := registry.New(client)
+	backfillUserRegistry(ctx, reg, store)
+
+	email, err := reg.Users(ctx, registry.FeatureEmail)
+	require.NoError(t, err)
+	require.Equal(t, []string{"user-1"}, email)
+	calendar, err := reg.Users(ctx, registry.FeatureCalendar)
+	require.NoError(t, err)
+	require.Equal(t, []string{"user-2"}, calendar)
+	manager, err := reg.Users(ctx, registry.FeatureManager)
+	require.NoError(t, err)
+	require.Equal(t, []string{"user-1", "user-2"}, manager)
+}
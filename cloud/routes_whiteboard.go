@@ -39,24 +39,53 @@ func registerWhiteboardRoutes(r *mux.Router, bus *wb.Bus) {
 	h := &whiteboardHandler{bus: bus}
 	r.HandleFunc("/wb/stream", h.handleSSE).Methods("GET")
 	r.HandleFunc("/wb/ws", h.handleWebSocket).Methods("GET")
+	r.HandleFunc("/wb/actions", h.handleUserAction).Methods("POST")
 	r.HandleFunc("/admin/wb/append", h.handleAppend).Methods("POST")
 }
 
 func (h *whiteboardHandler) handleAppend(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 
-	if h.bus == nil {
-		http.Error(w, "whiteboard bus unavailable", http.StatusServiceUnavailable)
+	var req appendRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
 		return
 	}
+	h.append(w, r, req)
+}
+
+// handleUserAction lets a signed-in user answer a manager prompt. Unlike the admin
+// append it only accepts manager.user_action, and always on the caller's stream.
+func (h *whiteboardHandler) handleUserAction(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
 
 	var req appendRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "invalid JSON body", http.StatusBadRequest)
 		return
 	}
+	if eventType, _ := req.Values["type"].(string); eventType != "manager.user_action" {
+		http.Error(w, "only manager.user_action can be appended here", http.StatusBadRequest)
+		return
+	}
+	if claims := authClaimsFromContext(r.Context()); claims != nil {
+		req.UserID = claims.Subject
+	}
+	h.append(w, r, req)
+}
+
+func (h *whiteboardHandler) append(w http.ResponseWriter, r *http.Request, req appendRequest) {
+	if h.bus == nil {
+		http.Error(w, "whiteboard bus unavailable", http.StatusServiceUnavailable)
+		return
+	}
 
-	req.UserID = strings.TrimSpace(req.UserID)
+	userID, err := requestUserID(r, req.UserID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	req.UserID = userID
 	if req.UserID == "" {
 		req.UserID = "test-user"
 	}
@@ -81,6 +110,10 @@ func (h *whiteboardHandler) handleAppend(w http.ResponseWriter, r *http.Request)
 	req.ThreadID = strings.TrimSpace(req.ThreadID)
 
 	id, err := h.bus.AppendWithThread(r.Context(), req.UserID, req.ThreadID, req.Values)
+	if errors.Is(err, wb.ErrInvalidEvent) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		http.Error(w, fmt.Sprintf("append failed: %v", err), http.StatusInternalServerError)
 		return
@@ -111,7 +144,11 @@ func (h *whiteboardHandler) handleSSE(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	userID := strings.TrimSpace(r.URL.Query().Get("user_id"))
+	userID, err := requestUserID(r, r.URL.Query().Get("user_id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
 	if userID == "" {
 		userID = "test-user"
 	}
@@ -184,7 +221,11 @@ func (h *whiteboardHandler) handleWebSocket(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	userID := strings.TrimSpace(r.URL.Query().Get("user_id"))
+	userID, err := requestUserID(r, r.URL.Query().Get("user_id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
 	if userID == "" {
 		userID = "test-user"
 	}
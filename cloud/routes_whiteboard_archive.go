@@ -0,0 +1,105 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"alfred-cloud/streams"
+	"alfred-cloud/wb"
+)
+
+const (
+	defaultArchiveWindow = 24 * time.Hour
+	defaultArchiveLimit  = 500
+	maxArchiveLimit      = 5000
+)
+
+type whiteboardArchiveResponse struct {
+	UserID string     `json:"user_id"`
+	From   time.Time  `json:"from"`
+	To     time.Time  `json:"to"`
+	Events []wb.Event `json:"events"`
+}
+
+// registerWhiteboardArchiveRoutes serves whiteboard entries the stream trimmer
+// archived. archive is nil when STREAM_ARCHIVE_DIR is unset.
+func registerWhiteboardArchiveRoutes(r *mux.Router, archive *streams.Archive) {
+	r.HandleFunc("/wb/archive", func(w http.ResponseWriter, req *http.Request) {
+		if archive == nil {
+			http.Error(w, "whiteboard archive not configured", http.StatusServiceUnavailable)
+			return
+		}
+		q := req.URL.Query()
+		userID, err := requestUserID(req, q.Get("user_id"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		if userID == "" {
+			http.Error(w, "user_id is required", http.StatusBadRequest)
+			return
+		}
+
+		to := time.Now().UTC()
+		if raw := strings.TrimSpace(q.Get("to")); raw != "" {
+			if to, err = time.Parse(time.RFC3339, raw); err != nil {
+				http.Error(w, "to must be an RFC 3339 time", http.StatusBadRequest)
+				return
+			}
+		}
+		from := to.Add(-defaultArchiveWindow)
+		if raw := strings.TrimSpace(q.Get("from")); raw != "" {
+			if from, err = time.Parse(time.RFC3339, raw); err != nil {
+				http.Error(w, "from must be an RFC 3339 time", http.StatusBadRequest)
+				return
+			}
+		}
+		if !from.Before(to) {
+			http.Error(w, "from must be before to", http.StatusBadRequest)
+			return
+		}
+		limit := defaultArchiveLimit
+		if raw := strings.TrimSpace(q.Get("limit")); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil || n <= 0 || n > maxArchiveLimit {
+				http.Error(w, "limit must be between 1 and 5000", http.StatusBadRequest)
+				return
+			}
+			limit = n
+		}
+		threadID := strings.TrimSpace(q.Get("thread_id"))
+
+		// Read without a limit when filtering by thread so the limit counts matches.
+		readLimit := limit
+		if threadID != "" {
+			readLimit = 0
+		}
+		entries, err := archive.Query(userID, "wb", from, to, readLimit)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		resp := whiteboardArchiveResponse{UserID: userID, From: from.UTC(), To: to.UTC(), Events: make([]wb.Event, 0, len(entries))}
+		for _, entry := range entries {
+			thread, _ := entry.Values["thread_id"].(string)
+			if threadID != "" && thread != threadID {
+				continue
+			}
+			resp.Events = append(resp.Events, wb.Event{
+				ID:       entry.ID,
+				Stream:   entry.Stream,
+				UserID:   userID,
+				ThreadID: thread,
+				Values:   entry.Values,
+			})
+			if len(resp.Events) >= limit {
+				break
+			}
+		}
+		writeRegistryJSON(w, resp)
+	}).Methods("GET")
+}
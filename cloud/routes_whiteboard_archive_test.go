@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"alfred-cloud/security"
+	"alfred-cloud/streams"
+	"github.com/gorilla/mux"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWhiteboardArchiveRoute(t *testing.T) {
+	archive := streams.NewArchive(t.TempDir())
+	base := time.Date(2030, 3, 1, 22, 0, 0, 0, time.UTC)
+	var msgs []redis.XMessage
+	for i, thread := range []string{"a", "b", "a", "a"} {
+		msgs = append(msgs, redis.XMessage{
+			ID:     fmt.Sprintf("%d-0", base.Add(time.Duration(i)*time.Hour).UnixMilli()),
+			Values: map[string]any{"type": "prod.nudge", "thread_id": thread},
+		})
+	}
+	require.NoError(t, archive.Write("user:user-1:wb", msgs))
+
+	verifier, err := security.NewTokenVerifier("test-secret")
+	require.NoError(t, err)
+	r := mux.NewRouter()
+	r.Use(newAPIAuth(verifier).Middleware)
+	registerWhiteboardArchiveRoutes(r, archive)
+	server := httptest.NewServer(r)
+	t.Cleanup(server.Close)
+	token := signTestToken(t, verifier, security.AuthClaims{Subject: "user-1"})
+
+	query := url.Values{
+		"from":      {base.Format(time.RFC3339)},
+		"to":        {base.Add(4 * time.Hour).Format(time.RFC3339)},
+		"thread_id": {"a"},
+		"limit":     {"2"},
+	}
+	resp := doAuthRequest(t, http.MethodGet, server.URL+"/wb/archive?"+query.Encode(), token, nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var body whiteboardArchiveResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	require.Equal(t, "user-1", body.UserID)
+	require.Len(t, body.Events, 2, "entries span two day files")
+	require.Equal(t, msgs[0].ID, body.Events[0].ID)
+	require.Equal(t, msgs[2].ID, body.Events[1].ID)
+	require.Equal(t, "a", body.Events[1].ThreadID)
+	require.Equal(t, "user:user-1:wb", body.Events[1].Stream)
+
+	resp = doAuthRequest(t, http.MethodGet, server.URL+"/wb/archive?user_id=user-2", token, nil)
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+	resp = doAuthRequest(t, http.MethodGet, server.URL+"/wb/archive?from=yesterday", token, nil)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
@@ -0,0 +1,131 @@
+package security
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// RoleAdmin is the role claim required by admin and debug endpoints.
+const RoleAdmin = "admin"
+
+// clockSkew tolerates small clock differences between the issuer and this server.
+const clockSkew = 30 * time.Second
+
+var (
+	// ErrInvalidToken is returned for malformed tokens or bad signatures.
+	ErrInvalidToken = errors.New("invalid auth token")
+	// ErrTokenExpired is returned when the token's exp claim has passed.
+	ErrTokenExpired = errors.New("auth token expired")
+)
+
+// AuthClaims are the JWT claims the cloud API understands.
+type AuthClaims struct {
+	Subject   string `json:"sub"`
+	Role      string `json:"role,omitempty"`
+	IssuedAt  int64  `json:"iat,omitempty"`
+	ExpiresAt int64  `json:"exp,omitempty"`
+}
+
+// IsAdmin reports whether the claims carry the admin role.
+func (c *AuthClaims) IsAdmin() bool {
+	return c != nil && c.Role == RoleAdmin
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ,omitempty"`
+}
+
+// TokenVerifier signs and verifies HS256 JWTs with a shared secret.
+type TokenVerifier struct {
+	key []byte
+	now func() time.Time
+}
+
+// NewTokenVerifier creates a verifier for the given HMAC secret.
+func NewTokenVerifier(secret string) (*TokenVerifier, error) {
+	if strings.TrimSpace(secret) == "" {
+		return nil, errors.New("auth secret is required")
+	}
+	return &TokenVerifier{key: []byte(secret), now: time.Now}, nil
+}
+
+// Sign issues an HS256 token for the claims. IssuedAt is filled in when unset.
+func (v *TokenVerifier) Sign(claims AuthClaims) (string, error) {
+	if claims.IssuedAt == 0 {
+		claims.IssuedAt = v.now().Unix()
+	}
+	header, err := json.Marshal(jwtHeader{Alg: "HS256", Typ: "JWT"})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signingInput := encodeSegment(header) + "." + encodeSegment(payload)
+	return signingInput + "." + encodeSegment(v.sign(signingInput)), nil
+}
+
+// Verify checks the token signature and expiry and returns its claims. The exp
+// claim is required.
+func (v *TokenVerifier) Verify(token string) (*AuthClaims, error) {
+	parts := strings.Split(strings.TrimSpace(token), ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, ErrInvalidToken
+	}
+	// Pin the algorithm so "none" or asymmetric algs can't be substituted.
+	if header.Alg != "HS256" {
+		return nil, fmt.Errorf("%w: unsupported alg %q", ErrInvalidToken, header.Alg)
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !hmac.Equal(sig, v.sign(parts[0]+"."+parts[1])) {
+		return nil, ErrInvalidToken
+	}
+
+	var claims AuthClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, ErrInvalidToken
+	}
+	if strings.TrimSpace(claims.Subject) == "" {
+		return nil, fmt.Errorf("%w: missing sub", ErrInvalidToken)
+	}
+	// Tokens without exp would never lapse, so they are refused outright.
+	if claims.ExpiresAt == 0 {
+		return nil, fmt.Errorf("%w: missing exp", ErrInvalidToken)
+	}
+	if v.now().Add(-clockSkew).Unix() >= claims.ExpiresAt {
+		return nil, ErrTokenExpired
+	}
+	return &claims, nil
+}
+
+func (v *TokenVerifier) sign(input string) []byte {
+	mac := hmac.New(sha256.New, v.key)
+	mac.Write([]byte(input))
+	return mac.Sum(nil)
+}
+
+func encodeSegment(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeSegment(segment string, out any) error {
+	raw, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, out)
+}
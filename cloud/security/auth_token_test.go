@@ -0,0 +1,74 @@
+package security
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenVerifier_SignAndVerify(t *testing.T) {
+	verifier, err := NewTokenVerifier("test-secret")
+	require.NoError(t, err)
+
+	token, err := verifier.Sign(AuthClaims{Subject: "user-1", Role: RoleAdmin, ExpiresAt: time.Now().Add(time.Hour).Unix()})
+	require.NoError(t, err)
+
+	claims, err := verifier.Verify(token)
+	require.NoError(t, err)
+	assert.Equal(t, "user-1", claims.Subject)
+	assert.True(t, claims.IsAdmin())
+	assert.NotZero(t, claims.IssuedAt)
+}
+
+func TestTokenVerifier_RejectsTamperedAndForeignTokens(t *testing.T) {
+	verifier, err := NewTokenVerifier("test-secret")
+	require.NoError(t, err)
+	other, err := NewTokenVerifier("other-secret")
+	require.NoError(t, err)
+
+	exp := time.Now().Add(time.Hour).Unix()
+	token, err := verifier.Sign(AuthClaims{Subject: "user-1", ExpiresAt: exp})
+	require.NoError(t, err)
+
+	// Signed with a different key.
+	foreign, err := other.Sign(AuthClaims{Subject: "user-1", ExpiresAt: exp})
+	require.NoError(t, err)
+	_, err = verifier.Verify(foreign)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	// Payload swapped for one claiming admin.
+	parts := strings.Split(token, ".")
+	parts[1] = encodeSegment([]byte(`{"sub":"user-1","role":"admin"}`))
+	_, err = verifier.Verify(strings.Join(parts, "."))
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	// alg=none with an empty signature.
+	none := encodeSegment([]byte(`{"alg":"none"}`)) + "." + encodeSegment([]byte(`{"sub":"user-1"}`)) + "."
+	_, err = verifier.Verify(none)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	_, err = verifier.Verify("not-a-jwt")
+	assert.ErrorIs(t, err, ErrInvalidToken)
+}
+
+func TestTokenVerifier_Expiry(t *testing.T) {
+	verifier, err := NewTokenVerifier("test-secret")
+	require.NoError(t, err)
+
+	token, err := verifier.Sign(AuthClaims{Subject: "user-1", ExpiresAt: time.Now().Add(-time.Minute).Unix()})
+	require.NoError(t, err)
+	_, err = verifier.Verify(token)
+	assert.ErrorIs(t, err, ErrTokenExpired)
+
+	// A token without exp would be valid forever.
+	token, err = verifier.Sign(AuthClaims{Subject: "user-1"})
+	require.NoError(t, err)
+	_, err = verifier.Verify(token)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	_, err = NewTokenVerifier("  ")
+	assert.Error(t, err)
+}
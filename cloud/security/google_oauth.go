@@ -198,3 +198,8 @@ func (g *GoogleServiceClient) GetServiceStatus(ctx context.Context, userID strin
 
 	return status
 }
+
+// ListConnectedUsers returns the users holding a token for the service
+func (g *GoogleServiceClient) ListConnectedUsers(ctx context.Context, service ServiceScope) ([]string, error) {
+	return g.tokenStore.ListServiceUsers(ctx, service)
+}
@@ -0,0 +1,256 @@
+package security
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+const (
+	tokenEnvelopeVersion = 1
+	tokenKeySize         = 32 // AES-256
+)
+
+// ErrUnknownTokenKey is returned when a record was sealed with a key that is not in the keyring.
+var ErrUnknownTokenKey = errors.New("unknown token encryption key")
+
+// tokenEnvelope is the at-rest form of an encrypted token record. Each record has
+// its own data key, wrapped by the keyring key named in KeyID.
+type tokenEnvelope struct {
+	Version    int    `json:"v"`
+	KeyID      string `json:"kid"`
+	WrappedKey string `json:"wrapped_key"`
+	Nonce      string `json:"nonce"`
+	Ciphertext string `json:"ciphertext"`
+}
+
+// TokenCipher envelope-encrypts token records with AES-GCM. New records are sealed
+// with the active key; older keys stay in the keyring so existing records can be opened.
+type TokenCipher struct {
+	activeID string
+	keys     map[string][]byte
+}
+
+// NewTokenCipher builds a cipher from a keyring of 32-byte keys and the id of the key used for new records.
+func NewTokenCipher(activeID string, keys map[string][]byte) (*TokenCipher, error) {
+	activeID = strings.TrimSpace(activeID)
+	if activeID == "" {
+		return nil, errors.New("active token key id is required")
+	}
+	ring := make(map[string][]byte, len(keys))
+	for id, key := range keys {
+		id = strings.TrimSpace(id)
+		if id == "" || strings.ContainsAny(id, ":,") {
+			return nil, fmt.Errorf("invalid token key id %q", id)
+		}
+		if len(key) != tokenKeySize {
+			return nil, fmt.Errorf("token key %s must be %d bytes, got %d", id, tokenKeySize, len(key))
+		}
+		ring[id] = append([]byte(nil), key...)
+	}
+	if _, ok := ring[activeID]; !ok {
+		return nil, fmt.Errorf("active token key %s not in keyring", activeID)
+	}
+	return &TokenCipher{activeID: activeID, keys: ring}, nil
+}
+
+// TokenCipherFromEnv loads the keyring from OAUTH_TOKEN_KEYS or OAUTH_TOKEN_KEY_FILE.
+// Both use "kid:base64key" entries (comma or newline separated). OAUTH_TOKEN_ACTIVE_KEY
+// picks the key for new records and defaults to the first entry. It returns nil when
+// neither source is set, leaving tokens in plaintext.
+func TokenCipherFromEnv() (*TokenCipher, error) {
+	raw := strings.TrimSpace(os.Getenv("OAUTH_TOKEN_KEYS"))
+	if raw == "" {
+		if path := strings.TrimSpace(os.Getenv("OAUTH_TOKEN_KEY_FILE")); path != "" {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return nil, fmt.Errorf("read token key file: %w", err)
+			}
+			raw = string(data)
+		}
+	}
+	if strings.TrimSpace(raw) == "" {
+		return nil, nil
+	}
+
+	keys, order, err := parseTokenKeys(raw)
+	if err != nil {
+		return nil, err
+	}
+	activeID := strings.TrimSpace(os.Getenv("OAUTH_TOKEN_ACTIVE_KEY"))
+	if activeID == "" {
+		activeID = order[0]
+	}
+	return NewTokenCipher(activeID, keys)
+}
+
+// RequiredTokenCipherFromEnv is TokenCipherFromEnv for processes that store tokens.
+// With no keys configured it fails, unless OAUTH_TOKEN_ENCRYPTION_DISABLED=true opts
+// into plaintext records for local development, in which case it returns nil.
+func RequiredTokenCipherFromEnv() (*TokenCipher, error) {
+	cipher, err := TokenCipherFromEnv()
+	if err != nil || cipher != nil {
+		return cipher, err
+	}
+	if strings.EqualFold(strings.TrimSpace(os.Getenv("OAUTH_TOKEN_ENCRYPTION_DISABLED")), "true") {
+		return nil, nil
+	}
+	return nil, errors.New("OAUTH_TOKEN_KEYS or OAUTH_TOKEN_KEY_FILE is required (set OAUTH_TOKEN_ENCRYPTION_DISABLED=true for local development)")
+}
+
+func parseTokenKeys(raw string) (map[string][]byte, []string, error) {
+	keys := make(map[string][]byte)
+	var order []string
+	for _, entry := range strings.FieldsFunc(raw, func(r rune) bool { return r == ',' || r == '\n' }) {
+		entry = strings.TrimSpace(entry)
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		id, encoded, ok := strings.Cut(entry, ":")
+		if !ok {
+			return nil, nil, fmt.Errorf("token key entry must be kid:base64key")
+		}
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil {
+			return nil, nil, fmt.Errorf("decode token key %s: %w", id, err)
+		}
+		id = strings.TrimSpace(id)
+		if _, dup := keys[id]; dup {
+			return nil, nil, fmt.Errorf("duplicate token key id %s", id)
+		}
+		keys[id] = key
+		order = append(order, id)
+	}
+	if len(order) == 0 {
+		return nil, nil, errors.New("no token keys configured")
+	}
+	return keys, order, nil
+}
+
+// ActiveKeyID returns the id of the key used to seal new records.
+func (c *TokenCipher) ActiveKeyID() string {
+	return c.activeID
+}
+
+// KeyIDs lists the keyring ids in sorted order.
+func (c *TokenCipher) KeyIDs() []string {
+	ids := make([]string, 0, len(c.keys))
+	for id := range c.keys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Seal encrypts plaintext under a fresh data key. aad binds the record to its
+// Redis key so a ciphertext cannot be replayed under another user.
+func (c *TokenCipher) Seal(plaintext, aad []byte) ([]byte, error) {
+	dataKey := make([]byte, tokenKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, fmt.Errorf("generate data key: %w", err)
+	}
+
+	nonce, ciphertext, err := gcmSeal(dataKey, plaintext, aad)
+	if err != nil {
+		return nil, err
+	}
+	wrapNonce, wrapped, err := gcmSeal(c.keys[c.activeID], dataKey, []byte(c.activeID))
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(tokenEnvelope{
+		Version:    tokenEnvelopeVersion,
+		KeyID:      c.activeID,
+		WrappedKey: encodeBytes(append(wrapNonce, wrapped...)),
+		Nonce:      encodeBytes(nonce),
+		Ciphertext: encodeBytes(ciphertext),
+	})
+}
+
+// Open decrypts a sealed record and reports the key id it was sealed with.
+func (c *TokenCipher) Open(sealed, aad []byte) ([]byte, string, error) {
+	env, ok := parseTokenEnvelope(sealed)
+	if !ok {
+		return nil, "", errors.New("not an encrypted token record")
+	}
+	kek, ok := c.keys[env.KeyID]
+	if !ok {
+		return nil, env.KeyID, fmt.Errorf("%w: %s", ErrUnknownTokenKey, env.KeyID)
+	}
+
+	wrapped, err := base64.StdEncoding.DecodeString(env.WrappedKey)
+	if err != nil {
+		return nil, env.KeyID, fmt.Errorf("decode wrapped key: %w", err)
+	}
+	dataKey, err := gcmOpen(kek, wrapped, []byte(env.KeyID))
+	if err != nil {
+		return nil, env.KeyID, fmt.Errorf("unwrap data key: %w", err)
+	}
+
+	nonce, err := base64.StdEncoding.DecodeString(env.Nonce)
+	if err != nil {
+		return nil, env.KeyID, fmt.Errorf("decode nonce: %w", err)
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(env.Ciphertext)
+	if err != nil {
+		return nil, env.KeyID, fmt.Errorf("decode ciphertext: %w", err)
+	}
+	plaintext, err := gcmOpen(dataKey, append(nonce, ciphertext...), aad)
+	if err != nil {
+		return nil, env.KeyID, fmt.Errorf("decrypt token: %w", err)
+	}
+	return plaintext, env.KeyID, nil
+}
+
+// parseTokenEnvelope reports whether data is an encrypted record rather than plaintext TokenInfo JSON.
+func parseTokenEnvelope(data []byte) (tokenEnvelope, bool) {
+	var env tokenEnvelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return env, false
+	}
+	return env, env.Version > 0 && env.KeyID != "" && env.Ciphertext != ""
+}
+
+func gcmSeal(key, plaintext, aad []byte) ([]byte, []byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, nil, fmt.Errorf("generate nonce: %w", err)
+	}
+	return nonce, aead.Seal(nil, nonce, plaintext, aad), nil
+}
+
+// gcmOpen decrypts data laid out as nonce||ciphertext.
+func gcmOpen(key, data, aad []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	return aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], aad)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func encodeBytes(b []byte) string {
+	return base64.StdEncoding.EncodeToString(b)
+}
@@ -0,0 +1,191 @@
+package security
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+func testTokenKey(fill byte) []byte {
+	return bytes.Repeat([]byte{fill}, tokenKeySize)
+}
+
+func newTestTokenStore(t *testing.T) (*TokenStore, *miniredis.Miniredis, *redis.Client) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	return NewTokenStore(client), mr, client
+}
+
+func TestTokenCipher_SealOpen(t *testing.T) {
+	cipher, err := NewTokenCipher("k1", map[string][]byte{"k1": testTokenKey(1)})
+	require.NoError(t, err)
+
+	sealed, err := cipher.Seal([]byte(`{"access_token":"secret"}`), []byte("oauth_token:u1:gmail"))
+	require.NoError(t, err)
+	assert.NotContains(t, string(sealed), "secret")
+
+	plaintext, keyID, err := cipher.Open(sealed, []byte("oauth_token:u1:gmail"))
+	require.NoError(t, err)
+	assert.Equal(t, "k1", keyID)
+	assert.JSONEq(t, `{"access_token":"secret"}`, string(plaintext))
+
+	// The record is bound to its Redis key.
+	_, _, err = cipher.Open(sealed, []byte("oauth_token:u2:gmail"))
+	assert.Error(t, err)
+
+	other, err := NewTokenCipher("k2", map[string][]byte{"k2": testTokenKey(2)})
+	require.NoError(t, err)
+	_, _, err = other.Open(sealed, []byte("oauth_token:u1:gmail"))
+	assert.ErrorIs(t, err, ErrUnknownTokenKey)
+
+	_, err = NewTokenCipher("k1", map[string][]byte{"k1": []byte("short")})
+	assert.Error(t, err)
+	_, err = NewTokenCipher("missing", map[string][]byte{"k1": testTokenKey(1)})
+	assert.Error(t, err)
+}
+
+func TestTokenCipherFromEnv(t *testing.T) {
+	k1 := base64.StdEncoding.EncodeToString(testTokenKey(1))
+	k2 := base64.StdEncoding.EncodeToString(testTokenKey(2))
+
+	t.Setenv("OAUTH_TOKEN_KEYS", "")
+	t.Setenv("OAUTH_TOKEN_KEY_FILE", "")
+	cipher, err := TokenCipherFromEnv()
+	require.NoError(t, err)
+	assert.Nil(t, cipher)
+
+	t.Setenv("OAUTH_TOKEN_KEYS", "k2:"+k2+",k1:"+k1)
+	cipher, err = TokenCipherFromEnv()
+	require.NoError(t, err)
+	assert.Equal(t, "k2", cipher.ActiveKeyID(), "first entry is active by default")
+	assert.Equal(t, []string{"k1", "k2"}, cipher.KeyIDs())
+
+	path := filepath.Join(t.TempDir(), "token.keys")
+	require.NoError(t, os.WriteFile(path, []byte("# rotated 2025-11\nk1:"+k1+"\nk2:"+k2+"\n"), 0o600))
+	t.Setenv("OAUTH_TOKEN_KEYS", "")
+	t.Setenv("OAUTH_TOKEN_KEY_FILE", path)
+	t.Setenv("OAUTH_TOKEN_ACTIVE_KEY", "k2")
+	cipher, err = TokenCipherFromEnv()
+	require.NoError(t, err)
+	assert.Equal(t, "k2", cipher.ActiveKeyID())
+
+	t.Setenv("OAUTH_TOKEN_ACTIVE_KEY", "k3")
+	_, err = TokenCipherFromEnv()
+	assert.Error(t, err)
+}
+
+func TestRequiredTokenCipherFromEnv(t *testing.T) {
+	t.Setenv("OAUTH_TOKEN_KEYS", "")
+	t.Setenv("OAUTH_TOKEN_KEY_FILE", "")
+	t.Setenv("OAUTH_TOKEN_ENCRYPTION_DISABLED", "")
+	_, err := RequiredTokenCipherFromEnv()
+	assert.Error(t, err, "missing keys must fail closed")
+
+	t.Setenv("OAUTH_TOKEN_ENCRYPTION_DISABLED", "true")
+	cipher, err := RequiredTokenCipherFromEnv()
+	require.NoError(t, err)
+	assert.Nil(t, cipher)
+
+	t.Setenv("OAUTH_TOKEN_KEYS", "k1:"+base64.StdEncoding.EncodeToString(testTokenKey(1)))
+	cipher, err = RequiredTokenCipherFromEnv()
+	require.NoError(t, err)
+	assert.Equal(t, "k1", cipher.ActiveKeyID())
+}
+
+func TestTokenStore_EncryptsTokensAtRest(t *testing.T) {
+	ctx := context.Background()
+	store, mr, _ := newTestTokenStore(t)
+	cipher, err := NewTokenCipher("k1", map[string][]byte{"k1": testTokenKey(1)})
+	require.NoError(t, err)
+	store.UseCipher(cipher)
+
+	token := &oauth2.Token{AccessToken: "access-123", RefreshToken: "refresh-456", TokenType: "Bearer", Expiry: time.Now().Add(time.Hour)}
+	require.NoError(t, store.StoreToken(ctx, ServiceGmail, "user-1", token))
+
+	raw, err := mr.Get("oauth_token:user-1:gmail")
+	require.NoError(t, err)
+	assert.NotContains(t, raw, "access-123")
+	assert.NotContains(t, raw, "refresh-456")
+
+	var env tokenEnvelope
+	require.NoError(t, json.Unmarshal([]byte(raw), &env))
+	assert.Equal(t, "k1", env.KeyID)
+
+	got, err := store.GetToken(ctx, ServiceGmail, "user-1")
+	require.NoError(t, err)
+	assert.Equal(t, "access-123", got.AccessToken)
+	assert.Equal(t, "refresh-456", got.RefreshToken)
+}
+
+func TestTokenStore_MigratesPlaintextAndRotatesKeys(t *testing.T) {
+	ctx := context.Background()
+	store, mr, _ := newTestTokenStore(t)
+
+	// Records written before encryption was enabled.
+	require.NoError(t, store.StoreToken(ctx, ServiceGmail, "user-1", &oauth2.Token{AccessToken: "plain-access-1", RefreshToken: "plain-refresh-1"}))
+	require.NoError(t, store.StoreToken(ctx, ServiceCalendar, "user-2", &oauth2.Token{AccessToken: "plain-access-2", RefreshToken: "plain-refresh-2"}))
+	ttlBefore := mr.TTL("oauth_token:user-1:gmail")
+	require.NotZero(t, ttlBefore)
+
+	v1, err := NewTokenCipher("k1", map[string][]byte{"k1": testTokenKey(1)})
+	require.NoError(t, err)
+	store.UseCipher(v1)
+
+	// Plaintext records stay readable and are migrated on access.
+	got, err := store.GetToken(ctx, ServiceGmail, "user-1")
+	require.NoError(t, err)
+	assert.Equal(t, "plain-access-1", got.AccessToken)
+	raw, _ := mr.Get("oauth_token:user-1:gmail")
+	assert.NotContains(t, raw, "plain-access-1")
+	assert.Equal(t, ttlBefore, mr.TTL("oauth_token:user-1:gmail"), "migration keeps the record TTL")
+
+	stats, err := store.ReencryptTokens(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, TokenReencryptStats{Scanned: 2, Migrated: 1, AlreadyCurrent: 1}, stats)
+
+	// Rotate: k2 becomes active, k1 stays in the keyring to open existing records.
+	v2, err := NewTokenCipher("k2", map[string][]byte{"k1": testTokenKey(1), "k2": testTokenKey(2)})
+	require.NoError(t, err)
+	store.UseCipher(v2)
+
+	stats, err = store.ReencryptTokens(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, TokenReencryptStats{Scanned: 2, Rotated: 2}, stats)
+
+	// k1 can now be retired.
+	v2only, err := NewTokenCipher("k2", map[string][]byte{"k2": testTokenKey(2)})
+	require.NoError(t, err)
+	store.UseCipher(v2only)
+	got, err = store.GetToken(ctx, ServiceCalendar, "user-2")
+	require.NoError(t, err)
+	assert.Equal(t, "plain-refresh-2", got.RefreshToken)
+}
+
+func TestTokenStore_EncryptedRecordWithoutCipherFails(t *testing.T) {
+	ctx := context.Background()
+	store, _, _ := newTestTokenStore(t)
+	cipher, err := NewTokenCipher("k1", map[string][]byte{"k1": testTokenKey(1)})
+	require.NoError(t, err)
+	store.UseCipher(cipher)
+	require.NoError(t, store.StoreToken(ctx, ServiceGmail, "user-1", &oauth2.Token{AccessToken: "a1"}))
+
+	store.UseCipher(nil)
+	_, err = store.GetToken(ctx, ServiceGmail, "user-1")
+	assert.Error(t, err)
+
+	_, err = store.ReencryptTokens(ctx)
+	assert.Error(t, err)
+}
@@ -0,0 +1,72 @@
+package security
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+func TestTokenStore_IndexTracksStoreAndDelete(t *testing.T) {
+	ctx := context.Background()
+	store, _, _ := newTestTokenStore(t)
+	token := &oauth2.Token{AccessToken: "access", Expiry: time.Now().Add(time.Hour)}
+
+	// User ids may contain colons; the index must not depend on key parsing.
+	require.NoError(t, store.StoreToken(ctx, ServiceGmail, "org:alice", token))
+	require.NoError(t, store.StoreToken(ctx, ServiceCalendar, "org:alice", token))
+	require.NoError(t, store.StoreToken(ctx, ServiceGmail, "bob", token))
+
+	services, err := store.ListUserServices(ctx, "org:alice")
+	require.NoError(t, err)
+	assert.Equal(t, []ServiceScope{ServiceCalendar, ServiceGmail}, services)
+
+	users, err := store.ListServiceUsers(ctx, ServiceGmail)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"bob", "org:alice"}, users)
+
+	require.NoError(t, store.DeleteToken(ctx, ServiceGmail, "org:alice"))
+	services, err = store.ListUserServices(ctx, "org:alice")
+	require.NoError(t, err)
+	assert.Equal(t, []ServiceScope{ServiceCalendar}, services)
+	users, err = store.ListServiceUsers(ctx, ServiceGmail)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"bob"}, users)
+}
+
+func TestTokenStore_IndexPrunesExpiredTokens(t *testing.T) {
+	ctx := context.Background()
+	store, mr, _ := newTestTokenStore(t)
+	require.NoError(t, store.StoreToken(ctx, ServiceGmail, "user-1", &oauth2.Token{AccessToken: "access"}))
+
+	// The 30 day TTL lapses without DeleteToken being called.
+	mr.FastForward(31 * 24 * time.Hour)
+
+	users, err := store.ListServiceUsers(ctx, ServiceGmail)
+	require.NoError(t, err)
+	assert.Empty(t, users)
+	assert.False(t, mr.Exists(serviceUsersKey(ServiceGmail)), "stale members are pruned")
+}
+
+func TestTokenStore_RebuildIndex(t *testing.T) {
+	ctx := context.Background()
+	store, mr, _ := newTestTokenStore(t)
+
+	// Records written before the index existed.
+	require.NoError(t, mr.Set("oauth_token:org:alice:gmail", `{"access_token":"a"}`))
+	require.NoError(t, mr.Set("oauth_token:bob:calendar", `{"access_token":"b"}`))
+
+	indexed, err := store.RebuildIndex(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, indexed)
+
+	users, err := store.ListServiceUsers(ctx, ServiceGmail)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"org:alice"}, users)
+	services, err := store.ListUserServices(ctx, "bob")
+	require.NoError(t, err)
+	assert.Equal(t, []ServiceScope{ServiceCalendar}, services)
+}
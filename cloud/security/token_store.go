@@ -7,6 +7,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -69,6 +71,7 @@ type OAuthConfig struct {
 type TokenStore struct {
 	redisClient  *redis.Client
 	oauthConfigs map[ServiceScope]*oauth2.Config
+	cipher       *TokenCipher
 }
 
 // NewTokenStore creates a new token store
@@ -79,6 +82,12 @@ func NewTokenStore(redisClient *redis.Client) *TokenStore {
 	}
 }
 
+// UseCipher encrypts token records at rest. Records written in plaintext or with
+// an older key are still readable and are re-encrypted with the active key on access.
+func (ts *TokenStore) UseCipher(cipher *TokenCipher) {
+	ts.cipher = cipher
+}
+
 // ConfigureService sets up OAuth configuration for a service
 func (ts *TokenStore) ConfigureService(service ServiceScope, clientID, clientSecret, redirectURL string, scopes []string) {
 	config := &oauth2.Config{
@@ -217,15 +226,19 @@ func (ts *TokenStore) StoreToken(ctx context.Context, service ServiceScope, user
 		UpdatedAt:    time.Now(),
 	}
 
-	tokenData, err := json.Marshal(tokenInfo)
+	tokenKey := tokenRedisKey(userID, service)
+	tokenData, err := ts.encodeTokenRecord(tokenKey, tokenInfo)
 	if err != nil {
-		return fmt.Errorf("failed to marshal token info: %w", err)
+		return err
 	}
 
-	tokenKey := fmt.Sprintf("oauth_token:%s:%s", userID, service)
-
 	// Store with 30 day expiry, will be refreshed on access
-	if err := ts.redisClient.Set(ctx, tokenKey, tokenData, 30*24*time.Hour).Err(); err != nil {
+	if _, err := ts.redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, tokenKey, tokenData, 30*24*time.Hour)
+		pipe.SAdd(ctx, userServicesKey(userID), string(service))
+		pipe.SAdd(ctx, serviceUsersKey(service), userID)
+		return nil
+	}); err != nil {
 		return fmt.Errorf("failed to store token in Redis: %w", err)
 	}
 
@@ -235,18 +248,24 @@ func (ts *TokenStore) StoreToken(ctx context.Context, service ServiceScope, user
 
 // GetToken retrieves OAuth token for a user and service
 func (ts *TokenStore) GetToken(ctx context.Context, service ServiceScope, userID string) (*oauth2.Token, error) {
-	tokenKey := fmt.Sprintf("oauth_token:%s:%s", userID, service)
+	tokenKey := tokenRedisKey(userID, service)
 
-	tokenData, err := ts.redisClient.Get(ctx, tokenKey).Result()
+	tokenData, err := ts.redisClient.Get(ctx, tokenKey).Bytes()
 	if err == redis.Nil {
 		return nil, fmt.Errorf("no token found for user %s, service %s", userID, service)
 	} else if err != nil {
 		return nil, fmt.Errorf("failed to retrieve token: %w", err)
 	}
 
-	var tokenInfo TokenInfo
-	if err := json.Unmarshal([]byte(tokenData), &tokenInfo); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal token info: %w", err)
+	tokenInfo, keyID, err := ts.decodeTokenRecord(tokenKey, tokenData)
+	if err != nil {
+		return nil, err
+	}
+	if ts.needsReencrypt(keyID) {
+		// Lazily migrate plaintext or old-key records; the next rotation sweep catches failures.
+		if _, err := ts.reencryptKey(ctx, tokenKey); err != nil {
+			log.Printf("Warning: failed to re-encrypt token for user %s, service %s: %v", userID, service, err)
+		}
 	}
 
 	token := &oauth2.Token{
@@ -314,9 +333,14 @@ func (ts *TokenStore) GetValidToken(ctx context.Context, service ServiceScope, u
 
 // DeleteToken removes stored token for a user and service
 func (ts *TokenStore) DeleteToken(ctx context.Context, service ServiceScope, userID string) error {
-	tokenKey := fmt.Sprintf("oauth_token:%s:%s", userID, service)
-
-	if err := ts.redisClient.Del(ctx, tokenKey).Err(); err != nil {
+	tokenKey := tokenRedisKey(userID, service)
+
+	if _, err := ts.redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, tokenKey)
+		pipe.SRem(ctx, userServicesKey(userID), string(service))
+		pipe.SRem(ctx, serviceUsersKey(service), userID)
+		return nil
+	}); err != nil {
 		return fmt.Errorf("failed to delete token: %w", err)
 	}
 
@@ -326,21 +350,229 @@ func (ts *TokenStore) DeleteToken(ctx context.Context, service ServiceScope, use
 
 // ListUserServices returns all services for which user has tokens
 func (ts *TokenStore) ListUserServices(ctx context.Context, userID string) ([]ServiceScope, error) {
-	pattern := fmt.Sprintf("oauth_token:%s:*", userID)
-	keys, err := ts.redisClient.Keys(ctx, pattern).Result()
+	members, err := ts.liveIndexMembers(ctx, userServicesKey(userID), func(member string) string {
+		return tokenRedisKey(userID, ServiceScope(member))
+	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to list user tokens: %w", err)
 	}
 
-	var services []ServiceScope
-	for _, key := range keys {
-		// Extract service from key format: oauth_token:{userID}:{service}
-		parts := []rune(key)
-		if len(parts) > 2 {
-			serviceStr := string(parts[len(fmt.Sprintf("oauth_token:%s:", userID)):])
-			services = append(services, ServiceScope(serviceStr))
+	services := make([]ServiceScope, 0, len(members))
+	for _, member := range members {
+		services = append(services, ServiceScope(member))
+	}
+	return services, nil
+}
+
+// ListServiceUsers returns every user holding a token for the service, sorted.
+func (ts *TokenStore) ListServiceUsers(ctx context.Context, service ServiceScope) ([]string, error) {
+	users, err := ts.liveIndexMembers(ctx, serviceUsersKey(service), func(member string) string {
+		return tokenRedisKey(member, service)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s users: %w", service, err)
+	}
+	return users, nil
+}
+
+// liveIndexMembers reads an index set and drops members whose token has expired,
+// since the 30 day token TTL removes records without going through DeleteToken.
+func (ts *TokenStore) liveIndexMembers(ctx context.Context, indexKey string, tokenKey func(member string) string) ([]string, error) {
+	members, err := ts.redisClient.SMembers(ctx, indexKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(members) == 0 {
+		return members, nil
+	}
+
+	pipe := ts.redisClient.Pipeline()
+	exists := make([]*redis.IntCmd, len(members))
+	for i, member := range members {
+		exists[i] = pipe.Exists(ctx, tokenKey(member))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	live := make([]string, 0, len(members))
+	var stale []any
+	for i, member := range members {
+		if exists[i].Val() > 0 {
+			live = append(live, member)
+		} else {
+			stale = append(stale, member)
+		}
+	}
+	if len(stale) > 0 {
+		if err := ts.redisClient.SRem(ctx, indexKey, stale...).Err(); err != nil {
+			log.Printf("Warning: failed to prune %d stale entries from %s: %v", len(stale), indexKey, err)
 		}
 	}
+	sort.Strings(live)
+	return live, nil
+}
 
-	return services, nil
+// RebuildIndex backfills the user/service index sets from existing token records.
+// It uses SCAN, so it is safe to run against a live Redis; tokens stored before the
+// index existed only become listable after it runs.
+func (ts *TokenStore) RebuildIndex(ctx context.Context) (int, error) {
+	indexed := 0
+	iter := ts.redisClient.Scan(ctx, 0, "oauth_token:*", 100).Iterator()
+	for iter.Next(ctx) {
+		userID, service, ok := parseTokenRedisKey(iter.Val())
+		if !ok {
+			continue
+		}
+		if _, err := ts.redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.SAdd(ctx, userServicesKey(userID), string(service))
+			pipe.SAdd(ctx, serviceUsersKey(service), userID)
+			return nil
+		}); err != nil {
+			return indexed, fmt.Errorf("failed to index %s: %w", iter.Val(), err)
+		}
+		indexed++
+	}
+	if err := iter.Err(); err != nil {
+		return indexed, fmt.Errorf("failed to scan tokens: %w", err)
+	}
+	return indexed, nil
+}
+
+// TokenReencryptStats summarizes a ReencryptTokens sweep.
+type TokenReencryptStats struct {
+	Scanned        int `json:"scanned"`
+	Migrated       int `json:"migrated"` // plaintext records now encrypted
+	Rotated        int `json:"rotated"`  // records moved from an older key to the active key
+	Failed         int `json:"failed"`
+	AlreadyCurrent int `json:"already_current"`
+}
+
+// ReencryptTokens walks every stored token and seals it with the active key. Run it
+// after adding a new active key (rotation) or after enabling encryption (migration).
+func (ts *TokenStore) ReencryptTokens(ctx context.Context) (TokenReencryptStats, error) {
+	var stats TokenReencryptStats
+	if ts.cipher == nil {
+		return stats, fmt.Errorf("token encryption not configured")
+	}
+
+	iter := ts.redisClient.Scan(ctx, 0, "oauth_token:*", 100).Iterator()
+	for iter.Next(ctx) {
+		stats.Scanned++
+		previous, err := ts.reencryptKey(ctx, iter.Val())
+		switch {
+		case err != nil:
+			stats.Failed++
+			log.Printf("Warning: failed to re-encrypt %s: %v", iter.Val(), err)
+		case previous == ts.cipher.ActiveKeyID():
+			stats.AlreadyCurrent++
+		case previous == "":
+			stats.Migrated++
+		default:
+			stats.Rotated++
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return stats, fmt.Errorf("failed to scan tokens: %w", err)
+	}
+	return stats, nil
+}
+
+// reencryptKey rewrites one record with the active key, keeping its TTL. It returns
+// the key id the record had before ("" for plaintext). WATCH guards against a
+// concurrent StoreToken being overwritten with stale data.
+func (ts *TokenStore) reencryptKey(ctx context.Context, tokenKey string) (string, error) {
+	var previous string
+	err := ts.redisClient.Watch(ctx, func(tx *redis.Tx) error {
+		data, err := tx.Get(ctx, tokenKey).Bytes()
+		if err != nil {
+			return err
+		}
+		info, keyID, err := ts.decodeTokenRecord(tokenKey, data)
+		if err != nil {
+			return err
+		}
+		previous = keyID
+		if !ts.needsReencrypt(keyID) {
+			return nil
+		}
+		sealed, err := ts.encodeTokenRecord(tokenKey, info)
+		if err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.SetArgs(ctx, tokenKey, sealed, redis.SetArgs{KeepTTL: true})
+			return nil
+		})
+		return err
+	}, tokenKey)
+	return previous, err
+}
+
+func (ts *TokenStore) needsReencrypt(keyID string) bool {
+	return ts.cipher != nil && keyID != ts.cipher.ActiveKeyID()
+}
+
+// encodeTokenRecord serializes a token, sealing it when a cipher is configured.
+func (ts *TokenStore) encodeTokenRecord(tokenKey string, info *TokenInfo) ([]byte, error) {
+	data, err := json.Marshal(info)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal token info: %w", err)
+	}
+	if ts.cipher == nil {
+		return data, nil
+	}
+	sealed, err := ts.cipher.Seal(data, []byte(tokenKey))
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt token: %w", err)
+	}
+	return sealed, nil
+}
+
+// decodeTokenRecord reads either an encrypted envelope or a legacy plaintext record
+// and returns the key id it was sealed with ("" for plaintext).
+func (ts *TokenStore) decodeTokenRecord(tokenKey string, data []byte) (*TokenInfo, string, error) {
+	keyID := ""
+	if env, ok := parseTokenEnvelope(data); ok {
+		if ts.cipher == nil {
+			return nil, env.KeyID, fmt.Errorf("token is encrypted with key %s but no cipher is configured", env.KeyID)
+		}
+		plaintext, id, err := ts.cipher.Open(data, []byte(tokenKey))
+		if err != nil {
+			return nil, id, err
+		}
+		data, keyID = plaintext, id
+	}
+
+	var tokenInfo TokenInfo
+	if err := json.Unmarshal(data, &tokenInfo); err != nil {
+		return nil, keyID, fmt.Errorf("failed to unmarshal token info: %w", err)
+	}
+	return &tokenInfo, keyID, nil
+}
+
+func tokenRedisKey(userID string, service ServiceScope) string {
+	return fmt.Sprintf("oauth_token:%s:%s", userID, service)
+}
+
+func userServicesKey(userID string) string {
+	return "oauth_services:" + userID
+}
+
+func serviceUsersKey(service ServiceScope) string {
+	return "oauth_users:" + string(service)
+}
+
+// parseTokenRedisKey splits oauth_token:{user}:{service}. Service names never contain
+// colons, so splitting on the last one keeps user ids with colons intact.
+func parseTokenRedisKey(key string) (string, ServiceScope, bool) {
+	rest, ok := strings.CutPrefix(key, "oauth_token:")
+	if !ok {
+		return "", "", false
+	}
+	idx := strings.LastIndex(rest, ":")
+	if idx <= 0 || idx == len(rest)-1 {
+		return "", "", false
+	}
+	return rest[:idx], ServiceScope(rest[idx+1:]), true
 }
@@ -0,0 +1,215 @@
+package streams
+
+import (
+	"bufio"
+	"cmp"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const archiveDateLayout = "2006-01-02"
+
+// ArchivedEntry is one trimmed stream entry as stored in the archive.
+type ArchivedEntry struct {
+	ID     string         `json:"id"`
+	Stream string         `json:"stream"`
+	Time   time.Time      `json:"time"`
+	Values map[string]any `json:"values"`
+}
+
+// Archive stores trimmed entries as gzip-compressed JSONL, one file per user,
+// stream and UTC day: {dir}/{user}/{stream}/{yyyy-mm-dd}.jsonl.gz, where stream
+// is the key after user:{id}: with colons replaced by underscores. Each write
+// appends a gzip member, which readers see as one continuous file.
+type Archive struct {
+	dir string
+	mu  sync.Mutex
+}
+
+// NewArchive creates an archive rooted at dir.
+func NewArchive(dir string) *Archive {
+	return &Archive{dir: dir}
+}
+
+// Write appends msgs, read from the stream key, to the day files they belong to.
+func (a *Archive) Write(key string, msgs []redis.XMessage) error {
+	userID, stream := splitUserStream(key)
+	if userID == "" {
+		return fmt.Errorf("archive %s: not a user stream", key)
+	}
+	byDay := make(map[string][]ArchivedEntry)
+	for _, msg := range msgs {
+		at, err := streamIDTime(msg.ID)
+		if err != nil {
+			return fmt.Errorf("archive %s: %w", key, err)
+		}
+		day := at.Format(archiveDateLayout)
+		byDay[day] = append(byDay[day], ArchivedEntry{ID: msg.ID, Stream: key, Time: at, Values: msg.Values})
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for day, entries := range byDay {
+		path, err := a.path(userID, stream, day)
+		if err != nil {
+			return err
+		}
+		if err := appendArchiveFile(path, entries); err != nil {
+			return fmt.Errorf("archive %s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// Query returns up to limit archived entries of the user's stream (e.g. "wb")
+// stamped within [from, to), oldest first.
+func (a *Archive) Query(userID, stream string, from, to time.Time, limit int) ([]ArchivedEntry, error) {
+	from, to = from.UTC(), to.UTC()
+	var out []ArchivedEntry
+	for day := from.Truncate(24 * time.Hour); day.Before(to); day = day.AddDate(0, 0, 1) {
+		path, err := a.path(userID, stream, day.Format(archiveDateLayout))
+		if err != nil {
+			return nil, err
+		}
+		entries, err := readArchiveFile(path)
+		if err != nil {
+			return nil, err
+		}
+		sort.SliceStable(entries, func(i, j int) bool { return compareStreamIDs(entries[i].ID, entries[j].ID) < 0 })
+		for _, entry := range entries {
+			if entry.Time.Before(from) || !entry.Time.Before(to) {
+				continue
+			}
+			out = append(out, entry)
+			if limit > 0 && len(out) >= limit {
+				return out, nil
+			}
+		}
+	}
+	return out, nil
+}
+
+func (a *Archive) path(userID, stream, day string) (string, error) {
+	user := pathSegment(userID)
+	name := pathSegment(strings.ReplaceAll(stream, ":", "_"))
+	if user == "" || name == "" {
+		return "", fmt.Errorf("archive: invalid user %q or stream %q", userID, stream)
+	}
+	return filepath.Join(a.dir, user, name, day+".jsonl.gz"), nil
+}
+
+// pathSegment keeps a user or stream name from escaping its directory.
+func pathSegment(s string) string {
+	s = strings.TrimSpace(s)
+	if s == "." || s == ".." || strings.ContainsAny(s, `/\`) {
+		return ""
+	}
+	return s
+}
+
+func appendArchiveFile(path string, entries []ArchivedEntry) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(f)
+	enc := json.NewEncoder(zw)
+	for _, entry := range entries {
+		if err := enc.Encode(entry); err != nil {
+			zw.Close()
+			f.Close()
+			return err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func readArchiveFile(path string) ([]ArchivedEntry, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", path, err)
+	}
+	defer zr.Close()
+
+	var entries []ArchivedEntry
+	dec := json.NewDecoder(bufio.NewReader(zr))
+	for {
+		var entry ArchivedEntry
+		if err := dec.Decode(&entry); err == io.EOF {
+			return entries, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("read %s: %w", path, err)
+		}
+		entries = append(entries, entry)
+	}
+}
+
+// splitUserStream splits user:{id}:{stream} into the user ID and stream name.
+func splitUserStream(key string) (string, string) {
+	parts := strings.SplitN(key, ":", 3)
+	if len(parts) < 3 || parts[0] != "user" {
+		return "", ""
+	}
+	return parts[1], parts[2]
+}
+
+// parseStreamID splits a <ms>-<seq> entry ID.
+func parseStreamID(id string) (uint64, uint64, error) {
+	msPart, seqPart, _ := strings.Cut(id, "-")
+	ms, err := strconv.ParseUint(msPart, 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid stream id %q", id)
+	}
+	var seq uint64
+	if seqPart != "" {
+		if seq, err = strconv.ParseUint(seqPart, 10, 64); err != nil {
+			return 0, 0, fmt.Errorf("invalid stream id %q", id)
+		}
+	}
+	return ms, seq, nil
+}
+
+func streamIDTime(id string) (time.Time, error) {
+	ms, _, err := parseStreamID(id)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.UnixMilli(int64(ms)).UTC(), nil
+}
+
+// compareStreamIDs orders entry IDs; unparsable IDs sort first.
+func compareStreamIDs(a, b string) int {
+	ams, aseq, _ := parseStreamID(a)
+	bms, bseq, _ := parseStreamID(b)
+	if c := cmp.Compare(ams, bms); c != 0 {
+		return c
+	}
+	return cmp.Compare(aseq, bseq)
+}
@@ -0,0 +1,439 @@
+package streams
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	defaultConsumerBatch       = 10
+	defaultConsumerBlock       = 2 * time.Second
+	defaultConsumerConcurrency = 4
+	defaultDrainTimeout        = 10 * time.Second
+	defaultRetryInterval       = time.Second
+	readErrorDelay             = 500 * time.Millisecond
+)
+
+// Entry is one stream entry handed to a consumer handler.
+type Entry struct {
+	redis.XMessage
+	Stream string
+	UserID string
+}
+
+// EntryHandler processes one entry. Returning nil acknowledges it; errors
+// are retried and dead-lettered as described on Delivery.
+type EntryHandler func(ctx context.Context, entry Entry) error
+
+// Typed adapts a handler that works on decoded payloads. A decode error is
+// Permanent, so the entry is dead-lettered rather than retried.
+func Typed[T any](decode func(values map[string]any) (T, error), handle func(ctx context.Context, userID string, payload T) error) EntryHandler {
+	return func(ctx context.Context, entry Entry) error {
+		payload, err := decode(entry.Values)
+		if err != nil {
+			return Permanent(fmt.Errorf("decode %s: %w", entry.ID, err))
+		}
+		return handle(ctx, entry.UserID, payload)
+	}
+}
+
+// JSONField decodes entries that carry a JSON document in a single field.
+func JSONField[T any](field string) func(values map[string]any) (*T, error) {
+	return func(values map[string]any) (*T, error) {
+		raw, ok := values[field]
+		if !ok {
+			return nil, fmt.Errorf("missing %s field", field)
+		}
+		doc, ok := raw.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s field is not a string", field)
+		}
+		var payload *T
+		if err := json.Unmarshal([]byte(doc), &payload); err != nil {
+			return nil, fmt.Errorf("unmarshal %s: %w", field, err)
+		}
+		return payload, nil
+	}
+}
+
+// ConsumerOptions configures a Consumer.
+type ConsumerOptions struct {
+	// Group is the consumer group shared by every replica.
+	Group string
+	// Name identifies this process in the group; DefaultConsumerName is used
+	// when empty, so replicas split the streams rather than share one name.
+	Name string
+	// Agent names the dead-letter streams, user:{id}:dlq:{agent}.
+	Agent string
+	// StartID is where a newly created group starts reading: "$" (the
+	// default) for new entries only, "0" for everything already in the stream.
+	StartID string
+	// Users seeds the consumed users until SetUsers replaces them.
+	Users []string
+	// BatchSize and Block bound each XREADGROUP call.
+	BatchSize int64
+	Block     time.Duration
+	// Concurrency caps how many streams are handled at once. Entries of one
+	// stream are always handled in order.
+	Concurrency int
+	// DrainTimeout is how long Stop lets in-flight handlers finish before
+	// their context is cancelled.
+	DrainTimeout time.Duration
+	Retry        RetryPolicy
+}
+
+// ConsumerMetrics is a snapshot of a consumer's counters.
+type ConsumerMetrics struct {
+	Group        string    `json:"group"`
+	Name         string    `json:"name"`
+	Agent        string    `json:"agent"`
+	Users        int       `json:"users"`
+	Running      bool      `json:"running"`
+	Read         int64     `json:"read"`
+	Acked        int64     `json:"acked"`
+	Failed       int64     `json:"failed"`
+	Retried      int64     `json:"retried"`
+	DeadLettered int64     `json:"dead_lettered"`
+	InFlight     int64     `json:"in_flight"`
+	LastError    string    `json:"last_error,omitempty"`
+	LastErrorAt  time.Time `json:"last_error_at,omitempty"`
+}
+
+type route struct {
+	pattern string
+	handler EntryHandler
+}
+
+type streamRoute struct {
+	userID  string
+	handler EntryHandler
+}
+
+// Consumer reads one consumer group across every user's streams. Handlers
+// are registered per key pattern with Handle; SetUsers picks the users.
+// Entries go through a Delivery, so failures are retried and dead-lettered.
+type Consumer struct {
+	client   *redis.Client
+	opts     ConsumerOptions
+	delivery *Delivery
+
+	mu     sync.Mutex
+	routes []route
+	users  []string
+	stop   context.CancelFunc
+	done   chan struct{}
+
+	read     atomic.Int64
+	inFlight atomic.Int64
+}
+
+// NewConsumer creates a consumer runner on the helper's client.
+func (sh *StreamsHelper) NewConsumer(opts ConsumerOptions) *Consumer {
+	if opts.Name == "" {
+		opts.Name = DefaultConsumerName(opts.Group)
+	}
+	if opts.StartID == "" {
+		opts.StartID = "$"
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = defaultConsumerBatch
+	}
+	if opts.Block <= 0 {
+		opts.Block = defaultConsumerBlock
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = defaultConsumerConcurrency
+	}
+	if opts.DrainTimeout <= 0 {
+		opts.DrainTimeout = defaultDrainTimeout
+	}
+	return &Consumer{
+		client:   sh.client,
+		opts:     opts,
+		delivery: NewDelivery(sh.client, opts.Group, opts.Name, opts.Agent, opts.Retry),
+		users:    sanitizeUsers(opts.Users),
+	}
+}
+
+// DefaultConsumerName returns host-pid, falling back to prefix for the host.
+func DefaultConsumerName(prefix string) string {
+	host, err := os.Hostname()
+	if err != nil || strings.TrimSpace(host) == "" {
+		host = prefix
+	}
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}
+
+// Handle routes the streams matching pattern, a key with one %s for the
+// user ID such as "user:%s:in:prod", to handler. Call it before Run.
+func (c *Consumer) Handle(pattern string, handler EntryHandler) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.routes = append(c.routes, route{pattern: pattern, handler: handler})
+}
+
+// Name returns the consumer's name within its group.
+func (c *Consumer) Name() string {
+	return c.opts.Name
+}
+
+// Users returns the users being consumed.
+func (c *Consumer) Users() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.users...)
+}
+
+// SetUsers replaces the consumed users, creating groups for their streams.
+// A running consumer picks them up on its next read.
+func (c *Consumer) SetUsers(ctx context.Context, userIDs []string) {
+	userIDs = sanitizeUsers(userIDs)
+	c.ensureGroups(ctx, userIDs)
+	c.mu.Lock()
+	c.users = userIDs
+	c.mu.Unlock()
+}
+
+// Run consumes until ctx is cancelled or Stop is called, then waits for
+// in-flight handlers. Handlers keep their context through the drain and
+// lose it once DrainTimeout has passed.
+func (c *Consumer) Run(ctx context.Context) error {
+	readCtx, stop := context.WithCancel(ctx)
+	defer stop()
+	handleCtx, cancelHandlers := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelHandlers()
+
+	c.mu.Lock()
+	if c.done != nil {
+		c.mu.Unlock()
+		return fmt.Errorf("consumer %s/%s is already running", c.opts.Group, c.opts.Name)
+	}
+	done := make(chan struct{})
+	c.stop, c.done = stop, done
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		c.stop, c.done = nil, nil
+		c.mu.Unlock()
+		close(done)
+	}()
+
+	go func() {
+		select {
+		case <-done:
+			return
+		case <-readCtx.Done():
+		}
+		timer := time.NewTimer(c.opts.DrainTimeout)
+		defer timer.Stop()
+		select {
+		case <-done:
+		case <-timer.C:
+			cancelHandlers()
+		}
+	}()
+
+	c.ensureGroups(readCtx, c.Users())
+	var lastRetry time.Time
+	for readCtx.Err() == nil {
+		streams := c.streams()
+		if len(streams) == 0 {
+			sleepCtx(readCtx, c.opts.Block)
+			continue
+		}
+		if time.Since(lastRetry) >= defaultRetryInterval {
+			c.retry(handleCtx, streams)
+			lastRetry = time.Now()
+		}
+
+		keys := make([]string, 0, len(streams)*2)
+		for key := range streams {
+			keys = append(keys, key)
+		}
+		for range streams {
+			keys = append(keys, ">")
+		}
+		res, err := c.client.XReadGroup(readCtx, &redis.XReadGroupArgs{
+			Group:    c.opts.Group,
+			Consumer: c.opts.Name,
+			Streams:  keys,
+			Count:    c.opts.BatchSize,
+			Block:    c.opts.Block,
+		}).Result()
+		if err != nil {
+			switch {
+			case readCtx.Err() != nil, errors.Is(err, redis.Nil):
+			case redis.HasErrorPrefix(err, "NOGROUP"):
+				// A stream was deleted, or a user was added without a group.
+				c.ensureGroups(readCtx, c.Users())
+			default:
+				log.Printf("streams: %s/%s read failed: %v", c.opts.Group, c.opts.Name, err)
+				sleepCtx(readCtx, readErrorDelay)
+			}
+			continue
+		}
+		c.dispatch(len(res), func(i int) {
+			stream := res[i]
+			c.read.Add(int64(len(stream.Messages)))
+			for _, msg := range stream.Messages {
+				c.handle(handleCtx, stream.Stream, streams[stream.Stream], msg)
+			}
+		})
+	}
+	return ctx.Err()
+}
+
+// Stop stops reading and waits for Run to drain.
+func (c *Consumer) Stop() {
+	c.mu.Lock()
+	stop, done := c.stop, c.done
+	c.mu.Unlock()
+	if stop == nil {
+		return
+	}
+	stop()
+	<-done
+}
+
+// Metrics returns a snapshot of the consumer's counters.
+func (c *Consumer) Metrics() ConsumerMetrics {
+	c.mu.Lock()
+	users, running := len(c.users), c.done != nil
+	c.mu.Unlock()
+	stats := &c.delivery.stats
+	stats.mu.Lock()
+	lastError, lastErrorAt := stats.lastError, stats.lastErrorAt
+	stats.mu.Unlock()
+	return ConsumerMetrics{
+		Group:        c.opts.Group,
+		Name:         c.opts.Name,
+		Agent:        c.opts.Agent,
+		Users:        users,
+		Running:      running,
+		Read:         c.read.Load(),
+		Acked:        stats.acked.Load(),
+		Failed:       stats.failed.Load(),
+		Retried:      stats.retried.Load(),
+		DeadLettered: stats.deadLettered.Load(),
+		InFlight:     c.inFlight.Load(),
+		LastError:    lastError,
+		LastErrorAt:  lastErrorAt,
+	}
+}
+
+func (c *Consumer) handle(ctx context.Context, stream string, r streamRoute, msg redis.XMessage) {
+	c.inFlight.Add(1)
+	defer c.inFlight.Add(-1)
+	if err := c.delivery.Handle(ctx, stream, msg, r.bind(stream)); err != nil {
+		log.Printf("streams: %s/%s: %v", c.opts.Group, c.opts.Name, err)
+	}
+}
+
+func (c *Consumer) retry(ctx context.Context, streams map[string]streamRoute) {
+	keys := make([]string, 0, len(streams))
+	for key := range streams {
+		keys = append(keys, key)
+	}
+	c.dispatch(len(keys), func(i int) {
+		key := keys[i]
+		c.inFlight.Add(1)
+		defer c.inFlight.Add(-1)
+		if _, err := c.delivery.Retry(ctx, key, streams[key].bind(key)); err != nil {
+			log.Printf("streams: %s/%s retry: %v", c.opts.Group, c.opts.Name, err)
+		}
+	})
+}
+
+// dispatch runs fn for 0..n-1 with at most Concurrency at once and waits.
+func (c *Consumer) dispatch(n int, fn func(i int)) {
+	sem := make(chan struct{}, c.opts.Concurrency)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(i int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			fn(i)
+		}(i)
+	}
+	wg.Wait()
+}
+
+// streams maps every consumed key to its user and handler.
+func (c *Consumer) streams() map[string]streamRoute {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	streams := make(map[string]streamRoute, len(c.users)*len(c.routes))
+	for _, r := range c.routes {
+		for _, userID := range c.users {
+			streams[fmt.Sprintf(r.pattern, userID)] = streamRoute{userID: userID, handler: r.handler}
+		}
+	}
+	return streams
+}
+
+func (c *Consumer) ensureGroups(ctx context.Context, userIDs []string) {
+	c.mu.Lock()
+	routes := append([]route(nil), c.routes...)
+	c.mu.Unlock()
+	for _, r := range routes {
+		for _, userID := range userIDs {
+			key := fmt.Sprintf(r.pattern, userID)
+			if err := EnsureGroup(ctx, c.client, key, c.opts.Group, c.opts.StartID); err != nil && ctx.Err() == nil {
+				log.Printf("streams: %v", err)
+			}
+		}
+	}
+}
+
+func (r streamRoute) bind(stream string) Handler {
+	return func(ctx context.Context, msg redis.XMessage) error {
+		return r.handler(ctx, Entry{XMessage: msg, Stream: stream, UserID: r.userID})
+	}
+}
+
+// EnsureGroup creates group on stream, and the stream itself, unless the
+// group already exists.
+func EnsureGroup(ctx context.Context, client *redis.Client, stream, group, startID string) error {
+	err := client.XGroupCreateMkStream(ctx, stream, group, startID).Err()
+	if err != nil && !redis.HasErrorPrefix(err, "BUSYGROUP") {
+		return fmt.Errorf("create group %s on %s: %w", group, stream, err)
+	}
+	return nil
+}
+
+func sanitizeUsers(userIDs []string) []string {
+	seen := make(map[string]bool, len(userIDs))
+	users := make([]string, 0, len(userIDs))
+	for _, userID := range userIDs {
+		userID = strings.TrimSpace(userID)
+		if userID == "" || seen[userID] {
+			continue
+		}
+		seen[userID] = true
+		users = append(users, userID)
+	}
+	return users
+}
+
+func sleepCtx(ctx context.Context, d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+}
@@ -0,0 +1,101 @@
+package streams
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConsumerRoutesDecodesAndDrains(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	ctx := context.Background()
+
+	type note struct {
+		Text string `json:"text"`
+	}
+	var (
+		mu   sync.Mutex
+		seen = map[string][]string{}
+	)
+	started, release := make(chan struct{}), make(chan struct{})
+	consumer := NewStreamsHelper(client).NewConsumer(ConsumerOptions{
+		Group: "notes", Name: "c1", Agent: "notes", StartID: "0",
+		Users: []string{"u1", "u2"}, Block: 20 * time.Millisecond, Concurrency: 2,
+	})
+	consumer.Handle("user:%s:in:notes", Typed(JSONField[note]("raw_json"), func(ctx context.Context, userID string, n *note) error {
+		if n.Text == "slow" {
+			close(started)
+			<-release
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		seen[userID] = append(seen[userID], n.Text)
+		return nil
+	}))
+	notes := func(userID string) []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), seen[userID]...)
+	}
+	add := func(userID, raw string) {
+		require.NoError(t, client.XAdd(ctx, &redis.XAddArgs{Stream: "user:" + userID + ":in:notes", Values: map[string]any{"raw_json": raw}}).Err())
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- consumer.Run(ctx) }()
+
+	add("u1", `{"text":"a"}`)
+	add("u1", `{"text":"b"}`)
+	add("u2", `{`)
+	add("u1", `{"text":"c"}`)
+	require.Eventually(t, func() bool { return len(notes("u1")) == 3 }, 2*time.Second, 10*time.Millisecond)
+	require.Equal(t, []string{"a", "b", "c"}, notes("u1"), "one stream is handled in order")
+	require.Eventually(t, func() bool { return consumer.Metrics().DeadLettered == 1 }, 2*time.Second, 10*time.Millisecond)
+	n, err := client.XLen(ctx, DeadLetterStream("u2", "notes")).Result()
+	require.NoError(t, err)
+	require.Equal(t, int64(1), n, "undecodable entries are dead-lettered")
+
+	consumer.SetUsers(ctx, []string{"u1", "u3"})
+	add("u3", `{"text":"d"}`)
+	require.Eventually(t, func() bool { return len(notes("u3")) == 1 }, 2*time.Second, 10*time.Millisecond)
+
+	// Stop waits for the entry being handled and acknowledges it.
+	add("u1", `{"text":"slow"}`)
+	<-started
+	stopped := make(chan struct{})
+	go func() {
+		consumer.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		t.Fatal("Stop returned before the in-flight handler finished")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	select {
+	case <-stopped:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Stop did not return after the handler finished")
+	}
+	require.NoError(t, <-done)
+	require.Equal(t, []string{"a", "b", "c", "slow"}, notes("u1"))
+	pending, err := client.XPending(ctx, "user:u1:in:notes", "notes").Result()
+	require.NoError(t, err)
+	require.Zero(t, pending.Count)
+
+	metrics := consumer.Metrics()
+	require.False(t, metrics.Running)
+	require.Equal(t, int64(6), metrics.Read)
+	require.Equal(t, int64(5), metrics.Acked)
+	require.Equal(t, int64(1), metrics.DeadLettered)
+	require.Zero(t, metrics.InFlight)
+	require.Contains(t, metrics.LastError, "decode")
+}
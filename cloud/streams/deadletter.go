@@ -0,0 +1,132 @@
+package streams
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Fields added to a dead-lettered entry next to its original values.
+const (
+	dlqFieldStream   = "dlq_stream"
+	dlqFieldID       = "dlq_id"
+	dlqFieldGroup    = "dlq_group"
+	dlqFieldConsumer = "dlq_consumer"
+	dlqFieldAttempts = "dlq_attempts"
+	dlqFieldError    = "dlq_error"
+	dlqFieldFailedAt = "dlq_failed_at"
+)
+
+var deadLetterFields = []string{
+	dlqFieldStream, dlqFieldID, dlqFieldGroup, dlqFieldConsumer,
+	dlqFieldAttempts, dlqFieldError, dlqFieldFailedAt,
+}
+
+// ErrDeadLetterNotFound is returned when a dead-letter entry does not exist.
+var ErrDeadLetterNotFound = errors.New("dead letter not found")
+
+// DeadLetterStream returns the stream holding an agent's failed entries for a user.
+func DeadLetterStream(userID, agent string) string {
+	return fmt.Sprintf("user:%s:dlq:%s", userID, agent)
+}
+
+// DeadLetter is one entry of a dead-letter stream.
+type DeadLetter struct {
+	ID           string         `json:"id"`
+	SourceStream string         `json:"source_stream"`
+	SourceID     string         `json:"source_id"`
+	Group        string         `json:"group"`
+	Consumer     string         `json:"consumer"`
+	Attempts     int64          `json:"attempts"`
+	Error        string         `json:"error"`
+	FailedAt     time.Time      `json:"failed_at"`
+	Values       map[string]any `json:"values"`
+}
+
+func parseDeadLetter(msg redis.XMessage) DeadLetter {
+	field := func(name string) string {
+		v, _ := msg.Values[name].(string)
+		return v
+	}
+	dl := DeadLetter{
+		ID:           msg.ID,
+		SourceStream: field(dlqFieldStream),
+		SourceID:     field(dlqFieldID),
+		Group:        field(dlqFieldGroup),
+		Consumer:     field(dlqFieldConsumer),
+		Error:        field(dlqFieldError),
+		Values:       make(map[string]any, len(msg.Values)),
+	}
+	dl.Attempts, _ = strconv.ParseInt(field(dlqFieldAttempts), 10, 64)
+	dl.FailedAt, _ = time.Parse(time.RFC3339Nano, field(dlqFieldFailedAt))
+	for k, v := range msg.Values {
+		if !strings.HasPrefix(k, "dlq_") {
+			dl.Values[k] = v
+		}
+	}
+	return dl
+}
+
+// DeadLetters returns up to count dead letters for the user and agent, newest first.
+func (sh *StreamsHelper) DeadLetters(ctx context.Context, userID, agent string, count int64) ([]DeadLetter, error) {
+	msgs, err := sh.client.XRevRangeN(ctx, DeadLetterStream(userID, agent), "+", "-", count).Result()
+	if err != nil {
+		return nil, fmt.Errorf("read dead letters: %w", err)
+	}
+	letters := make([]DeadLetter, 0, len(msgs))
+	for _, msg := range msgs {
+		letters = append(letters, parseDeadLetter(msg))
+	}
+	return letters, nil
+}
+
+// ReplayDeadLetter appends the entry's original values to the stream it
+// failed on, so the consumer group sees it as new, and removes it from the
+// dead-letter stream. It returns the replayed entry and its new ID.
+func (sh *StreamsHelper) ReplayDeadLetter(ctx context.Context, userID, agent, id string) (DeadLetter, string, error) {
+	dl, err := sh.deadLetter(ctx, userID, agent, id)
+	if err != nil {
+		return DeadLetter{}, "", err
+	}
+	if dl.SourceStream == "" {
+		return dl, "", fmt.Errorf("dead letter %s has no source stream", id)
+	}
+	var add *redis.StringCmd
+	_, err = sh.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		add = pipe.XAdd(ctx, &redis.XAddArgs{Stream: dl.SourceStream, Values: dl.Values})
+		pipe.XDel(ctx, DeadLetterStream(userID, agent), id)
+		return nil
+	})
+	if err != nil {
+		return dl, "", fmt.Errorf("replay dead letter %s: %w", id, err)
+	}
+	return dl, add.Val(), nil
+}
+
+// DiscardDeadLetter drops an entry from the dead-letter stream.
+func (sh *StreamsHelper) DiscardDeadLetter(ctx context.Context, userID, agent, id string) error {
+	n, err := sh.client.XDel(ctx, DeadLetterStream(userID, agent), id).Result()
+	if err != nil {
+		return fmt.Errorf("discard dead letter %s: %w", id, err)
+	}
+	if n == 0 {
+		return ErrDeadLetterNotFound
+	}
+	return nil
+}
+
+func (sh *StreamsHelper) deadLetter(ctx context.Context, userID, agent, id string) (DeadLetter, error) {
+	msgs, err := sh.client.XRange(ctx, DeadLetterStream(userID, agent), id, id).Result()
+	if err != nil {
+		return DeadLetter{}, fmt.Errorf("read dead letter %s: %w", id, err)
+	}
+	if len(msgs) == 0 {
+		return DeadLetter{}, ErrDeadLetterNotFound
+	}
+	return parseDeadLetter(msgs[0]), nil
+}
@@ -0,0 +1,358 @@
+package streams
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	defaultMaxAttempts = 5
+	defaultBaseBackoff = 2 * time.Second
+	defaultMaxBackoff  = 2 * time.Minute
+	defaultClaimIdle   = 5 * time.Minute
+	defaultRetryBatch  = 10
+)
+
+// RetryPolicy bounds how often a consumer group retries an entry whose
+// handler failed before giving up on it.
+type RetryPolicy struct {
+	// MaxAttempts is how many deliveries an entry gets before it is dead-lettered.
+	MaxAttempts int64
+	// BaseBackoff is the wait before the first retry. It doubles with every
+	// further attempt, up to MaxBackoff.
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+	// ClaimIdle is how long an entry may sit pending under another consumer,
+	// for example one that crashed, before it is claimed with XAUTOCLAIM.
+	ClaimIdle time.Duration
+	// BatchSize caps how many pending entries one Retry call looks at.
+	BatchSize int64
+}
+
+// DefaultRetryPolicy returns the policy used when nothing is configured.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: defaultMaxAttempts,
+		BaseBackoff: defaultBaseBackoff,
+		MaxBackoff:  defaultMaxBackoff,
+		ClaimIdle:   defaultClaimIdle,
+		BatchSize:   defaultRetryBatch,
+	}
+}
+
+// RetryPolicyFromEnv reads STREAM_RETRY_MAX_ATTEMPTS, STREAM_RETRY_BASE_BACKOFF,
+// STREAM_RETRY_MAX_BACKOFF and STREAM_CLAIM_IDLE over the defaults.
+func RetryPolicyFromEnv() RetryPolicy {
+	policy := DefaultRetryPolicy()
+	if raw := strings.TrimSpace(os.Getenv("STREAM_RETRY_MAX_ATTEMPTS")); raw != "" {
+		if n, err := strconv.ParseInt(raw, 10, 64); err == nil && n > 0 {
+			policy.MaxAttempts = n
+		}
+	}
+	policy.BaseBackoff = envDuration("STREAM_RETRY_BASE_BACKOFF", policy.BaseBackoff)
+	policy.MaxBackoff = envDuration("STREAM_RETRY_MAX_BACKOFF", policy.MaxBackoff)
+	policy.ClaimIdle = envDuration("STREAM_CLAIM_IDLE", policy.ClaimIdle)
+	return policy
+}
+
+func envDuration(name string, fallback time.Duration) time.Duration {
+	if d, err := time.ParseDuration(strings.TrimSpace(os.Getenv(name))); err == nil && d > 0 {
+		return d
+	}
+	return fallback
+}
+
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	def := DefaultRetryPolicy()
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = def.MaxAttempts
+	}
+	if p.BaseBackoff <= 0 {
+		p.BaseBackoff = def.BaseBackoff
+	}
+	if p.MaxBackoff < p.BaseBackoff {
+		p.MaxBackoff = max(def.MaxBackoff, p.BaseBackoff)
+	}
+	if p.ClaimIdle < p.MaxBackoff {
+		p.ClaimIdle = max(def.ClaimIdle, p.MaxBackoff)
+	}
+	if p.BatchSize <= 0 {
+		p.BatchSize = def.BatchSize
+	}
+	return p
+}
+
+// Backoff returns how long to wait after the given failed delivery.
+func (p RetryPolicy) Backoff(attempt int64) time.Duration {
+	wait := p.BaseBackoff
+	for i := int64(1); i < attempt && wait < p.MaxBackoff; i++ {
+		wait *= 2
+	}
+	return min(wait, p.MaxBackoff)
+}
+
+// Handler processes one stream entry. Returning nil acknowledges it.
+type Handler func(ctx context.Context, msg redis.XMessage) error
+
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// Permanent marks a handler error that retrying cannot fix, such as a
+// malformed payload. The entry is dead-lettered without further attempts.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return permanentError{err: err}
+}
+
+// IsPermanent reports whether err was marked with Permanent.
+func IsPermanent(err error) bool {
+	var p permanentError
+	return errors.As(err, &p)
+}
+
+// Delivery runs one consumer group's entries through a handler under a
+// RetryPolicy. A handled entry is acknowledged; a failed one stays pending
+// and is retried with exponential backoff; one that keeps failing is moved
+// to the user's dead-letter stream for the agent and acknowledged there.
+type Delivery struct {
+	client   *redis.Client
+	group    string
+	consumer string
+	agent    string
+	policy   RetryPolicy
+	stats    deliveryStats
+}
+
+// deliveryStats counts delivery outcomes for ConsumerMetrics.
+type deliveryStats struct {
+	acked        atomic.Int64
+	failed       atomic.Int64
+	retried      atomic.Int64
+	deadLettered atomic.Int64
+
+	mu          sync.Mutex
+	lastError   string
+	lastErrorAt time.Time
+}
+
+func (s *deliveryStats) recordError(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastError = err.Error()
+	s.lastErrorAt = time.Now().UTC()
+}
+
+// NewDelivery creates a delivery for group, reading as consumer. agent names
+// the dead-letter stream, see DeadLetterStream.
+func NewDelivery(client *redis.Client, group, consumer, agent string, policy RetryPolicy) *Delivery {
+	return &Delivery{
+		client:   client,
+		group:    group,
+		consumer: consumer,
+		agent:    agent,
+		policy:   policy.withDefaults(),
+	}
+}
+
+// Policy returns the effective retry policy.
+func (d *Delivery) Policy() RetryPolicy {
+	return d.policy
+}
+
+// Handle runs an entry just read with XREADGROUP ">", its first delivery.
+// The returned error is the handler's, annotated with what happens next.
+func (d *Delivery) Handle(ctx context.Context, stream string, msg redis.XMessage, handler Handler) error {
+	return d.deliver(ctx, stream, msg, 1, handler)
+}
+
+// HandlePending runs an entry this consumer already holds in the pending list,
+// for callers that read or claim entries themselves. The attempt number is the
+// group's delivery count, so an entry that keeps failing is dead-lettered.
+func (d *Delivery) HandlePending(ctx context.Context, stream string, msg redis.XMessage, handler Handler) error {
+	attempt, err := d.deliveryCount(ctx, stream, msg.ID)
+	if err != nil {
+		return err
+	}
+	if attempt > 1 {
+		d.stats.retried.Add(1)
+	}
+	return d.deliver(ctx, stream, msg, attempt, handler)
+}
+
+// Retry redelivers pending entries that are due: this consumer's failed
+// entries once their backoff has passed, then entries any consumer has left
+// idle for ClaimIdle. It returns how many entries it handled and the joined
+// errors of those that failed again.
+func (d *Delivery) Retry(ctx context.Context, stream string, handler Handler) (int, error) {
+	var (
+		handled int
+		errs    []error
+	)
+
+	pending, err := d.client.XPendingExt(ctx, &redis.XPendingExtArgs{
+		Stream:   stream,
+		Group:    d.group,
+		Start:    "-",
+		End:      "+",
+		Count:    d.policy.BatchSize,
+		Consumer: d.consumer,
+	}).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return 0, fmt.Errorf("list pending entries of %s: %w", stream, err)
+	}
+	for _, entry := range pending {
+		wait := d.policy.Backoff(entry.RetryCount)
+		if entry.Idle < wait {
+			continue
+		}
+		msgs, err := d.client.XClaim(ctx, &redis.XClaimArgs{
+			Stream:   stream,
+			Group:    d.group,
+			Consumer: d.consumer,
+			MinIdle:  wait,
+			Messages: []string{entry.ID},
+		}).Result()
+		if err != nil && !errors.Is(err, redis.Nil) {
+			errs = append(errs, fmt.Errorf("claim %s on %s: %w", entry.ID, stream, err))
+			continue
+		}
+		for _, msg := range msgs {
+			handled++
+			d.stats.retried.Add(1)
+			if err := d.deliver(ctx, stream, msg, entry.RetryCount+1, handler); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	// Entries abandoned by other consumers. Their delivery counts were bumped
+	// by the claim, so look them up before deciding whether to try again.
+	msgs, _, err := d.client.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+		Stream:   stream,
+		Group:    d.group,
+		Consumer: d.consumer,
+		MinIdle:  d.policy.ClaimIdle,
+		Start:    "0-0",
+		Count:    d.policy.BatchSize,
+	}).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return handled, errors.Join(append(errs, fmt.Errorf("autoclaim %s: %w", stream, err))...)
+	}
+	for _, msg := range msgs {
+		attempt, err := d.deliveryCount(ctx, stream, msg.ID)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		handled++
+		if attempt > d.policy.MaxAttempts {
+			// It was delivered that often without ever being acknowledged, so
+			// handling it is what keeps failing.
+			cause := fmt.Errorf("not acknowledged after %d deliveries", attempt-1)
+			d.stats.recordError(cause)
+			if err := d.deadLetter(ctx, stream, msg, attempt-1, cause); err != nil {
+				errs = append(errs, err)
+			}
+			continue
+		}
+		d.stats.retried.Add(1)
+		if err := d.deliver(ctx, stream, msg, attempt, handler); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return handled, errors.Join(errs...)
+}
+
+func (d *Delivery) deliver(ctx context.Context, stream string, msg redis.XMessage, attempt int64, handler Handler) error {
+	err := handler(ctx, msg)
+	if err == nil {
+		if err := d.client.XAck(ctx, stream, d.group, msg.ID).Err(); err != nil {
+			return fmt.Errorf("ack %s on %s: %w", msg.ID, stream, err)
+		}
+		d.stats.acked.Add(1)
+		return nil
+	}
+	d.stats.recordError(err)
+	if !IsPermanent(err) && attempt < d.policy.MaxAttempts {
+		d.stats.failed.Add(1)
+		return fmt.Errorf("entry %s attempt %d/%d failed, retrying in %s: %w",
+			msg.ID, attempt, d.policy.MaxAttempts, d.policy.Backoff(attempt), err)
+	}
+	if dlErr := d.deadLetter(ctx, stream, msg, attempt, err); dlErr != nil {
+		return fmt.Errorf("entry %s failed (%v) and could not be dead-lettered: %w", msg.ID, err, dlErr)
+	}
+	return fmt.Errorf("entry %s dead-lettered after %d attempts: %w", msg.ID, attempt, err)
+}
+
+func (d *Delivery) deliveryCount(ctx context.Context, stream, id string) (int64, error) {
+	pending, err := d.client.XPendingExt(ctx, &redis.XPendingExtArgs{
+		Stream: stream,
+		Group:  d.group,
+		Start:  id,
+		End:    id,
+		Count:  1,
+	}).Result()
+	if err != nil {
+		return 0, fmt.Errorf("delivery count of %s on %s: %w", id, stream, err)
+	}
+	if len(pending) == 0 {
+		return 0, fmt.Errorf("entry %s on %s is no longer pending", id, stream)
+	}
+	return pending[0].RetryCount, nil
+}
+
+// deadLetter copies msg to the dead-letter stream with the failure attached
+// and acknowledges it in one transaction.
+func (d *Delivery) deadLetter(ctx context.Context, stream string, msg redis.XMessage, attempts int64, cause error) error {
+	userID := UserIDFromStream(stream)
+	if userID == "" {
+		return fmt.Errorf("cannot dead-letter entries of %s: not a user stream", stream)
+	}
+	values := make(map[string]any, len(msg.Values)+len(deadLetterFields))
+	for k, v := range msg.Values {
+		values[k] = v
+	}
+	values[dlqFieldStream] = stream
+	values[dlqFieldID] = msg.ID
+	values[dlqFieldGroup] = d.group
+	values[dlqFieldConsumer] = d.consumer
+	values[dlqFieldAttempts] = attempts
+	values[dlqFieldError] = cause.Error()
+	values[dlqFieldFailedAt] = time.Now().UTC().Format(time.RFC3339Nano)
+
+	_, err := d.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.XAdd(ctx, &redis.XAddArgs{Stream: DeadLetterStream(userID, d.agent), Values: values})
+		pipe.XAck(ctx, stream, d.group, msg.ID)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("dead-letter %s from %s: %w", msg.ID, stream, err)
+	}
+	d.stats.deadLettered.Add(1)
+	return nil
+}
+
+// UserIDFromStream extracts the user ID from a user:{id}:... stream key, or
+// returns "" for other keys.
+func UserIDFromStream(key string) string {
+	parts := strings.SplitN(key, ":", 3)
+	if len(parts) < 3 || parts[0] != "user" {
+		return ""
+	}
+	return parts[1]
+}
@@ -0,0 +1,126 @@
+package streams
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeliveryRetriesWithBackoffThenDeadLetters(t *testing.T) {
+	mr := miniredis.RunT(t)
+	now := time.Date(2030, 1, 1, 9, 0, 0, 0, time.UTC)
+	mr.SetTime(now)
+	advance := func(d time.Duration) {
+		now = now.Add(d)
+		mr.SetTime(now)
+	}
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	ctx := context.Background()
+
+	const stream = "user:u1:in:prod"
+	require.NoError(t, client.XGroupCreateMkStream(ctx, stream, "group", "0").Err())
+	delivery := NewDelivery(client, "group", "c1", "productivity", RetryPolicy{
+		MaxAttempts: 3, BaseBackoff: time.Second, MaxBackoff: 4 * time.Second, ClaimIdle: time.Minute,
+	})
+
+	calls := 0
+	failing := func(ctx context.Context, msg redis.XMessage) error {
+		calls++
+		return errors.New("downstream unavailable")
+	}
+	read := func(consumer string) []redis.XMessage {
+		res, err := client.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group: "group", Consumer: consumer, Streams: []string{stream, ">"}, Count: 10, Block: -1,
+		}).Result()
+		require.NoError(t, err)
+		return res[0].Messages
+	}
+
+	id, err := client.XAdd(ctx, &redis.XAddArgs{Stream: stream, Values: map[string]any{"bundle_id": "com.apple.Safari"}}).Result()
+	require.NoError(t, err)
+	for _, msg := range read("c1") {
+		require.ErrorContains(t, delivery.Handle(ctx, stream, msg, failing), "attempt 1/3")
+	}
+
+	handled, err := delivery.Retry(ctx, stream, failing)
+	require.NoError(t, err)
+	require.Zero(t, handled, "the first backoff has not passed")
+
+	advance(time.Second)
+	handled, err = delivery.Retry(ctx, stream, failing)
+	require.Equal(t, 1, handled)
+	require.ErrorContains(t, err, "attempt 2/3")
+
+	advance(time.Second)
+	handled, _ = delivery.Retry(ctx, stream, failing)
+	require.Zero(t, handled, "the second backoff is twice as long")
+
+	advance(time.Second)
+	handled, err = delivery.Retry(ctx, stream, failing)
+	require.Equal(t, 1, handled)
+	require.ErrorContains(t, err, "dead-lettered after 3 attempts")
+	require.Equal(t, 3, calls)
+
+	pending, err := client.XPending(ctx, stream, "group").Result()
+	require.NoError(t, err)
+	require.Zero(t, pending.Count)
+
+	helper := NewStreamsHelper(client)
+	letters, err := helper.DeadLetters(ctx, "u1", "productivity", 10)
+	require.NoError(t, err)
+	require.Len(t, letters, 1)
+	require.Equal(t, stream, letters[0].SourceStream)
+	require.Equal(t, id, letters[0].SourceID)
+	require.Equal(t, int64(3), letters[0].Attempts)
+	require.Equal(t, "downstream unavailable", letters[0].Error)
+	require.Equal(t, map[string]any{"bundle_id": "com.apple.Safari"}, letters[0].Values)
+
+	// Malformed entries skip the retries.
+	_, err = client.XAdd(ctx, &redis.XAddArgs{Stream: stream, Values: map[string]any{"junk": "1"}}).Result()
+	require.NoError(t, err)
+	for _, msg := range read("c1") {
+		err := delivery.Handle(ctx, stream, msg, func(ctx context.Context, msg redis.XMessage) error {
+			return Permanent(errors.New("missing event_id"))
+		})
+		require.ErrorContains(t, err, "dead-lettered after 1 attempts")
+	}
+
+	// A consumer that died with an entry pending loses it after ClaimIdle.
+	_, err = client.XAdd(ctx, &redis.XAddArgs{Stream: stream, Values: map[string]any{"bundle_id": "com.figma"}}).Result()
+	require.NoError(t, err)
+	require.Len(t, read("crashed"), 1)
+	ok := func(ctx context.Context, msg redis.XMessage) error { return nil }
+	handled, err = delivery.Retry(ctx, stream, ok)
+	require.NoError(t, err)
+	require.Zero(t, handled)
+	advance(time.Minute)
+	handled, err = delivery.Retry(ctx, stream, ok)
+	require.NoError(t, err)
+	require.Equal(t, 1, handled)
+	pending, err = client.XPending(ctx, stream, "group").Result()
+	require.NoError(t, err)
+	require.Zero(t, pending.Count)
+
+	// Replaying puts the original values back on the source stream.
+	letters, err = helper.DeadLetters(ctx, "u1", "productivity", 10)
+	require.NoError(t, err)
+	require.Len(t, letters, 2)
+	replayed, newID, err := helper.ReplayDeadLetter(ctx, "u1", "productivity", letters[1].ID)
+	require.NoError(t, err)
+	require.Equal(t, stream, replayed.SourceStream)
+	msgs := read("c1")
+	require.Len(t, msgs, 1)
+	require.Equal(t, newID, msgs[0].ID)
+	require.Equal(t, map[string]any{"bundle_id": "com.apple.Safari"}, msgs[0].Values)
+
+	require.NoError(t, helper.DiscardDeadLetter(ctx, "u1", "productivity", letters[0].ID))
+	require.ErrorIs(t, helper.DiscardDeadLetter(ctx, "u1", "productivity", letters[0].ID), ErrDeadLetterNotFound)
+	_, _, err = helper.ReplayDeadLetter(ctx, "u1", "productivity", letters[1].ID)
+	require.ErrorIs(t, err, ErrDeadLetterNotFound)
+}
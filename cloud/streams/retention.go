@@ -0,0 +1,287 @@
+package streams
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	defaultTrimInterval  = 5 * time.Minute
+	defaultTrimBatchSize = 500
+	trimScanCount        = 200
+)
+
+// RetentionPolicy bounds every per-user stream user:{id}:{Stream}. Entries past
+// MaxLen or older than MaxAge are trimmed oldest first (zero disables either
+// bound), and copied to the archive first when Archive is set.
+type RetentionPolicy struct {
+	Stream  string
+	MaxLen  int64
+	MaxAge  time.Duration
+	Archive bool
+}
+
+// DefaultRetentionPolicies covers the whiteboard and the agent input streams.
+// The productivity stream takes a heartbeat every few seconds, so it keeps a day.
+func DefaultRetentionPolicies() []RetentionPolicy {
+	return []RetentionPolicy{
+		{Stream: "wb", MaxLen: 10000, MaxAge: 30 * 24 * time.Hour, Archive: true},
+		{Stream: "in:prod", MaxLen: 20000, MaxAge: 24 * time.Hour},
+		{Stream: "in:calendar", MaxLen: 5000, MaxAge: 7 * 24 * time.Hour},
+		{Stream: "in:email", MaxLen: 5000, MaxAge: 7 * 24 * time.Hour},
+		{Stream: "processed:email", MaxLen: 5000, MaxAge: 7 * 24 * time.Hour},
+	}
+}
+
+// RetentionPoliciesFromEnv applies STREAM_RETENTION_{NAME}_MAXLEN, _MAX_AGE and
+// _ARCHIVE over the defaults, where NAME is the stream upper-cased with colons
+// as underscores (WB, IN_PROD, ...). A value of 0 disables that bound.
+func RetentionPoliciesFromEnv() []RetentionPolicy {
+	policies := DefaultRetentionPolicies()
+	for i := range policies {
+		p := &policies[i]
+		prefix := "STREAM_RETENTION_" + strings.ToUpper(strings.ReplaceAll(p.Stream, ":", "_"))
+		if raw := strings.TrimSpace(os.Getenv(prefix + "_MAXLEN")); raw != "" {
+			if n, err := strconv.ParseInt(raw, 10, 64); err == nil && n >= 0 {
+				p.MaxLen = n
+			}
+		}
+		if raw := strings.TrimSpace(os.Getenv(prefix + "_MAX_AGE")); raw == "0" {
+			p.MaxAge = 0
+		} else if d, err := time.ParseDuration(raw); err == nil && d > 0 {
+			p.MaxAge = d
+		}
+		if b, err := strconv.ParseBool(strings.TrimSpace(os.Getenv(prefix + "_ARCHIVE"))); err == nil {
+			p.Archive = b
+		}
+	}
+	return policies
+}
+
+// TrimmerOptions configures a Trimmer.
+type TrimmerOptions struct {
+	Policies []RetentionPolicy
+	// Interval between trim passes; defaults to 5m.
+	Interval time.Duration
+	// Archive receives trimmed entries of policies with Archive set; nil drops them.
+	Archive   *Archive
+	BatchSize int64
+}
+
+// TrimmerOptionsFromEnv reads the retention policies, STREAM_TRIM_INTERVAL and
+// STREAM_ARCHIVE_DIR (archival is off while it is unset).
+func TrimmerOptionsFromEnv() TrimmerOptions {
+	opts := TrimmerOptions{
+		Policies: RetentionPoliciesFromEnv(),
+		Interval: envDuration("STREAM_TRIM_INTERVAL", defaultTrimInterval),
+	}
+	if dir := strings.TrimSpace(os.Getenv("STREAM_ARCHIVE_DIR")); dir != "" {
+		opts.Archive = NewArchive(dir)
+	}
+	return opts
+}
+
+// TrimStats summarizes a trim pass.
+type TrimStats struct {
+	Streams  int
+	Trimmed  int64
+	Archived int64
+}
+
+// Trimmer applies retention policies to every matching stream in Redis.
+// Entries a consumer group has not read yet, or read but not acknowledged,
+// are never trimmed, so a stalled consumer holds its stream at full length.
+type Trimmer struct {
+	client *redis.Client
+	opts   TrimmerOptions
+}
+
+// NewTrimmer creates a trimmer; call Run to trim periodically or TrimOnce for one pass.
+func NewTrimmer(client *redis.Client, opts TrimmerOptions) *Trimmer {
+	if opts.Interval <= 0 {
+		opts.Interval = defaultTrimInterval
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = defaultTrimBatchSize
+	}
+	return &Trimmer{client: client, opts: opts}
+}
+
+// Archive returns the archive trimmed entries are written to, or nil.
+func (t *Trimmer) Archive() *Archive {
+	return t.opts.Archive
+}
+
+// Run trims every Interval until ctx is cancelled.
+func (t *Trimmer) Run(ctx context.Context) {
+	ticker := time.NewTicker(t.opts.Interval)
+	defer ticker.Stop()
+	for {
+		stats, err := t.TrimOnce(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("stream trimmer: %v", err)
+		}
+		if stats.Trimmed > 0 {
+			log.Printf("stream trimmer: trimmed %d entries from %d streams (%d archived)", stats.Trimmed, stats.Streams, stats.Archived)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// TrimOnce applies each policy to the streams matching it. A failing stream is
+// reported and skipped; the others are still trimmed.
+func (t *Trimmer) TrimOnce(ctx context.Context) (TrimStats, error) {
+	var stats TrimStats
+	var errs []error
+	for _, policy := range t.opts.Policies {
+		if policy.MaxLen <= 0 && policy.MaxAge <= 0 {
+			continue
+		}
+		var cursor uint64
+		for {
+			keys, next, err := t.client.ScanType(ctx, cursor, "user:*:"+policy.Stream, trimScanCount, "stream").Result()
+			if err != nil {
+				errs = append(errs, fmt.Errorf("scan %s streams: %w", policy.Stream, err))
+				break
+			}
+			for _, key := range keys {
+				if _, stream := splitUserStream(key); stream != policy.Stream {
+					continue
+				}
+				stats.Streams++
+				trimmed, archived, err := t.trimStream(ctx, key, policy)
+				stats.Trimmed += trimmed
+				stats.Archived += archived
+				if err != nil {
+					errs = append(errs, fmt.Errorf("trim %s: %w", key, err))
+				}
+			}
+			if cursor = next; cursor == 0 {
+				break
+			}
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return stats, errors.Join(errs...)
+}
+
+func (t *Trimmer) trimStream(ctx context.Context, key string, policy RetentionPolicy) (int64, int64, error) {
+	var excess int64
+	if policy.MaxLen > 0 {
+		n, err := t.client.XLen(ctx, key).Result()
+		if err != nil {
+			return 0, 0, err
+		}
+		excess = n - policy.MaxLen
+	}
+	var minID string
+	if policy.MaxAge > 0 {
+		minID = fmt.Sprintf("%d-0", time.Now().Add(-policy.MaxAge).UnixMilli())
+	}
+	if excess <= 0 && minID == "" {
+		return 0, 0, nil
+	}
+	bounds, err := t.groupBounds(ctx, key)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var trimmed, archived int64
+	start := "-"
+	for {
+		msgs, err := t.client.XRangeN(ctx, key, start, "+", t.opts.BatchSize).Result()
+		if err != nil {
+			return trimmed, archived, err
+		}
+		drop := 0
+		for _, msg := range msgs {
+			expired := minID != "" && compareStreamIDs(msg.ID, minID) < 0
+			if (!expired && trimmed+int64(drop) >= excess) || !bounds.consumed(msg.ID) {
+				break
+			}
+			drop++
+		}
+		if drop == 0 {
+			return trimmed, archived, nil
+		}
+		if policy.Archive && t.opts.Archive != nil {
+			if err := t.opts.Archive.Write(key, msgs[:drop]); err != nil {
+				return trimmed, archived, err
+			}
+			archived += int64(drop)
+		}
+		next := nextStreamID(msgs[drop-1].ID)
+		if err := t.client.XTrimMinID(ctx, key, next).Err(); err != nil {
+			return trimmed, archived, err
+		}
+		trimmed += int64(drop)
+		if drop < len(msgs) || int64(len(msgs)) < t.opts.BatchSize {
+			return trimmed, archived, nil
+		}
+		start = next
+	}
+}
+
+// groupBound is how far one consumer group has got through a stream.
+type groupBound struct {
+	lastDelivered string
+	oldestPending string
+}
+
+type groupBounds []groupBound
+
+// consumed reports whether every group has read and acknowledged id.
+func (b groupBounds) consumed(id string) bool {
+	for _, g := range b {
+		if compareStreamIDs(id, g.lastDelivered) > 0 {
+			return false
+		}
+		if g.oldestPending != "" && compareStreamIDs(id, g.oldestPending) >= 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (t *Trimmer) groupBounds(ctx context.Context, key string) (groupBounds, error) {
+	groups, err := t.client.XInfoGroups(ctx, key).Result()
+	if err != nil {
+		return nil, fmt.Errorf("read groups: %w", err)
+	}
+	bounds := make(groupBounds, 0, len(groups))
+	for _, group := range groups {
+		bound := groupBound{lastDelivered: group.LastDeliveredID}
+		if group.Pending > 0 {
+			pending, err := t.client.XPending(ctx, key, group.Name).Result()
+			if err != nil {
+				return nil, fmt.Errorf("read pending for %s: %w", group.Name, err)
+			}
+			bound.oldestPending = pending.Lower
+		}
+		bounds = append(bounds, bound)
+	}
+	return bounds, nil
+}
+
+// nextStreamID returns the smallest entry ID greater than id.
+func nextStreamID(id string) string {
+	ms, seq, err := parseStreamID(id)
+	if err != nil {
+		return id
+	}
+	return fmt.Sprintf("%d-%d", ms, seq+1)
+}
@@ -0,0 +1,82 @@
+package streams
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrimmerKeepsUnconsumedEntriesAndArchives(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	ctx := context.Background()
+
+	// Six whiteboard entries an hour apart, two days old.
+	const wbKey = "user:u1:wb"
+	base := time.Now().Add(-48 * time.Hour).Truncate(time.Hour)
+	ids := make([]string, 6)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("%d-0", base.Add(time.Duration(i)*time.Hour).UnixMilli())
+		require.NoError(t, client.XAdd(ctx, &redis.XAddArgs{Stream: wbKey, ID: ids[i], Values: map[string]any{"type": "prod.nudge", "n": i}}).Err())
+	}
+	// The manager group has read four entries and acknowledged the first two.
+	require.NoError(t, client.XGroupCreate(ctx, wbKey, "manager", "0").Err())
+	res, err := client.XReadGroup(ctx, &redis.XReadGroupArgs{Group: "manager", Consumer: "m1", Streams: []string{wbKey, ">"}, Count: 4, Block: -1}).Result()
+	require.NoError(t, err)
+	require.Len(t, res[0].Messages, 4)
+	require.NoError(t, client.XAck(ctx, wbKey, "manager", ids[0], ids[1]).Err())
+
+	// A heartbeat stream nobody consumes as a group, bounded by length.
+	const prodKey = "user:u1:in:prod"
+	for i := 0; i < 5; i++ {
+		require.NoError(t, client.XAdd(ctx, &redis.XAddArgs{Stream: prodKey, Values: map[string]any{"n": i}}).Err())
+	}
+	require.NoError(t, client.XAdd(ctx, &redis.XAddArgs{Stream: "user:u1:dlq:wb", Values: map[string]any{"n": 0}}).Err())
+
+	archive := NewArchive(t.TempDir())
+	trimmer := NewTrimmer(client, TrimmerOptions{
+		Policies: []RetentionPolicy{
+			{Stream: "wb", MaxAge: 24 * time.Hour, Archive: true},
+			{Stream: "in:prod", MaxLen: 3},
+		},
+		Archive:   archive,
+		BatchSize: 2,
+	})
+	stats, err := trimmer.TrimOnce(ctx)
+	require.NoError(t, err)
+	require.Equal(t, TrimStats{Streams: 2, Trimmed: 4, Archived: 2}, stats)
+
+	left, err := client.XRange(ctx, wbKey, "-", "+").Result()
+	require.NoError(t, err)
+	require.Len(t, left, 4, "the pending and unread entries stay")
+	require.Equal(t, ids[2], left[0].ID)
+	n, err := client.XLen(ctx, prodKey).Result()
+	require.NoError(t, err)
+	require.Equal(t, int64(3), n)
+
+	require.NoError(t, client.XAck(ctx, wbKey, "manager", ids[2], ids[3]).Err())
+	stats, err = trimmer.TrimOnce(ctx)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), stats.Trimmed)
+
+	entries, err := archive.Query("u1", "wb", base, base.Add(3*time.Hour), 0)
+	require.NoError(t, err)
+	require.Len(t, entries, 3, "two passes append to the same day file")
+	require.Equal(t, []string{ids[0], ids[1], ids[2]}, []string{entries[0].ID, entries[1].ID, entries[2].ID})
+	require.Equal(t, wbKey, entries[0].Stream)
+	require.Equal(t, "prod.nudge", entries[0].Values["type"])
+	require.Equal(t, "1", entries[1].Values["n"])
+
+	entries, err = archive.Query("u1", "wb", base.Add(time.Hour), base.Add(24*time.Hour), 2)
+	require.NoError(t, err)
+	require.Equal(t, []string{ids[1], ids[2]}, []string{entries[0].ID, entries[1].ID})
+
+	_, err = archive.Query("../u1", "wb", base, base.Add(time.Hour), 0)
+	require.Error(t, err)
+}
@@ -0,0 +1,291 @@
+package calendar_planner
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+	"google.golang.org/api/calendar/v3"
+)
+
+// PrimaryCalendarID is the Google alias for a user's own calendar. Watched
+// calendars and sync tokens always use the alias rather than the user's email.
+const PrimaryCalendarID = "primary"
+
+// CalendarInfo describes one calendar on a user's calendar list.
+type CalendarInfo struct {
+	ID            string `json:"id"`
+	Summary       string `json:"summary"`
+	Description   string `json:"description,omitempty"`
+	TimeZone      string `json:"time_zone,omitempty"`
+	AccessRole    string `json:"access_role,omitempty"`
+	Primary       bool   `json:"primary"`
+	Watched       bool   `json:"watched"`
+	Informational bool   `json:"informational"`
+}
+
+// CalendarPreferences tells the shadow calendar which calendars only carry
+// free/informational events (holidays, a partner's calendar) that never conflict.
+type CalendarPreferences interface {
+	InformationalCalendars(ctx context.Context, userID string) (map[string]bool, error)
+}
+
+// CalendarDirectory keeps each user's discovered calendar list together with
+// the calendars they want watched and the ones they mark informational.
+type CalendarDirectory struct {
+	client *redis.Client
+}
+
+// NewCalendarDirectory creates a directory backed by Redis.
+func NewCalendarDirectory(client *redis.Client) *CalendarDirectory {
+	return &CalendarDirectory{client: client}
+}
+
+// Discover fetches the user's calendar list from Google and stores it. It
+// returns the calendars annotated with the user's watched/informational choices.
+func (d *CalendarDirectory) Discover(ctx context.Context, userID string, service *calendar.Service) ([]CalendarInfo, error) {
+	if service == nil {
+		return nil, errors.New("calendar service is required")
+	}
+	var (
+		pageToken string
+		entries   []*calendar.CalendarListEntry
+	)
+	for {
+		call := service.CalendarList.List().ShowHidden(false)
+		if pageToken != "" {
+			call = call.PageToken(pageToken)
+		}
+		resp, err := call.Context(ctx).Do()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list calendars: %w", err)
+		}
+		entries = append(entries, resp.Items...)
+		if resp.NextPageToken == "" {
+			break
+		}
+		pageToken = resp.NextPageToken
+	}
+
+	fields := make(map[string]interface{}, len(entries))
+	for _, entry := range entries {
+		info := CalendarInfo{
+			ID:          entry.Id,
+			Summary:     entry.Summary,
+			Description: entry.Description,
+			TimeZone:    entry.TimeZone,
+			AccessRole:  entry.AccessRole,
+			Primary:     entry.Primary,
+		}
+		if entry.SummaryOverride != "" {
+			info.Summary = entry.SummaryOverride
+		}
+		if info.Primary {
+			info.ID = PrimaryCalendarID
+		}
+		payload, err := json.Marshal(info)
+		if err != nil {
+			return nil, err
+		}
+		fields[info.ID] = payload
+	}
+
+	key := calendarListKey(userID)
+	_, err := d.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, key)
+		if len(fields) > 0 {
+			pipe.HSet(ctx, key, fields)
+		}
+		for _, entry := range entries {
+			if entry.Primary {
+				pipe.Set(ctx, calendarPrimaryKey(userID), entry.Id, 0)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to store calendar list for %s: %w", userID, err)
+	}
+	return d.Calendars(ctx, userID)
+}
+
+// Calendars returns the stored calendar list, sorted with the primary calendar
+// first. Watched calendars that have not been discovered yet are included too.
+func (d *CalendarDirectory) Calendars(ctx context.Context, userID string) ([]CalendarInfo, error) {
+	entries, err := d.client.HGetAll(ctx, calendarListKey(userID)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load calendar list for %s: %w", userID, err)
+	}
+	watched, err := d.WatchedCalendars(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	informational, err := d.InformationalCalendars(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	byID := make(map[string]*CalendarInfo, len(entries))
+	for id, raw := range entries {
+		var info CalendarInfo
+		if err := json.Unmarshal([]byte(raw), &info); err != nil {
+			continue
+		}
+		byID[id] = &info
+	}
+	for _, id := range watched {
+		if _, ok := byID[id]; !ok {
+			byID[id] = &CalendarInfo{ID: id, Summary: id, Primary: id == PrimaryCalendarID}
+		}
+		byID[id].Watched = true
+	}
+
+	calendars := make([]CalendarInfo, 0, len(byID))
+	for id, info := range byID {
+		info.Informational = informational[id]
+		calendars = append(calendars, *info)
+	}
+	sort.Slice(calendars, func(i, j int) bool {
+		if calendars[i].Primary != calendars[j].Primary {
+			return calendars[i].Primary
+		}
+		return calendars[i].ID < calendars[j].ID
+	})
+	return calendars, nil
+}
+
+// WatchedCalendars returns the calendars to sync for the user. Users who never
+// chose any watch just their primary calendar.
+func (d *CalendarDirectory) WatchedCalendars(ctx context.Context, userID string) ([]string, error) {
+	ids, err := d.client.SMembers(ctx, calendarWatchedKey(userID)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load watched calendars for %s: %w", userID, err)
+	}
+	if len(ids) == 0 {
+		return []string{PrimaryCalendarID}, nil
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// IsWatched reports whether calendarID is one of the user's watched calendars.
+func (d *CalendarDirectory) IsWatched(ctx context.Context, userID, calendarID string) (bool, error) {
+	calendarID, err := d.canonicalID(ctx, userID, calendarID)
+	if err != nil {
+		return false, err
+	}
+	watched, err := d.WatchedCalendars(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	for _, id := range watched {
+		if id == calendarID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// SetWatched replaces the user's watched calendars. An empty list resets the
+// user to watching only their primary calendar.
+func (d *CalendarDirectory) SetWatched(ctx context.Context, userID string, calendarIDs []string) ([]string, error) {
+	seen := make(map[string]struct{}, len(calendarIDs))
+	members := make([]interface{}, 0, len(calendarIDs))
+	for _, id := range calendarIDs {
+		id, err := d.canonicalID(ctx, userID, id)
+		if err != nil {
+			return nil, err
+		}
+		if _, dup := seen[id]; dup || id == "" {
+			continue
+		}
+		seen[id] = struct{}{}
+		members = append(members, id)
+	}
+
+	key := calendarWatchedKey(userID)
+	_, err := d.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, key)
+		if len(members) > 0 {
+			pipe.SAdd(ctx, key, members...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to store watched calendars for %s: %w", userID, err)
+	}
+	return d.WatchedCalendars(ctx, userID)
+}
+
+// SetInformational marks or unmarks a calendar as free/informational.
+func (d *CalendarDirectory) SetInformational(ctx context.Context, userID, calendarID string, informational bool) error {
+	calendarID, err := d.canonicalID(ctx, userID, calendarID)
+	if err != nil {
+		return err
+	}
+	if calendarID == "" {
+		return errors.New("calendar id is required")
+	}
+	if informational {
+		err = d.client.SAdd(ctx, calendarInformationalKey(userID), calendarID).Err()
+	} else {
+		err = d.client.SRem(ctx, calendarInformationalKey(userID), calendarID).Err()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update informational calendars for %s: %w", userID, err)
+	}
+	return nil
+}
+
+// InformationalCalendars returns the calendars whose events never count as conflicts.
+func (d *CalendarDirectory) InformationalCalendars(ctx context.Context, userID string) (map[string]bool, error) {
+	ids, err := d.client.SMembers(ctx, calendarInformationalKey(userID)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load informational calendars for %s: %w", userID, err)
+	}
+	result := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		result[id] = true
+	}
+	return result, nil
+}
+
+// canonicalID maps the user's own calendar address to the primary alias so the
+// same calendar never gets two sync tokens or webhook channels.
+func (d *CalendarDirectory) canonicalID(ctx context.Context, userID, calendarID string) (string, error) {
+	calendarID = strings.TrimSpace(calendarID)
+	if calendarID == "" || calendarID == PrimaryCalendarID {
+		return calendarID, nil
+	}
+	primary, err := d.client.Get(ctx, calendarPrimaryKey(userID)).Result()
+	if err == redis.Nil {
+		return calendarID, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve primary calendar for %s: %w", userID, err)
+	}
+	if strings.EqualFold(primary, calendarID) {
+		return PrimaryCalendarID, nil
+	}
+	return calendarID, nil
+}
+
+func calendarListKey(userID string) string {
+	return fmt.Sprintf("calendar_list:%s", userID)
+}
+
+func calendarPrimaryKey(userID string) string {
+	return fmt.Sprintf("calendar_list:%s:primary", userID)
+}
+
+func calendarWatchedKey(userID string) string {
+	return fmt.Sprintf("calendar_list:%s:watched", userID)
+}
+
+func calendarInformationalKey(userID string) string {
+	return fmt.Sprintf("calendar_list:%s:informational", userID)
+}
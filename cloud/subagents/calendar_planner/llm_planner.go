@@ -0,0 +1,321 @@
+package calendar_planner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultPlannerAPIURL    = "https://api.openai.com/v1/chat/completions"
+	defaultPlannerModel     = "gpt-5-mini"
+	defaultPlannerTimeout   = 90 * time.Second
+	defaultPlannerMaxTokens = 4000
+	maxPlannerErrorBody     = 512
+)
+
+const plannerPromptTemplate = `You are Alfred's calendar strategist optimizing flow state, energy cycles, travel buffers, and contingency plans.
+Date: %s.
+Requests: %q.
+Context: %s
+
+Rules:
+- include timezone offsets in start_time and end_time (RFC 3339)
+- keep plan_blocks <= 12 entries
+- keep descriptions <= 140 characters
+- priority is high, medium or low
+- when the request resolves an overlap between two existing events, set action and target on every block:
+  action "patch" moves the target event to the block's time, "delete" drops it, "create" adds a new event;
+  target "primary" is the first event named in the request and "conflicting" the second
+- otherwise leave action and target empty`
+
+const rankPromptTemplate = `You are Alfred's calendar strategist. A scheduling conflict needs resolving:
+%s
+
+A solver found these conflict-free options:
+%s
+Rank every option from best to worst for the user's focus, energy and the people involved.
+Give each a short explanation (<= 140 characters) the user will read. Only use the indexes listed.`
+
+var rankResponseSchema = map[string]any{
+	"type":                 "object",
+	"additionalProperties": false,
+	"required":             []string{"ranking"},
+	"properties": map[string]any{
+		"ranking": map[string]any{
+			"type": "array",
+			"items": map[string]any{
+				"type":                 "object",
+				"additionalProperties": false,
+				"required":             []string{"index", "explanation"},
+				"properties": map[string]any{
+					"index":       map[string]any{"type": "integer"},
+					"explanation": map[string]any{"type": "string"},
+				},
+			},
+		},
+	},
+}
+
+// plannerResponseSchema is the strict JSON schema the model must answer with.
+// Strict mode requires every property to be listed as required, so optional
+// fields are returned as empty strings and cleaned up by sanitizeBlocks.
+var plannerResponseSchema = map[string]any{
+	"type":                 "object",
+	"additionalProperties": false,
+	"required":             []string{"notes", "plan_blocks"},
+	"properties": map[string]any{
+		"notes": map[string]any{
+			"type":  "array",
+			"items": map[string]any{"type": "string"},
+		},
+		"plan_blocks": map[string]any{
+			"type": "array",
+			"items": map[string]any{
+				"type":                 "object",
+				"additionalProperties": false,
+				"required": []string{
+					"title", "start_time", "end_time", "description", "location",
+					"priority", "tags", "all_day", "action", "target",
+				},
+				"properties": map[string]any{
+					"title":       map[string]any{"type": "string"},
+					"start_time":  map[string]any{"type": "string"},
+					"end_time":    map[string]any{"type": "string"},
+					"description": map[string]any{"type": "string"},
+					"location":    map[string]any{"type": "string"},
+					"priority":    map[string]any{"type": "string", "enum": []string{"high", "medium", "low"}},
+					"tags":        map[string]any{"type": "array", "items": map[string]any{"type": "string"}},
+					"all_day":     map[string]any{"type": "boolean"},
+					"action":      map[string]any{"type": "string", "enum": []string{"", OperationPatch, OperationCreate, OperationDelete}},
+					"target":      map[string]any{"type": "string", "enum": []string{"", TargetPrimary, TargetConflicting}},
+				},
+			},
+		},
+	},
+}
+
+// LLMPlanner generates calendar plans by calling an OpenAI-compatible chat
+// completions endpoint directly, replacing the Python helper.
+type LLMPlanner struct {
+	client    *http.Client
+	apiURL    string
+	apiKey    string
+	model     string
+	maxTokens int
+}
+
+type plannerChatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type plannerJSONSchema struct {
+	Name   string         `json:"name"`
+	Schema map[string]any `json:"schema"`
+	Strict bool           `json:"strict"`
+}
+
+type plannerResponseFormat struct {
+	Type       string             `json:"type"`
+	JSONSchema *plannerJSONSchema `json:"json_schema,omitempty"`
+}
+
+type plannerChatRequest struct {
+	Model               string                `json:"model"`
+	Messages            []plannerChatMessage  `json:"messages"`
+	MaxCompletionTokens int                   `json:"max_completion_tokens,omitempty"`
+	ResponseFormat      plannerResponseFormat `json:"response_format"`
+}
+
+type plannerChatResponse struct {
+	Choices []struct {
+		Message struct {
+			Content string `json:"content"`
+			Refusal string `json:"refusal,omitempty"`
+		} `json:"message"`
+		FinishReason string `json:"finish_reason"`
+	} `json:"choices"`
+}
+
+// NewLLMPlannerFromEnv configures the planner from PLANNER_API_URL,
+// PLANNER_API_KEY (falling back to OPENAI_API_KEY, which the Python helper
+// used), PLANNER_MODEL_NAME and PLANNER_MAX_COMPLETION_TOKENS.
+func NewLLMPlannerFromEnv() (*LLMPlanner, error) {
+	apiURL := strings.TrimSpace(os.Getenv("PLANNER_API_URL"))
+	if apiURL == "" {
+		apiURL = defaultPlannerAPIURL
+	}
+
+	apiKey := strings.TrimSpace(os.Getenv("PLANNER_API_KEY"))
+	if apiKey == "" {
+		apiKey = strings.TrimSpace(os.Getenv("OPENAI_API_KEY"))
+	}
+	if apiKey == "" {
+		return nil, errors.New("PLANNER_API_KEY is required for the native planner")
+	}
+
+	model := strings.TrimSpace(os.Getenv("PLANNER_MODEL_NAME"))
+	if model == "" {
+		model = defaultPlannerModel
+	}
+
+	maxTokens := defaultPlannerMaxTokens
+	if raw := strings.TrimSpace(os.Getenv("PLANNER_MAX_COMPLETION_TOKENS")); raw != "" {
+		if n, err := strconv.Atoi(raw); err == nil && n > 0 {
+			maxTokens = n
+		}
+	}
+
+	return &LLMPlanner{
+		client:    &http.Client{Timeout: defaultPlannerTimeout},
+		apiURL:    apiURL,
+		apiKey:    apiKey,
+		model:     model,
+		maxTokens: maxTokens,
+	}, nil
+}
+
+// Model returns the model name the planner calls.
+func (p *LLMPlanner) Model() string {
+	return p.model
+}
+
+// GenerateCalendarPlan asks the model for plan blocks and expands them into
+// calendar events the same way the Python-backed planner does.
+func (p *LLMPlanner) GenerateCalendarPlan(ctx context.Context, planDate, timeBlock, activityType string) (*CalendarPlan, error) {
+	timeBlock = strings.TrimSpace(timeBlock)
+	if timeBlock == "" {
+		return nil, fmt.Errorf("timeBlock is required")
+	}
+	planDate = strings.TrimSpace(planDate)
+	if planDate == "" {
+		planDate = time.Now().Format("2006-01-02")
+	}
+
+	content, err := p.complete(ctx, p.buildRequest(planDate, timeBlock, activityType))
+	if err != nil {
+		return nil, err
+	}
+
+	var response struct {
+		Notes      []string    `json:"notes"`
+		PlanBlocks []PlanBlock `json:"plan_blocks"`
+	}
+	if err := json.Unmarshal([]byte(content), &response); err != nil {
+		return nil, fmt.Errorf("planner content decode failed: %w", err)
+	}
+
+	return composePlan(planDate, response.Notes, response.PlanBlocks)
+}
+
+// RankCandidates asks the model to order the scheduler's candidates and
+// explain each one. The model only picks among them; see orderCandidates.
+func (p *LLMPlanner) RankCandidates(ctx context.Context, conflict string, candidates []ScheduleCandidate) ([]ScheduleCandidate, error) {
+	var options strings.Builder
+	for i, c := range candidates {
+		fmt.Fprintf(&options, "%d: move %s (%s) to %s-%s, %+d minutes\n",
+			i, c.Summary, c.Target, c.Start.Format("Mon Jan 2 15:04"), c.End.Format("15:04"), c.ShiftMinutes)
+	}
+	content, err := p.complete(ctx, plannerChatRequest{
+		Model: p.model,
+		Messages: []plannerChatMessage{
+			{Role: "user", Content: fmt.Sprintf(rankPromptTemplate, conflict, options.String())},
+		},
+		MaxCompletionTokens: p.maxTokens,
+		ResponseFormat: plannerResponseFormat{
+			Type:       "json_schema",
+			JSONSchema: &plannerJSONSchema{Name: "candidate_ranking", Schema: rankResponseSchema, Strict: true},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var response struct {
+		Ranking []CandidateRank `json:"ranking"`
+	}
+	if err := json.Unmarshal([]byte(content), &response); err != nil {
+		return nil, fmt.Errorf("ranking content decode failed: %w", err)
+	}
+	return orderCandidates(candidates, response.Ranking), nil
+}
+
+// complete sends one chat completion and returns the message content.
+func (p *LLMPlanner) complete(ctx context.Context, request plannerChatRequest) (string, error) {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode planner request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.apiURL, bytes.NewReader(body))
+	if err != nil {
+		return "", fmt.Errorf("create planner request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+p.apiKey)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("call planner model: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("read planner response: %w", err)
+	}
+	if resp.StatusCode >= 300 {
+		detail := strings.TrimSpace(string(respBody))
+		if len(detail) > maxPlannerErrorBody {
+			detail = detail[:maxPlannerErrorBody] + "..."
+		}
+		return "", fmt.Errorf("planner model error %d: %s", resp.StatusCode, detail)
+	}
+
+	var parsed plannerChatResponse
+	if err := json.Unmarshal(respBody, &parsed); err != nil {
+		return "", fmt.Errorf("planner response decode failed: %w", err)
+	}
+	if len(parsed.Choices) == 0 {
+		return "", errors.New("planner model returned no choices")
+	}
+	choice := parsed.Choices[0]
+	if choice.Message.Refusal != "" {
+		return "", fmt.Errorf("planner model refused: %s", choice.Message.Refusal)
+	}
+	content := strings.TrimSpace(choice.Message.Content)
+	if content == "" {
+		return "", fmt.Errorf("planner model returned empty content (finish_reason %q)", choice.FinishReason)
+	}
+	return content, nil
+}
+
+func (p *LLMPlanner) buildRequest(planDate, timeBlock, activityType string) plannerChatRequest {
+	activityType = strings.TrimSpace(activityType)
+	if activityType == "" {
+		activityType = "general productivity"
+	}
+	return plannerChatRequest{
+		Model: p.model,
+		Messages: []plannerChatMessage{
+			{Role: "user", Content: fmt.Sprintf(plannerPromptTemplate, planDate, timeBlock, activityType)},
+		},
+		MaxCompletionTokens: p.maxTokens,
+		ResponseFormat: plannerResponseFormat{
+			Type: "json_schema",
+			JSONSchema: &plannerJSONSchema{
+				Name:   "calendar_plan",
+				Schema: plannerResponseSchema,
+				Strict: true,
+			},
+		},
+	}
+}
@@ -0,0 +1,85 @@
+package calendar_planner
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLLMPlannerAgainstStubModel(t *testing.T) {
+	var got plannerChatRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "Bearer test-key", r.Header.Get("Authorization"))
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&got))
+
+		content, _ := json.Marshal(map[string]any{
+			"notes": []string{"Protect focus time", "Protect focus time"},
+			"plan_blocks": []map[string]any{
+				{
+					"title": " Design review ", "start_time": "2025-11-15T14:00:00-08:00", "end_time": "2025-11-15T15:00:00-08:00",
+					"description": "", "location": "", "priority": "high", "tags": []string{"meeting"}, "all_day": false,
+					"action": "PATCH", "target": "conflicting",
+				},
+				{
+					"title": "Missing times", "start_time": "", "end_time": "",
+					"description": "", "location": "", "priority": "low", "tags": []string{}, "all_day": false,
+					"action": "", "target": "",
+				},
+			},
+		})
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"choices": []map[string]any{{"message": map[string]any{"content": string(content)}, "finish_reason": "stop"}},
+		})
+	}))
+	t.Cleanup(server.Close)
+
+	t.Setenv("PLANNER_API_URL", server.URL)
+	t.Setenv("PLANNER_API_KEY", "test-key")
+	t.Setenv("PLANNER_MODEL_NAME", "stub-model")
+	planner, err := NewLLMPlannerFromEnv()
+	require.NoError(t, err)
+
+	plan, err := planner.GenerateCalendarPlan(context.Background(), "2025-11-15", "Resolve overlap: Standup vs Design review", "calendar_conflict")
+	require.NoError(t, err)
+
+	require.Equal(t, "stub-model", got.Model)
+	require.Equal(t, "json_schema", got.ResponseFormat.Type)
+	require.NotNil(t, got.ResponseFormat.JSONSchema)
+	require.True(t, got.ResponseFormat.JSONSchema.Strict)
+	require.Contains(t, got.Messages[0].Content, "Resolve overlap: Standup vs Design review")
+
+	require.Equal(t, []string{"Protect focus time"}, plan.Notes)
+	require.Len(t, plan.Blocks, 1)
+	require.Len(t, plan.Events, 1)
+	evt := plan.Events[0]
+	require.Equal(t, "Design review", evt.Summary)
+	require.Equal(t, OperationPatch, evt.Action)
+	require.Equal(t, TargetConflicting, evt.Target)
+	require.Equal(t, "2025-11-15T14:00:00-08:00", evt.Start.DateTime)
+	require.Equal(t, "UTC-08:00", evt.Start.TimeZone)
+}
+
+func TestLLMPlannerSurfacesModelErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error":{"message":"invalid schema"}}`, http.StatusBadRequest)
+	}))
+	t.Cleanup(server.Close)
+
+	t.Setenv("PLANNER_API_URL", server.URL)
+	t.Setenv("PLANNER_API_KEY", "test-key")
+	planner, err := NewLLMPlannerFromEnv()
+	require.NoError(t, err)
+
+	_, err = planner.GenerateCalendarPlan(context.Background(), "2025-11-15", "Plan my morning", "")
+	require.ErrorContains(t, err, "planner model error 400")
+	require.ErrorContains(t, err, "invalid schema")
+
+	t.Setenv("PLANNER_API_KEY", "")
+	t.Setenv("OPENAI_API_KEY", "")
+	_, err = NewLLMPlannerFromEnv()
+	require.Error(t, err)
+}
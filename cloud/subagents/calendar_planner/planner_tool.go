@@ -34,6 +34,10 @@ type PlanBlock struct {
 	Priority    string   `json:"priority,omitempty"`
 	Tags        []string `json:"tags,omitempty"`
 	AllDay      bool     `json:"all_day,omitempty"`
+	// Action (patch|create|delete) and Target (primary|conflicting) say how the
+	// block resolves a conflict; blocks without them are plain suggestions.
+	Action string `json:"action,omitempty"`
+	Target string `json:"target,omitempty"`
 }
 
 // GoogleCalendarEvent represents an event structure compatible with Google Calendar APIs.
@@ -45,6 +49,8 @@ type GoogleCalendarEvent struct {
 	End         GoogleCalendarTime `json:"end"`
 	Tags        []string           `json:"tags,omitempty"`
 	Priority    string             `json:"priority,omitempty"`
+	Action      string             `json:"action,omitempty"`
+	Target      string             `json:"target,omitempty"`
 }
 
 // GoogleCalendarTime represents a timestamp payload for Google Calendar.
@@ -125,7 +131,13 @@ func (ps *CalendarManagerService) GenerateCalendarPlan(
 		return nil, fmt.Errorf("planner response decode failed: %w", err)
 	}
 
-	if len(response.PlanBlocks) == 0 {
+	return composePlan(planDate, response.Notes, response.PlanBlocks)
+}
+
+// composePlan sanitizes the blocks a planner returned and expands them into
+// calendar events for planDate, defaulting to today.
+func composePlan(planDate string, notes []string, rawBlocks []PlanBlock) (*CalendarPlan, error) {
+	if len(rawBlocks) == 0 {
 		return nil, fmt.Errorf("planner response missing plan_blocks")
 	}
 
@@ -134,13 +146,13 @@ func (ps *CalendarManagerService) GenerateCalendarPlan(
 		planDate = time.Now().Format("2006-01-02")
 	}
 
-	blocks := sanitizeBlocks(response.PlanBlocks)
+	blocks := sanitizeBlocks(rawBlocks)
 	if len(blocks) == 0 {
 		return nil, fmt.Errorf("planner blocks invalid after sanitization")
 	}
 
 	plan := &CalendarPlan{
-		Notes:  dedupeStrings(response.Notes),
+		Notes:  dedupeStrings(notes),
 		Blocks: blocks,
 	}
 	events, err := buildGoogleEvents(planDate, blocks)
@@ -173,6 +185,8 @@ func buildGoogleEvents(planDate string, blocks []PlanBlock) ([]GoogleCalendarEve
 			Location:    block.Location,
 			Tags:        block.Tags,
 			Priority:    block.Priority,
+			Action:      block.Action,
+			Target:      block.Target,
 			Start: GoogleCalendarTime{
 				DateTime: start.Format(time.RFC3339),
 				TimeZone: formatTimeZone(start),
@@ -195,6 +209,8 @@ func sanitizeBlocks(blocks []PlanBlock) []PlanBlock {
 		block.EndTime = strings.TrimSpace(block.EndTime)
 		block.Location = strings.TrimSpace(block.Location)
 		block.Priority = strings.TrimSpace(block.Priority)
+		block.Action = strings.ToLower(strings.TrimSpace(block.Action))
+		block.Target = strings.ToLower(strings.TrimSpace(block.Target))
 		block.Tags = dedupeStrings(block.Tags)
 		if block.StartTime == "" || block.EndTime == "" {
 			continue
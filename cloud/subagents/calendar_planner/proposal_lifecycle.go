@@ -0,0 +1,295 @@
+package calendar_planner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// Proposal statuses. A proposal starts pending and moves to exactly one of the
+// other states, all of which are final except that undoing an accepted
+// proposal reopens it.
+const (
+	ProposalPending    = "pending"
+	ProposalAccepted   = "accepted"
+	ProposalRejected   = "rejected"
+	ProposalExpired    = "expired"
+	ProposalSuperseded = "superseded"
+)
+
+var (
+	// ErrProposalNotFound is returned when a proposal does not exist for the user.
+	ErrProposalNotFound = errors.New("proposal not found")
+	// ErrInvalidTransition is returned when a proposal cannot move to the requested status.
+	ErrInvalidTransition = errors.New("invalid proposal transition")
+	// ErrProposalBusy is returned while another request is applying or undoing the proposal.
+	ErrProposalBusy = errors.New("proposal is being applied")
+)
+
+// proposalClaimTTL bounds how long a request that died mid-apply holds its proposal.
+const proposalClaimTTL = 2 * time.Minute
+
+// ProposalTransition records one status change of a proposal.
+type ProposalTransition struct {
+	From   string    `json:"from,omitempty"`
+	To     string    `json:"to"`
+	Reason string    `json:"reason,omitempty"`
+	At     time.Time `json:"at"`
+}
+
+// IsProposalStatus reports whether status is one of the proposal states.
+func IsProposalStatus(status string) bool {
+	switch status {
+	case ProposalPending, ProposalAccepted, ProposalRejected, ProposalExpired, ProposalSuperseded:
+		return true
+	}
+	return false
+}
+
+// normalizeProposalStatus maps statuses written before the state machine existed;
+// confirmed proposals used to be stored as "applied".
+func normalizeProposalStatus(status string) string {
+	switch status = strings.ToLower(strings.TrimSpace(status)); status {
+	case "":
+		return ProposalPending
+	case "applied":
+		return ProposalAccepted
+	}
+	return status
+}
+
+// Transition moves a pending proposal to a final status, or an accepted one
+// back to pending, and records why.
+func (p *ShadowProposal) Transition(to, reason string, at time.Time) error {
+	from := normalizeProposalStatus(p.Status)
+	if !canTransition(from, to) {
+		return fmt.Errorf("%w: %s -> %s", ErrInvalidTransition, from, to)
+	}
+	p.Status = to
+	p.StatusReason = reason
+	p.UpdatedAt = at
+	p.History = append(p.History, ProposalTransition{From: from, To: to, Reason: reason, At: at})
+	return nil
+}
+
+func canTransition(from, to string) bool {
+	switch from {
+	case ProposalPending:
+		return to != ProposalPending && IsProposalStatus(to)
+	case ProposalAccepted:
+		return to == ProposalPending
+	}
+	return false
+}
+
+// expiry is when the conflict the proposal resolves is over. Proposals stored
+// before ExpiresAt was recorded fall back to the earlier of the two event ends.
+func (p *ShadowProposal) expiry() time.Time {
+	if !p.ExpiresAt.IsZero() {
+		return p.ExpiresAt
+	}
+	var expiry time.Time
+	for _, summary := range []*ShadowEventSummary{p.PrimaryEvent, p.ConflictingEvent} {
+		if summary == nil {
+			continue
+		}
+		end, err := time.Parse(time.RFC3339, summary.EndISO)
+		if err != nil {
+			continue
+		}
+		if expiry.IsZero() || end.Before(expiry) {
+			expiry = end
+		}
+	}
+	return expiry
+}
+
+// sameConflict reports whether the proposal was made for a and b at their current times.
+func (p *ShadowProposal) sameConflict(a, b *ShadowEvent) bool {
+	matches := func(summary *ShadowEventSummary) bool {
+		if summary == nil {
+			return false
+		}
+		for _, evt := range []*ShadowEvent{a, b} {
+			if summary.ref() == evt.ref() {
+				return summary.StartISO == evt.StartTime.Format(time.RFC3339) && summary.EndISO == evt.EndTime.Format(time.RFC3339)
+			}
+		}
+		return false
+	}
+	return matches(p.PrimaryEvent) && matches(p.ConflictingEvent)
+}
+
+// ListProposals returns the user's proposals, oldest first, expiring any whose
+// conflict is over. A non-empty status keeps only proposals in that state.
+func (s *ShadowCalendarService) ListProposals(ctx context.Context, userID, status string) ([]*ShadowProposal, error) {
+	if s == nil || s.store == nil {
+		return nil, errors.New("shadow calendar store not configured")
+	}
+	if status != "" && !IsProposalStatus(status) {
+		return nil, fmt.Errorf("unknown proposal status %q", status)
+	}
+	proposals, err := s.store.ListProposals(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	filtered := proposals[:0]
+	for _, proposal := range proposals {
+		if err := s.expireIfDue(ctx, proposal, now); err != nil {
+			return nil, err
+		}
+		proposal.Status = normalizeProposalStatus(proposal.Status)
+		if status == "" || proposal.Status == status {
+			filtered = append(filtered, proposal)
+		}
+	}
+	return filtered, nil
+}
+
+// TransitionProposal moves a proposal to status and emits the change to
+// the user's whiteboard. A proposal whose conflict is already over is expired
+// instead and the transition fails.
+func (s *ShadowCalendarService) TransitionProposal(ctx context.Context, userID, proposalID, status, reason string) (*ShadowProposal, error) {
+	if s == nil || s.store == nil {
+		return nil, errors.New("shadow calendar store not configured")
+	}
+	proposal, err := s.store.GetProposal(ctx, userID, proposalID)
+	if err != nil {
+		return nil, err
+	}
+	if proposal == nil {
+		return nil, ErrProposalNotFound
+	}
+	now := time.Now().UTC()
+	if err := s.expireIfDue(ctx, proposal, now); err != nil {
+		return nil, err
+	}
+	if err := proposal.Transition(status, reason, now); err != nil {
+		return proposal, err
+	}
+	if err := s.store.SaveProposal(ctx, proposal); err != nil {
+		return nil, err
+	}
+	s.emitTransition(ctx, proposal)
+	return proposal, nil
+}
+
+// ClaimProposal reserves a proposal for one request changing the calendar on
+// its behalf, so two confirms cannot both apply the plan. Callers read the
+// status after claiming and call the returned func when done.
+func (s *ShadowCalendarService) ClaimProposal(ctx context.Context, userID, proposalID string) (func(), error) {
+	if s == nil || s.store == nil {
+		return nil, errors.New("shadow calendar store not configured")
+	}
+	claimed, err := s.store.ClaimProposal(ctx, userID, proposalID, proposalClaimTTL)
+	if err != nil {
+		return nil, err
+	}
+	if !claimed {
+		return nil, ErrProposalBusy
+	}
+	return func() {
+		if err := s.store.ReleaseProposal(context.WithoutCancel(ctx), userID, proposalID); err != nil {
+			log.Printf("shadow calendar: release proposal %s: %v", proposalID, err)
+		}
+	}, nil
+}
+
+// expireProposals expires every pending proposal whose conflict is over.
+func (s *ShadowCalendarService) expireProposals(ctx context.Context, userID string) error {
+	proposals, err := s.store.ListProposals(ctx, userID)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	for _, proposal := range proposals {
+		if err := s.expireIfDue(ctx, proposal, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *ShadowCalendarService) expireIfDue(ctx context.Context, proposal *ShadowProposal, now time.Time) error {
+	if normalizeProposalStatus(proposal.Status) != ProposalPending {
+		return nil
+	}
+	expiry := proposal.expiry()
+	if expiry.IsZero() || expiry.After(now) {
+		return nil
+	}
+	if err := proposal.Transition(ProposalExpired, "conflict time has passed", now); err != nil {
+		return err
+	}
+	if err := s.store.SaveProposal(ctx, proposal); err != nil {
+		return err
+	}
+	s.emitTransition(ctx, proposal)
+	return nil
+}
+
+// emitTransition appends the proposal's current status to the user's whiteboard,
+// threaded by conflict so successive proposals for the same overlap stay together.
+func (s *ShadowCalendarService) emitTransition(ctx context.Context, proposal *ShadowProposal) {
+	if s.bus == nil {
+		return
+	}
+	values := map[string]any{
+		"type":         "calendar.proposal." + proposal.Status,
+		"user_id":      proposal.UserID,
+		"proposal_id":  proposal.ID,
+		"status":       proposal.Status,
+		"summary":      proposal.Reason,
+		"conflict_key": proposal.ConflictKey,
+	}
+	if n := len(proposal.History); n > 0 && proposal.History[n-1].From != "" {
+		values["previous_status"] = proposal.History[n-1].From
+	}
+	if proposal.StatusReason != "" {
+		values["reason"] = proposal.StatusReason
+	}
+	if proposal.SupersededBy != "" {
+		values["superseded_by"] = proposal.SupersededBy
+	}
+	if _, err := s.bus.AppendWithThread(ctx, proposal.UserID, proposal.ConflictKey, values); err != nil {
+		log.Printf("shadow calendar: emit proposal %s %s for %s: %v", proposal.ID, proposal.Status, proposal.UserID, err)
+	}
+}
+
+// emitProposed asks the manager to put a new proposal to the user. The prompt's
+// delta_id is the proposal id, which the confirm and reject routes take.
+func (s *ShadowCalendarService) emitProposed(ctx context.Context, proposal *ShadowProposal) {
+	if s.bus == nil {
+		return
+	}
+	values := map[string]any{
+		"type":         "calendar.plan.proposed",
+		"user_id":      proposal.UserID,
+		"delta_id":     proposal.ID,
+		"summary":      proposal.Reason,
+		"impact":       proposal.impact(),
+		"conflict_key": proposal.ConflictKey,
+	}
+	if _, err := s.bus.AppendWithThread(ctx, proposal.UserID, proposal.ConflictKey, values); err != nil {
+		log.Printf("shadow calendar: emit plan.proposed %s for %s: %v", proposal.ID, proposal.UserID, err)
+	}
+}
+
+// impact describes in a few words what accepting the proposal changes.
+func (p *ShadowProposal) impact() string {
+	if len(p.Candidates) > 0 {
+		c := p.Candidates[0]
+		return fmt.Sprintf("moves %s to %s-%s", c.Summary, c.Start.Format(time.Kitchen), c.End.Format(time.Kitchen))
+	}
+	if p.Plan != nil && len(p.Plan.Events) > 0 {
+		if len(p.Plan.Events) == 1 {
+			return "changes 1 event"
+		}
+		return fmt.Sprintf("changes %d events", len(p.Plan.Events))
+	}
+	return "no change drafted; resolve it by hand"
+}
@@ -0,0 +1,143 @@
+package calendar_planner
+
+import (
+	"fmt"
+	"strings"
+
+	"google.golang.org/api/calendar/v3"
+)
+
+// Operation actions a plan event can request.
+const (
+	OperationPatch  = "patch"
+	OperationCreate = "create"
+	OperationDelete = "delete"
+)
+
+// Operation outcomes reported when a proposal is applied.
+const (
+	OperationPending        = "pending"
+	OperationApplied        = "applied"
+	OperationFailed         = "failed"
+	OperationSkipped        = "skipped"
+	OperationRolledBack     = "rolled_back"
+	OperationRollbackFailed = "rollback_failed"
+)
+
+// Operation targets name one of the two events in the conflict.
+const (
+	TargetPrimary     = "primary"
+	TargetConflicting = "conflicting"
+)
+
+// ProposalOperation is one calendar change made while applying a proposal.
+// Before holds the event as it was so the change can be compensated or undone;
+// AppliedEtag and AppliedSequence identify the version the change produced, so
+// an undo can tell whether the event was edited again since.
+type ProposalOperation struct {
+	Action     string               `json:"action"`
+	Target     string               `json:"target,omitempty"`
+	CalendarID string               `json:"calendar_id"`
+	EventID    string               `json:"event_id,omitempty"`
+	Event      *GoogleCalendarEvent `json:"event,omitempty"`
+	Before     *calendar.Event      `json:"before,omitempty"`
+
+	AppliedEtag     string `json:"applied_etag,omitempty"`
+	AppliedSequence int64  `json:"applied_sequence,omitempty"`
+
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// PlannedOperations turns the proposal's plan into calendar operations. Plans
+// whose events carry an action or target are applied whole; older plans only
+// move the applyTo event to plan.Events[planIndex], on calendarID when set.
+// Whole plans always use the calendar each target event lives on.
+func (p *ShadowProposal) PlannedOperations(applyTo string, planIndex int, calendarID string) ([]ProposalOperation, error) {
+	if p.Plan == nil || len(p.Plan.Events) == 0 {
+		return nil, fmt.Errorf("proposal missing plan events")
+	}
+	if p.Plan.hasOperations() {
+		return p.operations(p.Plan.Events)
+	}
+	if planIndex < 0 || planIndex >= len(p.Plan.Events) {
+		return nil, fmt.Errorf("plan_index out of range")
+	}
+	evt := p.Plan.Events[planIndex]
+	evt.Action, evt.Target = OperationPatch, applyTo
+	ops, err := p.operations([]GoogleCalendarEvent{evt})
+	if err != nil {
+		return nil, err
+	}
+	if calendarID != "" {
+		ops[0].CalendarID = calendarID
+	}
+	return ops, nil
+}
+
+func (plan *CalendarPlan) hasOperations() bool {
+	for _, evt := range plan.Events {
+		if evt.Action != "" || evt.Target != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *ShadowProposal) operations(events []GoogleCalendarEvent) ([]ProposalOperation, error) {
+	ops := make([]ProposalOperation, 0, len(events))
+	for i := range events {
+		evt := events[i]
+		action := strings.ToLower(strings.TrimSpace(evt.Action))
+		target := strings.ToLower(strings.TrimSpace(evt.Target))
+		if action == "" {
+			action = OperationPatch
+			if target == "" {
+				action = OperationCreate
+			}
+		}
+
+		op := ProposalOperation{Action: action, Target: target, Status: OperationPending}
+		switch action {
+		case OperationPatch, OperationDelete:
+			summary, err := p.targetEvent(target)
+			if err != nil {
+				return nil, err
+			}
+			op.EventID = summary.EventID
+			op.CalendarID = summary.CalendarID
+		case OperationCreate:
+			op.Target = ""
+			if p.PrimaryEvent != nil {
+				op.CalendarID = p.PrimaryEvent.CalendarID
+			}
+		default:
+			return nil, fmt.Errorf("unsupported plan action %q", evt.Action)
+		}
+		if action != OperationDelete {
+			if strings.TrimSpace(evt.Start.DateTime) == "" || strings.TrimSpace(evt.End.DateTime) == "" {
+				return nil, fmt.Errorf("proposal event missing start or end time")
+			}
+			op.Event = &evt
+		}
+		op.CalendarID = calendarOrPrimary(op.CalendarID)
+		ops = append(ops, op)
+	}
+	return ops, nil
+}
+
+func (p *ShadowProposal) targetEvent(target string) (*ShadowEventSummary, error) {
+	var summary *ShadowEventSummary
+	switch target {
+	case TargetPrimary:
+		summary = p.PrimaryEvent
+	case TargetConflicting:
+		summary = p.ConflictingEvent
+	default:
+		return nil, fmt.Errorf("target must be '%s' or '%s'", TargetPrimary, TargetConflicting)
+	}
+	if summary == nil || strings.TrimSpace(summary.EventID) == "" {
+		return nil, fmt.Errorf("target event missing")
+	}
+	return summary, nil
+}
@@ -0,0 +1,475 @@
+package calendar_planner
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxRecurrencePeriods bounds how many periods (days, weeks, months, years) an
+// expansion walks, so malformed or very sparse rules cannot spin forever.
+const maxRecurrencePeriods = 5000
+
+// recurrenceSet is a parsed Google Calendar recurrence: one RRULE plus any
+// RDATE/EXDATE lines.
+type recurrenceSet struct {
+	rule    *recurrenceRule
+	rdates  []time.Time
+	exdates map[int64]struct{}
+}
+
+type recurrenceRule struct {
+	freq       string
+	interval   int
+	count      int
+	until      time.Time
+	byDay      []weekdayNum
+	byMonthDay []int
+	byMonth    []time.Month
+	weekStart  time.Weekday
+}
+
+// weekdayNum is a BYDAY entry such as MO, 2TU or -1FR. ordinal 0 means every such weekday.
+type weekdayNum struct {
+	ordinal int
+	day     time.Weekday
+}
+
+var rruleWeekdays = map[string]time.Weekday{
+	"SU": time.Sunday,
+	"MO": time.Monday,
+	"TU": time.Tuesday,
+	"WE": time.Wednesday,
+	"TH": time.Thursday,
+	"FR": time.Friday,
+	"SA": time.Saturday,
+}
+
+// ExpandRecurrence returns the start times of the occurrences of a recurring
+// event that begin in [from, to). dtstart is the series' first start and loc the
+// event's timezone, which keeps wall-clock times stable across DST changes.
+func ExpandRecurrence(lines []string, dtstart time.Time, loc *time.Location, from, to time.Time) ([]time.Time, error) {
+	if loc == nil {
+		loc = dtstart.Location()
+	}
+	set, err := parseRecurrence(lines, loc)
+	if err != nil {
+		return nil, err
+	}
+	return set.between(dtstart.In(loc), from, to), nil
+}
+
+// NextOccurrence returns the first occurrence of a recurring event that ends
+// after the given time, shifted to keep the event's duration.
+func NextOccurrence(lines []string, start, end time.Time, loc *time.Location, after time.Time) (time.Time, time.Time, bool) {
+	duration := end.Sub(start)
+	// Look back one duration so an occurrence in progress still counts.
+	starts, err := ExpandRecurrence(lines, start, loc, after.Add(-duration), after.AddDate(1, 0, 0))
+	if err != nil || len(starts) == 0 {
+		return time.Time{}, time.Time{}, false
+	}
+	for _, occurrence := range starts {
+		if occurrence.Add(duration).After(after) {
+			return occurrence, occurrence.Add(duration), true
+		}
+	}
+	return time.Time{}, time.Time{}, false
+}
+
+func parseRecurrence(lines []string, loc *time.Location) (*recurrenceSet, error) {
+	set := &recurrenceSet{exdates: make(map[int64]struct{})}
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid recurrence line %q", line)
+		}
+		name, params, _ := strings.Cut(name, ";")
+		switch strings.ToUpper(name) {
+		case "RRULE":
+			rule, err := parseRRule(value, loc)
+			if err != nil {
+				return nil, err
+			}
+			set.rule = rule
+		case "RDATE", "EXDATE":
+			dates, err := parseRecurrenceDates(params, value, loc)
+			if err != nil {
+				return nil, err
+			}
+			if strings.EqualFold(name, "RDATE") {
+				set.rdates = append(set.rdates, dates...)
+				continue
+			}
+			for _, date := range dates {
+				set.exdates[date.Unix()] = struct{}{}
+			}
+		}
+	}
+	if set.rule == nil && len(set.rdates) == 0 {
+		return nil, errors.New("recurrence has no RRULE or RDATE")
+	}
+	return set, nil
+}
+
+func parseRRule(value string, loc *time.Location) (*recurrenceRule, error) {
+	rule := &recurrenceRule{interval: 1, weekStart: time.Monday}
+	for _, part := range strings.Split(value, ";") {
+		key, val, ok := strings.Cut(part, "=")
+		if !ok {
+			continue
+		}
+		val = strings.ToUpper(strings.TrimSpace(val))
+		switch strings.ToUpper(strings.TrimSpace(key)) {
+		case "FREQ":
+			rule.freq = val
+		case "INTERVAL":
+			n, err := strconv.Atoi(val)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("invalid INTERVAL %q", val)
+			}
+			rule.interval = n
+		case "COUNT":
+			n, err := strconv.Atoi(val)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("invalid COUNT %q", val)
+			}
+			rule.count = n
+		case "UNTIL":
+			until, err := parseICalTime(val, loc)
+			if err != nil {
+				return nil, fmt.Errorf("invalid UNTIL %q: %w", val, err)
+			}
+			if len(val) == len("20060102") {
+				// A date-only UNTIL includes the whole day.
+				until = until.AddDate(0, 0, 1).Add(-time.Second)
+			}
+			rule.until = until
+		case "BYDAY":
+			for _, item := range strings.Split(val, ",") {
+				day, err := parseWeekdayNum(item)
+				if err != nil {
+					return nil, err
+				}
+				rule.byDay = append(rule.byDay, day)
+			}
+		case "BYMONTHDAY":
+			for _, item := range strings.Split(val, ",") {
+				n, err := strconv.Atoi(item)
+				if err != nil || n == 0 || n < -31 || n > 31 {
+					return nil, fmt.Errorf("invalid BYMONTHDAY %q", item)
+				}
+				rule.byMonthDay = append(rule.byMonthDay, n)
+			}
+		case "BYMONTH":
+			for _, item := range strings.Split(val, ",") {
+				n, err := strconv.Atoi(item)
+				if err != nil || n < 1 || n > 12 {
+					return nil, fmt.Errorf("invalid BYMONTH %q", item)
+				}
+				rule.byMonth = append(rule.byMonth, time.Month(n))
+			}
+		case "WKST":
+			day, ok := rruleWeekdays[val]
+			if !ok {
+				return nil, fmt.Errorf("invalid WKST %q", val)
+			}
+			rule.weekStart = day
+		}
+	}
+	switch rule.freq {
+	case "DAILY", "WEEKLY", "MONTHLY", "YEARLY":
+	default:
+		return nil, fmt.Errorf("unsupported FREQ %q", rule.freq)
+	}
+	return rule, nil
+}
+
+func parseWeekdayNum(value string) (weekdayNum, error) {
+	value = strings.TrimSpace(value)
+	if len(value) < 2 {
+		return weekdayNum{}, fmt.Errorf("invalid BYDAY %q", value)
+	}
+	day, ok := rruleWeekdays[value[len(value)-2:]]
+	if !ok {
+		return weekdayNum{}, fmt.Errorf("invalid BYDAY %q", value)
+	}
+	ordinal := 0
+	if prefix := value[:len(value)-2]; prefix != "" {
+		n, err := strconv.Atoi(prefix)
+		if err != nil || n == 0 {
+			return weekdayNum{}, fmt.Errorf("invalid BYDAY %q", value)
+		}
+		ordinal = n
+	}
+	return weekdayNum{ordinal: ordinal, day: day}, nil
+}
+
+// parseRecurrenceDates parses the value of an RDATE/EXDATE line, honouring a TZID parameter.
+func parseRecurrenceDates(params, value string, loc *time.Location) ([]time.Time, error) {
+	for _, param := range strings.Split(params, ";") {
+		if key, val, ok := strings.Cut(param, "="); ok && strings.EqualFold(key, "TZID") {
+			tz, err := time.LoadLocation(val)
+			if err != nil {
+				return nil, fmt.Errorf("unknown TZID %q: %w", val, err)
+			}
+			loc = tz
+		}
+	}
+	var dates []time.Time
+	for _, item := range strings.Split(value, ",") {
+		date, err := parseICalTime(strings.TrimSpace(item), loc)
+		if err != nil {
+			return nil, err
+		}
+		dates = append(dates, date)
+	}
+	return dates, nil
+}
+
+func parseICalTime(value string, loc *time.Location) (time.Time, error) {
+	switch {
+	case strings.HasSuffix(value, "Z"):
+		return time.Parse("20060102T150405Z", value)
+	case strings.Contains(value, "T"):
+		return time.ParseInLocation("20060102T150405", value, loc)
+	default:
+		return time.ParseInLocation("20060102", value, loc)
+	}
+}
+
+// between expands the set and returns occurrences starting in [from, to).
+func (s *recurrenceSet) between(dtstart, from, to time.Time) []time.Time {
+	seen := make(map[int64]struct{})
+	var result []time.Time
+	add := func(t time.Time) {
+		if t.Before(from) || !t.Before(to) {
+			return
+		}
+		if _, excluded := s.exdates[t.Unix()]; excluded {
+			return
+		}
+		if _, dup := seen[t.Unix()]; dup {
+			return
+		}
+		seen[t.Unix()] = struct{}{}
+		result = append(result, t)
+	}
+
+	if s.rule != nil {
+		s.rule.expand(dtstart, from, to, add)
+	} else {
+		add(dtstart)
+	}
+	for _, rdate := range s.rdates {
+		add(rdate)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Before(result[j]) })
+	return result
+}
+
+// expand walks the rule period by period, calling emit for every occurrence
+// from dtstart until COUNT, UNTIL or the end of the window is reached.
+func (r *recurrenceRule) expand(dtstart, windowStart, windowEnd time.Time, emit func(time.Time)) {
+	emitted := 0
+	first := 0
+	if r.count == 0 {
+		// Without COUNT nothing before the window matters, so skip straight to it.
+		first = r.periodsBefore(dtstart, windowStart)
+	}
+	for period := first; period < first+maxRecurrencePeriods; period++ {
+		candidates := r.periodCandidates(dtstart, period*r.interval)
+		for _, candidate := range candidates {
+			if candidate.Before(dtstart) {
+				continue
+			}
+			if !r.until.IsZero() && candidate.After(r.until) {
+				return
+			}
+			if !candidate.Before(windowEnd) {
+				return
+			}
+			emit(candidate)
+			emitted++
+			if r.count > 0 && emitted >= r.count {
+				return
+			}
+		}
+	}
+}
+
+// periodsBefore returns how many whole periods lie between dtstart and t, less one for safety.
+func (r *recurrenceRule) periodsBefore(dtstart, t time.Time) int {
+	if !t.After(dtstart) {
+		return 0
+	}
+	var units int
+	switch r.freq {
+	case "DAILY":
+		units = int(t.Sub(dtstart).Hours() / 24)
+	case "WEEKLY":
+		units = int(t.Sub(dtstart).Hours() / (24 * 7))
+	case "MONTHLY":
+		units = (t.Year()-dtstart.Year())*12 + int(t.Month()-dtstart.Month())
+	case "YEARLY":
+		units = t.Year() - dtstart.Year()
+	}
+	periods := units/r.interval - 1
+	if periods < 0 {
+		return 0
+	}
+	return periods
+}
+
+// periodCandidates returns the sorted occurrences inside the period that is
+// offset periods after the one containing dtstart.
+func (r *recurrenceRule) periodCandidates(dtstart time.Time, offset int) []time.Time {
+	loc := dtstart.Location()
+	hour, minute, sec := dtstart.Clock()
+	at := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, hour, minute, sec, 0, loc)
+	}
+
+	var days []time.Time
+	switch r.freq {
+	case "DAILY":
+		day := dtstart.AddDate(0, 0, offset)
+		day = at(day.Year(), day.Month(), day.Day())
+		if r.matchesMonth(day.Month()) && r.matchesMonthDay(day) && r.matchesWeekday(day) {
+			days = append(days, day)
+		}
+	case "WEEKLY":
+		shift := (int(dtstart.Weekday()) - int(r.weekStart) + 7) % 7
+		weekStart := dtstart.AddDate(0, 0, offset*7-shift)
+		weekdays := r.byDay
+		if len(weekdays) == 0 {
+			weekdays = []weekdayNum{{day: dtstart.Weekday()}}
+		}
+		for i := 0; i < 7; i++ {
+			day := weekStart.AddDate(0, 0, i)
+			day = at(day.Year(), day.Month(), day.Day())
+			for _, wd := range weekdays {
+				if wd.day == day.Weekday() && r.matchesMonth(day.Month()) {
+					days = append(days, day)
+					break
+				}
+			}
+		}
+	case "MONTHLY":
+		first := time.Date(dtstart.Year(), dtstart.Month(), 1, 0, 0, 0, 0, loc).AddDate(0, offset, 0)
+		if r.matchesMonth(first.Month()) {
+			days = r.monthCandidates(first.Year(), first.Month(), dtstart, at)
+		}
+	case "YEARLY":
+		year := dtstart.Year() + offset
+		months := r.byMonth
+		if len(months) == 0 {
+			months = []time.Month{dtstart.Month()}
+		}
+		for _, month := range months {
+			days = append(days, r.monthCandidates(year, month, dtstart, at)...)
+		}
+	}
+	sort.Slice(days, func(i, j int) bool { return days[i].Before(days[j]) })
+	return days
+}
+
+// monthCandidates applies BYMONTHDAY/BYDAY within one month, defaulting to
+// dtstart's day of month (months without that day are skipped, as in RFC 5545).
+func (r *recurrenceRule) monthCandidates(year int, month time.Month, dtstart time.Time, at func(int, time.Month, int) time.Time) []time.Time {
+	lastDay := time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day()
+	var days []time.Time
+	switch {
+	case len(r.byMonthDay) > 0:
+		for _, n := range r.byMonthDay {
+			day := n
+			if n < 0 {
+				day = lastDay + n + 1
+			}
+			if day < 1 || day > lastDay {
+				continue
+			}
+			candidate := at(year, month, day)
+			if r.matchesWeekday(candidate) {
+				days = append(days, candidate)
+			}
+		}
+	case len(r.byDay) > 0:
+		for _, wd := range r.byDay {
+			var matches []int
+			for day := 1; day <= lastDay; day++ {
+				if time.Date(year, month, day, 0, 0, 0, 0, time.UTC).Weekday() == wd.day {
+					matches = append(matches, day)
+				}
+			}
+			switch {
+			case wd.ordinal == 0:
+				for _, day := range matches {
+					days = append(days, at(year, month, day))
+				}
+			case wd.ordinal > 0 && wd.ordinal <= len(matches):
+				days = append(days, at(year, month, matches[wd.ordinal-1]))
+			case wd.ordinal < 0 && -wd.ordinal <= len(matches):
+				days = append(days, at(year, month, matches[len(matches)+wd.ordinal]))
+			}
+		}
+	default:
+		if dtstart.Day() <= lastDay {
+			days = append(days, at(year, month, dtstart.Day()))
+		}
+	}
+	return days
+}
+
+func (r *recurrenceRule) matchesMonth(month time.Month) bool {
+	if len(r.byMonth) == 0 {
+		return true
+	}
+	for _, m := range r.byMonth {
+		if m == month {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *recurrenceRule) matchesMonthDay(day time.Time) bool {
+	if len(r.byMonthDay) == 0 {
+		return true
+	}
+	lastDay := time.Date(day.Year(), day.Month()+1, 0, 0, 0, 0, 0, time.UTC).Day()
+	for _, n := range r.byMonthDay {
+		if n == day.Day() || (n < 0 && lastDay+n+1 == day.Day()) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesWeekday filters by BYDAY for frequencies where it limits rather than expands.
+func (r *recurrenceRule) matchesWeekday(day time.Time) bool {
+	if len(r.byDay) == 0 || (r.freq != "DAILY" && len(r.byMonthDay) == 0) {
+		return true
+	}
+	for _, wd := range r.byDay {
+		if wd.day == day.Weekday() {
+			return true
+		}
+	}
+	return false
+}
+
+// instanceEventID builds the ID Google assigns to one occurrence of a series,
+// so generated instances line up with instances Google reports individually.
+func instanceEventID(masterID string, originalStart time.Time, allDay bool) string {
+	if allDay {
+		return masterID + "_" + originalStart.Format("20060102")
+	}
+	return masterID + "_" + originalStart.UTC().Format("20060102T150405Z")
+}
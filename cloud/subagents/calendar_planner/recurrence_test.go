@@ -0,0 +1,86 @@
+package calendar_planner
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func formatStarts(starts []time.Time) []string {
+	out := make([]string, 0, len(starts))
+	for _, start := range starts {
+		out = append(out, start.Format(time.RFC3339))
+	}
+	return out
+}
+
+func TestExpandRecurrenceWeeklyWithExdate(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+	dtstart := time.Date(2024, 3, 4, 9, 30, 0, 0, ny) // Monday, the week before DST starts
+	starts, err := ExpandRecurrence([]string{
+		"RRULE:FREQ=WEEKLY;BYDAY=MO,WE;UNTIL=20240320T235959Z",
+		"EXDATE;TZID=America/New_York:20240306T093000",
+	}, dtstart, ny, dtstart, dtstart.AddDate(0, 1, 0))
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"2024-03-04T09:30:00-05:00",
+		"2024-03-11T09:30:00-04:00", // wall-clock time survives the DST change
+		"2024-03-13T09:30:00-04:00",
+		"2024-03-18T09:30:00-04:00",
+		"2024-03-20T09:30:00-04:00",
+	}, formatStarts(starts))
+}
+
+func TestExpandRecurrenceMonthlyAndCount(t *testing.T) {
+	dtstart := time.Date(2024, 1, 26, 16, 0, 0, 0, time.UTC)
+	lastFriday, err := ExpandRecurrence([]string{"RRULE:FREQ=MONTHLY;BYDAY=-1FR;COUNT=3"}, dtstart, time.UTC, dtstart, dtstart.AddDate(1, 0, 0))
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"2024-01-26T16:00:00Z",
+		"2024-02-23T16:00:00Z",
+		"2024-03-29T16:00:00Z",
+	}, formatStarts(lastFriday))
+
+	// Months without a 31st are skipped rather than clamped.
+	dtstart = time.Date(2024, 1, 31, 8, 0, 0, 0, time.UTC)
+	monthEnd, err := ExpandRecurrence([]string{"RRULE:FREQ=MONTHLY"}, dtstart, time.UTC, dtstart, time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"2024-01-31T08:00:00Z",
+		"2024-03-31T08:00:00Z",
+		"2024-05-31T08:00:00Z",
+	}, formatStarts(monthEnd))
+}
+
+func TestExpandRecurrenceWindowSkipsAhead(t *testing.T) {
+	// A daily series started decades ago still expands inside a recent window.
+	dtstart := time.Date(1990, 1, 1, 7, 0, 0, 0, time.UTC)
+	from := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	starts, err := ExpandRecurrence([]string{"RRULE:FREQ=DAILY;INTERVAL=2"}, dtstart, time.UTC, from, from.AddDate(0, 0, 5))
+	require.NoError(t, err)
+	require.Len(t, starts, 2)
+	for _, start := range starts {
+		require.Zero(t, int(start.Sub(dtstart).Hours()/24)%2)
+	}
+
+	_, err = ExpandRecurrence([]string{"RRULE:FREQ=HOURLY"}, dtstart, time.UTC, from, from.AddDate(0, 0, 1))
+	require.Error(t, err)
+}
+
+func TestNextOccurrence(t *testing.T) {
+	dtstart := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC) // Monday
+	start, end, ok := NextOccurrence([]string{"RRULE:FREQ=WEEKLY"}, dtstart, dtstart.Add(30*time.Minute), time.UTC, time.Date(2024, 2, 7, 12, 0, 0, 0, time.UTC))
+	require.True(t, ok)
+	require.Equal(t, time.Date(2024, 2, 12, 10, 0, 0, 0, time.UTC), start)
+	require.Equal(t, time.Date(2024, 2, 12, 10, 30, 0, 0, time.UTC), end)
+}
+
+func TestInstanceEventIDRoundTrip(t *testing.T) {
+	start := time.Date(2024, 2, 5, 10, 0, 0, 0, time.UTC)
+	id := instanceEventID("standup", start, false)
+	require.Equal(t, "standup_20240205T100000Z", id)
+	require.Equal(t, start, instanceOriginalStart(id))
+	require.Equal(t, "holiday_20240205", instanceEventID("holiday", start, true))
+}
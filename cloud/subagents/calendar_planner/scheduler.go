@@ -0,0 +1,476 @@
+package calendar_planner
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	defaultWorkdayStart  = 9 * time.Hour
+	defaultWorkdayEnd    = 18 * time.Hour
+	defaultMinGap        = 10 * time.Minute
+	defaultSlotStep      = 15 * time.Minute
+	defaultSearchDays    = 5
+	defaultMaxCandidates = 3
+)
+
+// SchedulingConstraints bound where the scheduler may move an event.
+type SchedulingConstraints struct {
+	// WorkdayStart and WorkdayEnd are offsets from local midnight.
+	WorkdayStart time.Duration
+	WorkdayEnd   time.Duration
+	WorkingDays  []time.Weekday
+	// Location is the user's zone; each event's own zone is used when nil.
+	Location *time.Location
+	// MinGap is the free time required between a moved event and its
+	// neighbours; zero means the default and a negative value means none.
+	MinGap time.Duration
+	// SlotStep is the granularity of candidate start times.
+	SlotStep time.Duration
+	// SearchDays is how many days, starting with the conflict's day, are searched.
+	SearchDays    int
+	MaxCandidates int
+	// Priorities overrides event priorities by event ID. Higher priorities are
+	// costlier to move; by default an event's priority is its guest count.
+	Priorities map[string]int
+}
+
+// ScheduleCandidate is one way to resolve a conflict: move the target event
+// into a slot that overlaps nothing else.
+type ScheduleCandidate struct {
+	Target       string    `json:"target"`
+	EventID      string    `json:"event_id"`
+	Summary      string    `json:"summary"`
+	Start        time.Time `json:"start"`
+	End          time.Time `json:"end"`
+	ShiftMinutes int       `json:"shift_minutes"`
+	Cost         int       `json:"cost"`
+	Explanation  string    `json:"explanation,omitempty"`
+}
+
+// ValidationReport records whether a plan's changes keep the calendar consistent.
+type ValidationReport struct {
+	Valid     bool              `json:"valid"`
+	Checks    []ValidationCheck `json:"checks"`
+	CheckedAt time.Time         `json:"checked_at"`
+}
+
+// ValidationCheck is one rule a plan was checked against.
+type ValidationCheck struct {
+	Name   string `json:"name"`
+	Passed bool   `json:"passed"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// Validation check names.
+const (
+	CheckPlan             = "plan"
+	CheckResolvesConflict = "resolves_conflict"
+	CheckNoNewOverlaps    = "no_new_overlaps"
+	CheckMinGap           = "min_gap"
+	CheckWorkingHours     = "working_hours"
+	CheckFixedEvents      = "fixed_events"
+	CheckFuture           = "future"
+)
+
+// Scheduler resolves conflicts deterministically by searching the shadow
+// calendar for free slots, and validates plans from any source against the
+// same rules.
+type Scheduler struct {
+	constraints SchedulingConstraints
+}
+
+// NewScheduler fills unset constraints with defaults: weekdays 09:00-18:00,
+// a 10 minute gap, 15 minute slots, five days of search and three candidates.
+func NewScheduler(constraints SchedulingConstraints) *Scheduler {
+	if constraints.WorkdayEnd <= 0 {
+		constraints.WorkdayEnd = defaultWorkdayEnd
+	}
+	if constraints.WorkdayStart <= 0 || constraints.WorkdayStart >= constraints.WorkdayEnd {
+		constraints.WorkdayStart = defaultWorkdayStart
+	}
+	if len(constraints.WorkingDays) == 0 {
+		constraints.WorkingDays = []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday}
+	}
+	switch {
+	case constraints.MinGap == 0:
+		constraints.MinGap = defaultMinGap
+	case constraints.MinGap < 0:
+		constraints.MinGap = 0
+	}
+	if constraints.SlotStep <= 0 {
+		constraints.SlotStep = defaultSlotStep
+	}
+	if constraints.SearchDays <= 0 {
+		constraints.SearchDays = defaultSearchDays
+	}
+	if constraints.MaxCandidates <= 0 {
+		constraints.MaxCandidates = defaultMaxCandidates
+	}
+	return &Scheduler{constraints: constraints}
+}
+
+// ParseClock parses a wall-clock time such as "09:00" into an offset from midnight.
+func ParseClock(raw string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(raw))
+	if err != nil {
+		return 0, fmt.Errorf("invalid clock time %q: %w", raw, err)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// Window is the time range whose busy events Solve needs for the conflict.
+func (s *Scheduler) Window(a, b *ShadowEvent) (time.Time, time.Time) {
+	from := a.StartTime
+	if b.StartTime.Before(from) {
+		from = b.StartTime
+	}
+	from = time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, from.Location()).Add(-24 * time.Hour)
+	return from, from.AddDate(0, 0, s.constraints.SearchDays+2)
+}
+
+// Solve returns up to MaxCandidates reschedulings of a or b, cheapest first.
+// busy holds the events that block time around the conflict. Every candidate
+// lies in working hours after now, keeps MinGap to every busy event and never
+// moves a fixed event, so applying it cannot create a new overlap.
+func (s *Scheduler) Solve(a, b *ShadowEvent, busy []*ShadowEvent, now time.Time) []ScheduleCandidate {
+	if a.AllDay || b.AllDay {
+		return nil
+	}
+	var candidates []ScheduleCandidate
+	for _, mover := range []struct {
+		evt    *ShadowEvent
+		target string
+	}{{a, TargetPrimary}, {b, TargetConflicting}} {
+		if isFixedEvent(mover.evt) {
+			continue
+		}
+		candidates = append(candidates, s.slotsFor(mover.evt, mover.target, busy, now)...)
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].Cost != candidates[j].Cost {
+			return candidates[i].Cost < candidates[j].Cost
+		}
+		return candidates[i].Start.Before(candidates[j].Start)
+	})
+	if len(candidates) > s.constraints.MaxCandidates {
+		candidates = candidates[:s.constraints.MaxCandidates]
+	}
+	return candidates
+}
+
+func (s *Scheduler) slotsFor(evt *ShadowEvent, target string, busy []*ShadowEvent, now time.Time) []ScheduleCandidate {
+	c := s.constraints
+	duration := evt.EndTime.Sub(evt.StartTime)
+	loc := s.location(evt)
+	original := evt.StartTime.In(loc)
+	firstDay := time.Date(original.Year(), original.Month(), original.Day(), 0, 0, 0, 0, loc)
+	weight := 1 + s.priority(evt)
+
+	var slots []ScheduleCandidate
+	for d := 0; d < c.SearchDays; d++ {
+		day := firstDay.AddDate(0, 0, d)
+		if !s.isWorkingDay(day.Weekday()) {
+			continue
+		}
+		for start := day.Add(c.WorkdayStart); !start.Add(duration).After(day.Add(c.WorkdayEnd)); start = start.Add(c.SlotStep) {
+			end := start.Add(duration)
+			if start.Equal(evt.StartTime) || !start.After(now) {
+				continue
+			}
+			if s.blockerFor(evt.EventID, start, end, busy) != nil {
+				continue
+			}
+			shift := int(start.Sub(evt.StartTime) / time.Minute)
+			slots = append(slots, ScheduleCandidate{
+				Target:       target,
+				EventID:      evt.EventID,
+				Summary:      evt.Summary,
+				Start:        start,
+				End:          end,
+				ShiftMinutes: shift,
+				Cost:         absInt(shift) * weight,
+			})
+		}
+	}
+	return slots
+}
+
+// blockerFor returns the first busy event, other than eventID, that [start, end)
+// overlaps or comes closer to than MinGap.
+func (s *Scheduler) blockerFor(eventID string, start, end time.Time, busy []*ShadowEvent) *ShadowEvent {
+	gap := s.constraints.MinGap
+	for _, other := range busy {
+		if other.EventID == eventID {
+			continue
+		}
+		if start.Before(other.EndTime.Add(gap)) && other.StartTime.Before(end.Add(gap)) {
+			return other
+		}
+	}
+	return nil
+}
+
+// Validate applies ops to a copy of the calendar and checks the result: the
+// conflict between a and b is gone, changed events overlap nothing and keep
+// MinGap, fall in working hours and in the future, and no fixed event moved.
+func (s *Scheduler) Validate(a, b *ShadowEvent, ops []ProposalOperation, busy []*ShadowEvent, now time.Time) *ValidationReport {
+	calendar := make(map[string]*ShadowEvent, len(busy)+2)
+	for _, evt := range append([]*ShadowEvent{a, b}, busy...) {
+		if _, ok := calendar[evt.EventID]; !ok {
+			copy := *evt
+			calendar[evt.EventID] = &copy
+		}
+	}
+
+	var planErrs, fixedErrs []string
+	var changed []*ShadowEvent
+	for i, op := range ops {
+		if op.Action == OperationPatch || op.Action == OperationDelete {
+			current := calendar[op.EventID]
+			if current == nil {
+				planErrs = append(planErrs, fmt.Sprintf("%s targets unknown event %s", op.Action, op.EventID))
+				continue
+			}
+			if isFixedEvent(current) {
+				fixedErrs = append(fixedErrs, fmt.Sprintf("%s would %s a fixed event", current.Summary, op.Action))
+			}
+			if op.Action == OperationDelete {
+				delete(calendar, op.EventID)
+				continue
+			}
+		}
+		start, end, err := operationTimes(op)
+		if err != nil {
+			planErrs = append(planErrs, err.Error())
+			continue
+		}
+		var evt ShadowEvent
+		if op.Action == OperationPatch {
+			evt = *calendar[op.EventID]
+		} else {
+			evt = ShadowEvent{EventID: fmt.Sprintf("new-%d", i), Summary: op.Event.Summary}
+		}
+		evt.StartTime, evt.EndTime, evt.AllDay = start, end, false
+		calendar[evt.EventID] = &evt
+		changed = append(changed, &evt)
+	}
+
+	var overlapErrs, gapErrs, hoursErrs, pastErrs []string
+	seen := make(map[string]bool)
+	for _, evt := range changed {
+		for _, other := range calendar {
+			if other.EventID == evt.EventID {
+				continue
+			}
+			pair := buildConflictKey(evt.EventID, other.EventID)
+			if seen[pair] {
+				continue
+			}
+			switch {
+			case eventsOverlap(evt, other):
+				seen[pair] = true
+				overlapErrs = append(overlapErrs, fmt.Sprintf("%s overlaps %s", evt.Summary, other.Summary))
+			case !other.AllDay && s.blockerFor(evt.EventID, evt.StartTime, evt.EndTime, []*ShadowEvent{other}) != nil:
+				seen[pair] = true
+				gapErrs = append(gapErrs, fmt.Sprintf("%s is within %s of %s", evt.Summary, s.constraints.MinGap, other.Summary))
+			}
+		}
+		if !s.inWorkingHours(evt) {
+			hoursErrs = append(hoursErrs, fmt.Sprintf("%s at %s is outside working hours", evt.Summary, evt.StartTime.In(s.location(evt)).Format("Mon 15:04")))
+		}
+		if !evt.StartTime.After(now) {
+			pastErrs = append(pastErrs, fmt.Sprintf("%s starts in the past", evt.Summary))
+		}
+	}
+
+	var resolveErrs []string
+	if len(ops) == 0 {
+		resolveErrs = append(resolveErrs, "plan makes no changes")
+	} else if finalA, finalB := calendar[a.EventID], calendar[b.EventID]; finalA != nil && finalB != nil && eventsOverlap(finalA, finalB) {
+		resolveErrs = append(resolveErrs, fmt.Sprintf("%s still overlaps %s", finalA.Summary, finalB.Summary))
+	}
+
+	report := &ValidationReport{Valid: true, CheckedAt: now}
+	report.add(CheckPlan, planErrs)
+	report.add(CheckResolvesConflict, resolveErrs)
+	report.add(CheckNoNewOverlaps, overlapErrs)
+	report.add(CheckMinGap, gapErrs)
+	report.add(CheckWorkingHours, hoursErrs)
+	report.add(CheckFixedEvents, fixedErrs)
+	report.add(CheckFuture, pastErrs)
+	return report
+}
+
+func (r *ValidationReport) add(name string, failures []string) {
+	check := ValidationCheck{Name: name, Passed: len(failures) == 0}
+	if !check.Passed {
+		sort.Strings(failures)
+		check.Detail = strings.Join(failures, "; ")
+		r.Valid = false
+	}
+	r.Checks = append(r.Checks, check)
+}
+
+func operationTimes(op ProposalOperation) (time.Time, time.Time, error) {
+	if op.Event == nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("%s has no event times", op.Action)
+	}
+	start, err := time.Parse(time.RFC3339, op.Event.Start.DateTime)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid start time for %s: %w", op.Event.Summary, err)
+	}
+	end, err := time.Parse(time.RFC3339, op.Event.End.DateTime)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid end time for %s: %w", op.Event.Summary, err)
+	}
+	return start, end, nil
+}
+
+func (s *Scheduler) inWorkingHours(evt *ShadowEvent) bool {
+	start := evt.StartTime.In(s.location(evt))
+	day := time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, start.Location())
+	return s.isWorkingDay(day.Weekday()) &&
+		!start.Before(day.Add(s.constraints.WorkdayStart)) &&
+		!evt.EndTime.After(day.Add(s.constraints.WorkdayEnd))
+}
+
+func (s *Scheduler) isWorkingDay(day time.Weekday) bool {
+	for _, d := range s.constraints.WorkingDays {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+// location is the zone working hours are measured in for evt.
+func (s *Scheduler) location(evt *ShadowEvent) *time.Location {
+	if s.constraints.Location != nil {
+		return s.constraints.Location
+	}
+	if evt.StartTimezone != "" {
+		if loc, err := time.LoadLocation(evt.StartTimezone); err == nil {
+			return loc
+		}
+	}
+	return evt.StartTime.Location()
+}
+
+func (s *Scheduler) priority(evt *ShadowEvent) int {
+	if p, ok := s.constraints.Priorities[evt.EventID]; ok {
+		return p
+	}
+	guests := 0
+	for _, attendee := range evt.Attendees {
+		if !attendee.Self {
+			guests++
+		}
+	}
+	return guests
+}
+
+// isFixedEvent reports whether moving evt is someone else's call: it was
+// organized by another person or invites people outside the user's domain.
+func isFixedEvent(evt *ShadowEvent) bool {
+	self := evt.CreatorEmail
+	for _, attendee := range evt.Attendees {
+		if attendee.Self {
+			self = attendee.Email
+		}
+	}
+	organizer := strings.ToLower(evt.OrganizerEmail)
+	// Events on shared calendars are organized by the calendar itself.
+	if organizer != "" && !strings.HasSuffix(organizer, "calendar.google.com") && !strings.EqualFold(organizer, self) {
+		return true
+	}
+	if len(evt.Attendees) > 0 && self == "" {
+		return true
+	}
+	for _, attendee := range evt.Attendees {
+		if !attendee.Self && !strings.EqualFold(emailDomain(attendee.Email), emailDomain(self)) {
+			return true
+		}
+	}
+	return false
+}
+
+func emailDomain(email string) string {
+	if at := strings.LastIndex(email, "@"); at >= 0 {
+		return email[at+1:]
+	}
+	return ""
+}
+
+func absInt(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// plan turns the candidate into a single-patch plan for evt.
+func (c ScheduleCandidate) plan(evt *ShadowEvent) (*CalendarPlan, error) {
+	note := c.Explanation
+	if note == "" {
+		note = fmt.Sprintf("Move %s to %s-%s", c.Summary, c.Start.Format("Mon Jan 2 15:04"), c.End.Format("15:04"))
+	}
+	plan, err := composePlan(c.Start.Format("2006-01-02"), []string{note}, []PlanBlock{{
+		Title:       evt.Summary,
+		Description: evt.Description,
+		Location:    evt.Location,
+		StartTime:   c.Start.Format(time.RFC3339),
+		EndTime:     c.End.Format(time.RFC3339),
+		Action:      OperationPatch,
+		Target:      c.Target,
+	}})
+	if err != nil {
+		return nil, err
+	}
+	if evt.StartTimezone != "" {
+		plan.Events[0].Start.TimeZone = evt.StartTimezone
+		plan.Events[0].End.TimeZone = evt.StartTimezone
+		if evt.EndTimezone != "" {
+			plan.Events[0].End.TimeZone = evt.EndTimezone
+		}
+	}
+	return plan, nil
+}
+
+// CandidateRanker is implemented by planners that can order the scheduler's
+// candidates and explain them. Rankers only reorder; they never add slots.
+type CandidateRanker interface {
+	RankCandidates(ctx context.Context, conflict string, candidates []ScheduleCandidate) ([]ScheduleCandidate, error)
+}
+
+// CandidateRank is a planner's opinion of one candidate, by index.
+type CandidateRank struct {
+	Index       int    `json:"index"`
+	Explanation string `json:"explanation"`
+}
+
+// orderCandidates reorders candidates by ranks, attaching explanations.
+// Unknown or repeated indexes are ignored and unranked candidates keep their
+// solver order after the ranked ones, so a ranking can never add a slot.
+func orderCandidates(candidates []ScheduleCandidate, ranks []CandidateRank) []ScheduleCandidate {
+	ordered := make([]ScheduleCandidate, 0, len(candidates))
+	used := make([]bool, len(candidates))
+	for _, rank := range ranks {
+		if rank.Index < 0 || rank.Index >= len(candidates) || used[rank.Index] {
+			continue
+		}
+		used[rank.Index] = true
+		candidate := candidates[rank.Index]
+		candidate.Explanation = strings.TrimSpace(rank.Explanation)
+		ordered = append(ordered, candidate)
+	}
+	for i, candidate := range candidates {
+		if !used[i] {
+			ordered = append(ordered, candidate)
+		}
+	}
+	return ordered
+}
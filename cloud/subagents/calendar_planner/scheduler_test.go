@@ -0,0 +1,124 @@
+package calendar_planner
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSchedulerSolvesAroundBusyAndFixedEvents(t *testing.T) {
+	day := time.Date(2030, 3, 4, 0, 0, 0, 0, time.UTC) // a Monday
+	at := func(hour, minute int) time.Time {
+		return day.Add(time.Duration(hour)*time.Hour + time.Duration(minute)*time.Minute)
+	}
+	attendees, err := parseAttendees(`[{"email":"me@acme.com","self":true},{"email":"Client@customer.com"},{"email":"room-1@resource.calendar.google.com","resource":true}]`)
+	require.NoError(t, err)
+	require.Len(t, attendees, 2)
+
+	customer := &ShadowEvent{EventID: "customer", Summary: "Customer call", StartTime: at(10, 0), EndTime: at(11, 0),
+		OrganizerEmail: "client@customer.com", Attendees: attendees}
+	oneOnOne := &ShadowEvent{EventID: "one-on-one", Summary: "1:1", StartTime: at(10, 30), EndTime: at(11, 0),
+		CreatorEmail: "me@acme.com", OrganizerEmail: "me@acme.com",
+		Attendees: []ShadowAttendee{{Email: "me@acme.com", Self: true}, {Email: "bob@acme.com"}}}
+	standup := &ShadowEvent{EventID: "standup", Summary: "Standup", StartTime: at(9, 0), EndTime: at(9, 30)}
+	lunch := &ShadowEvent{EventID: "lunch", Summary: "Lunch", StartTime: at(11, 0), EndTime: at(12, 0)}
+	busy := []*ShadowEvent{standup, customer, oneOnOne, lunch}
+	require.True(t, isFixedEvent(customer))
+	require.False(t, isFixedEvent(oneOnOne))
+
+	scheduler := NewScheduler(SchedulingConstraints{WorkdayStart: 9 * time.Hour, WorkdayEnd: 17 * time.Hour, MinGap: 15 * time.Minute, Location: time.UTC})
+	now := day.Add(-time.Hour)
+	candidates := scheduler.Solve(customer, oneOnOne, busy, now)
+	require.Len(t, candidates, 3)
+	require.Equal(t, at(12, 15), candidates[0].Start, "first slot after lunch plus the gap")
+	require.Equal(t, at(12, 45), candidates[0].End)
+	require.Equal(t, 105, candidates[0].ShiftMinutes)
+	require.Equal(t, 210, candidates[0].Cost, "one guest doubles the cost")
+
+	for _, candidate := range candidates {
+		require.Equal(t, TargetConflicting, candidate.Target, "the customer call is fixed")
+		proposal := &ShadowProposal{PrimaryEvent: summarizeEvent(customer), ConflictingEvent: summarizeEvent(oneOnOne)}
+		proposal.Plan, err = candidate.plan(oneOnOne)
+		require.NoError(t, err)
+		ops, err := proposal.PlannedOperations(TargetPrimary, 0, "")
+		require.NoError(t, err)
+		report := scheduler.Validate(customer, oneOnOne, ops, busy, now)
+		require.True(t, report.Valid, "%+v", report.Checks)
+	}
+
+	// Plans from elsewhere are held to the same rules.
+	bad := []ProposalOperation{
+		{Action: OperationPatch, EventID: "customer", Event: &GoogleCalendarEvent{Summary: "Customer call",
+			Start: GoogleCalendarTime{DateTime: at(11, 30).Format(time.RFC3339)}, End: GoogleCalendarTime{DateTime: at(12, 30).Format(time.RFC3339)}}},
+		{Action: OperationCreate, Event: &GoogleCalendarEvent{Summary: "Late prep",
+			Start: GoogleCalendarTime{DateTime: at(20, 0).Format(time.RFC3339)}, End: GoogleCalendarTime{DateTime: at(21, 0).Format(time.RFC3339)}}},
+	}
+	report := scheduler.Validate(customer, oneOnOne, bad, busy, now)
+	require.False(t, report.Valid)
+	failed := map[string]string{}
+	for _, check := range report.Checks {
+		if !check.Passed {
+			failed[check.Name] = check.Detail
+		}
+	}
+	require.Equal(t, "Customer call overlaps Lunch", failed[CheckNoNewOverlaps])
+	require.Contains(t, failed, CheckFixedEvents)
+	require.Contains(t, failed[CheckWorkingHours], "Late prep")
+	require.NotContains(t, failed, CheckResolvesConflict)
+	require.NotContains(t, failed, CheckFuture)
+}
+
+func TestOrderCandidatesOnlyReorders(t *testing.T) {
+	candidates := []ScheduleCandidate{{EventID: "a"}, {EventID: "b"}, {EventID: "c"}}
+	ordered := orderCandidates(candidates, []CandidateRank{{Index: 2, Explanation: " keeps the morning free "}, {Index: 2}, {Index: 7}})
+	require.Len(t, ordered, 3)
+	require.Equal(t, "c", ordered[0].EventID)
+	require.Equal(t, "keeps the morning free", ordered[0].Explanation)
+	require.Equal(t, "a", ordered[1].EventID)
+	require.Equal(t, "b", ordered[2].EventID)
+}
+
+type rankingPlanner struct {
+	stubPlanner
+	ranked int
+}
+
+func (r *rankingPlanner) RankCandidates(ctx context.Context, conflict string, candidates []ScheduleCandidate) ([]ScheduleCandidate, error) {
+	r.ranked++
+	return orderCandidates(candidates, []CandidateRank{{Index: len(candidates) - 1, Explanation: "latest slot is calmest"}}), nil
+}
+
+func TestEnsureProposalUsesSchedulerCandidates(t *testing.T) {
+	planner := &rankingPlanner{}
+	store := newMemoryShadowStore()
+	svc := &ShadowCalendarService{planner: planner, store: store, scheduler: NewScheduler(SchedulingConstraints{Location: time.UTC})}
+	ctx := context.Background()
+
+	day := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, 2)
+	for day.Weekday() != time.Tuesday {
+		day = day.AddDate(0, 0, 1)
+	}
+	eventA := &ShadowEvent{UserID: "user-1", EventID: "evt-a", Summary: "Call", StartTime: day.Add(10 * time.Hour), EndTime: day.Add(11 * time.Hour)}
+	eventB := &ShadowEvent{UserID: "user-1", EventID: "evt-b", Summary: "Review", StartTime: day.Add(10*time.Hour + 30*time.Minute), EndTime: day.Add(12 * time.Hour)}
+	require.NoError(t, store.UpsertEvent(ctx, eventA))
+	require.NoError(t, store.UpsertEvent(ctx, eventB))
+
+	require.NoError(t, svc.ensureProposal(ctx, "user-1", eventA, eventB))
+	require.Equal(t, 0, planner.calls, "the planner only ranks solver candidates")
+	require.Equal(t, 1, planner.ranked)
+
+	proposals, err := store.ListProposals(ctx, "user-1")
+	require.NoError(t, err)
+	require.Len(t, proposals, 1)
+	proposal := proposals[0]
+	require.Len(t, proposal.Candidates, 3)
+	require.Equal(t, "latest slot is calmest", proposal.Candidates[0].Explanation)
+	require.Equal(t, []string{"latest slot is calmest"}, proposal.Plan.Notes)
+	require.Len(t, proposal.Plan.Events, 1)
+	require.Equal(t, OperationPatch, proposal.Plan.Events[0].Action)
+	require.Equal(t, proposal.Candidates[0].Start.Format(time.RFC3339), proposal.Plan.Events[0].Start.DateTime)
+	require.NotNil(t, proposal.Validation)
+	require.True(t, proposal.Validation.Valid, "%+v", proposal.Validation.Checks)
+}
@@ -12,6 +12,8 @@ import (
 	"sync"
 	"time"
 
+	"alfred-cloud/streams"
+	"alfred-cloud/wb"
 	"github.com/google/uuid"
 	"github.com/redis/go-redis/v9"
 )
@@ -28,6 +30,19 @@ type ShadowCalendarOptions struct {
 	BatchSize   int64
 	PollTimeout time.Duration
 	Store       ShadowStore
+	Calendars   CalendarPreferences
+	// Bus receives a calendar.proposal.* entry for every proposal transition.
+	Bus *wb.Bus
+	// Scheduling bounds the slots the conflict scheduler may propose.
+	Scheduling SchedulingConstraints
+	// Retry bounds redelivery of deltas that fail to apply; see streams.RetryPolicy.
+	Retry streams.RetryPolicy
+	// RecurrenceHorizon is how far ahead recurring events are expanded into
+	// occurrences; RecurrenceRefresh is how often that window is rolled forward
+	// and events older than Retention are pruned.
+	RecurrenceHorizon time.Duration
+	RecurrenceRefresh time.Duration
+	Retention         time.Duration
 }
 
 // ShadowCalendarService keeps a per-user shadow calendar using Redis streams.
@@ -35,32 +50,57 @@ type ShadowCalendarService struct {
 	redisClient *redis.Client
 	planner     PlannerRunner
 	store       ShadowStore
-	groupName   string
-	userIDs     []string
-	batchSize   int64
-	pollTimeout time.Duration
-	consumerID  string
-	ctx         context.Context
-	cancel      context.CancelFunc
-	wg          sync.WaitGroup
+	calendars   CalendarPreferences
+	bus         *wb.Bus
+	scheduler   *Scheduler
+	consumer    *streams.Consumer
+	horizon     time.Duration
+	refresh     time.Duration
+	retention   time.Duration
+
+	mu     sync.Mutex
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
 }
 
+const (
+	calendarStreamFormat = "user:%s:in:calendar"
+	// DeadLetterAgent names the dead-letter stream, user:{id}:dlq:calendar.
+	DeadLetterAgent = "calendar"
+)
+
 const (
 	shadowDefaultGroup   = "calendar-shadow"
 	shadowDefaultBatch   = 32
 	shadowDefaultTimeout = 2 * time.Second
+	shadowDefaultHorizon = 30 * 24 * time.Hour
+	shadowDefaultRefresh = 6 * time.Hour
+	shadowDefaultRetain  = 30 * 24 * time.Hour
 )
 
-// ShadowStore persists shadow calendar state.
+// ShadowStore persists shadow calendar state. Events are identified by
+// calendar and event ID together: Google gives an invite the same event ID on
+// every calendar it appears on.
 type ShadowStore interface {
 	UpsertEvent(ctx context.Context, event *ShadowEvent) error
-	RemoveEvent(ctx context.Context, userID, eventID string) error
+	RemoveEvent(ctx context.Context, userID, calendarID, eventID string) error
 	ListEvents(ctx context.Context, userID string) ([]*ShadowEvent, error)
+	// ListEventsInRange returns the events, excluding series masters, that overlap [from, to).
+	ListEventsInRange(ctx context.Context, userID string, from, to time.Time) ([]*ShadowEvent, error)
+	// ListSeriesEvents returns the stored occurrences of one recurring series.
+	ListSeriesEvents(ctx context.Context, userID, calendarID, recurringEventID string) ([]*ShadowEvent, error)
+	// PruneEvents removes events that ended before the cutoff and returns them.
+	PruneEvents(ctx context.Context, userID string, before time.Time) ([]*ShadowEvent, error)
 	SaveProposal(ctx context.Context, proposal *ShadowProposal) error
 	GetProposal(ctx context.Context, userID, proposalID string) (*ShadowProposal, error)
 	ListProposals(ctx context.Context, userID string) ([]*ShadowProposal, error)
 	FindProposalByConflict(ctx context.Context, userID, conflictKey string) (*ShadowProposal, error)
-	RemoveProposalsForEvent(ctx context.Context, userID, eventID string) error
+	RemoveProposalsForEvent(ctx context.Context, userID, calendarID, eventID string) error
+	// ClaimProposal marks a proposal as being applied until it is released or
+	// ttl passes, and reports false when it is already claimed.
+	ClaimProposal(ctx context.Context, userID, proposalID string, ttl time.Duration) (bool, error)
+	ReleaseProposal(ctx context.Context, userID, proposalID string) error
 }
 
 // ShadowEvent captures the latest version of a calendar event.
@@ -77,6 +117,7 @@ type ShadowEvent struct {
 	EndTimezone    string    `json:"end_timezone,omitempty"`
 	AllDay         bool      `json:"all_day"`
 	Status         string    `json:"status"`
+	Transparency   string    `json:"transparency,omitempty"`
 	ChangeType     string    `json:"change_type"`
 	HTMLLink       string    `json:"html_link,omitempty"`
 	CreatorEmail   string    `json:"creator_email,omitempty"`
@@ -87,6 +128,25 @@ type ShadowEvent struct {
 	RawPayload     string    `json:"raw_payload,omitempty"`
 	ChannelID      string    `json:"channel_id,omitempty"`
 	RecordedAt     time.Time `json:"recorded_at"`
+
+	// Recurrence holds the RRULE/EXDATE lines of a series master. Masters are
+	// bookkeeping only; their occurrences are stored as separate events.
+	Recurrence []string `json:"recurrence,omitempty"`
+	// RecurringEventID and OriginalStartTime identify one occurrence of a series.
+	RecurringEventID  string    `json:"recurring_event_id,omitempty"`
+	OriginalStartTime time.Time `json:"original_start_time,omitempty"`
+	// Expanded marks occurrences generated from the master's RRULE, as opposed
+	// to instances (including moved or cancelled exceptions) reported by Google.
+	Expanded bool `json:"expanded,omitempty"`
+
+	// Attendees are the people invited, excluding rooms and other resources.
+	Attendees []ShadowAttendee `json:"attendees,omitempty"`
+}
+
+// ShadowAttendee is one guest of an event. Self marks the calendar owner.
+type ShadowAttendee struct {
+	Email string `json:"email"`
+	Self  bool   `json:"self,omitempty"`
 }
 
 // ShadowProposal captures a planner run that resolves a conflict between events.
@@ -99,18 +159,33 @@ type ShadowProposal struct {
 	Reason           string              `json:"reason"`
 	Plan             *CalendarPlan       `json:"plan"`
 	Status           string              `json:"status"`
-	CreatedAt        time.Time           `json:"created_at"`
-	UpdatedAt        time.Time           `json:"updated_at"`
+	StatusReason     string              `json:"status_reason,omitempty"`
+	// SupersededBy is the newer proposal that replaced this one for the same conflict.
+	SupersededBy string `json:"superseded_by,omitempty"`
+	// ExpiresAt is when the overlap ends; pending proposals expire after it.
+	ExpiresAt time.Time            `json:"expires_at,omitempty"`
+	History   []ProposalTransition `json:"history,omitempty"`
+	// Operations records the calendar changes made when the proposal was accepted.
+	Operations []ProposalOperation `json:"operations,omitempty"`
+	// Candidates are the scheduler's conflict-free reschedulings, best first;
+	// the plan applies the first one.
+	Candidates []ScheduleCandidate `json:"candidates,omitempty"`
+	// Validation is the plan checked against the shadow calendar when proposed.
+	Validation *ValidationReport `json:"validation,omitempty"`
+	CreatedAt  time.Time         `json:"created_at"`
+	UpdatedAt  time.Time         `json:"updated_at"`
 }
 
 // ShadowEventSummary is a trimmed down view of an event stored alongside proposals.
 type ShadowEventSummary struct {
-	EventID    string `json:"event_id"`
-	CalendarID string `json:"calendar_id,omitempty"`
-	Summary    string `json:"summary"`
-	StartISO   string `json:"start_iso"`
-	EndISO     string `json:"end_iso"`
-	Location   string `json:"location,omitempty"`
+	EventID          string `json:"event_id"`
+	CalendarID       string `json:"calendar_id,omitempty"`
+	Summary          string `json:"summary"`
+	StartISO         string `json:"start_iso"`
+	EndISO           string `json:"end_iso"`
+	Location         string `json:"location,omitempty"`
+	RecurringEventID string `json:"recurring_event_id,omitempty"`
+	OccurrenceDate   string `json:"occurrence_date,omitempty"`
 }
 
 // ShadowSnapshot is returned via HTTP for debugging/tests.
@@ -122,28 +197,36 @@ type ShadowSnapshot struct {
 
 // ShadowEventView is a DTO for API responses.
 type ShadowEventView struct {
-	EventID    string `json:"event_id"`
-	Summary    string `json:"summary"`
-	StartISO   string `json:"start_iso"`
-	EndISO     string `json:"end_iso"`
-	AllDay     bool   `json:"all_day"`
-	Status     string `json:"status"`
-	ChangeType string `json:"change_type"`
-	Location   string `json:"location,omitempty"`
+	EventID          string `json:"event_id"`
+	Summary          string `json:"summary"`
+	StartISO         string `json:"start_iso"`
+	EndISO           string `json:"end_iso"`
+	AllDay           bool   `json:"all_day"`
+	Status           string `json:"status"`
+	ChangeType       string `json:"change_type"`
+	Location         string `json:"location,omitempty"`
+	RecurringEventID string `json:"recurring_event_id,omitempty"`
 }
 
-// NewShadowCalendarService wires the service to Redis streams.
+// NewShadowCalendarService wires the service to Redis streams. planner may be
+// nil when no planner is configured; conflicts are then proposed with the
+// scheduler's slots only, or without a plan.
 func NewShadowCalendarService(redisClient *redis.Client, planner PlannerRunner, opts ShadowCalendarOptions) (*ShadowCalendarService, error) {
 	if redisClient == nil {
 		return nil, errors.New("redis client is required")
 	}
-	if planner == nil {
-		return nil, errors.New("planner runner is required")
-	}
 	store := opts.Store
 	if store == nil {
 		store = &redisShadowStore{client: redisClient}
 	}
+	calendars := opts.Calendars
+	if calendars == nil {
+		calendars = NewCalendarDirectory(redisClient)
+	}
+	bus := opts.Bus
+	if bus == nil {
+		bus = wb.NewBus(redisClient)
+	}
 	group := opts.GroupName
 	if group == "" {
 		group = shadowDefaultGroup
@@ -156,52 +239,101 @@ func NewShadowCalendarService(redisClient *redis.Client, planner PlannerRunner,
 	if timeout <= 0 {
 		timeout = shadowDefaultTimeout
 	}
-	userIDs := sanitizeUserIDs(opts.UserIDs)
-	if len(userIDs) == 0 {
-		return nil, errors.New("at least one user id required for shadow calendar")
+	horizon := opts.RecurrenceHorizon
+	if horizon <= 0 {
+		horizon = shadowDefaultHorizon
+	}
+	refresh := opts.RecurrenceRefresh
+	if refresh <= 0 {
+		refresh = shadowDefaultRefresh
+	}
+	retention := opts.Retention
+	if retention <= 0 {
+		retention = shadowDefaultRetain
 	}
-	return &ShadowCalendarService{
+	s := &ShadowCalendarService{
 		redisClient: redisClient,
 		planner:     planner,
 		store:       store,
-		groupName:   group,
-		userIDs:     userIDs,
-		batchSize:   batch,
-		pollTimeout: timeout,
-		consumerID:  uuid.New().String(),
-	}, nil
+		calendars:   calendars,
+		bus:         bus,
+		scheduler:   NewScheduler(opts.Scheduling),
+		horizon:     horizon,
+		refresh:     refresh,
+		retention:   retention,
+	}
+	s.consumer = streams.NewStreamsHelper(redisClient).NewConsumer(streams.ConsumerOptions{
+		Group:     group,
+		Agent:     DeadLetterAgent,
+		StartID:   "0",
+		Users:     sanitizeUserIDs(opts.UserIDs),
+		BatchSize: batch,
+		Block:     timeout,
+		Retry:     opts.Retry,
+	})
+	s.consumer.Handle(calendarStreamFormat, func(ctx context.Context, entry streams.Entry) error {
+		return s.processMessage(ctx, entry.UserID, entry.XMessage)
+	})
+	return s, nil
 }
 
-// Start launches consumers for each user's calendar input stream.
+// Start launches the consumer for the users' calendar input streams.
 func (s *ShadowCalendarService) Start(ctx context.Context) error {
-	if s.ctx != nil {
-		return errors.New("shadow calendar already started")
-	}
 	if ctx == nil {
 		ctx = context.Background()
 	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ctx != nil {
+		return errors.New("shadow calendar already started")
+	}
 	ctx, cancel := context.WithCancel(ctx)
 	s.ctx = ctx
 	s.cancel = cancel
-	for _, userID := range s.userIDs {
-		streamKey := userCalendarStream(userID)
-		if err := s.ensureGroup(ctx, streamKey); err != nil {
-			return err
+	s.wg.Add(2)
+	go func() {
+		defer s.wg.Done()
+		if err := s.consumer.Run(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("shadow calendar: consumer stopped: %v", err)
 		}
-		consumerName := fmt.Sprintf("%s-%s", s.consumerID, userID)
-		s.wg.Add(1)
-		go s.consumeLoop(ctx, userID, streamKey, consumerName)
-	}
+	}()
+	go s.maintenanceLoop(ctx)
 	return nil
 }
 
-// Stop stops background consumers.
+// SetUsers replaces the consumed users; the running consumer picks them up
+// on its next read.
+func (s *ShadowCalendarService) SetUsers(ctx context.Context, userIDs []string) {
+	s.consumer.SetUsers(ctx, sanitizeUserIDs(userIDs))
+}
+
+// UserIDs returns the users whose streams are consumed.
+func (s *ShadowCalendarService) UserIDs() []string {
+	if s.consumer == nil {
+		return nil
+	}
+	return s.consumer.Users()
+}
+
+// Metrics reports the consumer's delivery counters.
+func (s *ShadowCalendarService) Metrics() streams.ConsumerMetrics {
+	return s.consumer.Metrics()
+}
+
+// Stop drains the consumer and stops background work.
 func (s *ShadowCalendarService) Stop() {
+	if s.consumer != nil {
+		s.consumer.Stop()
+	}
+	s.mu.Lock()
 	if s.cancel != nil {
 		s.cancel()
 	}
+	s.mu.Unlock()
 	s.wg.Wait()
+	s.mu.Lock()
 	s.ctx = nil
+	s.mu.Unlock()
 }
 
 // GetSnapshot returns the stored events + proposals for debugging/tests.
@@ -219,15 +351,20 @@ func (s *ShadowCalendarService) GetSnapshot(ctx context.Context, userID string)
 	}
 	views := make([]ShadowEventView, 0, len(events))
 	for _, evt := range events {
+		// Series masters and cancelled occurrences are bookkeeping, not calendar entries.
+		if isSeriesMaster(evt) || evt.Status == "cancelled" {
+			continue
+		}
 		views = append(views, ShadowEventView{
-			EventID:    evt.EventID,
-			Summary:    evt.Summary,
-			StartISO:   evt.StartTime.Format(time.RFC3339),
-			EndISO:     evt.EndTime.Format(time.RFC3339),
-			AllDay:     evt.AllDay,
-			Status:     evt.Status,
-			ChangeType: evt.ChangeType,
-			Location:   evt.Location,
+			EventID:          evt.EventID,
+			Summary:          evt.Summary,
+			StartISO:         evt.StartTime.Format(time.RFC3339),
+			EndISO:           evt.EndTime.Format(time.RFC3339),
+			AllDay:           evt.AllDay,
+			Status:           evt.Status,
+			ChangeType:       evt.ChangeType,
+			Location:         evt.Location,
+			RecurringEventID: evt.RecurringEventID,
 		})
 	}
 	sort.SliceStable(proposals, func(i, j int) bool {
@@ -236,7 +373,7 @@ func (s *ShadowCalendarService) GetSnapshot(ctx context.Context, userID string)
 	return &ShadowSnapshot{UserID: userID, Events: views, Proposals: proposals}, nil
 }
 
-// GetProposal returns a stored proposal by ID.
+// GetProposal returns a stored proposal by ID, expiring it first if its conflict is over.
 func (s *ShadowCalendarService) GetProposal(ctx context.Context, userID, proposalID string) (*ShadowProposal, error) {
 	if s == nil || s.store == nil {
 		return nil, errors.New("shadow calendar store not configured")
@@ -249,7 +386,15 @@ func (s *ShadowCalendarService) GetProposal(ctx context.Context, userID, proposa
 	if proposalID == "" || userID == "" {
 		return nil, nil
 	}
-	return s.store.GetProposal(ctx, userID, proposalID)
+	proposal, err := s.store.GetProposal(ctx, userID, proposalID)
+	if err != nil || proposal == nil {
+		return proposal, err
+	}
+	if err := s.expireIfDue(ctx, proposal, time.Now().UTC()); err != nil {
+		return nil, err
+	}
+	proposal.Status = normalizeProposalStatus(proposal.Status)
+	return proposal, nil
 }
 
 // SaveProposal persists a proposal after it has been modified.
@@ -266,71 +411,29 @@ func (s *ShadowCalendarService) SaveProposal(ctx context.Context, proposal *Shad
 	return s.store.SaveProposal(ctx, proposal)
 }
 
-func (s *ShadowCalendarService) ensureGroup(ctx context.Context, streamKey string) error {
-	if err := s.redisClient.XGroupCreateMkStream(ctx, streamKey, s.groupName, "0").Err(); err != nil {
-		if !strings.Contains(err.Error(), "BUSYGROUP") {
-			return fmt.Errorf("shadow calendar: create group %s for %s: %w", s.groupName, streamKey, err)
-		}
-	}
-	return nil
-}
-
-func (s *ShadowCalendarService) consumeLoop(ctx context.Context, userID, streamKey, consumerName string) {
-	defer s.wg.Done()
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		default:
-		}
-		cmd := s.redisClient.XReadGroup(ctx, &redis.XReadGroupArgs{
-			Group:    s.groupName,
-			Consumer: consumerName,
-			Streams:  []string{streamKey, ">"},
-			Count:    s.batchSize,
-			Block:    s.pollTimeout,
-		})
-		streams, err := cmd.Result()
-		if err != nil {
-			if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
-				return
-			}
-			if err == redis.Nil {
-				continue
-			}
-			log.Printf("shadow calendar: read failure for %s: %v", userID, err)
-			time.Sleep(500 * time.Millisecond)
-			continue
-		}
-		for _, stream := range streams {
-			for _, msg := range stream.Messages {
-				if err := s.processMessage(ctx, userID, msg); err != nil {
-					log.Printf("shadow calendar: process error user=%s id=%s: %v", userID, msg.ID, err)
-				}
-				if err := s.redisClient.XAck(ctx, streamKey, s.groupName, msg.ID).Err(); err != nil {
-					log.Printf("shadow calendar: failed ack %s: %v", msg.ID, err)
-				}
-			}
-		}
-	}
-}
-
 func (s *ShadowCalendarService) processMessage(ctx context.Context, fallbackUserID string, msg redis.XMessage) error {
 	delta, err := parseCalendarDelta(msg.Values)
 	if err != nil {
-		return err
+		// A malformed delta fails the same way every time; don't retry it.
+		return streams.Permanent(err)
 	}
 	if delta.Event == nil {
-		return errors.New("shadow calendar: missing event payload")
+		return streams.Permanent(errors.New("shadow calendar: missing event payload"))
 	}
 	if delta.Event.UserID == "" {
 		delta.Event.UserID = fallbackUserID
 	}
-	if delta.Deleted {
-		if err := s.store.RemoveEvent(ctx, delta.Event.UserID, delta.Event.EventID); err != nil {
+	switch {
+	case delta.Event.RecurringEventID != "":
+		return s.applyInstance(ctx, delta.Event, delta.Deleted)
+	case delta.Deleted:
+		if err := s.removeEvent(ctx, delta.Event.UserID, delta.Event.CalendarID, delta.Event.EventID); err != nil {
 			return err
 		}
-		return s.store.RemoveProposalsForEvent(ctx, delta.Event.UserID, delta.Event.EventID)
+		// A deleted series master takes all of its occurrences with it.
+		return s.removeSeries(ctx, delta.Event.UserID, delta.Event.CalendarID, delta.Event.EventID)
+	case isSeriesMaster(delta.Event):
+		return s.expandSeries(ctx, delta.Event)
 	}
 	if err := s.store.UpsertEvent(ctx, delta.Event); err != nil {
 		return err
@@ -339,15 +442,27 @@ func (s *ShadowCalendarService) processMessage(ctx context.Context, fallbackUser
 }
 
 func (s *ShadowCalendarService) evaluateConflicts(ctx context.Context, userID string, event *ShadowEvent) error {
-	events, err := s.store.ListEvents(ctx, userID)
+	informational, err := s.informationalCalendars(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if !blocksTime(event, informational) {
+		return nil
+	}
+	// Widen by a second so all-day events that merely touch are still considered.
+	events, err := s.store.ListEventsInRange(ctx, userID, event.StartTime.Add(-time.Second), event.EndTime.Add(time.Second))
 	if err != nil {
 		return err
 	}
 	for _, existing := range events {
+		// The same invite on two calendars is one meeting, not a conflict.
 		if existing.EventID == event.EventID {
 			continue
 		}
-		if !eventsOverlap(event, existing) {
+		if event.RecurringEventID != "" && existing.RecurringEventID == event.RecurringEventID {
+			continue
+		}
+		if !blocksTime(existing, informational) || !eventsOverlap(event, existing) {
 			continue
 		}
 		if err := s.ensureProposal(ctx, userID, event, existing); err != nil {
@@ -357,12 +472,29 @@ func (s *ShadowCalendarService) evaluateConflicts(ctx context.Context, userID st
 	return nil
 }
 
+func (s *ShadowCalendarService) informationalCalendars(ctx context.Context, userID string) (map[string]bool, error) {
+	if s.calendars == nil {
+		return nil, nil
+	}
+	return s.calendars.InformationalCalendars(ctx, userID)
+}
+
+// blocksTime reports whether an event can take part in a conflict. Events on
+// informational calendars and events shown as free never do.
+func blocksTime(evt *ShadowEvent, informational map[string]bool) bool {
+	if evt.Transparency == "transparent" || evt.Status == "cancelled" || isSeriesMaster(evt) {
+		return false
+	}
+	return !informational[calendarOrPrimary(evt.CalendarID)]
+}
+
 func (s *ShadowCalendarService) ensureProposal(ctx context.Context, userID string, a, b *ShadowEvent) error {
-	conflictKey := buildConflictKey(a.EventID, b.EventID)
-	if proposal, _ := s.store.FindProposalByConflict(ctx, userID, conflictKey); proposal != nil {
+	conflictKey := buildConflictKey(a.ref(), b.ref())
+	previous, _ := s.store.FindProposalByConflict(ctx, userID, conflictKey)
+	if previous != nil && previous.sameConflict(a, b) {
+		// Already proposed, or the user rejected this exact overlap; don't ask again.
 		return nil
 	}
-	planDate := a.StartTime.Format("2006-01-02")
 	timeBlock := fmt.Sprintf("Resolve overlap: %s (%s-%s) vs %s (%s-%s)",
 		a.Summary,
 		a.StartTime.Format(time.Kitchen),
@@ -370,35 +502,179 @@ func (s *ShadowCalendarService) ensureProposal(ctx context.Context, userID strin
 		b.Summary,
 		b.StartTime.Format(time.Kitchen),
 		b.EndTime.Format(time.Kitchen))
-	plan, err := s.planner.GenerateCalendarPlan(ctx, planDate, timeBlock, "calendar_conflict")
+	plan, candidates, err := s.planConflict(ctx, userID, a, b, timeBlock)
 	if err != nil {
-		return fmt.Errorf("planner run failed: %w", err)
+		return err
 	}
 	now := time.Now().UTC()
+	expiresAt := a.EndTime
+	if b.EndTime.Before(expiresAt) {
+		expiresAt = b.EndTime
+	}
 	proposal := &ShadowProposal{
 		ID:               uuid.New().String(),
 		UserID:           userID,
 		PrimaryEvent:     summarizeEvent(a),
 		ConflictingEvent: summarizeEvent(b),
 		ConflictKey:      conflictKey,
-		Reason:           fmt.Sprintf("Overlap detected between %s and %s", a.Summary, b.Summary),
+		Reason:           fmt.Sprintf("Overlap detected between %s and %s", describeOccurrence(a), describeOccurrence(b)),
 		Plan:             plan,
-		Status:           "pending",
+		Candidates:       candidates,
+		Status:           ProposalPending,
+		ExpiresAt:        expiresAt,
+		History:          []ProposalTransition{{To: ProposalPending, Reason: "conflict detected", At: now}},
 		CreatedAt:        now,
 		UpdatedAt:        now,
 	}
-	return s.store.SaveProposal(ctx, proposal)
+	if proposal.Validation, err = s.validateProposal(ctx, proposal, a, b, now); err != nil {
+		return err
+	}
+	// The events moved but still overlap: the new plan replaces a pending one.
+	// Save the old proposal first so the conflict index ends up on the new one.
+	if previous != nil && previous.Transition(ProposalSuperseded, "replaced by a newer plan", now) == nil {
+		previous.SupersededBy = proposal.ID
+		if err := s.store.SaveProposal(ctx, previous); err != nil {
+			return err
+		}
+		s.emitTransition(ctx, previous)
+	}
+	if err := s.store.SaveProposal(ctx, proposal); err != nil {
+		return err
+	}
+	s.emitTransition(ctx, proposal)
+	// An overlap already over only gets expired; there is nothing to ask.
+	if proposal.ExpiresAt.After(now) {
+		s.emitProposed(ctx, proposal)
+	}
+	return nil
+}
+
+// planConflict plans how to resolve the overlap between a and b. The scheduler
+// proposes conflict-free slots first and the planner, when it can, only ranks
+// and explains them; without a scheduler, or when no slot fits, the planner
+// drafts the plan from the free-text timeBlock. Without a planner either, the
+// plan is nil and the conflict is still reported.
+func (s *ShadowCalendarService) planConflict(ctx context.Context, userID string, a, b *ShadowEvent, timeBlock string) (*CalendarPlan, []ScheduleCandidate, error) {
+	if s.scheduler != nil {
+		from, to := s.scheduler.Window(a, b)
+		busy, err := s.busyEvents(ctx, userID, from, to)
+		if err != nil {
+			return nil, nil, err
+		}
+		if candidates := s.scheduler.Solve(a, b, busy, time.Now()); len(candidates) > 0 {
+			if ranker, ok := s.planner.(CandidateRanker); ok {
+				ranked, err := ranker.RankCandidates(ctx, timeBlock, candidates)
+				if err != nil {
+					log.Printf("shadow calendar: rank candidates for %s: %v", userID, err)
+				} else {
+					candidates = ranked
+				}
+			}
+			moved := a
+			if candidates[0].Target == TargetConflicting {
+				moved = b
+			}
+			plan, err := candidates[0].plan(moved)
+			return plan, candidates, err
+		}
+	}
+	if s.planner == nil {
+		return nil, nil, nil
+	}
+	plan, err := s.planner.GenerateCalendarPlan(ctx, a.StartTime.Format("2006-01-02"), timeBlock, "calendar_conflict")
+	if err != nil {
+		return nil, nil, fmt.Errorf("planner run failed: %w", err)
+	}
+	return plan, nil, nil
+}
+
+// validateProposal checks the proposal's plan against the shadow calendar.
+// Plans without actions are checked as a default confirm would apply them:
+// their first event replaces the primary event.
+func (s *ShadowCalendarService) validateProposal(ctx context.Context, proposal *ShadowProposal, a, b *ShadowEvent, now time.Time) (*ValidationReport, error) {
+	scheduler := s.scheduler
+	if scheduler == nil {
+		scheduler = NewScheduler(SchedulingConstraints{})
+	}
+	ops, err := proposal.PlannedOperations(TargetPrimary, 0, "")
+	if err != nil {
+		report := &ValidationReport{Valid: true, CheckedAt: now}
+		report.add(CheckPlan, []string{err.Error()})
+		return report, nil
+	}
+	from, to := a.StartTime, a.EndTime
+	for _, evt := range []*ShadowEvent{a, b} {
+		from, to = earlier(from, evt.StartTime), later(to, evt.EndTime)
+	}
+	for _, op := range ops {
+		if start, end, err := operationTimes(op); err == nil {
+			from, to = earlier(from, start), later(to, end)
+		}
+	}
+	gap := scheduler.constraints.MinGap + time.Second
+	busy, err := s.busyEvents(ctx, proposal.UserID, from.Add(-gap), to.Add(gap))
+	if err != nil {
+		return nil, err
+	}
+	return scheduler.Validate(a, b, ops, busy, now), nil
+}
+
+// busyEvents returns the events in [from, to) that block time.
+func (s *ShadowCalendarService) busyEvents(ctx context.Context, userID string, from, to time.Time) ([]*ShadowEvent, error) {
+	informational, err := s.informationalCalendars(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	events, err := s.store.ListEventsInRange(ctx, userID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	busy := events[:0]
+	for _, evt := range events {
+		if blocksTime(evt, informational) {
+			busy = append(busy, evt)
+		}
+	}
+	return busy, nil
+}
+
+func earlier(a, b time.Time) time.Time {
+	if b.Before(a) {
+		return b
+	}
+	return a
+}
+
+func later(a, b time.Time) time.Time {
+	if b.After(a) {
+		return b
+	}
+	return a
 }
 
 func summarizeEvent(evt *ShadowEvent) *ShadowEventSummary {
-	return &ShadowEventSummary{
-		EventID:    evt.EventID,
-		CalendarID: evt.CalendarID,
-		Summary:    evt.Summary,
-		StartISO:   evt.StartTime.Format(time.RFC3339),
-		EndISO:     evt.EndTime.Format(time.RFC3339),
-		Location:   evt.Location,
+	summary := &ShadowEventSummary{
+		EventID:          evt.EventID,
+		CalendarID:       evt.CalendarID,
+		Summary:          evt.Summary,
+		StartISO:         evt.StartTime.Format(time.RFC3339),
+		EndISO:           evt.EndTime.Format(time.RFC3339),
+		Location:         evt.Location,
+		RecurringEventID: evt.RecurringEventID,
+	}
+	if evt.RecurringEventID != "" {
+		summary.OccurrenceDate = occurrenceDate(evt)
+	}
+	return summary
+}
+
+// describeOccurrence names an event for proposal reasons, adding the date for
+// occurrences of a series so the proposal points at one specific instance.
+func describeOccurrence(evt *ShadowEvent) string {
+	if evt.RecurringEventID == "" {
+		return evt.Summary
 	}
+	return fmt.Sprintf("%s on %s", evt.Summary, occurrenceDate(evt))
 }
 
 func eventsOverlap(a, b *ShadowEvent) bool {
@@ -411,7 +687,7 @@ func eventsOverlap(a, b *ShadowEvent) bool {
 
 // userCalendarStream returns stream key.
 func userCalendarStream(userID string) string {
-	return fmt.Sprintf("user:%s:in:calendar", userID)
+	return fmt.Sprintf(calendarStreamFormat, userID)
 }
 
 // calendarDelta is a parsed Redis stream entry.
@@ -430,15 +706,19 @@ func parseCalendarDelta(values map[string]interface{}) (*calendarDelta, error) {
 	allDay := parseBool(stringValue(values, "all_day"))
 	startISO := stringValue(values, "start_time")
 	endISO := stringValue(values, "end_time")
+	status := strings.ToLower(stringValue(values, "status"))
+	changeType := strings.ToLower(stringValue(values, "change_type"))
+	deleted := status == "cancelled" || changeType == "deleted"
+	// Google reports deleted events without times; only live events need them.
 	startTime, err := parseEventTime(startISO, allDay)
-	if err != nil {
+	if err != nil && !(deleted && startISO == "") {
 		return nil, fmt.Errorf("invalid start time: %w", err)
 	}
 	endTime, err := parseEventTime(endISO, allDay)
-	if err != nil {
+	if err != nil && !(deleted && endISO == "") {
 		return nil, fmt.Errorf("invalid end time: %w", err)
 	}
-	if !endTime.After(startTime) {
+	if !startTime.IsZero() && !endTime.After(startTime) {
 		if allDay {
 			endTime = startTime.Add(24 * time.Hour)
 		} else {
@@ -448,8 +728,22 @@ func parseCalendarDelta(values map[string]interface{}) (*calendarDelta, error) {
 	sequence := parseInt(stringValue(values, "sequence"))
 	updated := parseTimeOrZero(stringValue(values, "updated"))
 	notified := parseTimeOrZero(stringValue(values, "notified_at"))
-	status := strings.ToLower(stringValue(values, "status"))
-	changeType := strings.ToLower(stringValue(values, "change_type"))
+	var originalStart time.Time
+	if raw := stringValue(values, "original_start_time"); raw != "" {
+		if originalStart, err = parseEventTime(raw, allDay); err != nil {
+			return nil, fmt.Errorf("invalid original start time: %w", err)
+		}
+	}
+	attendees, err := parseAttendees(stringValue(values, "attendees_json"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid attendees: %w", err)
+	}
+	var recurrence []string
+	for _, line := range strings.Split(stringValue(values, "recurrence"), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			recurrence = append(recurrence, line)
+		}
+	}
 	event := &ShadowEvent{
 		UserID:         userID,
 		CalendarID:     calendarID,
@@ -463,6 +757,7 @@ func parseCalendarDelta(values map[string]interface{}) (*calendarDelta, error) {
 		EndTimezone:    stringValue(values, "end_timezone"),
 		AllDay:         allDay,
 		Status:         status,
+		Transparency:   strings.ToLower(stringValue(values, "transparency")),
 		ChangeType:     changeType,
 		HTMLLink:       stringValue(values, "html_link"),
 		CreatorEmail:   stringValue(values, "creator_email"),
@@ -473,11 +768,38 @@ func parseCalendarDelta(values map[string]interface{}) (*calendarDelta, error) {
 		RawPayload:     stringValue(values, "raw_event"),
 		ChannelID:      stringValue(values, "channel_id"),
 		RecordedAt:     time.Now().UTC(),
+
+		Recurrence:        recurrence,
+		RecurringEventID:  stringValue(values, "recurring_event_id"),
+		OriginalStartTime: originalStart,
+		Attendees:         attendees,
 	}
-	deleted := status == "cancelled" || changeType == "deleted"
 	return &calendarDelta{Event: event, Deleted: deleted}, nil
 }
 
+// parseAttendees reads the attendee list Google sent, dropping rooms and other resources.
+func parseAttendees(raw string) ([]ShadowAttendee, error) {
+	if raw = strings.TrimSpace(raw); raw == "" || raw == "null" {
+		return nil, nil
+	}
+	var entries []struct {
+		Email    string `json:"email"`
+		Self     bool   `json:"self"`
+		Resource bool   `json:"resource"`
+	}
+	if err := json.Unmarshal([]byte(raw), &entries); err != nil {
+		return nil, err
+	}
+	var attendees []ShadowAttendee
+	for _, entry := range entries {
+		if entry.Resource || entry.Email == "" {
+			continue
+		}
+		attendees = append(attendees, ShadowAttendee{Email: strings.ToLower(entry.Email), Self: entry.Self})
+	}
+	return attendees, nil
+}
+
 func stringValue(values map[string]interface{}, key string) string {
 	if raw, ok := values[key]; ok && raw != nil {
 		return strings.TrimSpace(fmt.Sprintf("%v", raw))
@@ -533,6 +855,27 @@ func parseEventTime(value string, allDay bool) (time.Time, error) {
 	return day, nil
 }
 
+func calendarOrPrimary(calendarID string) string {
+	if calendarID == "" {
+		return PrimaryCalendarID
+	}
+	return calendarID
+}
+
+// eventRef identifies an event among all of a user's calendars; the store keys
+// events, its indexes and conflicts by it.
+func eventRef(calendarID, eventID string) string {
+	return calendarOrPrimary(calendarID) + "/" + eventID
+}
+
+func (e *ShadowEvent) ref() string {
+	return eventRef(e.CalendarID, e.EventID)
+}
+
+func (e *ShadowEventSummary) ref() string {
+	return eventRef(e.CalendarID, e.EventID)
+}
+
 func buildConflictKey(eventA, eventB string) string {
 	ids := []string{eventA, eventB}
 	sort.Strings(ids)
@@ -566,15 +909,56 @@ func (s *redisShadowStore) UpsertEvent(ctx context.Context, event *ShadowEvent)
 	if err != nil {
 		return err
 	}
-	key := shadowEventKey(event.UserID)
-	return s.client.HSet(ctx, key, event.EventID, payload).Err()
+	userID := event.UserID
+	if err := s.ensureTimeIndex(ctx, userID); err != nil {
+		return err
+	}
+	ref := event.ref()
+	previous, err := s.getEvent(ctx, userID, ref)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, shadowEventKey(userID), ref, payload)
+		if previous != nil && previous.RecurringEventID != "" && previous.RecurringEventID != event.RecurringEventID {
+			pipe.SRem(ctx, shadowSeriesKey(userID, eventRef(previous.CalendarID, previous.RecurringEventID)), ref)
+		}
+		if event.RecurringEventID != "" {
+			pipe.SAdd(ctx, shadowSeriesKey(userID, eventRef(event.CalendarID, event.RecurringEventID)), ref)
+		}
+		if isSeriesMaster(event) {
+			// Masters span the whole series and are never returned by range queries.
+			pipe.ZRem(ctx, shadowTimeIndexKey(userID), ref)
+			return nil
+		}
+		pipe.ZAdd(ctx, shadowTimeIndexKey(userID), redis.Z{Score: float64(event.StartTime.Unix()), Member: ref})
+		pipe.ZAddGT(ctx, shadowSpanKey(userID), redis.Z{Score: event.EndTime.Sub(event.StartTime).Seconds(), Member: "max"})
+		return nil
+	})
+	return err
 }
 
-func (s *redisShadowStore) RemoveEvent(ctx context.Context, userID, eventID string) error {
+func (s *redisShadowStore) RemoveEvent(ctx context.Context, userID, calendarID, eventID string) error {
 	if userID == "" || eventID == "" {
 		return nil
 	}
-	return s.client.HDel(ctx, shadowEventKey(userID), eventID).Err()
+	if err := s.ensureTimeIndex(ctx, userID); err != nil {
+		return err
+	}
+	ref := eventRef(calendarID, eventID)
+	previous, err := s.getEvent(ctx, userID, ref)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HDel(ctx, shadowEventKey(userID), ref)
+		pipe.ZRem(ctx, shadowTimeIndexKey(userID), ref)
+		if previous != nil && previous.RecurringEventID != "" {
+			pipe.SRem(ctx, shadowSeriesKey(userID, eventRef(calendarID, previous.RecurringEventID)), ref)
+		}
+		return nil
+	})
+	return err
 }
 
 func (s *redisShadowStore) ListEvents(ctx context.Context, userID string) ([]*ShadowEvent, error) {
@@ -599,6 +983,196 @@ func (s *redisShadowStore) ListEvents(ctx context.Context, userID string) ([]*Sh
 	return events, nil
 }
 
+// ListEventsInRange reads candidates from the start-time index. An event that
+// overlaps the range starts at most the longest stored duration before it.
+func (s *redisShadowStore) ListEventsInRange(ctx context.Context, userID string, from, to time.Time) ([]*ShadowEvent, error) {
+	if userID == "" {
+		return nil, nil
+	}
+	if err := s.ensureTimeIndex(ctx, userID); err != nil {
+		return nil, err
+	}
+	maxSpan, err := s.client.ZScore(ctx, shadowSpanKey(userID), "max").Result()
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+	refs, err := s.client.ZRangeByScore(ctx, shadowTimeIndexKey(userID), &redis.ZRangeBy{
+		Min: strconv.FormatInt(from.Unix()-int64(maxSpan), 10),
+		Max: "(" + strconv.FormatInt(to.Unix(), 10),
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+	events, err := s.getEvents(ctx, userID, refs)
+	if err != nil {
+		return nil, err
+	}
+	result := events[:0]
+	for _, evt := range events {
+		if evt.EndTime.After(from) && evt.StartTime.Before(to) {
+			result = append(result, evt)
+		}
+	}
+	return result, nil
+}
+
+func (s *redisShadowStore) ListSeriesEvents(ctx context.Context, userID, calendarID, recurringEventID string) ([]*ShadowEvent, error) {
+	if userID == "" || recurringEventID == "" {
+		return nil, nil
+	}
+	if err := s.ensureTimeIndex(ctx, userID); err != nil {
+		return nil, err
+	}
+	refs, err := s.client.SMembers(ctx, shadowSeriesKey(userID, eventRef(calendarID, recurringEventID))).Result()
+	if err != nil {
+		return nil, err
+	}
+	return s.getEvents(ctx, userID, refs)
+}
+
+// PruneEvents drops events that ended before the cutoff from the hash and every index.
+func (s *redisShadowStore) PruneEvents(ctx context.Context, userID string, before time.Time) ([]*ShadowEvent, error) {
+	if userID == "" {
+		return nil, nil
+	}
+	if err := s.ensureTimeIndex(ctx, userID); err != nil {
+		return nil, err
+	}
+	refs, err := s.client.ZRangeByScore(ctx, shadowTimeIndexKey(userID), &redis.ZRangeBy{
+		Min: "-inf",
+		Max: "(" + strconv.FormatInt(before.Unix(), 10),
+	}).Result()
+	if err != nil || len(refs) == 0 {
+		return nil, err
+	}
+	events, err := s.getEvents(ctx, userID, refs)
+	if err != nil {
+		return nil, err
+	}
+	var pruned []*ShadowEvent
+	_, err = s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, evt := range events {
+			if evt.EndTime.After(before) {
+				continue
+			}
+			ref := evt.ref()
+			pipe.HDel(ctx, shadowEventKey(userID), ref)
+			pipe.ZRem(ctx, shadowTimeIndexKey(userID), ref)
+			if evt.RecurringEventID != "" {
+				pipe.SRem(ctx, shadowSeriesKey(userID, eventRef(evt.CalendarID, evt.RecurringEventID)), ref)
+			}
+			pruned = append(pruned, evt)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pruned, nil
+}
+
+// ensureTimeIndex builds the start-time and series indexes for users whose
+// events were stored before the indexes existed, or before events were keyed
+// by calendar. Older events and the conflict keys of their proposals are
+// re-keyed on the way.
+func (s *redisShadowStore) ensureTimeIndex(ctx context.Context, userID string) error {
+	indexed, err := s.client.Exists(ctx, shadowIndexedKey(userID)).Result()
+	if err != nil || indexed == 1 {
+		return err
+	}
+	entries, err := s.client.HGetAll(ctx, shadowEventKey(userID)).Result()
+	if err != nil {
+		return err
+	}
+	proposals, err := s.ListProposals(ctx, userID)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, shadowTimeIndexKey(userID), shadowSpanKey(userID), shadowLegacyIndexedKey(userID))
+		for field, raw := range entries {
+			var evt ShadowEvent
+			if err := json.Unmarshal([]byte(raw), &evt); err != nil {
+				continue
+			}
+			ref := evt.ref()
+			if field != ref {
+				pipe.HDel(ctx, shadowEventKey(userID), field)
+				pipe.HSet(ctx, shadowEventKey(userID), ref, raw)
+			}
+			if evt.RecurringEventID != "" {
+				pipe.Del(ctx, shadowSeriesKey(userID, evt.RecurringEventID))
+				pipe.SAdd(ctx, shadowSeriesKey(userID, eventRef(evt.CalendarID, evt.RecurringEventID)), ref)
+			}
+			if isSeriesMaster(&evt) {
+				continue
+			}
+			pipe.ZAdd(ctx, shadowTimeIndexKey(userID), redis.Z{Score: float64(evt.StartTime.Unix()), Member: ref})
+			pipe.ZAddGT(ctx, shadowSpanKey(userID), redis.Z{Score: evt.EndTime.Sub(evt.StartTime).Seconds(), Member: "max"})
+		}
+		// Rebuilt oldest first, so each conflict points at its latest proposal.
+		pipe.Del(ctx, shadowConflictKey(userID))
+		for _, proposal := range proposals {
+			if proposal.PrimaryEvent != nil && proposal.ConflictingEvent != nil {
+				proposal.ConflictKey = buildConflictKey(proposal.PrimaryEvent.ref(), proposal.ConflictingEvent.ref())
+			}
+			payload, err := json.Marshal(proposal)
+			if err != nil {
+				return err
+			}
+			pipe.HSet(ctx, shadowProposalKey(userID), proposal.ID, payload)
+			if proposal.Status != ProposalSuperseded {
+				pipe.HSet(ctx, shadowConflictKey(userID), proposal.ConflictKey, proposal.ID)
+			}
+		}
+		pipe.Set(ctx, shadowIndexedKey(userID), "1", 0)
+		return nil
+	})
+	return err
+}
+
+func (s *redisShadowStore) getEvent(ctx context.Context, userID, ref string) (*ShadowEvent, error) {
+	raw, err := s.client.HGet(ctx, shadowEventKey(userID), ref).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var evt ShadowEvent
+	if err := json.Unmarshal([]byte(raw), &evt); err != nil {
+		return nil, nil
+	}
+	return &evt, nil
+}
+
+// getEvents loads events by ref in start order, skipping refs whose event is gone.
+func (s *redisShadowStore) getEvents(ctx context.Context, userID string, refs []string) ([]*ShadowEvent, error) {
+	if len(refs) == 0 {
+		return nil, nil
+	}
+	values, err := s.client.HMGet(ctx, shadowEventKey(userID), refs...).Result()
+	if err != nil {
+		return nil, err
+	}
+	events := make([]*ShadowEvent, 0, len(values))
+	for _, value := range values {
+		raw, ok := value.(string)
+		if !ok {
+			continue
+		}
+		var evt ShadowEvent
+		if err := json.Unmarshal([]byte(raw), &evt); err != nil {
+			continue
+		}
+		events = append(events, &evt)
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].StartTime.Before(events[j].StartTime)
+	})
+	return events, nil
+}
+
 func (s *redisShadowStore) SaveProposal(ctx context.Context, proposal *ShadowProposal) error {
 	payload, err := json.Marshal(proposal)
 	if err != nil {
@@ -607,6 +1181,9 @@ func (s *redisShadowStore) SaveProposal(ctx context.Context, proposal *ShadowPro
 	if err := s.client.HSet(ctx, shadowProposalKey(proposal.UserID), proposal.ID, payload).Err(); err != nil {
 		return err
 	}
+	if proposal.Status == ProposalSuperseded {
+		return nil // the conflict index points at the proposal that replaced it
+	}
 	return s.client.HSet(ctx, shadowConflictKey(proposal.UserID), proposal.ConflictKey, proposal.ID).Err()
 }
 
@@ -669,13 +1246,14 @@ func (s *redisShadowStore) FindProposalByConflict(ctx context.Context, userID, c
 	return &proposal, nil
 }
 
-func (s *redisShadowStore) RemoveProposalsForEvent(ctx context.Context, userID, eventID string) error {
+func (s *redisShadowStore) RemoveProposalsForEvent(ctx context.Context, userID, calendarID, eventID string) error {
 	proposals, err := s.ListProposals(ctx, userID)
 	if err != nil {
 		return err
 	}
+	ref := eventRef(calendarID, eventID)
 	for _, proposal := range proposals {
-		if proposal.PrimaryEvent.EventID != eventID && proposal.ConflictingEvent.EventID != eventID {
+		if proposal.PrimaryEvent.ref() != ref && proposal.ConflictingEvent.ref() != ref {
 			continue
 		}
 		if err := s.client.HDel(ctx, shadowProposalKey(userID), proposal.ID).Err(); err != nil {
@@ -688,10 +1266,42 @@ func (s *redisShadowStore) RemoveProposalsForEvent(ctx context.Context, userID,
 	return nil
 }
 
+func (s *redisShadowStore) ClaimProposal(ctx context.Context, userID, proposalID string, ttl time.Duration) (bool, error) {
+	return s.client.SetNX(ctx, shadowApplyingKey(userID, proposalID), time.Now().UTC().Format(time.RFC3339), ttl).Result()
+}
+
+func (s *redisShadowStore) ReleaseProposal(ctx context.Context, userID, proposalID string) error {
+	return s.client.Del(ctx, shadowApplyingKey(userID, proposalID)).Err()
+}
+
 func shadowEventKey(userID string) string {
 	return fmt.Sprintf("shadow_calendar:%s", userID)
 }
 
+func shadowTimeIndexKey(userID string) string {
+	return fmt.Sprintf("shadow_calendar:%s:by_start", userID)
+}
+
+func shadowSpanKey(userID string) string {
+	return fmt.Sprintf("shadow_calendar:%s:span", userID)
+}
+
+// shadowIndexedKey marks a user whose events and indexes are keyed by ref.
+func shadowIndexedKey(userID string) string {
+	return fmt.Sprintf("shadow_calendar:%s:indexed_by_calendar", userID)
+}
+
+// shadowLegacyIndexedKey marked indexes keyed by event ID alone.
+func shadowLegacyIndexedKey(userID string) string {
+	return fmt.Sprintf("shadow_calendar:%s:indexed", userID)
+}
+
+// shadowSeriesKey holds the refs of a series' occurrences; seriesID is the
+// master's ref, or its bare ID for sets written before events were keyed by calendar.
+func shadowSeriesKey(userID, seriesID string) string {
+	return fmt.Sprintf("shadow_calendar:%s:series:%s", userID, seriesID)
+}
+
 func shadowProposalKey(userID string) string {
 	return fmt.Sprintf("shadow_calendar:%s:proposals", userID)
 }
@@ -699,3 +1309,7 @@ func shadowProposalKey(userID string) string {
 func shadowConflictKey(userID string) string {
 	return fmt.Sprintf("shadow_calendar:%s:conflicts", userID)
 }
+
+func shadowApplyingKey(userID, proposalID string) string {
+	return fmt.Sprintf("shadow_calendar:%s:applying:%s", userID, proposalID)
+}
@@ -2,12 +2,17 @@ package calendar_planner
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"sort"
 	"sync"
 	"testing"
 	"time"
 
+	"alfred-cloud/manager"
+	"alfred-cloud/wb"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
 	"github.com/stretchr/testify/require"
 )
 
@@ -61,6 +66,147 @@ func TestEnsureProposalCreatesPlan(t *testing.T) {
 	require.Equal(t, 1, planner.calls)
 }
 
+func TestEnsureProposalWithoutPlanner(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	store := newMemoryShadowStore()
+	svc, err := NewShadowCalendarService(client, nil, ShadowCalendarOptions{Store: store})
+	require.NoError(t, err)
+	svc.scheduler = nil
+	ctx := context.Background()
+	start := time.Now().UTC().Truncate(time.Hour).Add(48 * time.Hour)
+	eventA := &ShadowEvent{EventID: "evt-a", Summary: "Call", StartTime: start, EndTime: start.Add(time.Hour)}
+	eventB := &ShadowEvent{EventID: "evt-b", Summary: "Review", StartTime: start.Add(30 * time.Minute), EndTime: start.Add(2 * time.Hour)}
+
+	// The conflict is still reported, without a plan to confirm.
+	require.NoError(t, svc.ensureProposal(ctx, "user-1", eventA, eventB))
+	proposals, err := store.ListProposals(ctx, "user-1")
+	require.NoError(t, err)
+	require.Len(t, proposals, 1)
+	require.Equal(t, ProposalPending, proposals[0].Status)
+	require.Nil(t, proposals[0].Plan)
+	_, err = proposals[0].PlannedOperations(TargetPrimary, 0, "")
+	require.Error(t, err)
+}
+
+func TestProposalLifecycle(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	planner := &stubPlanner{plan: &CalendarPlan{Notes: []string{"resolve"}}}
+	store := newMemoryShadowStore()
+	svc := &ShadowCalendarService{planner: planner, store: store, bus: wb.NewBus(client)}
+	ctx := context.Background()
+	start := time.Now().UTC().Truncate(time.Hour).Add(48 * time.Hour)
+	eventA := &ShadowEvent{EventID: "evt-a", Summary: "Call", StartTime: start, EndTime: start.Add(time.Hour)}
+	eventB := &ShadowEvent{EventID: "evt-b", Summary: "Review", StartTime: start.Add(30 * time.Minute), EndTime: start.Add(2 * time.Hour)}
+
+	require.NoError(t, svc.ensureProposal(ctx, "user-1", eventA, eventB))
+	pending, err := svc.ListProposals(ctx, "user-1", ProposalPending)
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	first := pending[0]
+	require.Equal(t, start.Add(time.Hour), first.ExpiresAt)
+
+	// Moving an event while the proposal is pending supersedes it with a new plan.
+	eventB.StartTime = start.Add(45 * time.Minute)
+	require.NoError(t, svc.ensureProposal(ctx, "user-1", eventA, eventB))
+	require.Equal(t, 2, planner.calls)
+	old, err := svc.GetProposal(ctx, "user-1", first.ID)
+	require.NoError(t, err)
+	require.Equal(t, ProposalSuperseded, old.Status)
+	pending, err = svc.ListProposals(ctx, "user-1", ProposalPending)
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	require.Equal(t, pending[0].ID, old.SupersededBy)
+
+	// A rejected conflict is not proposed again, and a final status cannot change.
+	rejected, err := svc.TransitionProposal(ctx, "user-1", pending[0].ID, ProposalRejected, "not now")
+	require.NoError(t, err)
+	require.Equal(t, ProposalRejected, rejected.Status)
+	require.Len(t, rejected.History, 2)
+	require.NoError(t, svc.ensureProposal(ctx, "user-1", eventA, eventB))
+	require.Equal(t, 2, planner.calls)
+	_, err = svc.TransitionProposal(ctx, "user-1", pending[0].ID, ProposalAccepted, "")
+	require.ErrorIs(t, err, ErrInvalidTransition)
+	_, err = svc.TransitionProposal(ctx, "user-1", "missing", ProposalRejected, "")
+	require.ErrorIs(t, err, ErrProposalNotFound)
+
+	// Pending proposals expire once the overlap is over.
+	past := start.AddDate(0, 0, -7)
+	stale := &ShadowEvent{EventID: "evt-c", Summary: "Old", StartTime: past, EndTime: past.Add(time.Hour)}
+	overlap := &ShadowEvent{EventID: "evt-d", Summary: "Older", StartTime: past, EndTime: past.Add(time.Hour)}
+	require.NoError(t, svc.ensureProposal(ctx, "user-1", stale, overlap))
+	expired, err := svc.ListProposals(ctx, "user-1", ProposalExpired)
+	require.NoError(t, err)
+	require.Len(t, expired, 1)
+	require.Equal(t, "conflict time has passed", expired[0].StatusReason)
+
+	entries, err := client.XRange(ctx, wb.StreamKey("user-1"), "-", "+").Result()
+	require.NoError(t, err)
+	var types []string
+	for _, entry := range entries {
+		types = append(types, entry.Values["type"].(string))
+	}
+	require.Equal(t, []string{
+		"calendar.proposal.pending",
+		"calendar.plan.proposed",
+		"calendar.proposal.superseded",
+		"calendar.proposal.pending",
+		"calendar.plan.proposed",
+		"calendar.proposal.rejected",
+		"calendar.proposal.pending",
+		"calendar.proposal.expired",
+	}, types)
+	require.Equal(t, "primary/evt-a|primary/evt-b", entries[2].Values["thread_id"])
+}
+
+// TestConflictProposalReachesManagerPrompt follows a detected overlap through the
+// whiteboard into the manager graph, which must ask the user about that proposal.
+func TestConflictProposalReachesManagerPrompt(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	bus := wb.NewBus(client)
+	store := newMemoryShadowStore()
+	svc := &ShadowCalendarService{planner: &stubPlanner{plan: &CalendarPlan{Notes: []string{"resolve"}}}, store: store, bus: bus}
+	ctx := context.Background()
+	start := time.Now().UTC().Truncate(time.Hour).Add(48 * time.Hour)
+	eventA := &ShadowEvent{EventID: "evt-a", Summary: "Call", StartTime: start, EndTime: start.Add(time.Hour)}
+	eventB := &ShadowEvent{EventID: "evt-b", Summary: "Review", StartTime: start.Add(30 * time.Minute), EndTime: start.Add(2 * time.Hour)}
+	require.NoError(t, svc.ensureProposal(ctx, "user-1", eventA, eventB))
+
+	graph, err := manager.NewManagerGraph(manager.GraphConfig{PlannerURL: "http://example.com/planner/run", Bus: bus})
+	require.NoError(t, err)
+	entries, err := client.XRange(ctx, wb.StreamKey("user-1"), "-", "+").Result()
+	require.NoError(t, err)
+	var prompts []manager.RunResult
+	for _, entry := range entries {
+		evt, err := bus.Get(ctx, "user-1", entry.ID)
+		require.NoError(t, err)
+		normalized, err := manager.NormalizeWhiteboardEvent(*evt)
+		require.NoError(t, err)
+		res, err := graph.Run(ctx, normalized)
+		require.NoError(t, err)
+		if res.PromptID != "" {
+			prompts = append(prompts, res)
+		}
+	}
+	require.Len(t, prompts, 1)
+
+	proposals, err := store.ListProposals(ctx, "user-1")
+	require.NoError(t, err)
+	require.Len(t, proposals, 1)
+	prompt, err := bus.Get(ctx, "user-1", prompts[0].PromptID)
+	require.NoError(t, err)
+	require.Equal(t, "manager.prompt", prompt.Values["type"])
+	require.Equal(t, proposals[0].ID, prompt.Values["delta_id"])
+	require.Equal(t, "accept,reject", prompt.Values["choices"])
+	require.Equal(t, "primary/evt-a|primary/evt-b", prompt.ThreadID)
+	require.Contains(t, prompt.Values["content"], "Overlap detected between Call and Review")
+}
+
 func TestEvaluateConflictsDetectsOverlap(t *testing.T) {
 	planner := &stubPlanner{plan: &CalendarPlan{Notes: []string{"resolve"}, Blocks: []PlanBlock{{Title: "Conflict", StartTime: "2024-02-01T10:00:00Z", EndTime: "2024-02-01T11:00:00Z"}}}}
 	store := newMemoryShadowStore()
@@ -78,6 +224,236 @@ func TestEvaluateConflictsDetectsOverlap(t *testing.T) {
 	require.Equal(t, 1, planner.calls)
 }
 
+func TestEvaluateConflictsSkipsInformationalCalendars(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	directory := NewCalendarDirectory(client)
+	ctx := context.Background()
+	require.NoError(t, directory.SetInformational(ctx, "user-1", "holidays", true))
+
+	planner := &stubPlanner{plan: &CalendarPlan{Notes: []string{"resolve"}}}
+	store := newMemoryShadowStore()
+	svc := &ShadowCalendarService{planner: planner, store: store, calendars: directory}
+	start := time.Date(2024, 7, 4, 15, 0, 0, 0, time.UTC)
+	holiday := &ShadowEvent{UserID: "user-1", CalendarID: "holidays", EventID: "july-4", Summary: "Independence Day", StartTime: start.Add(-15 * time.Hour), EndTime: start.Add(9 * time.Hour), AllDay: true}
+	free := &ShadowEvent{UserID: "user-1", CalendarID: "primary", EventID: "lunch", Summary: "Maybe lunch", StartTime: start, EndTime: start.Add(time.Hour), Transparency: "transparent"}
+	meeting := &ShadowEvent{UserID: "user-1", CalendarID: "primary", EventID: "sync", Summary: "Team sync", StartTime: start.Add(30 * time.Minute), EndTime: start.Add(90 * time.Minute)}
+	for _, evt := range []*ShadowEvent{holiday, free, meeting} {
+		require.NoError(t, store.UpsertEvent(ctx, evt))
+	}
+
+	require.NoError(t, svc.evaluateConflicts(ctx, "user-1", meeting))
+	require.NoError(t, svc.evaluateConflicts(ctx, "user-1", holiday))
+	require.Equal(t, 0, planner.calls, "holidays and free events never conflict")
+
+	// Once the calendar is no longer informational its events count again.
+	require.NoError(t, directory.SetInformational(ctx, "user-1", "holidays", false))
+	require.NoError(t, svc.evaluateConflicts(ctx, "user-1", meeting))
+	require.Equal(t, 1, planner.calls)
+}
+
+func TestRecurringSeriesConflictsPerOccurrence(t *testing.T) {
+	planner := &stubPlanner{plan: &CalendarPlan{Notes: []string{"resolve"}}}
+	store := newMemoryShadowStore()
+	svc := &ShadowCalendarService{planner: planner, store: store, horizon: 21 * 24 * time.Hour}
+	ctx := context.Background()
+
+	// A weekly standup that started a month ago, and a one-off meeting two weeks out.
+	now := time.Now().UTC()
+	dtstart := time.Date(now.Year(), now.Month(), now.Day(), 10, 0, 0, 0, time.UTC).AddDate(0, 0, -28)
+	target := dtstart.AddDate(0, 0, 42)
+	review := map[string]interface{}{
+		"event_id": "review", "user_id": "user-1", "event_summary": "Design review",
+		"start_time": target.Add(15 * time.Minute).Format(time.RFC3339), "end_time": target.Add(time.Hour).Format(time.RFC3339),
+	}
+	require.NoError(t, svc.processMessage(ctx, "user-1", redis.XMessage{Values: review}))
+	standup := map[string]interface{}{
+		"event_id": "standup", "user_id": "user-1", "event_summary": "Standup",
+		"start_time": dtstart.Format(time.RFC3339), "end_time": dtstart.Add(30 * time.Minute).Format(time.RFC3339),
+		"recurrence": "RRULE:FREQ=WEEKLY",
+	}
+	require.NoError(t, svc.processMessage(ctx, "user-1", redis.XMessage{Values: standup}))
+
+	occurrenceID := instanceEventID("standup", target, false)
+	proposals, err := store.ListProposals(ctx, "user-1")
+	require.NoError(t, err)
+	require.Len(t, proposals, 1, "only the overlapping occurrence conflicts")
+	occurrence := proposals[0].PrimaryEvent
+	if occurrence.EventID != occurrenceID {
+		occurrence = proposals[0].ConflictingEvent
+	}
+	require.Equal(t, occurrenceID, occurrence.EventID)
+	require.Equal(t, "standup", occurrence.RecurringEventID)
+	require.Equal(t, target.Format("2006-01-02"), occurrence.OccurrenceDate)
+	require.Contains(t, proposals[0].Reason, "Standup on "+target.Format("2006-01-02"))
+
+	snapshot, err := svc.GetSnapshot(ctx, "user-1")
+	require.NoError(t, err)
+	var occurrences int
+	for _, evt := range snapshot.Events {
+		if evt.RecurringEventID == "standup" {
+			occurrences++
+		}
+	}
+	require.GreaterOrEqual(t, occurrences, 3, "occurrences across the rolling window")
+	require.LessOrEqual(t, occurrences, 4)
+
+	// Moving that occurrence away from the review clears its proposal.
+	moved := map[string]interface{}{
+		"event_id": occurrenceID, "user_id": "user-1", "event_summary": "Standup", "recurring_event_id": "standup",
+		"original_start_time": target.Format(time.RFC3339),
+		"start_time":          target.Add(2 * time.Hour).Format(time.RFC3339), "end_time": target.Add(150 * time.Minute).Format(time.RFC3339),
+	}
+	require.NoError(t, svc.processMessage(ctx, "user-1", redis.XMessage{Values: moved}))
+	proposals, err = store.ListProposals(ctx, "user-1")
+	require.NoError(t, err)
+	require.Empty(t, proposals)
+
+	// Re-expanding the series keeps the exception instead of regenerating the old slot.
+	require.NoError(t, svc.refreshRecurring(ctx, "user-1"))
+	proposals, err = store.ListProposals(ctx, "user-1")
+	require.NoError(t, err)
+	require.Empty(t, proposals)
+
+	// A cancelled occurrence (Google sends no times) stays cancelled after a refresh.
+	next := instanceEventID("standup", target.AddDate(0, 0, -7), false)
+	cancelled := map[string]interface{}{"event_id": next, "user_id": "user-1", "recurring_event_id": "standup", "status": "cancelled"}
+	require.NoError(t, svc.processMessage(ctx, "user-1", redis.XMessage{Values: cancelled}))
+	require.NoError(t, svc.refreshRecurring(ctx, "user-1"))
+	events, err := store.ListEvents(ctx, "user-1")
+	require.NoError(t, err)
+	for _, evt := range events {
+		if evt.EventID == next {
+			require.Equal(t, "cancelled", evt.Status)
+		}
+	}
+
+	// Deleting the master removes every occurrence.
+	require.NoError(t, svc.processMessage(ctx, "user-1", redis.XMessage{Values: map[string]interface{}{"event_id": "standup", "user_id": "user-1", "status": "cancelled"}}))
+	events, err = store.ListEvents(ctx, "user-1")
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	require.Equal(t, "review", events[0].EventID)
+}
+
+func TestRedisShadowStoreRangeQueriesAndPrune(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	store := &redisShadowStore{client: client}
+	ctx := context.Background()
+	day := time.Date(2024, 6, 10, 0, 0, 0, 0, time.UTC)
+
+	// Events written before the index existed are indexed on first query.
+	legacy := &ShadowEvent{UserID: "user-1", EventID: "legacy", StartTime: day.Add(9 * time.Hour), EndTime: day.Add(10 * time.Hour)}
+	payload, err := json.Marshal(legacy)
+	require.NoError(t, err)
+	require.NoError(t, client.HSet(ctx, shadowEventKey("user-1"), "legacy", payload).Err())
+
+	events := []*ShadowEvent{
+		{UserID: "user-1", EventID: "offsite", StartTime: day.AddDate(0, 0, -2), EndTime: day.AddDate(0, 0, 1), AllDay: true},
+		{UserID: "user-1", EventID: "lunch", StartTime: day.Add(12 * time.Hour), EndTime: day.Add(13 * time.Hour)},
+		{UserID: "user-1", EventID: "old", StartTime: day.AddDate(-2, 0, 0), EndTime: day.AddDate(-2, 0, 0).Add(time.Hour)},
+		{UserID: "user-1", EventID: "standup", StartTime: day.AddDate(-1, 0, 0), EndTime: day.AddDate(-1, 0, 0).Add(15 * time.Minute), Recurrence: []string{"RRULE:FREQ=DAILY"}},
+		{UserID: "user-1", EventID: "standup_20240610T093000Z", RecurringEventID: "standup", StartTime: day.Add(9*time.Hour + 30*time.Minute), EndTime: day.Add(9*time.Hour + 45*time.Minute)},
+	}
+	for _, evt := range events {
+		require.NoError(t, store.UpsertEvent(ctx, evt))
+	}
+
+	inRange, err := store.ListEventsInRange(ctx, "user-1", day.Add(9*time.Hour), day.Add(11*time.Hour))
+	require.NoError(t, err)
+	ids := make([]string, 0, len(inRange))
+	for _, evt := range inRange {
+		ids = append(ids, evt.EventID)
+	}
+	// The multi-day offsite started long before the range but still overlaps it;
+	// the series master is never a range result.
+	require.Equal(t, []string{"offsite", "legacy", "standup_20240610T093000Z"}, ids)
+
+	series, err := store.ListSeriesEvents(ctx, "user-1", "", "standup")
+	require.NoError(t, err)
+	require.Len(t, series, 1)
+
+	pruned, err := store.PruneEvents(ctx, "user-1", day.AddDate(0, -1, 0))
+	require.NoError(t, err)
+	require.Len(t, pruned, 1)
+	require.Equal(t, "old", pruned[0].EventID)
+	all, err := store.ListEvents(ctx, "user-1")
+	require.NoError(t, err)
+	require.Len(t, all, 5, "the master survives pruning even though it started long ago")
+	indexed, err := client.ZCard(ctx, shadowTimeIndexKey("user-1")).Result()
+	require.NoError(t, err)
+	require.EqualValues(t, 4, indexed)
+
+	require.NoError(t, store.RemoveEvent(ctx, "user-1", PrimaryCalendarID, "standup_20240610T093000Z"))
+	series, err = store.ListSeriesEvents(ctx, "user-1", PrimaryCalendarID, "standup")
+	require.NoError(t, err)
+	require.Empty(t, series)
+}
+
+func TestSameInviteOnTwoCalendars(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	store := &redisShadowStore{client: client}
+	planner := &stubPlanner{plan: &CalendarPlan{Notes: []string{"resolve"}}}
+	svc := &ShadowCalendarService{planner: planner, store: store}
+	ctx := context.Background()
+	start := time.Now().UTC().Truncate(time.Hour).Add(48 * time.Hour)
+
+	// Proposals keyed by event ID alone are re-keyed when the store first migrates.
+	legacy := &ShadowEvent{UserID: "user-1", EventID: "focus", Summary: "Focus", StartTime: start.Add(30 * time.Minute), EndTime: start.Add(90 * time.Minute)}
+	payload, err := json.Marshal(legacy)
+	require.NoError(t, err)
+	require.NoError(t, client.HSet(ctx, shadowEventKey("user-1"), "focus", payload).Err())
+	old := &ShadowProposal{
+		ID: "old", UserID: "user-1", Status: ProposalRejected, ConflictKey: "focus|sync", CreatedAt: start.AddDate(0, 0, -1),
+		PrimaryEvent:     summarizeEvent(legacy),
+		ConflictingEvent: &ShadowEventSummary{EventID: "sync", CalendarID: "team@example.com", StartISO: start.Format(time.RFC3339), EndISO: start.Add(time.Hour).Format(time.RFC3339)},
+	}
+	require.NoError(t, store.SaveProposal(ctx, old))
+
+	// The same invite lands on the primary calendar and a shared one.
+	for _, calendarID := range []string{PrimaryCalendarID, "team@example.com"} {
+		require.NoError(t, svc.processMessage(ctx, "user-1", redis.XMessage{Values: map[string]interface{}{
+			"event_id": "sync", "user_id": "user-1", "calendar_id": calendarID, "event_summary": "Team sync",
+			"start_time": start.Format(time.RFC3339), "end_time": start.Add(time.Hour).Format(time.RFC3339),
+		}}))
+	}
+	events, err := store.ListEvents(ctx, "user-1")
+	require.NoError(t, err)
+	require.Len(t, events, 3, "each calendar keeps its own copy")
+	migrated, err := store.FindProposalByConflict(ctx, "user-1", "primary/focus|team@example.com/sync")
+	require.NoError(t, err)
+	require.NotNil(t, migrated)
+	require.Equal(t, "old", migrated.ID)
+
+	// The copies never conflict with each other; focus conflicts with the
+	// primary copy, while the rejected shared copy is not proposed again.
+	require.Equal(t, 1, planner.calls)
+	pending, err := svc.ListProposals(ctx, "user-1", ProposalPending)
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	require.Equal(t, "primary/focus|primary/sync", pending[0].ConflictKey)
+
+	// Removing the invite from the shared calendar leaves the primary copy and its proposal.
+	require.NoError(t, svc.processMessage(ctx, "user-1", redis.XMessage{Values: map[string]interface{}{
+		"event_id": "sync", "user_id": "user-1", "calendar_id": "team@example.com", "status": "cancelled",
+	}}))
+	inRange, err := store.ListEventsInRange(ctx, "user-1", start, start.Add(time.Hour))
+	require.NoError(t, err)
+	require.Len(t, inRange, 2)
+	for _, evt := range inRange {
+		require.Equal(t, PrimaryCalendarID, calendarOrPrimary(evt.CalendarID))
+	}
+	proposals, err := store.ListProposals(ctx, "user-1")
+	require.NoError(t, err)
+	require.Len(t, proposals, 1)
+	require.Equal(t, pending[0].ID, proposals[0].ID)
+}
+
 func TestEventsOverlapAllDay(t *testing.T) {
 	start := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
 	a := &ShadowEvent{EventID: "a", StartTime: start, EndTime: start.Add(24 * time.Hour), AllDay: true}
@@ -90,6 +466,31 @@ func TestSanitizeUserIDs(t *testing.T) {
 	require.Equal(t, []string{"user-1", "user-2"}, ids)
 }
 
+func TestSetUsersReconcilesConsumers(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+
+	svc, err := NewShadowCalendarService(client, &stubPlanner{}, ShadowCalendarOptions{
+		UserIDs:     []string{"user-1"},
+		Store:       newMemoryShadowStore(),
+		PollTimeout: 50 * time.Millisecond,
+	})
+	require.NoError(t, err)
+	require.NoError(t, svc.Start(context.Background()))
+	defer svc.Stop()
+
+	svc.SetUsers(context.Background(), []string{"user-2", "user-3"})
+	require.Equal(t, []string{"user-2", "user-3"}, svc.UserIDs())
+
+	require.Eventually(t, func() bool { return svc.Metrics().Running }, time.Second, 10*time.Millisecond)
+	require.Equal(t, 2, svc.Metrics().Users)
+
+	groups, err := client.XInfoGroups(context.Background(), userCalendarStream("user-3")).Result()
+	require.NoError(t, err)
+	require.Len(t, groups, 1, "new users get a consumer group")
+}
+
 type stubPlanner struct {
 	plan  *CalendarPlan
 	calls int
@@ -109,6 +510,7 @@ type memoryShadowStore struct {
 	events    map[string]map[string]*ShadowEvent
 	proposals map[string]map[string]*ShadowProposal
 	conflicts map[string]map[string]string
+	claimed   map[string]bool
 }
 
 func newMemoryShadowStore() *memoryShadowStore {
@@ -116,6 +518,7 @@ func newMemoryShadowStore() *memoryShadowStore {
 		events:    make(map[string]map[string]*ShadowEvent),
 		proposals: make(map[string]map[string]*ShadowProposal),
 		conflicts: make(map[string]map[string]string),
+		claimed:   make(map[string]bool),
 	}
 }
 
@@ -131,15 +534,15 @@ func (m *memoryShadowStore) UpsertEvent(ctx context.Context, event *ShadowEvent)
 		m.events[event.UserID] = byUser
 	}
 	copy := *event
-	byUser[event.EventID] = &copy
+	byUser[event.ref()] = &copy
 	return nil
 }
 
-func (m *memoryShadowStore) RemoveEvent(ctx context.Context, userID, eventID string) error {
+func (m *memoryShadowStore) RemoveEvent(ctx context.Context, userID, calendarID, eventID string) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	if byUser := m.events[userID]; byUser != nil {
-		delete(byUser, eventID)
+		delete(byUser, eventRef(calendarID, eventID))
 	}
 	return nil
 }
@@ -162,6 +565,41 @@ func (m *memoryShadowStore) ListEvents(ctx context.Context, userID string) ([]*S
 	return result, nil
 }
 
+func (m *memoryShadowStore) ListEventsInRange(ctx context.Context, userID string, from, to time.Time) ([]*ShadowEvent, error) {
+	events, _ := m.ListEvents(ctx, userID)
+	result := events[:0]
+	for _, evt := range events {
+		if !isSeriesMaster(evt) && evt.EndTime.After(from) && evt.StartTime.Before(to) {
+			result = append(result, evt)
+		}
+	}
+	return result, nil
+}
+
+func (m *memoryShadowStore) ListSeriesEvents(ctx context.Context, userID, calendarID, recurringEventID string) ([]*ShadowEvent, error) {
+	events, _ := m.ListEvents(ctx, userID)
+	result := events[:0]
+	for _, evt := range events {
+		if evt.RecurringEventID == recurringEventID && calendarOrPrimary(evt.CalendarID) == calendarOrPrimary(calendarID) {
+			result = append(result, evt)
+		}
+	}
+	return result, nil
+}
+
+func (m *memoryShadowStore) PruneEvents(ctx context.Context, userID string, before time.Time) ([]*ShadowEvent, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var pruned []*ShadowEvent
+	for ref, evt := range m.events[userID] {
+		if !isSeriesMaster(evt) && !evt.EndTime.After(before) {
+			delete(m.events[userID], ref)
+			pruned = append(pruned, evt)
+		}
+	}
+	return pruned, nil
+}
+
 func (m *memoryShadowStore) SaveProposal(ctx context.Context, proposal *ShadowProposal) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -172,6 +610,9 @@ func (m *memoryShadowStore) SaveProposal(ctx context.Context, proposal *ShadowPr
 	}
 	copy := *proposal
 	byUser[proposal.ID] = &copy
+	if proposal.Status == ProposalSuperseded {
+		return nil
+	}
 	if m.conflicts[proposal.UserID] == nil {
 		m.conflicts[proposal.UserID] = make(map[string]string)
 	}
@@ -220,15 +661,16 @@ func (m *memoryShadowStore) GetProposal(ctx context.Context, userID, proposalID
 	return &copy, nil
 }
 
-func (m *memoryShadowStore) RemoveProposalsForEvent(ctx context.Context, userID, eventID string) error {
+func (m *memoryShadowStore) RemoveProposalsForEvent(ctx context.Context, userID, calendarID, eventID string) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	byUser := m.proposals[userID]
 	if byUser == nil {
 		return nil
 	}
+	ref := eventRef(calendarID, eventID)
 	for id, proposal := range byUser {
-		if proposal.PrimaryEvent.EventID != eventID && proposal.ConflictingEvent.EventID != eventID {
+		if proposal.PrimaryEvent.ref() != ref && proposal.ConflictingEvent.ref() != ref {
 			continue
 		}
 		delete(byUser, id)
@@ -238,3 +680,21 @@ func (m *memoryShadowStore) RemoveProposalsForEvent(ctx context.Context, userID,
 	}
 	return nil
 }
+
+func (m *memoryShadowStore) ClaimProposal(ctx context.Context, userID, proposalID string, ttl time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := userID + "/" + proposalID
+	if m.claimed[key] {
+		return false, nil
+	}
+	m.claimed[key] = true
+	return true, nil
+}
+
+func (m *memoryShadowStore) ReleaseProposal(ctx context.Context, userID, proposalID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.claimed, userID+"/"+proposalID)
+	return nil
+}
@@ -0,0 +1,269 @@
+package calendar_planner
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// recurrenceLookback keeps occurrences that started recently, so a meeting in
+// progress still takes part in conflict checks after the window rolls forward.
+const recurrenceLookback = 24 * time.Hour
+
+func isSeriesMaster(evt *ShadowEvent) bool {
+	return len(evt.Recurrence) > 0 && evt.RecurringEventID == ""
+}
+
+// occurrenceDate is the date an occurrence was originally scheduled for, in the event's timezone.
+func occurrenceDate(evt *ShadowEvent) string {
+	start := evt.OriginalStartTime
+	if start.IsZero() {
+		start = evt.StartTime
+	}
+	return start.In(eventLocation(evt)).Format("2006-01-02")
+}
+
+func eventLocation(evt *ShadowEvent) *time.Location {
+	if evt.AllDay {
+		return time.UTC
+	}
+	if evt.StartTimezone != "" {
+		if loc, err := time.LoadLocation(evt.StartTimezone); err == nil {
+			return loc
+		}
+	}
+	return evt.StartTime.Location()
+}
+
+// instanceOriginalStart recovers an occurrence's original start from a Google
+// instance ID such as "abc_20240205T100000Z" or "abc_20240205".
+func instanceOriginalStart(eventID string) time.Time {
+	idx := strings.LastIndex(eventID, "_")
+	if idx < 0 {
+		return time.Time{}
+	}
+	suffix := eventID[idx+1:]
+	if t, err := time.Parse("20060102T150405Z", suffix); err == nil {
+		return t
+	}
+	if t, err := time.Parse("20060102", suffix); err == nil {
+		return t
+	}
+	return time.Time{}
+}
+
+func (s *ShadowCalendarService) removeEvent(ctx context.Context, userID, calendarID, eventID string) error {
+	if err := s.store.RemoveEvent(ctx, userID, calendarID, eventID); err != nil {
+		return err
+	}
+	return s.store.RemoveProposalsForEvent(ctx, userID, calendarID, eventID)
+}
+
+// seriesInstances returns the stored occurrences of a series on one calendar keyed by event ID.
+func (s *ShadowCalendarService) seriesInstances(ctx context.Context, userID, calendarID, masterID string) (map[string]*ShadowEvent, error) {
+	events, err := s.store.ListSeriesEvents(ctx, userID, calendarID, masterID)
+	if err != nil {
+		return nil, err
+	}
+	instances := make(map[string]*ShadowEvent, len(events))
+	for _, evt := range events {
+		instances[evt.EventID] = evt
+	}
+	return instances, nil
+}
+
+func (s *ShadowCalendarService) removeSeries(ctx context.Context, userID, calendarID, masterID string) error {
+	instances, err := s.seriesInstances(ctx, userID, calendarID, masterID)
+	if err != nil {
+		return err
+	}
+	for eventID := range instances {
+		if err := s.removeEvent(ctx, userID, calendarID, eventID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyInstance records one occurrence reported by Google. Moved or edited
+// occurrences replace the generated one with the same ID; cancelled ones are
+// kept as tombstones so re-expanding the series does not bring them back.
+func (s *ShadowCalendarService) applyInstance(ctx context.Context, evt *ShadowEvent, cancelled bool) error {
+	if evt.OriginalStartTime.IsZero() {
+		evt.OriginalStartTime = instanceOriginalStart(evt.EventID)
+	}
+	if evt.StartTime.IsZero() {
+		evt.StartTime = evt.OriginalStartTime
+		evt.EndTime = evt.OriginalStartTime
+	}
+	evt.Expanded = false
+
+	if cancelled {
+		evt.Status = "cancelled"
+		if err := s.store.UpsertEvent(ctx, evt); err != nil {
+			return err
+		}
+		return s.store.RemoveProposalsForEvent(ctx, evt.UserID, evt.CalendarID, evt.EventID)
+	}
+
+	instances, err := s.seriesInstances(ctx, evt.UserID, evt.CalendarID, evt.RecurringEventID)
+	if err != nil {
+		return err
+	}
+	if previous, ok := instances[evt.EventID]; ok && !sameSlot(previous, evt) {
+		// Proposals for the old slot no longer apply.
+		if err := s.store.RemoveProposalsForEvent(ctx, evt.UserID, evt.CalendarID, evt.EventID); err != nil {
+			return err
+		}
+	}
+	if err := s.store.UpsertEvent(ctx, evt); err != nil {
+		return err
+	}
+	return s.evaluateConflicts(ctx, evt.UserID, evt)
+}
+
+// expandSeries stores a series master and materialises its occurrences across
+// the rolling window, keeping exceptions Google reported for individual instances.
+func (s *ShadowCalendarService) expandSeries(ctx context.Context, master *ShadowEvent) error {
+	if err := s.store.UpsertEvent(ctx, master); err != nil {
+		return err
+	}
+	horizon := s.horizon
+	if horizon <= 0 {
+		horizon = shadowDefaultHorizon
+	}
+	now := time.Now().UTC()
+	duration := master.EndTime.Sub(master.StartTime)
+	starts, err := ExpandRecurrence(master.Recurrence, master.StartTime, eventLocation(master), now.Add(-recurrenceLookback-duration), now.Add(horizon))
+	if err != nil {
+		return fmt.Errorf("expand recurrence for %s: %w", master.EventID, err)
+	}
+
+	existing, err := s.seriesInstances(ctx, master.UserID, master.CalendarID, master.EventID)
+	if err != nil {
+		return err
+	}
+	wanted := make(map[string]struct{}, len(starts))
+	var changed []*ShadowEvent
+	for _, start := range starts {
+		instance := *master
+		instance.EventID = instanceEventID(master.EventID, start, master.AllDay)
+		instance.RecurringEventID = master.EventID
+		instance.OriginalStartTime = start
+		instance.StartTime = start
+		instance.EndTime = start.Add(duration)
+		instance.Recurrence = nil
+		instance.RawPayload = ""
+		instance.Expanded = true
+		wanted[instance.EventID] = struct{}{}
+
+		previous, ok := existing[instance.EventID]
+		if ok && !previous.Expanded {
+			continue // Google reported this occurrence itself; it wins over the rule
+		}
+		if ok && sameSlot(previous, &instance) && previous.Summary == instance.Summary {
+			continue
+		}
+		if ok {
+			if err := s.store.RemoveProposalsForEvent(ctx, master.UserID, master.CalendarID, instance.EventID); err != nil {
+				return err
+			}
+		}
+		if err := s.store.UpsertEvent(ctx, &instance); err != nil {
+			return err
+		}
+		changed = append(changed, &instance)
+	}
+
+	// Drop occurrences the rule no longer produces, or that rolled out of the
+	// window. Moved exceptions and cancellation tombstones stay.
+	for eventID, previous := range existing {
+		if _, ok := wanted[eventID]; ok {
+			continue
+		}
+		moved := !previous.Expanded && !previous.OriginalStartTime.IsZero() && !previous.StartTime.Equal(previous.OriginalStartTime)
+		if previous.Status == "cancelled" || moved {
+			continue
+		}
+		if err := s.removeEvent(ctx, master.UserID, master.CalendarID, eventID); err != nil {
+			return err
+		}
+	}
+
+	for _, instance := range changed {
+		if err := s.evaluateConflicts(ctx, master.UserID, instance); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// refreshRecurring rolls every stored series forward to the current window.
+func (s *ShadowCalendarService) refreshRecurring(ctx context.Context, userID string) error {
+	events, err := s.store.ListEvents(ctx, userID)
+	if err != nil {
+		return err
+	}
+	for _, evt := range events {
+		if !isSeriesMaster(evt) {
+			continue
+		}
+		if err := s.expandSeries(ctx, evt); err != nil {
+			log.Printf("shadow calendar: refresh series %s for %s: %v", evt.EventID, userID, err)
+		}
+	}
+	return nil
+}
+
+// pruneEvents drops events that ended before the retention window, with their proposals.
+func (s *ShadowCalendarService) pruneEvents(ctx context.Context, userID string) error {
+	retention := s.retention
+	if retention <= 0 {
+		retention = shadowDefaultRetain
+	}
+	pruned, err := s.store.PruneEvents(ctx, userID, time.Now().Add(-retention))
+	if err != nil {
+		return err
+	}
+	for _, evt := range pruned {
+		if err := s.store.RemoveProposalsForEvent(ctx, userID, evt.CalendarID, evt.EventID); err != nil {
+			return err
+		}
+	}
+	if len(pruned) > 0 {
+		log.Printf("shadow calendar: pruned %d events for %s", len(pruned), userID)
+	}
+	return nil
+}
+
+// maintenanceLoop periodically prunes old events, expires proposals whose
+// conflict is over and rolls recurring series forward.
+func (s *ShadowCalendarService) maintenanceLoop(ctx context.Context) {
+	defer s.wg.Done()
+	ticker := time.NewTicker(s.refresh)
+	defer ticker.Stop()
+	for {
+		for _, userID := range s.UserIDs() {
+			if err := s.pruneEvents(ctx, userID); err != nil {
+				log.Printf("shadow calendar: prune events for %s: %v", userID, err)
+			}
+			if err := s.expireProposals(ctx, userID); err != nil {
+				log.Printf("shadow calendar: expire proposals for %s: %v", userID, err)
+			}
+			if err := s.refreshRecurring(ctx, userID); err != nil {
+				log.Printf("shadow calendar: refresh recurring events for %s: %v", userID, err)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func sameSlot(a, b *ShadowEvent) bool {
+	return a.StartTime.Equal(b.StartTime) && a.EndTime.Equal(b.EndTime)
+}
@@ -5,9 +5,13 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"os"
+	"strconv"
 	"strings"
 	"time"
 
+	"alfred-cloud/streams"
+	"alfred-cloud/wb"
 	"github.com/redis/go-redis/v9"
 )
 
@@ -19,12 +23,11 @@ type EmailClassifierInterface interface {
 // EmailConsumer consumes emails from the input stream and processes them
 type EmailConsumer struct {
 	redisClient    *redis.Client
+	bus            *wb.Bus
 	classifier     EmailClassifierInterface
-	userIDs        []string
-	consumerGroup  string
-	consumerName   string
-	stopChan       chan struct{}
-	running        bool
+	minPriority    string
+	minConfidence  float64
+	consumer       *streams.Consumer
 }
 
 // ProcessedEmail represents an email that has been classified and processed
@@ -45,150 +48,86 @@ type ProcessedEmail struct {
 
 const (
 	defaultConsumerGroup = "email-triage"
-	defaultConsumerName  = "email-classifier"
+	inputStreamFormat    = "user:%s:in:email"
+	// DeadLetterAgent names the dead-letter stream, user:{id}:dlq:email.
+	DeadLetterAgent      = "email"
 	streamReadCount      = 10
 	streamBlockTimeout   = 5 * time.Second
-	idleProcessingDelay  = 1 * time.Second
+	defaultMinPriority   = "Low"
+	defaultMinConfidence = 0.5
+	// publishedReplyTTL keeps the whiteboard id of a published email long enough
+	// to cover stream retries.
+	publishedReplyTTL = 7 * 24 * time.Hour
 )
 
+// priorityRank orders classifier priorities so thresholds can be compared.
+var priorityRank = map[string]int{
+	"low":    1,
+	"medium": 2,
+	"high":   3,
+}
+
 // NewEmailConsumer creates a new email consumer
 func NewEmailConsumer(redisClient *redis.Client, classifier EmailClassifierInterface, userIDs []string) *EmailConsumer {
-	return &EmailConsumer{
+	c := &EmailConsumer{
 		redisClient:   redisClient,
+		bus:           wb.NewBus(redisClient),
 		classifier:    classifier,
-		userIDs:       userIDs,
-		consumerGroup: defaultConsumerGroup,
-		consumerName:  defaultConsumerName,
-		stopChan:      make(chan struct{}),
-		running:       false,
-	}
+		minPriority:   resolveMinPriority(),
+		minConfidence: resolveMinConfidence(),
+	}
+	c.consumer = streams.NewStreamsHelper(redisClient).NewConsumer(streams.ConsumerOptions{
+		Group:     defaultConsumerGroup,
+		Agent:     DeadLetterAgent,
+		StartID:   "0",
+		Users:     userIDs,
+		BatchSize: streamReadCount,
+		Block:     streamBlockTimeout,
+		Retry:     streams.RetryPolicyFromEnv(),
+	})
+	c.consumer.Handle(inputStreamFormat, streams.Typed(streams.JSONField[EmailMessage]("raw_json"), c.processEmail))
+	return c
 }
 
-// Start begins consuming emails from the input stream
+// Start consumes the users' email streams until ctx is cancelled or Stop is called
 func (c *EmailConsumer) Start(ctx context.Context) error {
-	if c.running {
-		return fmt.Errorf("email consumer is already running")
-	}
-
-	if err := c.ensureConsumerGroups(ctx); err != nil {
-		return fmt.Errorf("failed to ensure consumer groups: %w", err)
-	}
-
-	c.running = true
-	log.Printf("Starting email consumer for %d users, group: %s, name: %s", len(c.userIDs), c.consumerGroup, c.consumerName)
-
-	// Start the consumption loop
-	go c.consumeLoop(ctx)
-
-	return nil
+	log.Printf("Starting email consumer for %d users, name: %s", len(c.GetUserIDs()), c.consumer.Name())
+	return c.consumer.Run(ctx)
 }
 
-// Stop stops the email consumer
+// Stop stops reading and waits for in-flight emails
 func (c *EmailConsumer) Stop() {
-	if !c.running {
-		return
-	}
-
 	log.Println("Stopping email consumer...")
-	c.running = false
-	close(c.stopChan)
+	c.consumer.Stop()
 }
 
 // GetUserIDs returns the list of user IDs being consumed
 func (c *EmailConsumer) GetUserIDs() []string {
-	return c.userIDs
+	return c.consumer.Users()
 }
 
-// consumeLoop runs the main consumption loop
-func (c *EmailConsumer) consumeLoop(ctx context.Context) {
-	for {
-		select {
-		case <-ctx.Done():
-			log.Println("Email consumer stopped due to context cancellation")
-			return
-		case <-c.stopChan:
-			log.Println("Email consumer stopped via stop signal")
-			return
-		default:
-			idle := c.processAllUsers(ctx)
-			if idle {
-				// Sleep briefly if no messages were processed
-				time.Sleep(idleProcessingDelay)
-			}
-		}
-	}
-}
-
-// processAllUsers processes pending messages for all users
-func (c *EmailConsumer) processAllUsers(ctx context.Context) bool {
-	idle := true
-	for _, userID := range c.userIDs {
-		if processed := c.processUserMessages(ctx, userID); processed {
-			idle = false
-		}
-	}
-	return idle
+// SetUsers replaces the consumed users at runtime, creating consumer groups for new users.
+func (c *EmailConsumer) SetUsers(ctx context.Context, userIDs []string) {
+	c.consumer.SetUsers(ctx, userIDs)
+	log.Printf("Email consumer now consuming %d users", len(userIDs))
 }
 
-// processUserMessages processes pending messages for a specific user
-func (c *EmailConsumer) processUserMessages(ctx context.Context, userID string) bool {
-	streamKey := fmt.Sprintf("user:%s:in:email", userID)
-
-	// Read messages from the stream
-	messages, err := c.redisClient.XReadGroup(ctx, &redis.XReadGroupArgs{
-		Group:    c.consumerGroup,
-		Consumer: c.consumerName,
-		Streams:  []string{streamKey, ">"},
-		Count:    streamReadCount,
-		Block:    streamBlockTimeout,
-	}).Result()
-
-	if err != nil {
-		if err != redis.Nil {
-			log.Printf("Error reading from stream %s: %v", streamKey, err)
-		}
-		return false
-	}
-
-	if len(messages) == 0 || len(messages[0].Messages) == 0 {
-		return false
-	}
-
-	processedCount := 0
-	for _, streamMsg := range messages[0].Messages {
-		if err := c.processMessage(ctx, userID, streamMsg); err != nil {
-			log.Printf("Error processing message %s for user %s: %v", streamMsg.ID, userID, err)
-			// Continue processing other messages
-		} else {
-			processedCount++
-		}
-	}
-
-	if processedCount > 0 {
-		log.Printf("Processed %d messages for user %s", processedCount, userID)
-	}
-
-	return processedCount > 0
+// Metrics reports the consumer's delivery counters
+func (c *EmailConsumer) Metrics() streams.ConsumerMetrics {
+	return c.consumer.Metrics()
 }
 
-// processMessage processes a single message from the stream
-func (c *EmailConsumer) processMessage(ctx context.Context, userID string, streamMsg redis.XMessage) error {
-	// Parse the message from the stream
-	emailMsg, err := c.parseStreamMessage(streamMsg)
-	if err != nil {
-		log.Printf("Failed to parse stream message %s: %v", streamMsg.ID, err)
-		// Acknowledge the malformed message to avoid reprocessing
-		return c.acknowledgeMessage(ctx, userID, streamMsg.ID)
-	}
-
+// processEmail classifies one inbound email. Returning nil lets the consumer
+// acknowledge it; an error leaves it pending for a retry.
+func (c *EmailConsumer) processEmail(ctx context.Context, userID string, emailMsg *EmailMessage) error {
 	if emailMsg == nil {
-		return c.acknowledgeMessage(ctx, userID, streamMsg.ID)
+		return nil
 	}
 
 	// Only process emails that clearly need responses
 	if !c.shouldProcessEmail(emailMsg) {
 		log.Printf("Skipping email %s - does not clearly need response", emailMsg.ID)
-		return c.acknowledgeMessage(ctx, userID, streamMsg.ID)
+		return nil
 	}
 
 	// Classify the email
@@ -221,33 +160,18 @@ func (c *EmailConsumer) processMessage(ctx context.Context, userID string, strea
 		processedEmail.BodyPreview = truncateString(emailMsg.BodyText, 512)
 	}
 
-	// Emit the processed email to internal stream (Phase D - not whiteboard yet)
-	if err := c.emitProcessedEmail(ctx, userID, processedEmail); err != nil {
-		return fmt.Errorf("failed to emit processed email %s: %w", emailMsg.ID, err)
-	}
-
-	// Acknowledge the message
-	return c.acknowledgeMessage(ctx, userID, streamMsg.ID)
-}
-
-// parseStreamMessage parses a message from the Redis stream
-func (c *EmailConsumer) parseStreamMessage(streamMsg redis.XMessage) (*EmailMessage, error) {
-	rawJSON, ok := streamMsg.Values["raw_json"]
-	if !ok {
-		return nil, fmt.Errorf("missing raw_json field in stream message")
-	}
-
-	jsonStr, ok := rawJSON.(string)
-	if !ok {
-		return nil, fmt.Errorf("raw_json field is not a string")
+	// Publish emails that need a reply to the whiteboard for the manager
+	wbID, err := c.publishReplyNeeded(ctx, userID, processedEmail)
+	if err != nil {
+		return fmt.Errorf("failed to publish email %s to whiteboard: %w", emailMsg.ID, err)
 	}
 
-	var emailMsg EmailMessage
-	if err := json.Unmarshal([]byte(jsonStr), &emailMsg); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal email message: %w", err)
+	// Record every classification in the audit stream, published or not
+	if err := c.emitProcessedEmail(ctx, userID, processedEmail, wbID); err != nil {
+		return fmt.Errorf("failed to emit processed email %s: %w", emailMsg.ID, err)
 	}
 
-	return &emailMsg, nil
+	return nil
 }
 
 // shouldProcessEmail determines if an email needs processing (BE GENEROUS)
@@ -316,8 +240,79 @@ func fromDomain(from string) string {
 	return ""
 }
 
-// emitProcessedEmail emits a processed email to the internal stream
-func (c *EmailConsumer) emitProcessedEmail(ctx context.Context, userID string, processedEmail *ProcessedEmail) error {
+// shouldPublish reports whether a classification clears the reply thresholds.
+func (c *EmailConsumer) shouldPublish(classification *ClassificationResult) bool {
+	if classification == nil || !classification.RequiresResponse {
+		return false
+	}
+	if classification.Confidence < c.minConfidence {
+		return false
+	}
+	return priorityRank[strings.ToLower(strings.TrimSpace(classification.Priority))] >= priorityRank[strings.ToLower(c.minPriority)]
+}
+
+// publishReplyNeeded appends an email.reply_needed event to the user's whiteboard,
+// threaded by the Gmail thread id. It returns the whiteboard entry id, or "" when
+// the email does not clear the thresholds or has no draft to offer. A message is
+// published once; a retry after a later failure gets the recorded id back.
+func (c *EmailConsumer) publishReplyNeeded(ctx context.Context, userID string, processedEmail *ProcessedEmail) (string, error) {
+	if !c.shouldPublish(processedEmail.Classification) {
+		return "", nil
+	}
+	if strings.TrimSpace(processedEmail.Classification.DraftReply) == "" {
+		log.Printf("Not publishing email %s for user %s: reply needed but no draft", processedEmail.MessageID, userID)
+		return "", nil
+	}
+
+	publishedKey := c.publishedRedisKey(userID, processedEmail.MessageID)
+	existing, err := c.redisClient.Get(ctx, publishedKey).Result()
+	if err == nil && existing != "" {
+		log.Printf("Email %s already published to whiteboard as %s", processedEmail.MessageID, existing)
+		return existing, nil
+	}
+	if err != nil && err != redis.Nil {
+		return "", fmt.Errorf("failed to look up published email %s: %w", processedEmail.MessageID, err)
+	}
+
+	summary := strings.TrimSpace(processedEmail.Classification.Summary)
+	if summary == "" {
+		summary = processedEmail.Subject
+	}
+	threadID := processedEmail.ThreadID
+	if threadID == "" {
+		threadID = processedEmail.MessageID
+	}
+
+	id, err := c.bus.AppendWithThread(ctx, userID, threadID, map[string]any{
+		"type":       "email.reply_needed",
+		"user_id":    userID,
+		"message_id": processedEmail.MessageID,
+		"sender":     processedEmail.From,
+		"subject":    processedEmail.Subject,
+		"summary":    summary,
+		"draft":      processedEmail.Classification.DraftReply,
+		"priority":   processedEmail.Classification.Priority,
+		"confidence": processedEmail.Classification.Confidence,
+	})
+	if err != nil {
+		return "", err
+	}
+	if err := c.redisClient.Set(ctx, publishedKey, id, publishedReplyTTL).Err(); err != nil {
+		// The entry is already on the whiteboard; failing here would only publish it again.
+		log.Printf("Failed to record published email %s as %s: %v", processedEmail.MessageID, id, err)
+	}
+
+	log.Printf("Published email %s to whiteboard %s as %s", processedEmail.MessageID, wb.StreamKey(userID), id)
+	return id, nil
+}
+
+func (c *EmailConsumer) publishedRedisKey(userID, messageID string) string {
+	return fmt.Sprintf("email_triage:published:%s:%s", userID, messageID)
+}
+
+// emitProcessedEmail records a processed email in the audit stream, noting the
+// whiteboard entry id when it was published.
+func (c *EmailConsumer) emitProcessedEmail(ctx context.Context, userID string, processedEmail *ProcessedEmail, wbID string) error {
 	outputStreamKey := fmt.Sprintf("user:%s:processed:email", userID)
 
 	values := map[string]interface{}{
@@ -339,6 +334,7 @@ func (c *EmailConsumer) emitProcessedEmail(ctx context.Context, userID string, p
 		"reasoning":           processedEmail.Classification.Reasoning,
 		"received_at":         processedEmail.ReceivedAt.UTC().Format(time.RFC3339Nano),
 		"processed_at":        processedEmail.ProcessedAt.UTC().Format(time.RFC3339Nano),
+		"wb_id":               wbID,
 	}
 
 	// Add classification as JSON
@@ -358,29 +354,23 @@ func (c *EmailConsumer) emitProcessedEmail(ctx context.Context, userID string, p
 	return nil
 }
 
-// acknowledgeMessage acknowledges a message in the consumer group
-func (c *EmailConsumer) acknowledgeMessage(ctx context.Context, userID, messageID string) error {
-	streamKey := fmt.Sprintf("user:%s:in:email", userID)
-
-	if err := c.redisClient.XAck(ctx, streamKey, c.consumerGroup, messageID).Err(); err != nil {
-		return fmt.Errorf("failed to acknowledge message %s: %w", messageID, err)
+// resolveMinPriority reads EMAIL_TRIAGE_MIN_PRIORITY (High, Medium or Low).
+func resolveMinPriority() string {
+	raw := strings.TrimSpace(os.Getenv("EMAIL_TRIAGE_MIN_PRIORITY"))
+	if _, ok := priorityRank[strings.ToLower(raw)]; ok {
+		return raw
 	}
-
-	return nil
+	return defaultMinPriority
 }
 
-// ensureConsumerGroups ensures consumer groups exist for all user streams
-func (c *EmailConsumer) ensureConsumerGroups(ctx context.Context) error {
-	for _, userID := range c.userIDs {
-		streamKey := fmt.Sprintf("user:%s:in:email", userID)
-
-		// Create the consumer group with MKSTREAM option to create stream if it doesn't exist
-		err := c.redisClient.XGroupCreateMkStream(ctx, streamKey, c.consumerGroup, "0").Err()
-		if err != nil && !strings.Contains(err.Error(), "BUSYGROUP") {
-			log.Printf("Warning: Failed to create consumer group for %s: %v", streamKey, err)
+// resolveMinConfidence reads EMAIL_TRIAGE_MIN_CONFIDENCE as a value between 0 and 1.
+func resolveMinConfidence() float64 {
+	if raw := strings.TrimSpace(os.Getenv("EMAIL_TRIAGE_MIN_CONFIDENCE")); raw != "" {
+		if v, err := strconv.ParseFloat(raw, 64); err == nil && v >= 0 && v <= 1 {
+			return v
 		}
 	}
-	return nil
+	return defaultMinConfidence
 }
 
 // truncateString is defined in poller_30s.go
\ No newline at end of file
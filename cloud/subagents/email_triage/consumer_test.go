@@ -0,0 +1,185 @@
+package email_triage
+
+import (
+	"context"
+	"testing"
+
+	"alfred-cloud/manager"
+	"alfred-cloud/wb"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+type staticClassifier struct {
+	result *ClassificationResult
+}
+
+func (s staticClassifier) ClassifyEmail(ctx context.Context, email EmailContent) (*ClassificationResult, error) {
+	return s.result, nil
+}
+
+func newTestConsumer(t *testing.T, result *ClassificationResult) (*EmailConsumer, *redis.Client) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+
+	consumer := NewEmailConsumer(client, staticClassifier{result: result}, []string{"user-1"})
+	consumer.minPriority = "Medium"
+	consumer.minConfidence = 0.6
+	return consumer, client
+}
+
+func inboundEmail() *EmailMessage {
+	return &EmailMessage{
+		ID:       "msg-1",
+		ThreadID: "gmail-thread-1",
+		Subject:  "Can we move the review?",
+		From:     "Dana <dana@example.com>",
+		BodyText: "Could you do Thursday instead?",
+	}
+}
+
+func TestProcessMessagePublishesReplyNeeded(t *testing.T) {
+	ctx := context.Background()
+	consumer, client := newTestConsumer(t, &ClassificationResult{
+		Classification:   "Question",
+		RequiresResponse: true,
+		Summary:          "Dana asks to move the review to Thursday",
+		DraftReply:       "Thursday works for me.",
+		Priority:         "High",
+		Confidence:       0.9,
+	})
+
+	if err := consumer.processEmail(ctx, "user-1", inboundEmail()); err != nil {
+		t.Fatalf("processEmail: %v", err)
+	}
+
+	entries, err := client.XRange(ctx, wb.StreamKey("user-1"), "-", "+").Result()
+	if err != nil {
+		t.Fatalf("read whiteboard: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 whiteboard entry, got %d", len(entries))
+	}
+	values := entries[0].Values
+	if values["type"] != "email.reply_needed" || values["thread_id"] != "gmail-thread-1" {
+		t.Fatalf("unexpected whiteboard entry: %v", values)
+	}
+
+	normalized, err := manager.NormalizeWhiteboardEvent(wb.Event{ID: entries[0].ID, UserID: "user-1", Values: values})
+	if err != nil {
+		t.Fatalf("manager rejected entry: %v", err)
+	}
+	if normalized.ThreadID != "gmail-thread-1" || normalized.Event.Payload["draft"] != "Thursday works for me." {
+		t.Fatalf("unexpected normalized event: %+v", normalized)
+	}
+
+	audit, err := client.XRange(ctx, "user:user-1:processed:email", "-", "+").Result()
+	if err != nil {
+		t.Fatalf("read audit stream: %v", err)
+	}
+	if len(audit) != 1 || audit[0].Values["wb_id"] != entries[0].ID {
+		t.Fatalf("expected audit entry linked to %s, got %v", entries[0].ID, audit)
+	}
+}
+
+func TestProcessMessageBelowThresholdsOnlyAudits(t *testing.T) {
+	tests := []struct {
+		name   string
+		result *ClassificationResult
+	}{
+		{
+			name:   "no response needed",
+			result: &ClassificationResult{Classification: "FYI", Priority: "High", Confidence: 0.9},
+		},
+		{
+			name:   "priority too low",
+			result: &ClassificationResult{RequiresResponse: true, DraftReply: "Thanks!", Priority: "Low", Confidence: 0.9},
+		},
+		{
+			name:   "confidence too low",
+			result: &ClassificationResult{RequiresResponse: true, DraftReply: "Thanks!", Priority: "High", Confidence: 0.3},
+		},
+		{
+			name:   "no draft",
+			result: &ClassificationResult{RequiresResponse: true, Priority: "High", Confidence: 0.9},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			consumer, client := newTestConsumer(t, tt.result)
+
+			if err := consumer.processEmail(ctx, "user-1", inboundEmail()); err != nil {
+				t.Fatalf("processEmail: %v", err)
+			}
+
+			if n, _ := client.XLen(ctx, wb.StreamKey("user-1")).Result(); n != 0 {
+				t.Fatalf("expected no whiteboard entries, got %d", n)
+			}
+			if n, _ := client.XLen(ctx, "user:user-1:processed:email").Result(); n != 1 {
+				t.Fatalf("expected 1 audit entry, got %d", n)
+			}
+		})
+	}
+}
+
+func TestProcessMessageRetryAfterAuditFailureReusesWhiteboardEntry(t *testing.T) {
+	ctx := context.Background()
+	consumer, client := newTestConsumer(t, &ClassificationResult{
+		RequiresResponse: true,
+		Summary:          "Dana asks to move the review to Thursday",
+		DraftReply:       "Thursday works for me.",
+		Priority:         "High",
+		Confidence:       0.9,
+	})
+
+	// A plain key in place of the audit stream makes the XADD fail.
+	auditKey := "user:user-1:processed:email"
+	client.Set(ctx, auditKey, "blocked", 0)
+	if err := consumer.processEmail(ctx, "user-1", inboundEmail()); err == nil {
+		t.Fatal("expected the audit write to fail")
+	}
+	client.Del(ctx, auditKey)
+
+	if err := consumer.processEmail(ctx, "user-1", inboundEmail()); err != nil {
+		t.Fatalf("retry processEmail: %v", err)
+	}
+
+	entries, err := client.XRange(ctx, wb.StreamKey("user-1"), "-", "+").Result()
+	if err != nil {
+		t.Fatalf("read whiteboard: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 whiteboard entry after retry, got %d", len(entries))
+	}
+	audit, err := client.XRange(ctx, auditKey, "-", "+").Result()
+	if err != nil {
+		t.Fatalf("read audit stream: %v", err)
+	}
+	if len(audit) != 1 || audit[0].Values["wb_id"] != entries[0].ID {
+		t.Fatalf("expected audit entry linked to %s, got %v", entries[0].ID, audit)
+	}
+}
+
+func TestResolveThresholdsFromEnv(t *testing.T) {
+	t.Setenv("EMAIL_TRIAGE_MIN_PRIORITY", "medium")
+	t.Setenv("EMAIL_TRIAGE_MIN_CONFIDENCE", "0.75")
+	if got := resolveMinPriority(); got != "medium" {
+		t.Fatalf("expected medium, got %s", got)
+	}
+	if got := resolveMinConfidence(); got != 0.75 {
+		t.Fatalf("expected 0.75, got %v", got)
+	}
+
+	t.Setenv("EMAIL_TRIAGE_MIN_PRIORITY", "urgent")
+	t.Setenv("EMAIL_TRIAGE_MIN_CONFIDENCE", "7")
+	if got := resolveMinPriority(); got != defaultMinPriority {
+		t.Fatalf("expected default priority, got %s", got)
+	}
+	if got := resolveMinConfidence(); got != defaultMinConfidence {
+		t.Fatalf("expected default confidence, got %v", got)
+	}
+}
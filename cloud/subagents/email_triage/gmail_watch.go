@@ -0,0 +1,131 @@
+package email_triage
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"google.golang.org/api/gmail/v1"
+)
+
+// GmailWatch is the stored state of a user's Gmail push (users.watch) registration.
+type GmailWatch struct {
+	UserID       string
+	EmailAddress string
+	Topic        string
+	HistoryID    uint64
+	Expiration   time.Time
+}
+
+// EnsureWatch registers a Gmail push watch on the user's inbox for the Pub/Sub
+// topic, renewing it when it is missing, points at another topic, or expires
+// within threshold. It reports whether a new watch was registered.
+func (p *EmailPoller) EnsureWatch(ctx context.Context, userID, topic string, threshold time.Duration) (*GmailWatch, bool, error) {
+	existing, err := p.loadWatch(ctx, userID)
+	if err != nil {
+		return nil, false, err
+	}
+	if existing != nil && existing.Topic == topic && time.Until(existing.Expiration) > threshold {
+		return existing, false, nil
+	}
+
+	service, err := p.gmailService(ctx, userID)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to get Gmail service for user %s: %w", userID, err)
+	}
+	profile, err := service.Users.GetProfile("me").Context(ctx).Do()
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to get Gmail profile: %w", err)
+	}
+	resp, err := service.Users.Watch("me", &gmail.WatchRequest{
+		TopicName:           topic,
+		LabelIds:            []string{"INBOX"},
+		LabelFilterBehavior: "include",
+	}).Context(ctx).Do()
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to register Gmail watch: %w", err)
+	}
+
+	watch := &GmailWatch{
+		UserID:       userID,
+		EmailAddress: strings.ToLower(strings.TrimSpace(profile.EmailAddress)),
+		Topic:        topic,
+		HistoryID:    resp.HistoryId,
+		Expiration:   time.UnixMilli(resp.Expiration),
+	}
+	if err := p.persistWatch(ctx, watch); err != nil {
+		return nil, false, err
+	}
+
+	// Anchor the history cursor at the watch so the first notification is not
+	// swallowed by a bootstrap.
+	if current, err := p.loadHistoryID(ctx, userID); err == nil && current == 0 {
+		if err := p.persistHistoryID(ctx, userID, resp.HistoryId); err != nil {
+			log.Printf("Warning: %v", err)
+		}
+	}
+	return watch, true, nil
+}
+
+// UserForAddress resolves the user whose watched mailbox is emailAddress, or "" if none.
+func (p *EmailPoller) UserForAddress(ctx context.Context, emailAddress string) (string, error) {
+	userID, err := p.redisClient.Get(ctx, p.addressRedisKey(emailAddress)).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve Gmail address: %w", err)
+	}
+	return userID, nil
+}
+
+func (p *EmailPoller) watchRedisKey(userID string) string {
+	return fmt.Sprintf("email_sync:watch:%s", userID)
+}
+
+func (p *EmailPoller) addressRedisKey(emailAddress string) string {
+	return fmt.Sprintf("email_sync:address:%s", strings.ToLower(strings.TrimSpace(emailAddress)))
+}
+
+func (p *EmailPoller) loadWatch(ctx context.Context, userID string) (*GmailWatch, error) {
+	data, err := p.redisClient.HGetAll(ctx, p.watchRedisKey(userID)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load Gmail watch for user %s: %w", userID, err)
+	}
+	if len(data) == 0 {
+		return nil, nil
+	}
+	expMs, _ := strconv.ParseInt(data["expiration"], 10, 64)
+	historyID, _ := strconv.ParseUint(data["history_id"], 10, 64)
+	return &GmailWatch{
+		UserID:       userID,
+		EmailAddress: data["email_address"],
+		Topic:        data["topic"],
+		HistoryID:    historyID,
+		Expiration:   time.UnixMilli(expMs),
+	}, nil
+}
+
+func (p *EmailPoller) persistWatch(ctx context.Context, watch *GmailWatch) error {
+	_, err := p.redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, p.watchRedisKey(watch.UserID), map[string]interface{}{
+			"email_address": watch.EmailAddress,
+			"topic":         watch.Topic,
+			"history_id":    strconv.FormatUint(watch.HistoryID, 10),
+			"expiration":    strconv.FormatInt(watch.Expiration.UnixMilli(), 10),
+			"renewed_at":    time.Now().UTC().Format(time.RFC3339),
+		})
+		if watch.EmailAddress != "" {
+			pipe.Set(ctx, p.addressRedisKey(watch.EmailAddress), watch.UserID, 0)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to persist Gmail watch for user %s: %w", watch.UserID, err)
+	}
+	return nil
+}
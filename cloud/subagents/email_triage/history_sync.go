@@ -0,0 +1,310 @@
+package email_triage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"google.golang.org/api/gmail/v1"
+	"google.golang.org/api/googleapi"
+)
+
+const (
+	// SyncModeHistory follows the Gmail history API from a persisted historyId.
+	SyncModeHistory = "history"
+	// SyncModeQuery is the legacy unread-query poll anchored on the last message ID.
+	SyncModeQuery = "query"
+
+	defaultResyncWindow = 24 * time.Hour
+	defaultResyncMax    = 50
+	emittedMessageTTL   = 7 * 24 * time.Hour
+)
+
+// nonPrimaryCategories are the inbox tabs the triage pipeline ignores.
+var nonPrimaryCategories = map[string]bool{
+	"CATEGORY_PROMOTIONS": true,
+	"CATEGORY_SOCIAL":     true,
+	"CATEGORY_UPDATES":    true,
+	"CATEGORY_FORUMS":     true,
+}
+
+// HistorySyncResult summarizes one incremental sync pass for a user.
+type HistorySyncResult struct {
+	HistoryID uint64
+	Emitted   int
+	Skipped   int
+	Resynced  bool
+}
+
+// resolveSyncMode reads EMAIL_SYNC_MODE, defaulting to history-based sync.
+func resolveSyncMode() string {
+	switch strings.ToLower(strings.TrimSpace(os.Getenv("EMAIL_SYNC_MODE"))) {
+	case SyncModeQuery:
+		return SyncModeQuery
+	default:
+		return SyncModeHistory
+	}
+}
+
+// resolveResyncWindow reads EMAIL_RESYNC_WINDOW, how far back a full resync looks.
+func resolveResyncWindow() time.Duration {
+	if raw := strings.TrimSpace(os.Getenv("EMAIL_RESYNC_WINDOW")); raw != "" {
+		if d, err := time.ParseDuration(raw); err == nil && d > 0 {
+			return d
+		}
+	}
+	return defaultResyncWindow
+}
+
+// resolveResyncMax reads EMAIL_RESYNC_MAX, the most messages a full resync emits.
+func resolveResyncMax() int64 {
+	if raw := strings.TrimSpace(os.Getenv("EMAIL_RESYNC_MAX")); raw != "" {
+		if n, err := strconv.ParseInt(raw, 10, 64); err == nil && n > 0 {
+			return n
+		}
+	}
+	return defaultResyncMax
+}
+
+// SyncUserHistory emits every primary inbox message added since the user's stored
+// historyId, read or unread. The first sync for a user only records the current
+// historyId; when the stored id has expired (HTTP 404) it falls back to a bounded
+// resync over the last resyncWindow.
+func (p *EmailPoller) SyncUserHistory(ctx context.Context, userID string) (*HistorySyncResult, error) {
+	service, err := p.gmailService(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get Gmail service for user %s: %w", userID, err)
+	}
+
+	startID, err := p.loadHistoryID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load history ID: %w", err)
+	}
+	if startID == 0 {
+		return p.bootstrapHistory(ctx, service, userID)
+	}
+
+	result, err := p.syncFromHistory(ctx, service, userID, startID)
+	if isHistoryExpired(err) {
+		log.Printf("History %d expired for user %s; resyncing last %v", startID, userID, p.resyncWindow)
+		return p.resyncHistory(ctx, service, userID)
+	}
+	return result, err
+}
+
+// bootstrapHistory anchors a new user at the mailbox's current history record.
+func (p *EmailPoller) bootstrapHistory(ctx context.Context, service *gmail.Service, userID string) (*HistorySyncResult, error) {
+	profile, err := service.Users.GetProfile("me").Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get Gmail profile: %w", err)
+	}
+	if err := p.persistHistoryID(ctx, userID, profile.HistoryId); err != nil {
+		return nil, err
+	}
+	log.Printf("Initialized Gmail history for user %s at %d", userID, profile.HistoryId)
+	return &HistorySyncResult{HistoryID: profile.HistoryId}, nil
+}
+
+func (p *EmailPoller) syncFromHistory(ctx context.Context, service *gmail.Service, userID string, startID uint64) (*HistorySyncResult, error) {
+	result := &HistorySyncResult{HistoryID: startID}
+	var messageIDs []string
+	seen := make(map[string]bool)
+
+	pageToken := ""
+	for {
+		call := service.Users.History.List("me").
+			StartHistoryId(startID).
+			HistoryTypes("messageAdded").
+			LabelId("INBOX").
+			MaxResults(gmailListPageSize).
+			Context(ctx)
+		if pageToken != "" {
+			call = call.PageToken(pageToken)
+		}
+		response, err := call.Do()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, record := range response.History {
+			for _, added := range record.MessagesAdded {
+				if added == nil || added.Message == nil || added.Message.Id == "" || seen[added.Message.Id] {
+					continue
+				}
+				seen[added.Message.Id] = true
+				messageIDs = append(messageIDs, added.Message.Id)
+			}
+		}
+		if response.HistoryId > result.HistoryID {
+			result.HistoryID = response.HistoryId
+		}
+
+		if response.NextPageToken == "" {
+			break
+		}
+		pageToken = response.NextPageToken
+	}
+
+	if err := p.emitMessages(ctx, service, userID, messageIDs, result); err != nil {
+		return nil, err
+	}
+	if err := p.persistHistoryID(ctx, userID, result.HistoryID); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// resyncHistory re-anchors on the current historyId and emits primary inbox mail
+// from the last resyncWindow, capped at resyncMax messages. Messages already
+// emitted are skipped, so overlap with the expired history is harmless.
+func (p *EmailPoller) resyncHistory(ctx context.Context, service *gmail.Service, userID string) (*HistorySyncResult, error) {
+	// Read the profile first so anything arriving during the resync is picked up next time.
+	profile, err := service.Users.GetProfile("me").Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get Gmail profile: %w", err)
+	}
+
+	since := time.Now().Add(-p.resyncWindow)
+	response, err := service.Users.Messages.List("me").
+		Q(fmt.Sprintf("in:inbox category:primary after:%d", since.Unix())).
+		MaxResults(p.resyncMax).
+		Context(ctx).
+		Do()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list messages for resync: %w", err)
+	}
+
+	// The list is newest first; emit oldest first like the history path does.
+	ids := make([]string, 0, len(response.Messages))
+	for i := len(response.Messages) - 1; i >= 0; i-- {
+		if ref := response.Messages[i]; ref != nil && ref.Id != "" {
+			ids = append(ids, ref.Id)
+		}
+	}
+
+	result := &HistorySyncResult{HistoryID: profile.HistoryId, Resynced: true}
+	if err := p.emitMessages(ctx, service, userID, ids, result); err != nil {
+		return nil, err
+	}
+	if err := p.persistHistoryID(ctx, userID, result.HistoryID); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// emitMessages fetches and emits each message once, skipping non-primary mail
+// and messages deleted since they were listed.
+func (p *EmailPoller) emitMessages(ctx context.Context, service *gmail.Service, userID string, ids []string, result *HistorySyncResult) error {
+	for _, id := range ids {
+		message, err := service.Users.Messages.Get("me", id).Format("full").Context(ctx).Do()
+		if err != nil {
+			if isNotFound(err) {
+				result.Skipped++
+				continue
+			}
+			return fmt.Errorf("failed to get message %s: %w", id, err)
+		}
+		if !isPrimaryInboxMessage(message.LabelIds) {
+			result.Skipped++
+			continue
+		}
+
+		first, err := p.markEmitted(ctx, userID, id)
+		if err != nil {
+			return err
+		}
+		if !first {
+			result.Skipped++
+			continue
+		}
+
+		processed, err := p.processMessage(ctx, service, userID, message)
+		if err != nil {
+			log.Printf("Error processing message %s for user %s: %v", id, userID, err)
+			// Not emitted, so a later sync that lists it again must not skip it.
+			p.redisClient.Del(ctx, p.emittedRedisKey(userID, id))
+			result.Skipped++
+			continue
+		}
+		if err := p.emitToInputStream(ctx, userID, processed); err != nil {
+			// Let the next sync retry this message.
+			p.redisClient.Del(ctx, p.emittedRedisKey(userID, id))
+			return err
+		}
+		result.Emitted++
+	}
+	return nil
+}
+
+// isPrimaryInboxMessage mirrors the legacy `category:primary` filter, without requiring UNREAD.
+func isPrimaryInboxMessage(labels []string) bool {
+	inbox := false
+	for _, label := range labels {
+		if label == "INBOX" {
+			inbox = true
+		}
+		if nonPrimaryCategories[label] {
+			return false
+		}
+	}
+	return inbox
+}
+
+func isHistoryExpired(err error) bool {
+	return isNotFound(err)
+}
+
+func isNotFound(err error) bool {
+	var apiErr *googleapi.Error
+	return errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound
+}
+
+func (p *EmailPoller) historyRedisKey(userID string) string {
+	return fmt.Sprintf("email_sync:history_id:%s", userID)
+}
+
+func (p *EmailPoller) emittedRedisKey(userID, messageID string) string {
+	return fmt.Sprintf("email_sync:emitted:%s:%s", userID, messageID)
+}
+
+func (p *EmailPoller) loadHistoryID(ctx context.Context, userID string) (uint64, error) {
+	raw, err := p.redisClient.Get(ctx, p.historyRedisKey(userID)).Result()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	id, err := strconv.ParseUint(raw, 10, 64)
+	if err != nil {
+		log.Printf("Warning: Ignoring malformed history ID %q for user %s", raw, userID)
+		return 0, nil
+	}
+	return id, nil
+}
+
+func (p *EmailPoller) persistHistoryID(ctx context.Context, userID string, historyID uint64) error {
+	if historyID == 0 {
+		return nil
+	}
+	if err := p.redisClient.Set(ctx, p.historyRedisKey(userID), strconv.FormatUint(historyID, 10), 0).Err(); err != nil {
+		return fmt.Errorf("failed to persist history ID for user %s: %w", userID, err)
+	}
+	return nil
+}
+
+// markEmitted records that a message was emitted, reporting whether this is the first time.
+func (p *EmailPoller) markEmitted(ctx context.Context, userID, messageID string) (bool, error) {
+	first, err := p.redisClient.SetNX(ctx, p.emittedRedisKey(userID, messageID), time.Now().UTC().Format(time.RFC3339), emittedMessageTTL).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to record emitted message %s: %w", messageID, err)
+	}
+	return first, nil
+}
@@ -0,0 +1,276 @@
+package email_triage
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"google.golang.org/api/gmail/v1"
+	"google.golang.org/api/option"
+)
+
+// fakeGmail serves the handful of Gmail endpoints the history sync uses.
+type fakeGmail struct {
+	mu            sync.Mutex
+	historyID     uint64
+	expiredBefore uint64 // history.list with an older startHistoryId returns 404
+	history       []*gmail.History
+	messages      map[string]*gmail.Message
+	listed        []string // messages.list result, newest first
+	watches       []gmail.WatchRequest
+}
+
+func newFakeGmail(historyID uint64) *fakeGmail {
+	return &fakeGmail{historyID: historyID, messages: make(map[string]*gmail.Message)}
+}
+
+// deliver adds a message to the mailbox and records a messageAdded history entry.
+func (f *fakeGmail) deliver(id string, labels ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.historyID++
+	f.messages[id] = &gmail.Message{
+		Id:       id,
+		ThreadId: "thread-" + id,
+		LabelIds: labels,
+		Snippet:  "snippet " + id,
+		Payload: &gmail.MessagePart{Headers: []*gmail.MessagePartHeader{
+			{Name: "Subject", Value: "Subject " + id},
+			{Name: "From", Value: "sender@example.com"},
+		}},
+	}
+	f.history = append(f.history, &gmail.History{
+		Id:            f.historyID,
+		MessagesAdded: []*gmail.HistoryMessageAdded{{Message: &gmail.Message{Id: id}}},
+	})
+	f.listed = append([]string{id}, f.listed...)
+}
+
+func (f *fakeGmail) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	path := strings.TrimPrefix(r.URL.Path, "/gmail/v1/users/me/")
+	switch {
+	case path == "profile":
+		writeFakeJSON(w, gmail.Profile{EmailAddress: "User@Example.com", HistoryId: f.historyID})
+	case path == "watch":
+		var req gmail.WatchRequest
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		f.watches = append(f.watches, req)
+		writeFakeJSON(w, gmail.WatchResponse{HistoryId: f.historyID, Expiration: time.Now().Add(7 * 24 * time.Hour).UnixMilli()})
+	case path == "history":
+		start, _ := strconv.ParseUint(r.URL.Query().Get("startHistoryId"), 10, 64)
+		if start < f.expiredBefore {
+			writeFakeNotFound(w)
+			return
+		}
+		resp := gmail.ListHistoryResponse{HistoryId: f.historyID}
+		for _, h := range f.history {
+			if h.Id > start {
+				resp.History = append(resp.History, h)
+			}
+		}
+		writeFakeJSON(w, resp)
+	case path == "messages":
+		resp := gmail.ListMessagesResponse{}
+		for _, id := range f.listed {
+			resp.Messages = append(resp.Messages, &gmail.Message{Id: id})
+		}
+		writeFakeJSON(w, resp)
+	case strings.HasPrefix(path, "messages/"):
+		msg, ok := f.messages[strings.TrimPrefix(path, "messages/")]
+		if !ok {
+			writeFakeNotFound(w)
+			return
+		}
+		writeFakeJSON(w, msg)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func writeFakeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeFakeNotFound(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusNotFound)
+	_, _ = w.Write([]byte(`{"error":{"code":404,"message":"Requested entity was not found."}}`))
+}
+
+func newTestHistoryPoller(t *testing.T, fake *fakeGmail) (*EmailPoller, *redis.Client) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	service, err := gmail.NewService(context.Background(), option.WithEndpoint(server.URL+"/"), option.WithHTTPClient(server.Client()))
+	if err != nil {
+		t.Fatalf("gmail service: %v", err)
+	}
+
+	poller := NewEmailPoller(nil, client, []string{"user-1"})
+	poller.syncMode = SyncModeHistory
+	poller.resyncWindow = time.Hour
+	poller.resyncMax = 10
+	poller.gmailService = func(ctx context.Context, userID string) (*gmail.Service, error) {
+		return service, nil
+	}
+	return poller, client
+}
+
+func emittedIDs(t *testing.T, client *redis.Client) []string {
+	t.Helper()
+	msgs, err := client.XRange(context.Background(), "user:user-1:in:email", "-", "+").Result()
+	if err != nil {
+		t.Fatalf("read input stream: %v", err)
+	}
+	ids := make([]string, 0, len(msgs))
+	for _, msg := range msgs {
+		ids = append(ids, msg.Values["message_id"].(string))
+	}
+	return ids
+}
+
+func TestSyncUserHistoryFollowsHistory(t *testing.T) {
+	ctx := context.Background()
+	fake := newFakeGmail(100)
+	poller, client := newTestHistoryPoller(t, fake)
+
+	// First sync only anchors; nothing that predates it is emitted.
+	fake.deliver("old", "INBOX", "UNREAD")
+	res, err := poller.SyncUserHistory(ctx, "user-1")
+	if err != nil {
+		t.Fatalf("bootstrap: %v", err)
+	}
+	if res.HistoryID != 101 || res.Emitted != 0 {
+		t.Fatalf("unexpected bootstrap result %+v", res)
+	}
+
+	fake.deliver("read-on-phone", "INBOX", "CATEGORY_PERSONAL")
+	fake.deliver("promo", "INBOX", "CATEGORY_PROMOTIONS", "UNREAD")
+	fake.deliver("unread", "INBOX", "UNREAD")
+	fake.mu.Lock()
+	delete(fake.messages, "unread") // deleted before we fetched it
+	fake.mu.Unlock()
+	fake.deliver("later", "INBOX", "UNREAD")
+
+	res, err = poller.SyncUserHistory(ctx, "user-1")
+	if err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+	if res.Emitted != 2 || res.Skipped != 2 || res.HistoryID != 105 || res.Resynced {
+		t.Fatalf("unexpected sync result %+v", res)
+	}
+	if got := emittedIDs(t, client); strings.Join(got, ",") != "read-on-phone,later" {
+		t.Fatalf("unexpected emitted messages %v", got)
+	}
+	if stored, _ := client.Get(ctx, "email_sync:history_id:user-1").Result(); stored != "105" {
+		t.Fatalf("expected persisted history 105, got %q", stored)
+	}
+
+	// A poller started later resumes from the persisted historyId.
+	res, err = poller.SyncUserHistory(ctx, "user-1")
+	if err != nil {
+		t.Fatalf("resume: %v", err)
+	}
+	if res.Emitted != 0 {
+		t.Fatalf("expected nothing new, got %+v", res)
+	}
+}
+
+func TestSyncUserHistoryResyncsWhenHistoryExpired(t *testing.T) {
+	ctx := context.Background()
+	fake := newFakeGmail(200)
+	poller, client := newTestHistoryPoller(t, fake)
+
+	fake.deliver("seen", "INBOX")
+	fake.deliver("missed", "INBOX", "UNREAD")
+	fake.deliver("social", "INBOX", "CATEGORY_SOCIAL")
+	fake.expiredBefore = 150
+
+	if err := client.Set(ctx, "email_sync:history_id:user-1", "42", 0).Err(); err != nil {
+		t.Fatalf("seed history: %v", err)
+	}
+	if _, err := poller.markEmitted(ctx, "user-1", "seen"); err != nil {
+		t.Fatalf("seed emitted: %v", err)
+	}
+
+	res, err := poller.SyncUserHistory(ctx, "user-1")
+	if err != nil {
+		t.Fatalf("resync: %v", err)
+	}
+	if !res.Resynced || res.Emitted != 1 || res.Skipped != 2 || res.HistoryID != 203 {
+		t.Fatalf("unexpected resync result %+v", res)
+	}
+	if got := emittedIDs(t, client); strings.Join(got, ",") != "missed" {
+		t.Fatalf("unexpected emitted messages %v", got)
+	}
+	if stored, _ := client.Get(ctx, "email_sync:history_id:user-1").Result(); stored != "203" {
+		t.Fatalf("expected history re-anchored at 203, got %q", stored)
+	}
+}
+
+func TestIsPrimaryInboxMessage(t *testing.T) {
+	if !isPrimaryInboxMessage([]string{"INBOX", "CATEGORY_PERSONAL"}) {
+		t.Fatal("personal inbox mail is primary")
+	}
+	if isPrimaryInboxMessage([]string{"INBOX", "CATEGORY_UPDATES"}) {
+		t.Fatal("updates tab is not primary")
+	}
+	if isPrimaryInboxMessage([]string{"SENT"}) {
+		t.Fatal("sent mail is not inbox")
+	}
+}
+
+func TestEnsureWatchRegistersAndRenews(t *testing.T) {
+	ctx := context.Background()
+	fake := newFakeGmail(300)
+	poller, _ := newTestHistoryPoller(t, fake)
+
+	watch, renewed, err := poller.EnsureWatch(ctx, "user-1", "projects/p/topics/gmail", 24*time.Hour)
+	if err != nil {
+		t.Fatalf("ensure watch: %v", err)
+	}
+	if !renewed || watch.EmailAddress != "user@example.com" || watch.HistoryID != 300 {
+		t.Fatalf("unexpected watch %+v renewed=%t", watch, renewed)
+	}
+	if len(fake.watches) != 1 || fake.watches[0].TopicName != "projects/p/topics/gmail" {
+		t.Fatalf("unexpected watch requests %+v", fake.watches)
+	}
+
+	// The watch anchors the history cursor, so the first push is not a bootstrap.
+	if id, _ := poller.loadHistoryID(ctx, "user-1"); id != 300 {
+		t.Fatalf("expected history anchored at 300, got %d", id)
+	}
+	if userID, _ := poller.UserForAddress(ctx, "USER@example.com"); userID != "user-1" {
+		t.Fatalf("expected address to resolve to user-1, got %q", userID)
+	}
+
+	// Fresh watches are left alone; one inside the threshold is renewed.
+	if _, renewed, _ := poller.EnsureWatch(ctx, "user-1", "projects/p/topics/gmail", 24*time.Hour); renewed {
+		t.Fatal("watch far from expiry should not be renewed")
+	}
+	if _, renewed, _ := poller.EnsureWatch(ctx, "user-1", "projects/p/topics/gmail", 8*24*time.Hour); !renewed {
+		t.Fatal("watch inside the threshold should be renewed")
+	}
+	if len(fake.watches) != 2 {
+		t.Fatalf("expected 2 watch calls, got %d", len(fake.watches))
+	}
+	if userID, _ := poller.UserForAddress(ctx, "nobody@example.com"); userID != "" {
+		t.Fatalf("unknown address resolved to %q", userID)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"log"
 	"strings"
+	"sync"
 	"time"
 
 	"alfred-cloud/security"
@@ -33,13 +34,24 @@ type EmailMessage struct {
 	UserID    string    `json:"user_id"`
 }
 
+// GmailServiceFunc returns an authorized Gmail client for a user.
+type GmailServiceFunc func(ctx context.Context, userID string) (*gmail.Service, error)
+
 // EmailPoller polls Gmail for new messages every 30 seconds
 type EmailPoller struct {
 	googleClient   *security.GoogleServiceClient
+	gmailService   GmailServiceFunc
 	redisClient    *redis.Client
+	syncMode       string        // SyncModeHistory or SyncModeQuery
+	resyncWindow   time.Duration // history mode: how far back a full resync looks
+	resyncMax      int64         // history mode: most messages a full resync emits
+	mu             sync.Mutex
 	userIDs        []string
+	managed        bool // userIDs is kept in sync by SetUsers; empty means no users
+	syncLocks      map[string]*sync.Mutex
 	pollInterval   time.Duration
 	lastMessageIDs map[string]string // userID -> last message ID
+	initialized    map[string]bool   // users whose last message ID has been loaded
 	startupTime    time.Time         // NEW: Track when poller started
 	stopChan       chan struct{}
 	running        bool
@@ -47,16 +59,38 @@ type EmailPoller struct {
 
 // NewEmailPoller creates a new email poller
 func NewEmailPoller(googleClient *security.GoogleServiceClient, redisClient *redis.Client, userIDs []string) *EmailPoller {
-	return &EmailPoller{
+	p := &EmailPoller{
 		googleClient:   googleClient,
 		redisClient:    redisClient,
+		syncMode:       resolveSyncMode(),
+		resyncWindow:   resolveResyncWindow(),
+		resyncMax:      resolveResyncMax(),
 		userIDs:        userIDs,
 		pollInterval:   30 * time.Second,
 		lastMessageIDs: make(map[string]string),
+		initialized:    make(map[string]bool),
+		syncLocks:      make(map[string]*sync.Mutex),
 		startupTime:    time.Now(), // NEW: Record when poller started
 		stopChan:       make(chan struct{}),
 		running:        false,
 	}
+	if googleClient != nil {
+		p.gmailService = googleClient.GetGmailService
+	}
+	return p
+}
+
+// SetPollInterval changes how often all users are polled. Call before Start;
+// with push notifications enabled polling only needs to catch missed pushes.
+func (p *EmailPoller) SetPollInterval(interval time.Duration) {
+	if interval > 0 {
+		p.pollInterval = interval
+	}
+}
+
+// SyncMode reports whether the poller follows Gmail history or the legacy unread query.
+func (p *EmailPoller) SyncMode() string {
+	return p.syncMode
 }
 
 // Start begins the email polling process
@@ -66,24 +100,18 @@ func (p *EmailPoller) Start(ctx context.Context) error {
 	}
 
 	p.running = true
-	log.Printf("Starting email poller for %d users, checking every %v", len(p.userIDs), p.pollInterval)
-
-	// Initialize last message IDs for each user
-	for _, userID := range p.userIDs {
-		if id, err := p.loadLastMessageID(ctx, userID); err != nil {
-			log.Printf("Warning: Failed to load last message ID for user %s: %v", userID, err)
-		} else if id != "" {
-			continue
-		}
-
-		if err := p.initializeLastMessageID(ctx, userID); err != nil {
-			log.Printf("Warning: Failed to initialize last message ID for user %s: %v", userID, err)
-			continue
-		}
+	p.mu.Lock()
+	userCount, managed := len(p.userIDs), p.managed
+	p.mu.Unlock()
+	if userCount == 0 && !managed {
+		log.Printf("Starting email poller (%s mode) for all connected Gmail users, checking every %v", p.syncMode, p.pollInterval)
+	} else {
+		log.Printf("Starting email poller (%s mode) for %d users, checking every %v", p.syncMode, userCount, p.pollInterval)
+	}
 
-		if id := p.lastMessageIDs[userID]; id != "" {
-			p.persistLastMessageID(ctx, userID, id)
-		}
+	// Initialize sync state for each user
+	for _, userID := range p.activeUsers(ctx) {
+		p.ensureUserInitialized(ctx, userID)
 	}
 
 	// Start the polling loop
@@ -105,7 +133,20 @@ func (p *EmailPoller) Stop() {
 
 // GetUserIDs returns the list of user IDs being polled
 func (p *EmailPoller) GetUserIDs() []string {
-	return p.userIDs
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.userIDs...)
+}
+
+// SetUsers replaces the polled users at runtime. New users are initialized on
+// the next poll; once called, an empty list stops polling instead of falling
+// back to discovery.
+func (p *EmailPoller) SetUsers(ctx context.Context, userIDs []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.userIDs = append([]string(nil), userIDs...)
+	p.managed = true
+	log.Printf("Email poller now polling %d users", len(p.userIDs))
 }
 
 // pollLoop runs the main polling loop
@@ -127,21 +168,96 @@ func (p *EmailPoller) pollLoop(ctx context.Context) {
 	}
 }
 
+// activeUsers returns the configured users, or every user with a Gmail token
+// when none are configured and the list is not managed by SetUsers.
+func (p *EmailPoller) activeUsers(ctx context.Context) []string {
+	p.mu.Lock()
+	users, managed := append([]string(nil), p.userIDs...), p.managed
+	p.mu.Unlock()
+	if len(users) > 0 || managed {
+		return users
+	}
+	users, err := p.googleClient.ListConnectedUsers(ctx, security.ServiceGmail)
+	if err != nil {
+		log.Printf("Warning: Failed to discover Gmail users: %v", err)
+		return nil
+	}
+	return users
+}
+
+// ensureUserInitialized loads or seeds the last message ID the first time a user is seen.
+// History mode keeps its own cursor, so it only needs this for query mode.
+func (p *EmailPoller) ensureUserInitialized(ctx context.Context, userID string) {
+	if p.initialized[userID] || p.syncMode == SyncModeHistory {
+		return
+	}
+
+	if id, err := p.loadLastMessageID(ctx, userID); err != nil {
+		log.Printf("Warning: Failed to load last message ID for user %s: %v", userID, err)
+	} else if id != "" {
+		p.initialized[userID] = true
+		return
+	}
+
+	if err := p.initializeLastMessageID(ctx, userID); err != nil {
+		log.Printf("Warning: Failed to initialize last message ID for user %s: %v", userID, err)
+		return
+	}
+
+	if id := p.lastMessageIDs[userID]; id != "" {
+		p.persistLastMessageID(ctx, userID, id)
+	}
+	p.initialized[userID] = true
+}
+
 // pollAllUsers checks for new emails for all users
 func (p *EmailPoller) pollAllUsers(ctx context.Context) {
-	for _, userID := range p.userIDs {
-		if err := p.pollUser(ctx, userID); err != nil {
+	for _, userID := range p.activeUsers(ctx) {
+		if err := p.SyncUser(ctx, userID); err != nil {
 			log.Printf("Error polling user %s: %v", userID, err)
 		}
 	}
 }
 
+// SyncUser fetches a user's new mail using the configured sync mode. Calls for the
+// same user are serialized, so a push notification and a poll never race.
+func (p *EmailPoller) SyncUser(ctx context.Context, userID string) error {
+	lock := p.syncLock(userID)
+	lock.Lock()
+	defer lock.Unlock()
+
+	if p.syncMode != SyncModeHistory {
+		p.ensureUserInitialized(ctx, userID)
+		return p.pollUser(ctx, userID)
+	}
+
+	result, err := p.SyncUserHistory(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if result.Emitted > 0 || result.Resynced {
+		log.Printf("Gmail history sync for user %s: emitted=%d skipped=%d resynced=%t history=%d", userID, result.Emitted, result.Skipped, result.Resynced, result.HistoryID)
+	}
+	return nil
+}
+
+func (p *EmailPoller) syncLock(userID string) *sync.Mutex {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	lock, ok := p.syncLocks[userID]
+	if !ok {
+		lock = &sync.Mutex{}
+		p.syncLocks[userID] = lock
+	}
+	return lock
+}
+
 // pollUser checks for new emails for a specific user
 func (p *EmailPoller) pollUser(ctx context.Context, userID string) error {
 	log.Printf("Polling user %s for new emails...", userID)
 
 	// Get Gmail service
-	service, err := p.googleClient.GetGmailService(ctx, userID)
+	service, err := p.gmailService(ctx, userID)
 	if err != nil {
 		return fmt.Errorf("failed to get Gmail service for user %s: %w", userID, err)
 	}
@@ -183,7 +299,7 @@ func (p *EmailPoller) pollUser(ctx context.Context, userID string) error {
 
 // initializeLastMessageID initializes the last message ID for a user using timestamp filtering
 func (p *EmailPoller) initializeLastMessageID(ctx context.Context, userID string) error {
-	service, err := p.googleClient.GetGmailService(ctx, userID)
+	service, err := p.gmailService(ctx, userID)
 	if err != nil {
 		return fmt.Errorf("failed to get Gmail service: %w", err)
 	}
@@ -3,6 +3,7 @@ package productivity
 import (
 	"context"
 	"errors"
+	"log"
 	"strings"
 	"time"
 )
@@ -32,30 +33,23 @@ type Decision struct {
 	Kind         DecisionType `json:"kind"`
 	UserID       string       `json:"user_id"`
 	EventID      string       `json:"event_id,omitempty"`
+	Title        string       `json:"title,omitempty"`
 	Observed     string       `json:"observed"`
 	ExpectedApps []string     `json:"expected_apps,omitempty"`
+	BlockStart   time.Time    `json:"block_start"`
+	BlockEnd     time.Time    `json:"block_end"`
 	StartedAt    time.Time    `json:"started_at"`
 	DecidedAt    time.Time    `json:"decided_at"`
 }
 
 // Classifier evaluates heartbeats against expected apps and records decisions.
+// Per-user state lives in a ClassifierStore, so one classifier can serve many
+// users concurrently and several replicas can share a user.
 type Classifier struct {
 	heuristics  *HeuristicService
 	gracePeriod time.Duration
 	now         func() time.Time
-
-	decisions map[string][]Decision
-	state     map[string]*classifierState
-}
-
-type classifierState struct {
-	eventID          string
-	lastMatch        time.Time
-	mismatchStart    time.Time
-	decisionRecorded bool
-	lastDecisionKind DecisionType
-	lastObserved     string
-	negativeCache    map[string]struct{}
+	store       ClassifierStore
 }
 
 // ClassifierOption configures optional classifier settings.
@@ -79,6 +73,16 @@ func WithClock(clock func() time.Time) ClassifierOption {
 	}
 }
 
+// WithStateStore sets where state and decisions are kept. The default is an
+// in-memory store, which is lost on restart and not shared between replicas.
+func WithStateStore(store ClassifierStore) ClassifierOption {
+	return func(c *Classifier) {
+		if store != nil {
+			c.store = store
+		}
+	}
+}
+
 // NewClassifier constructs a classifier tied to the heuristic service.
 func NewClassifier(heuristics *HeuristicService, opts ...ClassifierOption) (*Classifier, error) {
 	if heuristics == nil {
@@ -88,8 +92,7 @@ func NewClassifier(heuristics *HeuristicService, opts ...ClassifierOption) (*Cla
 		heuristics:  heuristics,
 		gracePeriod: 2 * time.Minute,
 		now:         time.Now,
-		decisions:   make(map[string][]Decision),
-		state:       make(map[string]*classifierState),
+		store:       NewMemoryClassifierStore(),
 	}
 	for _, opt := range opts {
 		opt(c)
@@ -99,6 +102,7 @@ func NewClassifier(heuristics *HeuristicService, opts ...ClassifierOption) (*Cla
 
 // ProcessHeartbeat ingests a single heartbeat and returns a decision
 // when the foreground has been outside the expected set for the full grace period.
+// The decision is kept as unemitted until DecisionEmitted confirms it.
 func (c *Classifier) ProcessHeartbeat(ctx context.Context, hb Heartbeat) (*Decision, error) {
 	if c == nil {
 		return nil, errors.New("classifier not initialized")
@@ -117,82 +121,149 @@ func (c *Classifier) ProcessHeartbeat(ctx context.Context, hb Heartbeat) (*Decis
 		return nil, err
 	}
 
-	state := c.stateForUser(hb.UserID)
-	if heuristic == nil || len(heuristic.ExpectedApps) == 0 {
-		state.resetForEvent("")
-		return nil, nil
+	state, err := c.store.LoadState(ctx, hb.UserID)
+	if err != nil {
+		return nil, err
 	}
-	state.resetForEvent(heuristic.EventID)
 
+	// On a mismatch we haven't asked Nano about for this event yet, ask now,
+	// outside the state update: the update is retried when another replica
+	// touches the same user, and the model call should not be.
 	foreground := hb.foregroundKey()
-	if foreground == "" {
-		return nil, nil
-	}
-
-	if ForegroundMatches(heuristic, foreground) {
-		state.lastMatch = ts
-		state.mismatchStart = time.Time{}
-		state.decisionRecorded = false
-		state.lastObserved = foreground
-		return nil, nil
-	}
-
-	// Mismatch detected. Check if we should verify with Nano.
-	// If this foreground is not in our negative cache (meaning we haven't asked Nano yet for this event), ask now.
-	if state.negativeCache == nil {
-		state.negativeCache = make(map[string]struct{})
-	}
-	if _, knownBad := state.negativeCache[foreground]; !knownBad {
-		isMatch, err := c.heuristics.ClassifyMismatch(ctx, heuristic, foreground)
+	asked, nanoMatch := false, false
+	if active(heuristic) && foreground != "" && !ForegroundMatches(heuristic, foreground) && !state.knownOffTask(heuristic.EventID, foreground) {
+		nanoMatch, err = c.heuristics.ClassifyMismatch(ctx, heuristic, foreground)
 		if err != nil {
 			// If the check fails, we don't update cache, so we'll retry next time.
 			// We return the error so the caller knows something is wrong.
 			return nil, err
 		}
-		if isMatch {
-			// Nano said it's a match, and the heuristic has been updated.
-			// Treat this as a match.
-			state.lastMatch = ts
-			state.mismatchStart = time.Time{}
-			state.decisionRecorded = false
-			state.lastObserved = foreground
-			return nil, nil
+		asked = true
+	}
+
+	var decision *Decision
+	err = c.store.UpdateState(ctx, hb.UserID, func(st *ClassifierState) error {
+		decision = c.advance(st, heuristic, hb.UserID, foreground, ts, asked, nanoMatch)
+		if decision != nil {
+			pending := *decision
+			st.Unemitted = &pending
 		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if decision != nil {
+		if err := c.store.AppendDecision(ctx, *decision); err != nil {
+			return nil, err
+		}
+	}
+	return decision, nil
+}
+
+// UnemittedDecision returns the user's last decision if it never reached the
+// whiteboard, or nil.
+func (c *Classifier) UnemittedDecision(ctx context.Context, userID string) (*Decision, error) {
+	if c == nil {
+		return nil, errors.New("classifier not initialized")
+	}
+	state, err := c.store.LoadState(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return state.Unemitted, nil
+}
+
+// DecisionEmitted records that decision reached the whiteboard.
+func (c *Classifier) DecisionEmitted(ctx context.Context, decision *Decision) error {
+	if c == nil {
+		return errors.New("classifier not initialized")
+	}
+	if decision == nil || decision.UserID == "" {
+		return errors.New("decision with user_id is required")
+	}
+	return c.store.UpdateState(ctx, decision.UserID, func(st *ClassifierState) error {
+		if st.Unemitted != nil && st.Unemitted.Kind == decision.Kind && st.Unemitted.DecidedAt.Equal(decision.DecidedAt) {
+			st.Unemitted = nil
+		}
+		return nil
+	})
+}
+
+// Recompute drops the user's mismatch timers and off-task cache so the next
+// heartbeat is judged afresh, e.g. after the plan behind the heuristics changed.
+func (c *Classifier) Recompute(ctx context.Context, userID string) error {
+	if c == nil {
+		return errors.New("classifier not initialized")
+	}
+	if userID == "" {
+		return errors.New("user_id is required")
+	}
+	return c.store.UpdateState(ctx, userID, func(st *ClassifierState) error {
+		*st = ClassifierState{Unemitted: st.Unemitted}
+		return nil
+	})
+}
+
+// advance applies one heartbeat to st and returns the decision it triggers.
+// asked and nanoMatch carry Nano's verdict when it was consulted.
+func (c *Classifier) advance(st *ClassifierState, heuristic *EventHeuristic, userID, foreground string, ts time.Time, asked, nanoMatch bool) *Decision {
+	if !active(heuristic) {
+		st.resetForEvent("")
+		return nil
+	}
+	st.resetForEvent(heuristic.EventID)
+
+	if foreground == "" {
+		return nil
+	}
+
+	// Nano saying it's a match has already updated the heuristic; treat it as one.
+	if ForegroundMatches(heuristic, foreground) || (asked && nanoMatch) {
+		st.LastMatch = ts
+		st.MismatchStart = time.Time{}
+		st.DecisionRecorded = false
+		st.LastObserved = foreground
+		return nil
+	}
+	if asked {
 		// Nano said no. Cache it so we don't ask again for this event.
-		state.negativeCache[foreground] = struct{}{}
+		if st.NegativeCache == nil {
+			st.NegativeCache = make(map[string]bool)
+		}
+		st.NegativeCache[foreground] = true
 	}
 
-	if state.mismatchStart.IsZero() {
-		state.mismatchStart = ts
-		state.lastObserved = foreground
-		return nil, nil
+	if st.MismatchStart.IsZero() {
+		st.MismatchStart = ts
+		st.LastObserved = foreground
+		return nil
 	}
 
-	elapsed := ts.Sub(state.mismatchStart)
+	elapsed := ts.Sub(st.MismatchStart)
 	if elapsed < c.gracePeriod {
-		state.lastObserved = foreground
-		return nil, nil
+		st.LastObserved = foreground
+		return nil
 	}
-	if state.decisionRecorded {
-		return nil, nil
+	if st.DecisionRecorded {
+		return nil
 	}
 
-	kind := c.classifyDecision(heuristic, state, foreground, ts)
-	decision := Decision{
+	kind := c.classifyDecision(heuristic, st, foreground, ts)
+	st.DecisionRecorded = true
+	st.LastDecisionKind = kind
+	return &Decision{
 		Kind:         kind,
-		UserID:       hb.UserID,
+		UserID:       userID,
 		EventID:      heuristic.EventID,
+		Title:        heuristic.Title,
 		Observed:     foreground,
 		ExpectedApps: append([]string(nil), heuristic.ExpectedApps...),
-		StartedAt:    state.mismatchStart,
+		BlockStart:   heuristic.StartTime,
+		BlockEnd:     heuristic.EndTime,
+		StartedAt:    st.MismatchStart,
 		DecidedAt:    ts,
 	}
-
-	state.decisionRecorded = true
-	state.lastDecisionKind = kind
-	c.decisions[hb.UserID] = append(c.decisions[hb.UserID], decision)
-
-	return &decision, nil
 }
 
 // Decisions returns recorded decisions for a user (copy).
@@ -200,29 +271,30 @@ func (c *Classifier) Decisions(userID string) []Decision {
 	if c == nil {
 		return nil
 	}
-	decisions := c.decisions[userID]
-	out := make([]Decision, len(decisions))
-	copy(out, decisions)
-	return out
+	decisions, err := c.store.Decisions(context.Background(), userID)
+	if err != nil {
+		log.Printf("productivity classifier: load decisions for %s: %v", userID, err)
+		return nil
+	}
+	return decisions
 }
 
-func (c *Classifier) stateForUser(userID string) *classifierState {
-	if st, ok := c.state[userID]; ok {
-		return st
-	}
-	st := &classifierState{}
-	c.state[userID] = st
-	return st
+func active(heuristic *EventHeuristic) bool {
+	return heuristic != nil && len(heuristic.ExpectedApps) > 0
+}
+
+func (st *ClassifierState) knownOffTask(eventID, foreground string) bool {
+	return st.EventID == eventID && st.NegativeCache[foreground]
 }
 
-func (st *classifierState) resetForEvent(eventID string) {
-	if st.eventID != eventID {
-		st.eventID = eventID
-		st.lastMatch = time.Time{}
-		st.mismatchStart = time.Time{}
-		st.decisionRecorded = false
-		st.lastDecisionKind = ""
-		st.negativeCache = make(map[string]struct{})
+func (st *ClassifierState) resetForEvent(eventID string) {
+	if st.EventID != eventID {
+		st.EventID = eventID
+		st.LastMatch = time.Time{}
+		st.MismatchStart = time.Time{}
+		st.DecisionRecorded = false
+		st.LastDecisionKind = ""
+		st.NegativeCache = nil
 	}
 }
 
@@ -237,14 +309,14 @@ func (hb Heartbeat) foregroundKey() string {
 	return strings.ToLower(strings.Join(parts, " | "))
 }
 
-func (c *Classifier) classifyDecision(heuristic *EventHeuristic, st *classifierState, observed string, ts time.Time) DecisionType {
+func (c *Classifier) classifyDecision(heuristic *EventHeuristic, st *ClassifierState, observed string, ts time.Time) DecisionType {
 	if heuristic != nil && !heuristic.EndTime.IsZero() && ts.After(heuristic.EndTime) {
 		return DecisionOverrun
 	}
-	if st.lastMatch.IsZero() {
+	if st.LastMatch.IsZero() {
 		return DecisionUnderrun
 	}
-	if st.lastDecisionKind == DecisionNudge && st.lastObserved == observed {
+	if st.LastDecisionKind == DecisionNudge && st.LastObserved == observed {
 		return DecisionAllowlist
 	}
 	return DecisionNudge
@@ -0,0 +1,212 @@
+package productivity
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	classifierStateTTL     = 24 * time.Hour
+	decisionHistoryTTL     = 7 * 24 * time.Hour
+	maxDecisionHistory     = 500
+	maxStateUpdateAttempts = 10
+)
+
+// ClassifierState is one user's mismatch tracking for the active event.
+type ClassifierState struct {
+	EventID          string       `json:"event_id,omitempty"`
+	LastMatch        time.Time    `json:"last_match,omitempty"`
+	MismatchStart    time.Time    `json:"mismatch_start,omitempty"`
+	DecisionRecorded bool         `json:"decision_recorded,omitempty"`
+	LastDecisionKind DecisionType `json:"last_decision_kind,omitempty"`
+	LastObserved     string       `json:"last_observed,omitempty"`
+	// NegativeCache holds foregrounds the model already judged off-task for EventID.
+	NegativeCache map[string]bool `json:"negative_cache,omitempty"`
+	// Unemitted is the last recorded decision until it reaches the whiteboard, so a
+	// heartbeat retried after a failed emit hands it out again.
+	Unemitted *Decision `json:"unemitted,omitempty"`
+}
+
+// ClassifierStore persists classifier state and decision history so every
+// consumer replica, and the next process after a restart, sees the same timers.
+type ClassifierStore interface {
+	// LoadState returns the user's state, or a zero state if none is stored.
+	LoadState(ctx context.Context, userID string) (*ClassifierState, error)
+	// UpdateState applies fn to the user's current state and saves the result
+	// atomically with respect to other updates. fn may run more than once.
+	UpdateState(ctx context.Context, userID string, fn func(*ClassifierState) error) error
+	AppendDecision(ctx context.Context, decision Decision) error
+	// Decisions returns the user's recorded decisions, oldest first.
+	Decisions(ctx context.Context, userID string) ([]Decision, error)
+}
+
+// MemoryClassifierStore keeps classifier state in process memory.
+type MemoryClassifierStore struct {
+	mu        sync.Mutex
+	state     map[string][]byte
+	decisions map[string][]Decision
+}
+
+// NewMemoryClassifierStore creates an empty in-memory store.
+func NewMemoryClassifierStore() *MemoryClassifierStore {
+	return &MemoryClassifierStore{
+		state:     make(map[string][]byte),
+		decisions: make(map[string][]Decision),
+	}
+}
+
+func (m *MemoryClassifierStore) LoadState(ctx context.Context, userID string) (*ClassifierState, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return decodeClassifierState(m.state[userID])
+}
+
+func (m *MemoryClassifierStore) UpdateState(ctx context.Context, userID string, fn func(*ClassifierState) error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	state, err := decodeClassifierState(m.state[userID])
+	if err != nil {
+		return err
+	}
+	if err := fn(state); err != nil {
+		return err
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	m.state[userID] = data
+	return nil
+}
+
+func (m *MemoryClassifierStore) AppendDecision(ctx context.Context, decision Decision) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	history := append(m.decisions[decision.UserID], decision)
+	if len(history) > maxDecisionHistory {
+		history = history[len(history)-maxDecisionHistory:]
+	}
+	m.decisions[decision.UserID] = history
+	return nil
+}
+
+func (m *MemoryClassifierStore) Decisions(ctx context.Context, userID string) ([]Decision, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Decision(nil), m.decisions[userID]...), nil
+}
+
+// RedisClassifierStore shares classifier state between consumer replicas.
+// State updates use WATCH/MULTI so concurrent heartbeats for one user are
+// applied one after another rather than overwriting each other.
+type RedisClassifierStore struct {
+	client *redis.Client
+}
+
+// NewRedisClassifierStore creates a store backed by Redis.
+func NewRedisClassifierStore(client *redis.Client) *RedisClassifierStore {
+	return &RedisClassifierStore{client: client}
+}
+
+func (s *RedisClassifierStore) LoadState(ctx context.Context, userID string) (*ClassifierState, error) {
+	data, err := s.client.Get(ctx, classifierStateKey(userID)).Bytes()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, fmt.Errorf("load classifier state: %w", err)
+	}
+	return decodeClassifierState(data)
+}
+
+func (s *RedisClassifierStore) UpdateState(ctx context.Context, userID string, fn func(*ClassifierState) error) error {
+	key := classifierStateKey(userID)
+	for attempt := 0; attempt < maxStateUpdateAttempts; attempt++ {
+		err := s.client.Watch(ctx, func(tx *redis.Tx) error {
+			data, err := tx.Get(ctx, key).Bytes()
+			if err != nil && !errors.Is(err, redis.Nil) {
+				return err
+			}
+			state, err := decodeClassifierState(data)
+			if err != nil {
+				return err
+			}
+			if err := fn(state); err != nil {
+				return err
+			}
+			updated, err := json.Marshal(state)
+			if err != nil {
+				return err
+			}
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.Set(ctx, key, updated, classifierStateTTL)
+				return nil
+			})
+			return err
+		}, key)
+		if errors.Is(err, redis.TxFailedErr) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("update classifier state: %w", err)
+		}
+		return nil
+	}
+	return fmt.Errorf("update classifier state for %s: too many concurrent updates", userID)
+}
+
+func (s *RedisClassifierStore) AppendDecision(ctx context.Context, decision Decision) error {
+	data, err := json.Marshal(decision)
+	if err != nil {
+		return fmt.Errorf("marshal decision: %w", err)
+	}
+	key := classifierDecisionsKey(decision.UserID)
+	_, err = s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.RPush(ctx, key, data)
+		pipe.LTrim(ctx, key, -maxDecisionHistory, -1)
+		pipe.Expire(ctx, key, decisionHistoryTTL)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("store decision: %w", err)
+	}
+	return nil
+}
+
+func (s *RedisClassifierStore) Decisions(ctx context.Context, userID string) ([]Decision, error) {
+	entries, err := s.client.LRange(ctx, classifierDecisionsKey(userID), 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("load decisions: %w", err)
+	}
+	decisions := make([]Decision, 0, len(entries))
+	for _, entry := range entries {
+		var decision Decision
+		if err := json.Unmarshal([]byte(entry), &decision); err != nil {
+			return nil, fmt.Errorf("decode decision: %w", err)
+		}
+		decisions = append(decisions, decision)
+	}
+	return decisions, nil
+}
+
+func decodeClassifierState(data []byte) (*ClassifierState, error) {
+	state := &ClassifierState{}
+	if len(data) == 0 {
+		return state, nil
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("decode classifier state: %w", err)
+	}
+	return state, nil
+}
+
+func classifierStateKey(userID string) string {
+	return fmt.Sprintf("prod:classifier:state:%s", userID)
+}
+
+func classifierDecisionsKey(userID string) string {
+	return fmt.Sprintf("prod:classifier:decisions:%s", userID)
+}
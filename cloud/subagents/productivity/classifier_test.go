@@ -2,6 +2,9 @@ package productivity
 
 import (
 	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -139,3 +142,103 @@ func TestClassifierResetsTimerOnMatch(t *testing.T) {
 
 	require.Len(t, classifier.Decisions("user-2"), 1)
 }
+
+type countingGenerator struct {
+	staticGenerator
+	classified atomic.Int32
+}
+
+func (g *countingGenerator) ClassifyForeground(ctx context.Context, payload EventPayload, foreground string) (bool, error) {
+	g.classified.Add(1)
+	return g.staticGenerator.ClassifyForeground(ctx, payload, foreground)
+}
+
+func TestClassifierStateSharedAcrossRestartsAndReplicas(t *testing.T) {
+	ctx := context.Background()
+	client, cleanup := newTestRedis(t)
+	defer cleanup()
+
+	generator := &countingGenerator{staticGenerator: staticGenerator{apps: []string{"com.microsoft.VSCode"}}}
+	svc, err := NewHeuristicService(NewHeuristicStore(client), generator)
+	require.NoError(t, err)
+
+	now := time.Now()
+	_, err = svc.UpsertEventHeuristic(ctx, EventPayload{
+		UserID:    "user-1",
+		EventID:   "evt-1",
+		Title:     "Coding",
+		StartTime: now.Add(-time.Minute),
+		EndTime:   now.Add(time.Hour),
+	})
+	require.NoError(t, err)
+
+	store := NewRedisClassifierStore(client)
+	first, err := NewClassifier(svc, WithStateStore(store))
+	require.NoError(t, err)
+	decision, err := first.ProcessHeartbeat(ctx, Heartbeat{UserID: "user-1", BundleID: "com.apple.finder", Timestamp: now})
+	require.NoError(t, err)
+	require.Nil(t, decision)
+
+	// A restarted (or second) instance continues the same mismatch timer and
+	// remembers Nano's verdict instead of asking again.
+	second, err := NewClassifier(svc, WithStateStore(NewRedisClassifierStore(client)))
+	require.NoError(t, err)
+	decision, err = second.ProcessHeartbeat(ctx, Heartbeat{UserID: "user-1", BundleID: "com.apple.finder", Timestamp: now.Add(121 * time.Second)})
+	require.NoError(t, err)
+	require.NotNil(t, decision)
+	require.Equal(t, DecisionUnderrun, decision.Kind)
+	require.Equal(t, int32(1), generator.classified.Load())
+
+	decision, err = first.ProcessHeartbeat(ctx, Heartbeat{UserID: "user-1", BundleID: "com.apple.finder", Timestamp: now.Add(150 * time.Second)})
+	require.NoError(t, err)
+	require.Nil(t, decision, "the decision was already recorded by the other instance")
+	require.Len(t, first.Decisions("user-1"), 1)
+}
+
+func TestClassifierHandlesUsersConcurrently(t *testing.T) {
+	ctx := context.Background()
+	client, cleanup := newTestRedis(t)
+	defer cleanup()
+
+	svc, err := NewHeuristicService(NewHeuristicStore(client), &staticGenerator{apps: []string{"com.microsoft.VSCode"}})
+	require.NoError(t, err)
+	now := time.Now()
+	users := make([]string, 8)
+	for i := range users {
+		users[i] = fmt.Sprintf("user-%d", i)
+		_, err = svc.UpsertEventHeuristic(ctx, EventPayload{
+			UserID:    users[i],
+			EventID:   "evt",
+			Title:     "Coding",
+			StartTime: now.Add(-time.Minute),
+			EndTime:   now.Add(time.Hour),
+		})
+		require.NoError(t, err)
+	}
+
+	for name, store := range map[string]ClassifierStore{"memory": NewMemoryClassifierStore(), "redis": NewRedisClassifierStore(client)} {
+		t.Run(name, func(t *testing.T) {
+			classifier, err := NewClassifier(svc, WithStateStore(store))
+			require.NoError(t, err)
+
+			// Two workers per user race on the same state; each user still gets exactly one decision.
+			var wg sync.WaitGroup
+			for _, userID := range users {
+				for worker := 0; worker < 2; worker++ {
+					wg.Add(1)
+					go func(userID string) {
+						defer wg.Done()
+						for _, offset := range []time.Duration{0, 130 * time.Second, 140 * time.Second, 150 * time.Second} {
+							_, err := classifier.ProcessHeartbeat(ctx, Heartbeat{UserID: userID, BundleID: "com.apple.finder", Timestamp: now.Add(offset)})
+							require.NoError(t, err)
+						}
+					}(userID)
+				}
+			}
+			wg.Wait()
+			for _, userID := range users {
+				require.Len(t, classifier.Decisions(userID), 1, userID)
+			}
+		})
+	}
+}
@@ -4,18 +4,20 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"os"
 	"strings"
 	"time"
 
 	"alfred-cloud/streams"
+	"alfred-cloud/wb"
 	"github.com/redis/go-redis/v9"
 )
 
 const (
-	ConsumerGroup    = "productivity-subagent"
-	ConsumerName     = "worker-1"
-	StreamKeyFormat  = "user:%s:in:prod"
-	WhiteboardFormat = "user:%s:wb"
+	ConsumerGroup   = "productivity-subagent"
+	StreamKeyFormat = "user:%s:in:prod"
+	// DeadLetterAgent names the dead-letter stream, user:{id}:dlq:productivity.
+	DeadLetterAgent = "productivity"
 )
 
 type ProductivityConsumer struct {
@@ -23,86 +25,54 @@ type ProductivityConsumer struct {
 	classifier *Classifier
 	heuristics *HeuristicService
 	streams    *streams.StreamsHelper
-	userIDs    []string
-	stopChan   chan struct{}
+	emitter    *DecisionEmitter
+	consumer   *streams.Consumer
 }
 
 func NewProductivityConsumer(client *redis.Client, classifier *Classifier, heuristics *HeuristicService, userIDs []string) *ProductivityConsumer {
-	return &ProductivityConsumer{
+	helper := streams.NewStreamsHelper(client)
+	c := &ProductivityConsumer{
 		client:     client,
 		classifier: classifier,
 		heuristics: heuristics,
-		streams:    streams.NewStreamsHelper(client),
-		userIDs:    userIDs,
-		stopChan:   make(chan struct{}),
-	}
+		streams:    helper,
+		emitter:    NewDecisionEmitter(wb.NewBus(client)),
+	}
+	// The consumer name is unique per process (PRODUCTIVITY_CONSUMER_NAME, or
+	// host-pid) so replicas split each stream instead of sharing one name.
+	c.consumer = helper.NewConsumer(streams.ConsumerOptions{
+		Group: ConsumerGroup,
+		Name:  strings.TrimSpace(os.Getenv("PRODUCTIVITY_CONSUMER_NAME")),
+		Agent: DeadLetterAgent,
+		Users: userIDs,
+		Retry: streams.RetryPolicyFromEnv(),
+	})
+	c.consumer.Handle(StreamKeyFormat, func(ctx context.Context, entry streams.Entry) error {
+		return c.processMessage(ctx, entry.UserID, entry.Values)
+	})
+	return c
 }
 
+// Start consumes the users' productivity streams until ctx is cancelled or Stop is called.
 func (c *ProductivityConsumer) Start(ctx context.Context) error {
-	log.Printf("Starting productivity consumer for users: %v", c.userIDs)
-
-	// Ensure groups exist
-	streamKeys := make([]string, 0, len(c.userIDs))
-	args := make([]string, 0, len(c.userIDs)*2)
+	log.Printf("Starting productivity consumer %s for users: %v", c.consumer.Name(), c.consumer.Users())
+	return c.consumer.Run(ctx)
+}
 
-	for _, userID := range c.userIDs {
-		key := fmt.Sprintf(StreamKeyFormat, userID)
-		streamKeys = append(streamKeys, key)
+// Stop stops reading and waits for in-flight messages.
+func (c *ProductivityConsumer) Stop() {
+	c.consumer.Stop()
+}
 
-		// Create group (ignore if exists)
-		err := c.client.XGroupCreateMkStream(ctx, key, ConsumerGroup, "$").Err()
-		if err != nil && !strings.Contains(err.Error(), "BUSYGROUP") {
-			log.Printf("Failed to create group for %s: %v", key, err)
-		}
-		args = append(args, key)
-	}
-	// Append ">" for each stream
-	for range c.userIDs {
-		args = append(args, ">")
-	}
-
-	ticker := time.NewTicker(100 * time.Millisecond) // Small delay to prevent tight loop if empty
-	defer ticker.Stop()
-
-	for {
-		select {
-		case <-c.stopChan:
-			return nil
-		case <-ctx.Done():
-			return ctx.Err()
-		case <-ticker.C:
-			// Read from all streams
-			res, err := c.client.XReadGroup(ctx, &redis.XReadGroupArgs{
-				Group:    ConsumerGroup,
-				Consumer: ConsumerName,
-				Streams:  args,
-				Count:    10,
-				Block:    2 * time.Second,
-			}).Result()
-
-			if err != nil && err != redis.Nil {
-				if !strings.Contains(err.Error(), "timeout") {
-					log.Printf("Error reading streams: %v", err)
-				}
-				continue
-			}
-
-			for _, stream := range res {
-				userID := extractUserID(stream.Stream)
-				for _, msg := range stream.Messages {
-					if err := c.processMessage(ctx, userID, msg.Values); err != nil {
-						log.Printf("Error processing message %s: %v", msg.ID, err)
-						// We still ack? Or retry? For now ack to avoid stuck queue
-					}
-					c.client.XAck(ctx, stream.Stream, ConsumerGroup, msg.ID)
-				}
-			}
-		}
-	}
+// SetUsers replaces the consumed users at runtime, creating groups for new users.
+func (c *ProductivityConsumer) SetUsers(ctx context.Context, userIDs []string) {
+	c.consumer.SetUsers(ctx, userIDs)
+	log.Printf("Productivity consumer now consuming users: %v", userIDs)
 }
 
-func (c *ProductivityConsumer) Stop() {
-	close(c.stopChan)
+// Metrics reports the consumer's delivery counters.
+func (c *ProductivityConsumer) Metrics() streams.ConsumerMetrics {
+	return c.consumer.Metrics()
 }
 
 func (c *ProductivityConsumer) processMessage(ctx context.Context, userID string, values map[string]interface{}) error {
@@ -180,6 +150,12 @@ func (c *ProductivityConsumer) handleHeartbeat(ctx context.Context, userID strin
 	if err != nil {
 		return fmt.Errorf("classifier process: %w", err)
 	}
+	if decision == nil {
+		// A decision recorded while handling an earlier attempt, whose emit failed.
+		if decision, err = c.classifier.UnemittedDecision(ctx, userID); err != nil {
+			return fmt.Errorf("classifier unemitted decision: %w", err)
+		}
+	}
 
 	if decision != nil {
 		// Emit decision to whiteboard
@@ -192,32 +168,15 @@ func (c *ProductivityConsumer) handleHeartbeat(ctx context.Context, userID strin
 func (c *ProductivityConsumer) emitDecision(ctx context.Context, userID, threadID string, decision *Decision) error {
 	log.Printf("Emitting decision for %s: %s (%s)", userID, decision.Kind, decision.Observed)
 
-	if strings.TrimSpace(threadID) == "" {
-		threadID = "system"
+	if decision.UserID == "" {
+		decision.UserID = userID
 	}
-
-	wbKey := fmt.Sprintf(WhiteboardFormat, userID)
-
-	// Whiteboard only gets the core decision: underrun or overrun
-	msg := map[string]interface{}{
-		"decision": string(decision.Kind),
-		"ts":       time.Now().UTC().Format(time.RFC3339),
+	if _, err := c.emitter.Emit(ctx, threadID, decision); err != nil {
+		// The classifier still holds the decision, so the retry emits it.
+		return err
 	}
-	msg["thread_id"] = threadID
-
-	_, err := c.client.XAdd(ctx, &redis.XAddArgs{
-		Stream: wbKey,
-		Values: msg,
-	}).Result()
-
-	return err
-}
-
-func extractUserID(streamKey string) string {
-	// user:{id}:in:prod
-	parts := strings.Split(streamKey, ":")
-	if len(parts) >= 2 {
-		return parts[1]
+	if err := c.classifier.DecisionEmitted(ctx, decision); err != nil {
+		log.Printf("productivity: mark decision emitted for %s: %v", userID, err)
 	}
-	return "unknown"
+	return nil
 }
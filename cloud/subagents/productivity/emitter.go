@@ -0,0 +1,68 @@
+package productivity
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"alfred-cloud/wb"
+)
+
+const defaultDecisionThread = "system"
+
+// DecisionEmitter publishes classifier decisions to the user's whiteboard as prod.* events.
+type DecisionEmitter struct {
+	bus *wb.Bus
+}
+
+// NewDecisionEmitter creates an emitter that appends through the whiteboard bus.
+func NewDecisionEmitter(bus *wb.Bus) *DecisionEmitter {
+	return &DecisionEmitter{bus: bus}
+}
+
+// Emit appends the decision to user:{id}:wb and returns the whiteboard entry id.
+func (e *DecisionEmitter) Emit(ctx context.Context, threadID string, decision *Decision) (string, error) {
+	if e == nil || e.bus == nil {
+		return "", errors.New("decision emitter not configured")
+	}
+	if decision == nil {
+		return "", errors.New("decision is nil")
+	}
+	if strings.TrimSpace(threadID) == "" {
+		threadID = defaultDecisionThread
+	}
+	return e.bus.AppendWithThread(ctx, decision.UserID, threadID, DecisionValues(decision))
+}
+
+// DecisionValues maps a decision onto the whiteboard fields the manager normalizer expects:
+// type=prod.<kind>, block_id and activity_label, plus the observation and block timings.
+func DecisionValues(decision *Decision) map[string]any {
+	label := strings.TrimSpace(decision.Title)
+	if label == "" {
+		label = decision.EventID
+	}
+	apps, _ := json.Marshal(decision.ExpectedApps)
+
+	values := map[string]any{
+		"type":           "prod." + string(decision.Kind),
+		"user_id":        decision.UserID,
+		"block_id":       decision.EventID,
+		"activity_label": label,
+		"observed":       decision.Observed,
+		"expected_apps":  string(apps),
+		"ts":             time.Now().UTC().Format(time.RFC3339),
+	}
+	for key, ts := range map[string]time.Time{
+		"block_start": decision.BlockStart,
+		"block_end":   decision.BlockEnd,
+		"started_at":  decision.StartedAt,
+		"decided_at":  decision.DecidedAt,
+	} {
+		if !ts.IsZero() {
+			values[key] = ts.UTC().Format(time.RFC3339)
+		}
+	}
+	return values
+}
@@ -0,0 +1,99 @@
+package productivity
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"alfred-cloud/manager"
+	"alfred-cloud/wb"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecisionEmitterWritesManagerCompatibleEvent(t *testing.T) {
+	ctx := context.Background()
+	client, cleanup := newTestRedis(t)
+	defer cleanup()
+
+	blockStart := time.Date(2025, 11, 18, 14, 0, 0, 0, time.UTC)
+	emitter := NewDecisionEmitter(wb.NewBus(client))
+	id, err := emitter.Emit(ctx, "", &Decision{
+		Kind:         DecisionOverrun,
+		UserID:       "user-1",
+		EventID:      "evt-1",
+		Title:        "Coding",
+		Observed:     "com.apple.finder",
+		ExpectedApps: []string{"com.microsoft.VSCode"},
+		BlockStart:   blockStart,
+		BlockEnd:     blockStart.Add(time.Hour),
+		StartedAt:    blockStart.Add(30 * time.Minute),
+		DecidedAt:    blockStart.Add(32 * time.Minute),
+	})
+	require.NoError(t, err)
+
+	msgs, err := client.XRange(ctx, wb.StreamKey("user-1"), id, id).Result()
+	require.NoError(t, err)
+	require.Len(t, msgs, 1)
+	values := msgs[0].Values
+	require.Equal(t, "prod.overrun", values["type"])
+	require.Equal(t, "system", values["thread_id"])
+	require.Equal(t, `["com.microsoft.VSCode"]`, values["expected_apps"])
+	require.Equal(t, "2025-11-18T14:00:00Z", values["block_start"])
+	require.Equal(t, "2025-11-18T14:32:00Z", values["decided_at"])
+
+	normalized, err := manager.NormalizeWhiteboardEvent(wb.Event{ID: id, UserID: "user-1", Values: values})
+	require.NoError(t, err)
+	require.Equal(t, "prod", normalized.Event.Source)
+	require.Equal(t, "overrun", normalized.Event.Kind)
+	require.Equal(t, "evt-1", normalized.Event.Payload["block_id"])
+	require.Equal(t, "Coding", normalized.Event.Payload["activity_label"])
+	require.Equal(t, "com.apple.finder", normalized.Event.Payload["observed"])
+}
+
+func TestDecisionValuesAllowlistFallsBackToEventID(t *testing.T) {
+	values := DecisionValues(&Decision{Kind: DecisionAllowlist, UserID: "user-1", EventID: "evt-2"})
+	require.Equal(t, "prod.allowlist", values["type"])
+	require.Equal(t, "evt-2", values["activity_label"])
+	require.NotContains(t, values, "block_start")
+}
+
+func TestHeartbeatReemitsDecisionAfterFailedEmit(t *testing.T) {
+	ctx := context.Background()
+	client, cleanup := newTestRedis(t)
+	defer cleanup()
+
+	svc, err := NewHeuristicService(NewHeuristicStore(client), &staticGenerator{apps: []string{"com.microsoft.VSCode"}})
+	require.NoError(t, err)
+	now := time.Now()
+	_, err = svc.UpsertEventHeuristic(ctx, EventPayload{UserID: "user-1", EventID: "evt-1", Title: "Coding", StartTime: now.Add(-time.Minute), EndTime: now.Add(time.Hour)})
+	require.NoError(t, err)
+	classifier, err := NewClassifier(svc)
+	require.NoError(t, err)
+
+	// The whiteboard lives on its own server so it can fail on its own.
+	wbServer := miniredis.RunT(t)
+	wbClient := redis.NewClient(&redis.Options{Addr: wbServer.Addr()})
+	defer wbClient.Close()
+	consumer := &ProductivityConsumer{classifier: classifier, heuristics: svc, emitter: NewDecisionEmitter(wb.NewBus(wbClient))}
+
+	heartbeat := func(offset time.Duration) map[string]interface{} {
+		return map[string]interface{}{"bundle_id": "com.apple.finder", "ts": now.Add(offset).UTC().Format(time.RFC3339)}
+	}
+	require.NoError(t, consumer.handleHeartbeat(ctx, "user-1", heartbeat(0)))
+
+	wbServer.SetError("LOADING whiteboard unavailable")
+	require.Error(t, consumer.handleHeartbeat(ctx, "user-1", heartbeat(121*time.Second)))
+
+	// The retried entry finds the decision already recorded and emits it anyway, once.
+	wbServer.SetError("")
+	require.NoError(t, consumer.handleHeartbeat(ctx, "user-1", heartbeat(121*time.Second)))
+	require.NoError(t, consumer.handleHeartbeat(ctx, "user-1", heartbeat(150*time.Second)))
+
+	msgs, err := wbClient.XRange(ctx, wb.StreamKey("user-1"), "-", "+").Result()
+	require.NoError(t, err)
+	require.Len(t, msgs, 1)
+	require.Equal(t, "prod.underrun", msgs[0].Values["type"])
+	require.Len(t, classifier.Decisions("user-1"), 1)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"log"
+
+	"alfred-cloud/registry"
+	"alfred-cloud/security"
+)
+
+// userSetter is implemented by every per-user worker that can be reconciled at runtime.
+type userSetter interface {
+	SetUsers(ctx context.Context, userIDs []string)
+}
+
+// oauthFeatures are the features switched on when a user connects a Google service.
+func oauthFeatures(service security.ServiceScope) []registry.Feature {
+	switch service {
+	case security.ServiceGmail:
+		return []registry.Feature{registry.FeatureEmail, registry.FeatureManager}
+	case security.ServiceCalendar:
+		return []registry.Feature{registry.FeatureCalendar, registry.FeatureProductivity, registry.FeatureManager}
+	}
+	return nil
+}
+
+// backfillUserRegistry registers users who connected Google before the registry existed.
+func backfillUserRegistry(ctx context.Context, reg *registry.Registry, tokenStore *security.TokenStore) {
+	for _, service := range []security.ServiceScope{security.ServiceGmail, security.ServiceCalendar} {
+		users, err := tokenStore.ListServiceUsers(ctx, service)
+		if err != nil {
+			log.Printf("User registry backfill for %s failed: %v", service, err)
+			continue
+		}
+		for _, userID := range users {
+			if err := reg.Register(ctx, userID, oauthFeatures(service)...); err != nil {
+				log.Printf("User registry backfill for %s/%s failed: %v", userID, service, err)
+			}
+		}
+	}
+}
+
+// seedUserRegistry registers the users listed in a legacy *_USERS env var.
+func seedUserRegistry(ctx context.Context, reg *registry.Registry, envKey string, feature registry.Feature) {
+	for _, userID := range parseUserList(envKey, "") {
+		if err := reg.Register(ctx, userID, feature); err != nil {
+			log.Printf("User registry seed from %s failed for %s: %v", envKey, userID, err)
+		}
+	}
+}
+
+// watchUsers keeps worker's users in sync with the registry until ctx is canceled.
+// The first sync happens before it returns so workers start with the right users.
+func watchUsers(ctx context.Context, reg *registry.Registry, feature registry.Feature, worker userSetter) {
+	users, err := reg.Users(ctx, feature)
+	if err != nil {
+		log.Printf("User registry: load %s users failed: %v", feature, err)
+	}
+	worker.SetUsers(ctx, users)
+	go reg.Watch(ctx, feature, func(users []string) {
+		worker.SetUsers(ctx, users)
+	})
+}
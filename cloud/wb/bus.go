@@ -28,11 +28,19 @@ type Event struct {
 // Bus provides typed helpers for the per-user whiteboard stream.
 type Bus struct {
 	client *redis.Client
+	strict bool
 }
 
-// NewBus creates a new whiteboard bus for the given redis client.
+// NewBus creates a new whiteboard bus for the given redis client. It validates
+// strictly when WB_STRICT_SCHEMA is set.
 func NewBus(client *redis.Client) *Bus {
-	return &Bus{client: client}
+	return &Bus{client: client, strict: StrictFromEnv()}
+}
+
+// SetStrict makes appends reject unknown event types and undeclared fields, not
+// just registered types that are missing fields or carry the wrong kinds.
+func (b *Bus) SetStrict(strict bool) {
+	b.strict = strict
 }
 
 // StreamKey returns the canonical whiteboard stream key for a user.
@@ -49,6 +57,7 @@ func (b *Bus) Append(ctx context.Context, userID string, values map[string]any)
 }
 
 // AppendWithThread writes a payload to the user's whiteboard stream with thread_id.
+// Payloads that fail schema validation are rejected with an error wrapping ErrInvalidEvent.
 func (b *Bus) AppendWithThread(ctx context.Context, userID, threadID string, values map[string]any) (string, error) {
 	if b == nil || b.client == nil {
 		return "", fmt.Errorf("whiteboard bus not configured")
@@ -63,6 +72,9 @@ func (b *Bus) AppendWithThread(ctx context.Context, userID, threadID string, val
 	if threadID != "" {
 		values["thread_id"] = threadID
 	}
+	if err := Validate(values, b.strict); err != nil {
+		return "", err
+	}
 
 	return b.client.XAdd(ctx, &redis.XAddArgs{
 		Stream: StreamKey(userID),
@@ -92,9 +104,114 @@ func (b *Bus) Tail(ctx context.Context, userID, afterID string) ([]Event, string
 		return nil, afterID, err
 	}
 
-	events := make([]Event, 0)
+	events := toEvents(res)
 	nextID := afterID
+	if len(events) > 0 {
+		nextID = events[len(events)-1].ID
+	}
+
+	return events, nextID, nil
+}
+
+// Get returns a single whiteboard entry by id, or nil when it does not exist.
+func (b *Bus) Get(ctx context.Context, userID, id string) (*Event, error) {
+	if b == nil || b.client == nil {
+		return nil, fmt.Errorf("whiteboard bus not configured")
+	}
+	if !isStreamID(id) {
+		return nil, nil
+	}
+
+	stream := StreamKey(userID)
+	msgs, err := b.client.XRange(ctx, stream, id, id).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, nil
+		}
+		return nil, err
+	}
+	events := toEvents([]redis.XStream{{Stream: stream, Messages: msgs}})
+	if len(events) == 0 {
+		return nil, nil
+	}
+	return &events[0], nil
+}
+
+// EnsureGroup creates a consumer group on the user's whiteboard stream starting after startID.
+// An existing group is left untouched so its delivery cursor survives restarts.
+func (b *Bus) EnsureGroup(ctx context.Context, userID, group, startID string) error {
+	if b == nil || b.client == nil {
+		return fmt.Errorf("whiteboard bus not configured")
+	}
+	if strings.TrimSpace(startID) == "" {
+		startID = "$"
+	}
+	err := b.client.XGroupCreateMkStream(ctx, StreamKey(userID), group, startID).Err()
+	if err != nil && !strings.Contains(err.Error(), "BUSYGROUP") {
+		return err
+	}
+	return nil
+}
+
+// ReadGroup blocks for entries not yet delivered to any consumer in the group.
+func (b *Bus) ReadGroup(ctx context.Context, userID, group, consumer string) ([]Event, error) {
+	if b == nil || b.client == nil {
+		return nil, fmt.Errorf("whiteboard bus not configured")
+	}
+
+	res, err := b.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    group,
+		Consumer: consumer,
+		Streams:  []string{StreamKey(userID), ">"},
+		Count:    defaultBatchCount,
+		Block:    defaultBlock,
+	}).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return toEvents(res), nil
+}
+
+// ClaimStale transfers entries pending longer than minIdle in the group to consumer.
+func (b *Bus) ClaimStale(ctx context.Context, userID, group, consumer string, minIdle time.Duration) ([]Event, error) {
+	if b == nil || b.client == nil {
+		return nil, fmt.Errorf("whiteboard bus not configured")
+	}
+
+	stream := StreamKey(userID)
+	msgs, _, err := b.client.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+		Stream:   stream,
+		Group:    group,
+		Consumer: consumer,
+		MinIdle:  minIdle,
+		Start:    "0-0",
+		Count:    defaultBatchCount,
+	}).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return toEvents([]redis.XStream{{Stream: stream, Messages: msgs}}), nil
+}
 
+// Ack acknowledges processed entries so they leave the group's pending list.
+func (b *Bus) Ack(ctx context.Context, userID, group string, ids ...string) error {
+	if b == nil || b.client == nil {
+		return fmt.Errorf("whiteboard bus not configured")
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	return b.client.XAck(ctx, StreamKey(userID), group, ids...).Err()
+}
+
+func toEvents(res []redis.XStream) []Event {
+	events := make([]Event, 0)
 	for _, stream := range res {
 		for _, msg := range stream.Messages {
 			values := make(map[string]any, len(msg.Values))
@@ -109,11 +226,9 @@ func (b *Bus) Tail(ctx context.Context, userID, afterID string) ([]Event, string
 				ThreadID: threadID,
 				Values:   values,
 			})
-			nextID = msg.ID
 		}
 	}
-
-	return events, nextID, nil
+	return events
 }
 
 func stringVal(v any) string {
@@ -127,6 +242,25 @@ func stringVal(v any) string {
 	}
 }
 
+// isStreamID reports whether id has the <ms>-<seq> (or bare <ms>) shape of a stream entry id.
+func isStreamID(id string) bool {
+	parts := strings.Split(strings.TrimSpace(id), "-")
+	if len(parts) > 2 {
+		return false
+	}
+	for _, part := range parts {
+		if part == "" {
+			return false
+		}
+		for _, r := range part {
+			if r < '0' || r > '9' {
+				return false
+			}
+		}
+	}
+	return true
+}
+
 func userIDFromStream(stream string) string {
 	parts := strings.Split(stream, ":")
 	if len(parts) >= 2 {
@@ -0,0 +1,86 @@
+package wb
+
+// Whiteboard event types written by the agents and the manager. Producers are
+// validated against these on append and the manager normalizer builds its
+// payloads from them, so a field added here reaches both sides.
+func init() {
+	Register(
+		EventSchema{Type: "calendar.plan.proposed", Managed: true, Fields: []Field{
+			{Name: "delta_id", Kind: FieldString, Required: true},
+			{Name: "summary", Kind: FieldString, Required: true},
+			{Name: "impact", Kind: FieldString, Required: true},
+		}},
+		EventSchema{Type: "calendar.plan.new_version", Managed: true, Fields: []Field{
+			{Name: "plan_id", Kind: FieldString, Required: true},
+			{Name: "version", Kind: FieldString, Required: true},
+			{Name: "time_block", Kind: FieldString},
+			{Name: "plan_date", Kind: FieldString},
+		}},
+	)
+	for _, status := range []string{"pending", "accepted", "rejected", "expired", "superseded"} {
+		Register(EventSchema{Type: "calendar.proposal." + status, Managed: true, Fields: proposalFields})
+	}
+	for _, kind := range []string{"underrun", "overrun", "nudge", "allowlist"} {
+		Register(EventSchema{Type: "prod." + kind, Managed: true, Fields: prodFields})
+	}
+	Register(
+		EventSchema{Type: "email.reply_needed", Managed: true, Fields: []Field{
+			{Name: "message_id", Kind: FieldString, Required: true},
+			{Name: "sender", Kind: FieldString, Required: true},
+			{Name: "summary", Kind: FieldString, Required: true},
+			{Name: "draft", Kind: FieldString, Required: true},
+			{Name: "subject", Kind: FieldString},
+			{Name: "priority", Kind: FieldString},
+			{Name: "confidence", Kind: FieldFloat},
+		}},
+		EventSchema{Type: "manager.user_action", Managed: true, Fields: []Field{
+			{Name: "action_id", Kind: FieldString, Required: true},
+			{Name: "choice", Kind: FieldString, Required: true},
+			{Name: "thread_id", Kind: FieldString},
+			{Name: "metadata", Kind: FieldObject},
+		}},
+		EventSchema{Type: "manager.prompt", Fields: []Field{
+			{Name: "source", Kind: FieldString, Required: true},
+			{Name: "kind", Kind: FieldString, Required: true},
+			{Name: "content", Kind: FieldString, Required: true},
+			{Name: "prompt", Kind: FieldString, Required: true},
+			{Name: "wb_parent_id", Kind: FieldString, Required: true},
+			{Name: "choices", Kind: FieldString},
+			{Name: "delta_id", Kind: FieldString},
+			{Name: "message_id", Kind: FieldString},
+			{Name: "sender", Kind: FieldString},
+			{Name: "summary", Kind: FieldString},
+			{Name: "draft", Kind: FieldString},
+		}},
+		EventSchema{Type: "manager.plan_outcome", Fields: []Field{
+			{Name: "plan_id", Kind: FieldString, Required: true},
+			{Name: "version", Kind: FieldString, Required: true},
+			{Name: "planner_status", Kind: FieldString, Required: true},
+			{Name: "prod_status", Kind: FieldString, Required: true},
+			{Name: "wb_parent_id", Kind: FieldString, Required: true},
+			{Name: "planner_blocks", Kind: FieldInt},
+			{Name: "planner_notes", Kind: FieldJSON},
+		}},
+	)
+}
+
+var proposalFields = []Field{
+	{Name: "proposal_id", Kind: FieldString, Required: true},
+	{Name: "status", Kind: FieldString},
+	{Name: "previous_status", Kind: FieldString},
+	{Name: "summary", Kind: FieldString},
+	{Name: "reason", Kind: FieldString},
+	{Name: "superseded_by", Kind: FieldString},
+	{Name: "conflict_key", Kind: FieldString},
+}
+
+var prodFields = []Field{
+	{Name: "block_id", Kind: FieldString, Required: true},
+	{Name: "activity_label", Kind: FieldString, Required: true},
+	{Name: "observed", Kind: FieldString},
+	{Name: "expected_apps", Kind: FieldJSON},
+	{Name: "block_start", Kind: FieldTime},
+	{Name: "block_end", Kind: FieldTime},
+	{Name: "started_at", Kind: FieldTime},
+	{Name: "decided_at", Kind: FieldTime},
+}
@@ -0,0 +1,249 @@
+package wb
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrInvalidEvent is wrapped by every schema validation failure.
+var ErrInvalidEvent = errors.New("invalid whiteboard event")
+
+// FieldKind is the value shape a whiteboard field must have. Entries read back
+// from Redis are flat strings, so every kind also accepts its string form.
+type FieldKind string
+
+const (
+	FieldString FieldKind = "string"
+	FieldInt    FieldKind = "int"
+	FieldFloat  FieldKind = "float"
+	FieldBool   FieldKind = "bool"
+	// FieldTime is an RFC 3339 timestamp.
+	FieldTime FieldKind = "time"
+	// FieldJSON is JSON text kept verbatim, e.g. a list of bundle ids.
+	FieldJSON FieldKind = "json"
+	// FieldObject is a JSON object; normalized events carry it as a map.
+	FieldObject FieldKind = "object"
+)
+
+// Field declares one field of an event type.
+type Field struct {
+	Name     string
+	Kind     FieldKind
+	Required bool
+}
+
+// EventSchema declares a whiteboard event type and its fields.
+type EventSchema struct {
+	Type   string
+	Fields []Field
+	// Managed marks types the manager normalizes and routes; the rest (such as
+	// the manager's own output) are only validated on append.
+	Managed bool
+}
+
+// envelopeFields are accepted on every event without being declared.
+var envelopeFields = map[string]bool{
+	"type": true, "kind": true, "event_type": true,
+	"user_id": true, "thread_id": true, "ts": true,
+}
+
+var registry = struct {
+	sync.RWMutex
+	schemas map[string]EventSchema
+}{schemas: make(map[string]EventSchema)}
+
+// Register adds event types to the registry, replacing earlier definitions of the same type.
+func Register(schemas ...EventSchema) {
+	registry.Lock()
+	defer registry.Unlock()
+	for _, schema := range schemas {
+		schema.Type = strings.ToLower(strings.TrimSpace(schema.Type))
+		registry.schemas[schema.Type] = schema
+	}
+}
+
+// LookupSchema returns the registered schema for eventType.
+func LookupSchema(eventType string) (EventSchema, bool) {
+	registry.RLock()
+	defer registry.RUnlock()
+	schema, ok := registry.schemas[strings.ToLower(strings.TrimSpace(eventType))]
+	return schema, ok
+}
+
+// Schemas returns every registered event type, sorted by type.
+func Schemas() []EventSchema {
+	registry.RLock()
+	defer registry.RUnlock()
+	out := make([]EventSchema, 0, len(registry.schemas))
+	for _, schema := range registry.schemas {
+		out = append(out, schema)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Type < out[j].Type })
+	return out
+}
+
+// TypeOf returns the event type carried in values under type, kind or
+// event_type (first non-empty wins), lower-cased.
+func TypeOf(values map[string]any) string {
+	for _, key := range []string{"type", "kind", "event_type"} {
+		if s := textValue(values[key]); s != "" {
+			return strings.ToLower(s)
+		}
+	}
+	return ""
+}
+
+// Validate checks values against the schema of their event type. Unknown and
+// untyped events pass unless strict is set; in strict mode undeclared fields
+// are rejected as well.
+func Validate(values map[string]any, strict bool) error {
+	eventType := TypeOf(values)
+	if eventType == "" {
+		if strict {
+			return fmt.Errorf("%w: missing type", ErrInvalidEvent)
+		}
+		return nil
+	}
+	schema, ok := LookupSchema(eventType)
+	if !ok {
+		if strict {
+			return fmt.Errorf("%w: unknown type %s", ErrInvalidEvent, eventType)
+		}
+		return nil
+	}
+	return schema.Validate(values, strict)
+}
+
+// Validate checks that required fields are present and every declared field
+// has the declared kind. Strict also rejects fields the schema does not declare.
+func (s EventSchema) Validate(values map[string]any, strict bool) error {
+	var problems []string
+	declared := make(map[string]bool, len(s.Fields))
+	for _, field := range s.Fields {
+		declared[field.Name] = true
+		v, ok := values[field.Name]
+		if !ok || isEmpty(v) {
+			if field.Required {
+				problems = append(problems, field.Name+" is required")
+			}
+			continue
+		}
+		if !field.Kind.accepts(v) {
+			problems = append(problems, fmt.Sprintf("%s must be %s, got %T", field.Name, field.Kind, v))
+		}
+	}
+	if strict {
+		var extra []string
+		for name := range values {
+			if !declared[name] && !envelopeFields[name] {
+				extra = append(extra, name)
+			}
+		}
+		sort.Strings(extra)
+		for _, name := range extra {
+			problems = append(problems, name+" is not declared")
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%w: %s: %s", ErrInvalidEvent, s.Type, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func (k FieldKind) accepts(v any) bool {
+	switch k {
+	case FieldString:
+		return isText(v)
+	case FieldInt:
+		switch val := v.(type) {
+		case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+			return true
+		case string, []byte:
+			_, err := strconv.ParseInt(textValue(val), 10, 64)
+			return err == nil
+		}
+	case FieldFloat:
+		switch val := v.(type) {
+		case float32, float64, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+			return true
+		case string, []byte:
+			_, err := strconv.ParseFloat(textValue(val), 64)
+			return err == nil
+		}
+	case FieldBool:
+		switch val := v.(type) {
+		case bool:
+			return true
+		case string, []byte:
+			_, err := strconv.ParseBool(textValue(val))
+			return err == nil
+		}
+	case FieldTime:
+		switch val := v.(type) {
+		case time.Time:
+			return true
+		case string, []byte:
+			_, err := time.Parse(time.RFC3339Nano, textValue(val))
+			return err == nil
+		}
+	case FieldJSON:
+		if s, ok := v.(string); ok {
+			return json.Valid([]byte(s))
+		}
+		if b, ok := v.([]byte); ok {
+			return json.Valid(b)
+		}
+	case FieldObject:
+		switch val := v.(type) {
+		case map[string]any:
+			return true
+		case string, []byte:
+			var obj map[string]any
+			return json.Unmarshal([]byte(textValue(val)), &obj) == nil
+		}
+	}
+	return false
+}
+
+func isText(v any) bool {
+	switch v.(type) {
+	case string, []byte, fmt.Stringer:
+		return true
+	default:
+		return false
+	}
+}
+
+func isEmpty(v any) bool {
+	if v == nil {
+		return true
+	}
+	return isText(v) && textValue(v) == ""
+}
+
+func textValue(v any) string {
+	switch val := v.(type) {
+	case string:
+		return strings.TrimSpace(val)
+	case []byte:
+		return strings.TrimSpace(string(val))
+	case fmt.Stringer:
+		return strings.TrimSpace(val.String())
+	default:
+		return ""
+	}
+}
+
+// StrictFromEnv reports whether WB_STRICT_SCHEMA asks buses to reject unknown
+// event types and undeclared fields.
+func StrictFromEnv() bool {
+	strict, _ := strconv.ParseBool(strings.TrimSpace(os.Getenv("WB_STRICT_SCHEMA")))
+	return strict
+}
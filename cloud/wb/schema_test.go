@@ -0,0 +1,64 @@
+package wb
+
+import (
+	"context"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAppendValidatesAgainstSchema(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	ctx := context.Background()
+	bus := NewBus(client)
+
+	_, err := bus.AppendWithThread(ctx, "u1", "t1", map[string]any{
+		"type":           "prod.overrun",
+		"block_id":       "evt-1",
+		"activity_label": "Coding",
+		"block_start":    "2025-11-18T14:00:00Z",
+		"expected_apps":  `["com.microsoft.VSCode"]`,
+	})
+	require.NoError(t, err)
+
+	_, err = bus.AppendWithThread(ctx, "u1", "t1", map[string]any{
+		"decision":       "prod.overrun",
+		"block_id":       "evt-1",
+		"activity_label": "Coding",
+	})
+	require.NoError(t, err, "untyped events pass outside strict mode")
+
+	_, err = bus.AppendWithThread(ctx, "u1", "t1", map[string]any{
+		"type":        "prod.overrun",
+		"block_id":    "evt-1",
+		"block_start": "2pm",
+	})
+	require.ErrorIs(t, err, ErrInvalidEvent)
+	require.ErrorContains(t, err, "activity_label is required")
+	require.ErrorContains(t, err, "block_start must be time")
+
+	_, err = bus.AppendWithThread(ctx, "u1", "t1", map[string]any{"kind": "Manager.User_Action", "choice": "accept"})
+	require.ErrorContains(t, err, "manager.user_action: action_id is required", "kind is read when type is absent")
+
+	n, err := client.XLen(ctx, StreamKey("u1")).Result()
+	require.NoError(t, err)
+	require.Equal(t, int64(2), n, "rejected events are not written")
+
+	bus.SetStrict(true)
+	_, err = bus.Append(ctx, "u1", map[string]any{"body": "hello"})
+	require.ErrorContains(t, err, "missing type")
+	_, err = bus.Append(ctx, "u1", map[string]any{"type": "prod.unknown"})
+	require.ErrorContains(t, err, "unknown type prod.unknown")
+	_, err = bus.AppendWithThread(ctx, "u1", "t1", map[string]any{
+		"type": "manager.user_action", "action_id": "a1", "choice": "accept", "note": "x",
+	})
+	require.ErrorContains(t, err, "note is not declared")
+	_, err = bus.AppendWithThread(ctx, "u1", "t1", map[string]any{
+		"type": "manager.user_action", "action_id": "a1", "choice": "accept", "metadata": `{"prompt_id":"1-0"}`,
+	})
+	require.NoError(t, err)
+}